@@ -1,7 +1,15 @@
 package initial
 
 import (
+	"net/http"
+	"strings"
+	"time"
+
+	"test-user-server/internal/cache"
 	"test-user-server/internal/config"
+	"test-user-server/internal/dao"
+	"test-user-server/internal/database"
+	"test-user-server/internal/publisher"
 	"test-user-server/internal/server"
 
 	"github.com/go-dev-frame/sponge/pkg/app"
@@ -18,5 +26,82 @@ func CreateServices() []app.IServer {
 	)
 	servers = append(servers, httpServer)
 
+	// hard delete the userss that stayed in the trash longer than the retention
+	if cfg.Trash.RetentionDays > 0 {
+		interval := time.Duration(cfg.Trash.PurgeInterval) * time.Second
+		if interval <= 0 {
+			interval = time.Hour
+		}
+		purgeServer := server.NewUsersPurgeServer(
+			dao.NewUsersDao(database.GetDB(), cache.NewUsersCache(database.GetCacheType())),
+			time.Duration(cfg.Trash.RetentionDays)*24*time.Hour,
+			interval,
+		)
+		servers = append(servers, purgeServer)
+	}
+
+	// publish the users events of the outbox
+	eventPublisher := newUsersEventPublisher(cfg.Outbox)
+	if eventPublisher != nil {
+		interval := time.Duration(cfg.Outbox.PollInterval) * time.Millisecond
+		if interval <= 0 {
+			interval = time.Second
+		}
+		relay := server.NewUsersOutboxRelay(
+			dao.NewUsersDao(database.GetDB(), cache.NewUsersCache(database.GetCacheType())),
+			eventPublisher,
+			interval,
+		)
+		servers = append(servers, relay)
+	}
+
+	// delete the users events older than the retention, the unpublished ones too when there is no relay
+	if cfg.Outbox.RetentionDays > 0 {
+		interval := time.Duration(cfg.Outbox.PurgeInterval) * time.Second
+		if interval <= 0 {
+			interval = time.Hour
+		}
+		outboxPurgeServer := server.NewUsersOutboxPurgeServer(
+			dao.NewUsersDao(database.GetDB(), cache.NewUsersCache(database.GetCacheType())),
+			time.Duration(cfg.Outbox.RetentionDays)*24*time.Hour,
+			interval,
+			eventPublisher == nil,
+		)
+		servers = append(servers, outboxPurgeServer)
+	}
+
+	// send the users events to the subscribed webhooks
+	if cfg.Webhook.PollInterval > 0 {
+		timeout := time.Duration(cfg.Webhook.Timeout) * time.Second
+		if timeout <= 0 {
+			timeout = 10 * time.Second
+		}
+		dispatcher := server.NewUsersWebhookDispatcher(
+			dao.NewUsersDao(database.GetDB(), cache.NewUsersCache(database.GetCacheType())),
+			time.Duration(cfg.Webhook.PollInterval)*time.Millisecond,
+			server.WithUsersWebhookClient(&http.Client{Timeout: timeout}),
+			server.WithUsersWebhookRetry(cfg.Webhook.MaxAttempts,
+				time.Duration(cfg.Webhook.Backoff)*time.Second, time.Duration(cfg.Webhook.MaxBackoff)*time.Second),
+		)
+		servers = append(servers, dispatcher)
+	}
+
 	return servers
 }
+
+// newUsersEventPublisher the publisher of the configured driver, nil when there is none
+func newUsersEventPublisher(cfg config.Outbox) publisher.Publisher {
+	switch strings.ToLower(cfg.Driver) {
+	case "nats":
+		p, err := publisher.NewNATSPublisher(cfg.Nats.URL, cfg.Nats.SubjectPrefix)
+		if err != nil {
+			panic("init outbox publisher error: " + err.Error())
+		}
+		return p
+	case "kafka":
+		return publisher.NewKafkaRESTPublisher(cfg.Kafka.RestURL, cfg.Kafka.Topic)
+	case "memory":
+		return publisher.NewMemoryPublisher()
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package initial
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+
+	"test-user-server/internal/config"
+	"test-user-server/internal/database"
+	"test-user-server/internal/handler"
+	"test-user-server/internal/sheet"
+)
+
+// RunImport the import command, user_server import [-c config] [-key clerk_code|email] [-dry-run] file,
+// upserts the userss of a csv or xlsx file like POST /api/v1/users/import and prints the report as
+// json, it returns the exit code, 1 when the sheet was refused or some rows failed
+func RunImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.StringVar(&configFile, "c", "", "configuration file")
+	key := fs.String("key", "clerk_code", "column finding the users of a row, clerk_code or email")
+	dryRun := fs.Bool("dry-run", false, "report without writing")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: user_server import [-c config] [-key clerk_code|email] [-dry-run] file.csv|file.xlsx")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	getConfigFromLocal()
+	cfg := config.Get()
+	_, err := logger.Init(logger.WithLevel(cfg.Logger.Level), logger.WithFormat(cfg.Logger.Format))
+	if err != nil {
+		panic(err)
+	}
+	database.InitDB()
+	database.InitCache(cfg.App.CacheType)
+	defer func() {
+		_ = database.CloseDB()
+		if cfg.App.CacheType == "redis" {
+			_ = database.CloseRedis()
+		}
+	}()
+
+	name := fs.Arg(0)
+	f, err := os.Open(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close() //nolint
+	rows, err := sheet.Read(name, f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "read "+name+": "+err.Error())
+		return 1
+	}
+
+	report, fieldErrs, err := handler.NewUsersImporter().Import(context.Background(), rows, *key, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import error: "+err.Error())
+		return 1
+	}
+	var out interface{} = report
+	if len(fieldErrs) > 0 {
+		out = map[string]interface{}{"errors": fieldErrs}
+	}
+	b, _ := json.MarshalIndent(out, "", "  ")
+	fmt.Println(string(b))
+	if len(fieldErrs) > 0 || report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -6,6 +6,7 @@ package initial
 import (
 	"flag"
 	"strconv"
+	"strings"
 	"time"
 
 	ginAuth "github.com/go-dev-frame/sponge/pkg/gin/middleware/auth"
@@ -15,7 +16,11 @@ import (
 
 	"test-user-server/configs"
 	"test-user-server/internal/config"
+	"test-user-server/internal/cursor"
 	"test-user-server/internal/database"
+	"test-user-server/internal/devise"
+	"test-user-server/internal/mailer"
+	"test-user-server/internal/policy"
 )
 
 var (
@@ -78,11 +83,50 @@ func InitApp() {
 		logger.Infof("[%s] was initialized", cfg.App.CacheType)
 	}
 
+	// initializing devise options, they must be the same as the rails app sharing the users table
+	secretKey := cfg.Devise.SecretKey
+	if secretKey == "" && cfg.Rails.SecretKeyBase != "change-me" {
+		secretKey = cfg.Rails.SecretKeyBase
+	}
+	allowUnconfirmedAccessFor := time.Duration(-1)
+	if cfg.Devise.RefuseUnconfirmed {
+		allowUnconfirmedAccessFor = time.Duration(cfg.Devise.AllowUnconfirmedAccessFor) * time.Second
+	}
+	devise.Init(
+		devise.WithStretches(cfg.Devise.Stretches),
+		devise.WithPepper(cfg.Devise.Pepper),
+		devise.WithSecretKey(secretKey, cfg.Devise.KeyGeneratorHashDigest),
+		devise.WithLockable(cfg.Devise.LockStrategy, cfg.Devise.UnlockStrategy,
+			cfg.Devise.MaximumAttempts, time.Duration(cfg.Devise.UnlockIn)*time.Second),
+		devise.WithRecoverable(time.Duration(cfg.Devise.ResetPasswordWithin)*time.Second),
+		devise.WithConfirmable(cfg.Devise.Reconfirmable, allowUnconfirmedAccessFor,
+			time.Duration(cfg.Devise.ConfirmWithin)*time.Second),
+		devise.WithInvitable(cfg.Devise.InvitationLimit, time.Duration(cfg.Devise.InviteFor)*time.Second),
+	)
+
+	// initializing mailer for the devise instructions
+	switch strings.ToLower(cfg.Mailer.Driver) {
+	case "smtp":
+		mailer.Init(mailer.NewSMTPMailer(cfg.Mailer.SMTP.Host, cfg.Mailer.SMTP.Port,
+			cfg.Mailer.SMTP.Username, cfg.Mailer.SMTP.Password, cfg.Mailer.From))
+	case "file":
+		mailer.Init(mailer.NewFileMailer(cfg.Mailer.FileDir))
+	default:
+		mailer.Init(mailer.NewLogMailer())
+	}
+	logger.Infof("[%s mailer] was initialized", cfg.Mailer.Driver)
+
 	// initialize gin jwt auth with config
 	if cfg.JWT.SigningKey != "change-me" {
 		ginAuth.InitAuth([]byte(cfg.JWT.SigningKey), time.Duration(cfg.JWT.Expire)*time.Second)
 		logger.Info("[jwt auth] was initialized")
 	}
+
+	// initializing the signing key of the page cursors, every instance must share it
+	cursor.Init([]byte(cfg.Cursor.SigningKey))
+
+	// initializing the authorization policy, routes stay open while no authentication is configured
+	policy.Init(cfg.JWT.SigningKey != "change-me" || cfg.Rails.SecretKeyBase != "change-me", cfg.Authz.AdminIDs)
 }
 
 func initConfig() {
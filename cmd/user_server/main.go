@@ -2,6 +2,8 @@
 package main
 
 import (
+	"os"
+
 	"github.com/go-dev-frame/sponge/pkg/app"
 
 	"test-user-server/cmd/user_server/initial"
@@ -17,6 +19,11 @@ import (
 // @name Authorization
 // @description Type Bearer your-jwt-token to Value
 func main() {
+	// user_server import file.csv, upsert the userss of a sheet and exit
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(initial.RunImport(os.Args[2:]))
+	}
+
 	initial.InitApp()
 	services := initial.CreateServices()
 	closes := initial.Close(services)
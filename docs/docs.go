@@ -16,6 +16,40 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/api/v1/auth/sign_in": {
+            "post": {
+                "description": "Verifies the email and password against encrypted_password, updates the devise trackable columns and returns a jwt signed with the configured signing key. Failed attempts are counted and the account is locked after devise.maximumAttempts, like devise lockable. Unconfirmed accounts are refused when devise.refuseUnconfirmed is set.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Sign in with email and password",
+                "parameters": [
+                    {
+                        "description": "email and password",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.SignInRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.SignInReply"
+                        }
+                    }
+                }
+            }
+        },
         "/api/v1/users": {
             "post": {
                 "security": [
@@ -23,7 +57,7 @@ const docTemplate = `{
                         "BearerAuth": []
                     }
                 ],
-                "description": "Creates a new users entity using the provided data in the request body.",
+                "description": "Creates a new users entity using the provided data in the request body, the plaintext password is stored as a devise compatible bcrypt digest. A request that fails validation gets InvalidParams with data.errors listing each field, see types.FieldError.",
                 "consumes": [
                     "application/json"
                 ],
@@ -55,6 +89,57 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/v1/users/by/{key}/{value}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Gets detailed information of the users whose key column has the value, key is one of email, clerk_code, wecom_id, windows_sid and pre_sso_id. Lookups go through a cache index so integrations need not know the id.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get a users by a natural key",
+                "parameters": [
+                    {
+                        "enum": [
+                            "email",
+                            "clerk_code",
+                            "wecom_id",
+                            "windows_sid",
+                            "pre_sso_id"
+                        ],
+                        "type": "string",
+                        "description": "natural key column",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "value of the column",
+                        "name": "value",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.GetUsersByIDReply"
+                        }
+                    }
+                }
+            }
+        },
         "/api/v1/users/condition": {
             "post": {
                 "security": [
@@ -94,6 +179,35 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/v1/users/confirmation": {
+            "get": {
+                "description": "Sets confirmedAt and, for a pending email change, replaces email with unconfirmedEmail, like devise confirm_by_token.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Confirm an account or an email change",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "confirmation token from the confirmation instructions",
+                        "name": "token",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ConfirmUsersReply"
+                        }
+                    }
+                }
+            }
+        },
         "/api/v1/users/delete/ids": {
             "post": {
                 "security": [
@@ -101,7 +215,7 @@ const docTemplate = `{
                         "BearerAuth": []
                     }
                 ],
-                "description": "Deletes multiple users by a list of id",
+                "description": "Moves multiple users to the trash by a list of id, nothing is deleted when an id does not exist or is already in the trash and those ids are returned in data.notFoundIDs",
                 "consumes": [
                     "application/json"
                 ],
@@ -133,14 +247,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/v1/users/list": {
-            "get": {
+        "/api/v1/users/events/replay": {
+            "post": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Returns a paginated list of userss starting after a given last id, useful for cursor-based pagination.",
+                "description": "Marks the events of the outbox from the offset on as unpublished, the relay publishes them again in offset order and in the order of the writes of each users. The offset is in each event, consumers that lost events replay from the last one they handled, the events older than outbox.retentionDays are gone.",
                 "consumes": [
                     "application/json"
                 ],
@@ -150,26 +264,69 @@ const docTemplate = `{
                 "tags": [
                     "users"
                 ],
-                "summary": "Get a paginated list of userss by last id",
+                "summary": "Publish the users events again from an offset",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "default": 0,
-                        "description": "last id, default is MaxInt32",
-                        "name": "lastID",
+                        "description": "offset",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.ReplayUsersEventsRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ReplayUsersEventsReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/export": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Streams every users matching the conditions as a file download, the rows are read through a database cursor so the memory stays flat for any number of them. The filters are the columns of types.Params as json, without page and limit. The fields are those of the list, the secret columns are never exported. A csv starts with the utf-8 BOM for excel, the dates are written as 2006-01-02 and the times as RFC3339 so that the file can be imported back. An error after the first row cuts the file short.",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Export userss as csv, ndjson or xlsx",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "default": "csv",
+                        "description": "csv, ndjson or xlsx",
+                        "name": "format",
                         "in": "query"
                     },
                     {
-                        "type": "integer",
-                        "default": 10,
-                        "description": "number per page",
-                        "name": "limit",
+                        "type": "string",
+                        "description": "comma separated json names of the fields to export in that order, all of them by default",
+                        "name": "fields",
                         "in": "query"
                     },
                     {
                         "type": "string",
-                        "default": "-id",
-                        "description": "sort by column name of table, and the ",
+                        "description": "conditions as a json array of types.Column, e.g. [{\\",
+                        "name": "columns",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "id",
+                        "description": "comma separated columns, descending with a leading -, e.g. -created_at",
                         "name": "sort",
                         "in": "query"
                     }
@@ -178,20 +335,22 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.ListUserssReply"
+                            "type": "file"
                         }
                     }
                 }
-            },
+            }
+        },
+        "/api/v1/users/import": {
             "post": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Returns a paginated list of users based on query filters, including page number and size.",
+                "description": "Upserts a users for each row of the uploaded sheet, found by the key column, and reports what became of each row. The header of a column is the json name, the column name or the chinese title of a field, such as 工号, 姓名 and 邮箱, see types.ImportUsersRow. Empty cells are left alone, created userss have no password and set it through the reset password instructions. A row that fails validation or a unique index is reported with its errors and the others go on. A dryRun writes nothing and reports what would be done. A sheet has at most 5000 rows.",
                 "consumes": [
-                    "application/json"
+                    "multipart/form-data"
                 ],
                 "produces": [
                     "application/json"
@@ -199,36 +358,48 @@ const docTemplate = `{
                 "tags": [
                     "users"
                 ],
-                "summary": "Get a paginated list of userss by custom conditions",
+                "summary": "Import userss from a csv or xlsx file",
                 "parameters": [
                     {
-                        "description": "query parameters",
-                        "name": "data",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/types.Params"
-                        }
+                        "type": "file",
+                        "description": "csv or xlsx file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "default": "clerk_code",
+                        "description": "column finding the users of a row, clerk_code or email",
+                        "name": "key",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "default": false,
+                        "description": "report without writing",
+                        "name": "dryRun",
+                        "in": "formData"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.ListUserssReply"
+                            "$ref": "#/definitions/types.ImportUserssReply"
                         }
                     }
                 }
             }
         },
-        "/api/v1/users/list/ids": {
+        "/api/v1/users/invitation": {
             "post": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Returns a list of users that match the list of id.",
+                "description": "Creates the invitee with a digested invitation token and mails the raw token, like devise_invitable invite!. The invitation limit of the caller is enforced and its invitations count incremented in the same transaction.",
                 "consumes": [
                     "application/json"
                 ],
@@ -236,17 +407,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "invitation"
                 ],
-                "summary": "Batch get users by ids",
+                "summary": "Invite a new users",
                 "parameters": [
                     {
-                        "description": "id array",
+                        "description": "email of the invitee",
                         "name": "data",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/types.ListUserssByIDsRequest"
+                            "$ref": "#/definitions/types.InviteUsersRequest"
                         }
                     }
                 ],
@@ -254,20 +425,54 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.ListUserssByIDsReply"
+                            "$ref": "#/definitions/types.InviteUsersReply"
                         }
                     }
                 }
             }
         },
-        "/api/v1/users/{id}": {
-            "get": {
+        "/api/v1/users/invitation/accept": {
+            "put": {
+                "description": "Sets the password of the invitee, stamps invitationAcceptedAt and clears the invitation token, like devise_invitable accept_invitation!.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "invitation"
+                ],
+                "summary": "Accept an invitation",
+                "parameters": [
+                    {
+                        "description": "invitation token and password",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.AcceptInvitationRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.AcceptInvitationReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/invitation/resend": {
+            "post": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Gets detailed information of a users specified by the given id in the path.",
+                "description": "Replaces the invitation token of a pending invitee and mails the new raw token, the invitation limit is not used again. Only the inviter of the invitee or an admin may resend it.",
                 "consumes": [
                     "application/json"
                 ],
@@ -275,34 +480,38 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "invitation"
                 ],
-                "summary": "Get a users by id",
+                "summary": "Resend an invitation",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "id",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "email of the invitee",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.ResendInvitationRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.GetUsersByIDReply"
+                            "$ref": "#/definitions/types.ResendInvitationReply"
                         }
                     }
                 }
-            },
-            "put": {
+            }
+        },
+        "/api/v1/users/invitees": {
+            "get": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Updates the specified users by given id in the path, support partial update.",
+                "description": "Returns a paginated list of the users invited by the caller, accepted or not.",
                 "consumes": [
                     "application/json"
                 ],
@@ -310,43 +519,43 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "invitation"
                 ],
-                "summary": "Update a users by id",
+                "summary": "List my invitees",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "id",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "type": "integer",
+                        "default": 0,
+                        "description": "page number, starting from 0",
+                        "name": "page",
+                        "in": "query"
                     },
                     {
-                        "description": "users information",
-                        "name": "data",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/types.UpdateUsersByIDRequest"
-                        }
+                        "type": "integer",
+                        "default": 10,
+                        "description": "number per page, at most 100",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.UpdateUsersByIDReply"
+                            "$ref": "#/definitions/types.ListInviteesReply"
                         }
                     }
                 }
-            },
-            "delete": {
+            }
+        },
+        "/api/v1/users/list": {
+            "get": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Deletes a existing users identified by the given id in the path.",
+                "description": "Returns a page of userss in keyset order, pass nextCursor or prevCursor of a response as cursor to get the page after or before it. The cursor is signed and carries its sort, a sort parameter different from it is refused.",
                 "consumes": [
                     "application/json"
                 ],
@@ -356,61 +565,2171 @@ const docTemplate = `{
                 "tags": [
                     "users"
                 ],
-                "summary": "Delete a users by id",
+                "summary": "Get a page of userss by cursor",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "id",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "nextCursor or prevCursor of the previous page, empty for the first page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 10,
+                        "description": "number per page, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "comma separated json names of the fields to return, e.g. email,chineseName,clerkCode, the id is always returned",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "-id",
+                        "description": "sort by id, created_at, updated_at, email, chinese_name, clerk_code or sign_in_count, and the ",
+                        "name": "sort",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.DeleteUsersByIDReply"
+                            "$ref": "#/definitions/types.ListUserssByCursorReply"
                         }
                     }
                 }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a paginated list of users based on query filters, including page number and size.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get a paginated list of userss by custom conditions",
+                "parameters": [
+                    {
+                        "description": "query parameters",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.Params"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "comma separated json names of the fields to return, e.g. email,chineseName,clerkCode, the id is always returned",
+                        "name": "fields",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ListUserssReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/list/ids": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a list of users that match the list of id.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Batch get users by ids",
+                "parameters": [
+                    {
+                        "description": "id array",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.ListUserssByIDsRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "comma separated json names of the fields to return, e.g. email,chineseName,clerkCode, the id is always returned",
+                        "name": "fields",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ListUserssByIDsReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/me": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Gets detailed information of the caller, resolved from the jwt claims or from the warden user of the rails session.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get the current users",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.GetUsersByIDReply"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Updates the preferences and phone numbers of the caller, any other field in the request body is ignored.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Update the current users",
+                "parameters": [
+                    {
+                        "description": "users information",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.UpdateMeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.UpdateUsersByIDReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/password/forgot": {
+            "post": {
+                "description": "Stores the digest of a new reset password token and mails the raw token to the users, like devise send_reset_password_instructions. The reply is the same whether the email exists or not and is sent before the token is stored and mailed, a failure of either is only logged.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Send the reset password instructions",
+                "parameters": [
+                    {
+                        "description": "email",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.ForgotPasswordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ForgotPasswordReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/password/reset": {
+            "put": {
+                "description": "Verifies the reset password token and its expiry window, sets the new password and clears reset_password_token and reset_password_sent_at, like devise reset_password_by_token. A locked account is unlocked when the email unlock strategy is enabled.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Reset the password",
+                "parameters": [
+                    {
+                        "description": "reset password token and new password",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.ResetPasswordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ResetPasswordReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/search": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a page of the userss matching every keyword of q by email, chineseName, clerkCode, mobile, positionTitle or majorName, the best matches first. Exact matches rank above prefix, substring and one typo matches, chineseName also matches by its full pinyin and initials, e.g. zs finds 张三.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Search userss by keywords",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "keywords separated by spaces",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "default": 0,
+                        "description": "page number, starting from 0",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 10,
+                        "description": "number per page, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.SearchUserssReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/trash": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a page of the deactivated userss, the last deleted first. They are restored by their id until they are purged after the retention period.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "List the deleted userss",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "default": 0,
+                        "description": "page number, starting from 0",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 20,
+                        "description": "number per page, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ListTrashUserssReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/unlock": {
+            "post": {
+                "description": "Unlocks an account locked after too many failed sign in attempts, the token is the raw unlock token sent with the unlock instructions, like devise unlock_access_by_token.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Unlock an account",
+                "parameters": [
+                    {
+                        "description": "unlock token",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.UnlockUsersRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.UnlockUsersReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/webhooks": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the webhooks subscribed to the users events in the order they were created, without their secrets.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "List the webhooks",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ListUsersWebhooksReply"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Registers a receiver of the users events, it gets a POST of each event of the following writes that has one of the event types and changed one of the columns, an empty list matches them all. Each request carries X-Webhook-Event, X-Webhook-Delivery, X-Webhook-Timestamp and X-Webhook-Signature, sha256= and the hex HMAC-SHA256 of the timestamp, a dot and the body keyed by the secret. The secret is generated when it is not given and is only returned here.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Subscribe a webhook to the users events",
+                "parameters": [
+                    {
+                        "description": "webhook information",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.CreateUsersWebhookRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.CreateUsersWebhookReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/webhooks/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Gets the webhook identified by the given id in the path, without its secret.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "webhook id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.GetUsersWebhookReply"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Replaces the url and the filters of the webhook identified by the given id in the path, an empty secret keeps the current one and a null active keeps its state. A disabled webhook gets no new deliveries and its pending ones are dead. The deliveries already queued keep the event they were queued for.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Update a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "webhook id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "webhook information",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.UpdateUsersWebhookRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.UpdateUsersWebhookReply"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Deletes the webhook identified by the given id in the path with its delivery log, the pending deliveries are not sent.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Delete a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "webhook id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.DeleteUsersWebhookReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/webhooks/{id}/deliveries": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a page of the deliveries of the webhook identified by the given id in the path, the last queued first, with the outcome of their last attempt. A failed delivery is retried with a backoff that doubles after each attempt, it is dead once its attempts run out.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "List the deliveries of a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "webhook id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "pending",
+                            "succeeded",
+                            "dead"
+                        ],
+                        "type": "string",
+                        "description": "keep the deliveries in this state",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 0,
+                        "description": "page number, starting from 0",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 20,
+                        "description": "number per page, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ListUsersWebhookDeliveriesReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/webhooks/{id}/deliveries/{deliveryID}/redeliver": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Queues a new delivery of the event of the delivery in the path, whatever its state, it is sent at the next poll with all its attempts. Receivers tell a redelivery apart by the offset in the event. NotFound when the webhook has no such delivery.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Send a delivery of a webhook again",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "webhook id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "delivery id",
+                        "name": "deliveryID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.RedeliverUsersWebhookReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Gets detailed information of a users specified by the given id in the path. Secret columns are never returned, callers with the admin role claim get the admin projection.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get a users by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "etag of a cached copy, answered with 304 while it is still current",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "comma separated json names of the fields to return, e.g. email,chineseName,clerkCode, the id is always returned",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 time, the users is returned as it was then from its versions, NotFound when it did not exist or was deleted then, ErrHistoryUnavailable when it was written since without a version recorded until then. Not cached, there is no etag.",
+                        "name": "asOf",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.GetUsersByIDReply"
+                        }
+                    },
+                    "304": {
+                        "description": "not modified"
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Updates the specified users by given id in the path, support partial update, for admins only, users update their own record with PUT /api/v1/users/me. A new email is stored in unconfirmedEmail and only replaces email once confirmed with the mailed token, like devise reconfirmable. A request that fails validation gets InvalidParams with data.errors listing each field, see types.FieldError.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Update a users by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "etag returned by GetByID, the update is refused with 412 and the current users when the row has changed since",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "description": "users information",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.UpdateUsersByIDRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.UpdateUsersByIDReply"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/types.GetUsersByIDReply"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Deactivates a existing users identified by the given id in the path, it moves to the trash where it can be restored until it is purged after the retention period.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Delete a users by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.DeleteUsersByIDReply"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Applies a json merge patch (RFC 7396) to the specified users, a member set to null clears the column and zero values are written, absent members are left alone. Members must be patchable columns, email and password have their own routes.",
+                "consumes": [
+                    "application/merge-patch+json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Patch a users by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "members of types.UpdateUsersByIDRequest",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.UpdateUsersByIDReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/{id}/history": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a page of the audit trail of the users identified by the given id in the path, the last write first. Each entry has the caller, the request id and the columns it changed with their values before and after, the secret columns read [FILTERED]. The trail of a deleted users stays readable.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "List the writes of a users",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "default": 0,
+                        "description": "page number, starting from 0",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 20,
+                        "description": "number per page, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ListUsersHistoryReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/{id}/password": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Verifies the current password of the users identified by the given id in the path, then stores the bcrypt digest of the new password.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Change the password of a users",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "current and new password",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.ChangeUsersPasswordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ChangeUsersPasswordReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/{id}/restore": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Clears the deactivation of the users identified by the given id in the path, NotFound when it is not in the trash.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Restore a deleted users",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.RestoreUsersByIDReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/{id}/versions": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a page of the versions of the users identified by the given id in the path, the last one first. A version is the record as it was after a write, the secret columns are left empty. The versions of a deleted users stay readable.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "List the versions of a users",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "default": 0,
+                        "description": "page number, starting from 0",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 20,
+                        "description": "number per page, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ListUsersVersionsReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/{id}/versions/{version}/diff": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the columns that differ from the version against to the version in the path, with their values in both. Against defaults to the version before, 0 is before the users was created. NotFound when either version does not exist.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Compare two versions of a users",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "version number",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "version number to compare with, the version before by default",
+                        "name": "against",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.DiffUsersVersionsReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/{id}/versions/{version}/revert": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Writes the profile columns of the version in the path back to the users in one transaction, the revert is audited and recorded as a new version. Email, password, sign in and confirmation columns are left alone. NotFound when the users is in the trash or has no such version.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Revert a users to one of its versions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "version number",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.RevertUsersReply"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "types.AcceptInvitationReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object"
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.AcceptInvitationRequest": {
+            "type": "object",
+            "required": [
+                "password",
+                "token"
+            ],
+            "properties": {
+                "password": {
+                    "description": "plaintext password of the new account",
+                    "type": "string",
+                    "maxLength": 128,
+                    "minLength": 6
+                },
+                "token": {
+                    "description": "raw invitation token from the invitation instructions",
+                    "type": "string"
+                }
+            }
+        },
+        "types.ChangeUsersPasswordReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object"
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.ChangeUsersPasswordRequest": {
+            "type": "object",
+            "required": [
+                "currentPassword",
+                "password"
+            ],
+            "properties": {
+                "currentPassword": {
+                    "description": "plaintext current password",
+                    "type": "string"
+                },
+                "password": {
+                    "description": "plaintext new password",
+                    "type": "string",
+                    "maxLength": 128,
+                    "minLength": 6
+                }
+            }
+        },
+        "types.Column": {
+            "type": "object",
+            "properties": {
+                "exp": {
+                    "description": "expressions, which default to = when the value is null, have =, !=, \u003e, \u003e=, \u003c, \u003c=, like",
+                    "type": "string"
+                },
+                "logic": {
+                    "description": "logical type, default value is \"and\", support \u0026, and, ||, or",
+                    "type": "string"
+                },
+                "name": {
+                    "description": "column name",
+                    "type": "string"
+                },
+                "value": {
+                    "description": "column value"
+                }
+            }
+        },
+        "types.Conditions": {
+            "type": "object",
+            "properties": {
+                "columns": {
+                    "description": "columns info",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/types.Column"
+                    }
+                }
+            }
+        },
+        "types.ConfirmUsersReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object"
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.CreateUsersReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "id": {
+                            "description": "id",
+                            "type": "integer"
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.CreateUsersRequest": {
+            "type": "object",
+            "required": [
+                "email",
+                "password"
+            ],
+            "properties": {
+                "chineseName": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "clerkCode": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "confirmationSentAt": {
+                    "type": "string"
+                },
+                "confirmedAt": {
+                    "type": "string"
+                },
+                "currentSignInAt": {
+                    "type": "string"
+                },
+                "currentSignInIP": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "deskPhone": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "email": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "entryCompanyDate": {
+                    "type": "string"
+                },
+                "failedAttempts": {
+                    "type": "integer",
+                    "minimum": 0
+                },
+                "gender": {
+                    "description": "enum of true and false, anything else is rejected when the body is decoded",
+                    "type": "boolean"
+                },
+                "invitationAcceptedAt": {
+                    "type": "string"
+                },
+                "invitationCreatedAt": {
+                    "type": "string"
+                },
+                "invitationLimit": {
+                    "type": "integer",
+                    "minimum": 0
+                },
+                "invitationSentAt": {
+                    "type": "string"
+                },
+                "invitationsCount": {
+                    "type": "integer",
+                    "minimum": 0
+                },
+                "invitedByID": {
+                    "type": "integer"
+                },
+                "invitedByType": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "jobLevel": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "lastSignInAt": {
+                    "type": "string"
+                },
+                "lastSignInIP": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "lockedAt": {
+                    "type": "string"
+                },
+                "majorCode": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "majorName": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "mobile": {
+                    "description": "mainland china mobile number, +86 is optional",
+                    "type": "string"
+                },
+                "newUI": {
+                    "type": "boolean"
+                },
+                "openInNewTab": {
+                    "type": "boolean"
+                },
+                "password": {
+                    "description": "plaintext, hashed with bcrypt before it is stored",
+                    "type": "string",
+                    "maxLength": 128,
+                    "minLength": 6
+                },
+                "perPage": {
+                    "description": "0 keeps the default of 12",
+                    "type": "integer",
+                    "maximum": 100,
+                    "minimum": 1
+                },
+                "positionChangedInLastMonth": {
+                    "type": "boolean"
+                },
+                "positionNcPkPost": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "positionTitle": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "preSsoID": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "rememberCreatedAt": {
+                    "type": "string"
+                },
+                "resetPasswordSentAt": {
+                    "type": "string"
+                },
+                "signInCount": {
+                    "type": "integer",
+                    "minimum": 0
+                },
+                "unconfirmedEmail": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "wecomID": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "windowsSid": {
+                    "type": "string",
+                    "maxLength": 255
+                }
+            }
+        },
+        "types.CreateUsersWebhookReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "secret": {
+                            "description": "key of the X-Webhook-Signature of the deliveries, not shown again",
+                            "type": "string"
+                        },
+                        "webhook": {
+                            "$ref": "#/definitions/types.UsersWebhookObjDetail"
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.CreateUsersWebhookRequest": {
+            "type": "object",
+            "required": [
+                "url"
+            ],
+            "properties": {
+                "active": {
+                    "description": "true by default",
+                    "type": "boolean"
+                },
+                "columns": {
+                    "description": "an event is delivered when it changed one of these columns, empty for any",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "events": {
+                    "description": "event types to deliver, empty for all of them",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "secret": {
+                    "description": "key of the signatures, generated when empty",
+                    "type": "string",
+                    "maxLength": 255,
+                    "minLength": 16
+                },
+                "url": {
+                    "description": "gets a POST of each event it matches",
+                    "type": "string",
+                    "maxLength": 2048
+                }
+            }
+        },
+        "types.DeleteUsersByIDReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object"
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.DeleteUsersWebhookReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object"
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.DeleteUserssByIDsReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object"
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.DeleteUserssByIDsRequest": {
+            "type": "object",
+            "properties": {
+                "ids": {
+                    "description": "id list",
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "types.DiffUsersVersionsReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "changes": {
+                            "description": "by column name",
+                            "type": "object",
+                            "additionalProperties": {
+                                "$ref": "#/definitions/types.UsersChangeObj"
+                            }
+                        },
+                        "from": {
+                            "description": "0 is before the users was created",
+                            "type": "integer"
+                        },
+                        "to": {
+                            "type": "integer"
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.FieldError": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "description": "json name of the field, dotted for nested fields",
+                    "type": "string"
+                },
+                "message": {
+                    "description": "readable reason",
+                    "type": "string"
+                },
+                "param": {
+                    "description": "parameter of the rule, such as 255 for max=255, the expected type for type",
+                    "type": "string"
+                },
+                "rule": {
+                    "description": "failed rule, such as required, email, max, mobile, or type when the json value has the wrong type",
+                    "type": "string"
+                }
+            }
+        },
+        "types.ForgotPasswordReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object"
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.ForgotPasswordRequest": {
+            "type": "object",
+            "required": [
+                "email"
+            ],
+            "properties": {
+                "email": {
+                    "description": "email of the users",
+                    "type": "string"
+                }
+            }
+        },
+        "types.GetUsersByConditionReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "users": {
+                            "$ref": "#/definitions/types.UsersObjDetail"
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.GetUsersByIDReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "users": {
+                            "$ref": "#/definitions/types.UsersObjDetail"
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.GetUsersWebhookReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "webhook": {
+                            "$ref": "#/definitions/types.UsersWebhookObjDetail"
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.ImportUsersReport": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "dryRun": {
+                    "description": "nothing was written",
+                    "type": "boolean"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "rows": {
+                    "description": "the blank rows are left out",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/types.ImportUsersRowResult"
+                    }
+                },
+                "unchanged": {
+                    "type": "integer"
+                },
+                "updated": {
+                    "type": "integer"
+                }
+            }
+        },
+        "types.ImportUsersRowResult": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "description": "created, updated, unchanged or failed, what would be done in a dry run",
+                    "type": "string"
+                },
+                "errors": {
+                    "description": "why the row failed",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/types.FieldError"
+                    }
+                },
+                "id": {
+                    "description": "id of the users, 0 when it failed or would be created",
+                    "type": "integer"
+                },
+                "row": {
+                    "description": "number of the row, the header is row 1",
+                    "type": "integer"
+                }
+            }
+        },
+        "types.ImportUserssReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/types.ImportUsersReport"
+                        }
+                    ]
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.InviteUsersReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "id": {
+                            "description": "id of the invitee",
+                            "type": "integer"
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.InviteUsersRequest": {
+            "type": "object",
+            "required": [
+                "email"
+            ],
+            "properties": {
+                "email": {
+                    "description": "email of the invitee",
+                    "type": "string"
+                }
+            }
+        },
+        "types.InviteeObjDetail": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "invitationAcceptedAt": {
+                    "type": "string"
+                },
+                "invitationSentAt": {
+                    "type": "string"
+                }
             }
-        }
-    },
-    "definitions": {
-        "types.Column": {
+        },
+        "types.ListInviteesReply": {
             "type": "object",
             "properties": {
-                "exp": {
-                    "description": "expressions, which default to = when the value is null, have =, !=, \u003e, \u003e=, \u003c, \u003c=, like",
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "invitees": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/types.InviteeObjDetail"
+                            }
+                        },
+                        "total": {
+                            "type": "integer"
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
                     "type": "string"
+                }
+            }
+        },
+        "types.ListTrashUserssReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
                 },
-                "logic": {
-                    "description": "logical type, default value is \"and\", support \u0026, and, ||, or",
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "total": {
+                            "type": "integer"
+                        },
+                        "userss": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/types.UsersAdminObjDetail"
+                            }
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.ListUsersHistoryReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "history": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/types.UsersAuditObjDetail"
+                            }
+                        },
+                        "total": {
+                            "type": "integer"
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.ListUsersVersionsReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "total": {
+                            "type": "integer"
+                        },
+                        "versions": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/types.UsersVersionObjDetail"
+                            }
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.ListUsersWebhookDeliveriesReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "deliveries": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/types.UsersWebhookDeliveryObjDetail"
+                            }
+                        },
+                        "total": {
+                            "type": "integer"
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.ListUsersWebhooksReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "webhooks": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/types.UsersWebhookObjDetail"
+                            }
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.ListUserssByCursorReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "nextCursor": {
+                            "description": "cursor of the page after, empty on the last page",
+                            "type": "string"
+                        },
+                        "prevCursor": {
+                            "description": "cursor of the page before, empty on the first page",
+                            "type": "string"
+                        },
+                        "userss": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/types.UsersObjDetail"
+                            }
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.ListUserssByIDsReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "userss": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/types.UsersObjDetail"
+                            }
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.ListUserssByIDsRequest": {
+            "type": "object",
+            "properties": {
+                "ids": {
+                    "description": "id list",
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "types.ListUserssReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "userss": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/types.UsersObjDetail"
+                            }
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
                     "type": "string"
+                }
+            }
+        },
+        "types.Params": {
+            "type": "object",
+            "properties": {
+                "columns": {
+                    "description": "query conditions",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/types.Column"
+                    }
+                },
+                "limit": {
+                    "description": "lines per page",
+                    "type": "integer"
+                },
+                "page": {
+                    "description": "page number, starting from page 0",
+                    "type": "integer"
                 },
-                "name": {
-                    "description": "column name",
+                "sort": {
+                    "description": "sorted fields, multi-column sorting separated by commas",
                     "type": "string"
-                },
-                "value": {
-                    "description": "column value"
                 }
             }
         },
-        "types.Conditions": {
+        "types.RedeliverUsersWebhookReply": {
             "type": "object",
             "properties": {
-                "columns": {
-                    "description": "columns info",
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/types.Column"
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "delivery": {
+                            "description": "the new delivery, sent at the next poll",
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/types.UsersWebhookDeliveryObjDetail"
+                                }
+                            ]
+                        }
                     }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
                 }
             }
         },
-        "types.CreateUsersReply": {
+        "types.ReplayUsersEventsReply": {
             "type": "object",
             "properties": {
                 "code": {
@@ -421,8 +2740,8 @@ const docTemplate = `{
                     "description": "return data",
                     "type": "object",
                     "properties": {
-                        "id": {
-                            "description": "id",
+                        "replayed": {
+                            "description": "number of events to publish again",
                             "type": "integer"
                         }
                     }
@@ -433,141 +2752,16 @@ const docTemplate = `{
                 }
             }
         },
-        "types.CreateUsersRequest": {
+        "types.ReplayUsersEventsRequest": {
             "type": "object",
             "properties": {
-                "chineseName": {
-                    "type": "string"
-                },
-                "clerkCode": {
-                    "type": "string"
-                },
-                "confirmationSentAt": {
-                    "type": "string"
-                },
-                "confirmationToken": {
-                    "type": "string"
-                },
-                "confirmedAt": {
-                    "type": "string"
-                },
-                "currentSignInAt": {
-                    "type": "string"
-                },
-                "currentSignInIP": {
-                    "type": "string"
-                },
-                "deskPhone": {
-                    "type": "string"
-                },
-                "email": {
-                    "type": "string"
-                },
-                "encryptedPassword": {
-                    "type": "string"
-                },
-                "entryCompanyDate": {
-                    "type": "string"
-                },
-                "failedAttempts": {
-                    "type": "integer"
-                },
-                "gender": {
-                    "type": "boolean"
-                },
-                "invitationAcceptedAt": {
-                    "type": "string"
-                },
-                "invitationCreatedAt": {
-                    "type": "string"
-                },
-                "invitationLimit": {
-                    "type": "integer"
-                },
-                "invitationSentAt": {
-                    "type": "string"
-                },
-                "invitationToken": {
-                    "type": "string"
-                },
-                "invitationsCount": {
-                    "type": "integer"
-                },
-                "invitedByID": {
-                    "type": "integer"
-                },
-                "invitedByType": {
-                    "type": "string"
-                },
-                "jobLevel": {
-                    "type": "string"
-                },
-                "lastSignInAt": {
-                    "type": "string"
-                },
-                "lastSignInIP": {
-                    "type": "string"
-                },
-                "lockedAt": {
-                    "type": "string"
-                },
-                "majorCode": {
-                    "type": "string"
-                },
-                "majorName": {
-                    "type": "string"
-                },
-                "mobile": {
-                    "type": "string"
-                },
-                "newUI": {
-                    "type": "boolean"
-                },
-                "openInNewTab": {
-                    "type": "boolean"
-                },
-                "perPage": {
-                    "type": "integer"
-                },
-                "positionChangedInLastMonth": {
-                    "type": "boolean"
-                },
-                "positionNcPkPost": {
-                    "type": "string"
-                },
-                "positionTitle": {
-                    "type": "string"
-                },
-                "preSsoID": {
-                    "type": "string"
-                },
-                "rememberCreatedAt": {
-                    "type": "string"
-                },
-                "resetPasswordSentAt": {
-                    "type": "string"
-                },
-                "resetPasswordToken": {
-                    "type": "string"
-                },
-                "signInCount": {
+                "offset": {
+                    "description": "the events from this offset on are published again, 0 is all of them",
                     "type": "integer"
-                },
-                "unconfirmedEmail": {
-                    "type": "string"
-                },
-                "unlockToken": {
-                    "type": "string"
-                },
-                "wecomID": {
-                    "type": "string"
-                },
-                "windowsSid": {
-                    "type": "string"
                 }
             }
         },
-        "types.DeleteUsersByIDReply": {
+        "types.ResendInvitationReply": {
             "type": "object",
             "properties": {
                 "code": {
@@ -584,7 +2778,19 @@ const docTemplate = `{
                 }
             }
         },
-        "types.DeleteUserssByIDsReply": {
+        "types.ResendInvitationRequest": {
+            "type": "object",
+            "required": [
+                "email"
+            ],
+            "properties": {
+                "email": {
+                    "description": "email of the invitee",
+                    "type": "string"
+                }
+            }
+        },
+        "types.ResetPasswordReply": {
             "type": "object",
             "properties": {
                 "code": {
@@ -601,20 +2807,26 @@ const docTemplate = `{
                 }
             }
         },
-        "types.DeleteUserssByIDsRequest": {
+        "types.ResetPasswordRequest": {
             "type": "object",
+            "required": [
+                "password",
+                "token"
+            ],
             "properties": {
-                "ids": {
-                    "description": "id list",
-                    "type": "array",
-                    "minItems": 1,
-                    "items": {
-                        "type": "integer"
-                    }
+                "password": {
+                    "description": "new plaintext password",
+                    "type": "string",
+                    "maxLength": 128,
+                    "minLength": 6
+                },
+                "token": {
+                    "description": "raw reset password token from the instructions",
+                    "type": "string"
                 }
             }
         },
-        "types.GetUsersByConditionReply": {
+        "types.RestoreUsersByIDReply": {
             "type": "object",
             "properties": {
                 "code": {
@@ -623,12 +2835,7 @@ const docTemplate = `{
                 },
                 "data": {
                     "description": "return data",
-                    "type": "object",
-                    "properties": {
-                        "users": {
-                            "$ref": "#/definitions/types.UsersObjDetail"
-                        }
-                    }
+                    "type": "object"
                 },
                 "msg": {
                     "description": "return information description",
@@ -636,7 +2843,7 @@ const docTemplate = `{
                 }
             }
         },
-        "types.GetUsersByIDReply": {
+        "types.RevertUsersReply": {
             "type": "object",
             "properties": {
                 "code": {
@@ -645,12 +2852,7 @@ const docTemplate = `{
                 },
                 "data": {
                     "description": "return data",
-                    "type": "object",
-                    "properties": {
-                        "users": {
-                            "$ref": "#/definitions/types.UsersObjDetail"
-                        }
-                    }
+                    "type": "object"
                 },
                 "msg": {
                     "description": "return information description",
@@ -658,7 +2860,19 @@ const docTemplate = `{
                 }
             }
         },
-        "types.ListUserssByIDsReply": {
+        "types.SearchUsersResult": {
+            "type": "object",
+            "properties": {
+                "score": {
+                    "description": "the higher the better the match",
+                    "type": "number"
+                },
+                "users": {
+                    "$ref": "#/definitions/types.UsersObjDetail"
+                }
+            }
+        },
+        "types.SearchUserssReply": {
             "type": "object",
             "properties": {
                 "code": {
@@ -669,11 +2883,15 @@ const docTemplate = `{
                     "description": "return data",
                     "type": "object",
                     "properties": {
-                        "userss": {
+                        "results": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/types.UsersObjDetail"
+                                "$ref": "#/definitions/types.SearchUsersResult"
                             }
+                        },
+                        "total": {
+                            "description": "number of matches of all pages",
+                            "type": "integer"
                         }
                     }
                 },
@@ -683,20 +2901,55 @@ const docTemplate = `{
                 }
             }
         },
-        "types.ListUserssByIDsRequest": {
+        "types.SignInReply": {
             "type": "object",
             "properties": {
-                "ids": {
-                    "description": "id list",
-                    "type": "array",
-                    "minItems": 1,
-                    "items": {
-                        "type": "integer"
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "expiresIn": {
+                            "description": "token lifetime in seconds",
+                            "type": "integer"
+                        },
+                        "id": {
+                            "description": "id of the signed in users",
+                            "type": "integer"
+                        },
+                        "token": {
+                            "description": "jwt, send it as \"Authorization: Bearer token\"",
+                            "type": "string"
+                        }
                     }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
                 }
             }
         },
-        "types.ListUserssReply": {
+        "types.SignInRequest": {
+            "type": "object",
+            "required": [
+                "email",
+                "password"
+            ],
+            "properties": {
+                "email": {
+                    "description": "email of the users",
+                    "type": "string"
+                },
+                "password": {
+                    "description": "plaintext password",
+                    "type": "string"
+                }
+            }
+        },
+        "types.UnlockUsersReply": {
             "type": "object",
             "properties": {
                 "code": {
@@ -705,15 +2958,7 @@ const docTemplate = `{
                 },
                 "data": {
                     "description": "return data",
-                    "type": "object",
-                    "properties": {
-                        "userss": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/types.UsersObjDetail"
-                            }
-                        }
-                    }
+                    "type": "object"
                 },
                 "msg": {
                     "description": "return information description",
@@ -721,27 +2966,40 @@ const docTemplate = `{
                 }
             }
         },
-        "types.Params": {
+        "types.UnlockUsersRequest": {
             "type": "object",
+            "required": [
+                "token"
+            ],
             "properties": {
-                "columns": {
-                    "description": "query conditions",
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/types.Column"
-                    }
+                "token": {
+                    "description": "raw unlock token from the unlock instructions",
+                    "type": "string"
+                }
+            }
+        },
+        "types.UpdateMeRequest": {
+            "type": "object",
+            "properties": {
+                "deskPhone": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "mobile": {
+                    "description": "mainland china mobile number, +86 is optional",
+                    "type": "string"
                 },
-                "limit": {
-                    "description": "lines per page",
-                    "type": "integer"
+                "newUI": {
+                    "type": "boolean"
                 },
-                "page": {
-                    "description": "page number, starting from page 0",
-                    "type": "integer"
+                "openInNewTab": {
+                    "type": "boolean"
                 },
-                "sort": {
-                    "description": "sorted fields, multi-column sorting separated by commas",
-                    "type": "string"
+                "perPage": {
+                    "description": "0 keeps the current value",
+                    "type": "integer",
+                    "maximum": 100,
+                    "minimum": 1
                 }
             }
         },
@@ -766,17 +3024,16 @@ const docTemplate = `{
             "type": "object",
             "properties": {
                 "chineseName": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "clerkCode": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "confirmationSentAt": {
                     "type": "string"
                 },
-                "confirmationToken": {
-                    "type": "string"
-                },
                 "confirmedAt": {
                     "type": "string"
                 },
@@ -784,24 +3041,26 @@ const docTemplate = `{
                     "type": "string"
                 },
                 "currentSignInIP": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "deskPhone": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "email": {
-                    "type": "string"
-                },
-                "encryptedPassword": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "entryCompanyDate": {
                     "type": "string"
                 },
                 "failedAttempts": {
-                    "type": "integer"
+                    "type": "integer",
+                    "minimum": 0
                 },
                 "gender": {
+                    "description": "enum of true and false, anything else is rejected when the body is decoded",
                     "type": "boolean"
                 },
                 "id": {
@@ -815,42 +3074,47 @@ const docTemplate = `{
                     "type": "string"
                 },
                 "invitationLimit": {
-                    "type": "integer"
+                    "type": "integer",
+                    "minimum": 0
                 },
                 "invitationSentAt": {
                     "type": "string"
                 },
-                "invitationToken": {
-                    "type": "string"
-                },
                 "invitationsCount": {
-                    "type": "integer"
+                    "type": "integer",
+                    "minimum": 0
                 },
                 "invitedByID": {
                     "type": "integer"
                 },
                 "invitedByType": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "jobLevel": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "lastSignInAt": {
                     "type": "string"
                 },
                 "lastSignInIP": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "lockedAt": {
                     "type": "string"
                 },
                 "majorCode": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "majorName": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "mobile": {
+                    "description": "mainland china mobile number, +86 is optional",
                     "type": "string"
                 },
                 "newUI": {
@@ -859,20 +3123,32 @@ const docTemplate = `{
                 "openInNewTab": {
                     "type": "boolean"
                 },
+                "password": {
+                    "description": "plaintext, hashed with bcrypt before it is stored",
+                    "type": "string",
+                    "maxLength": 128,
+                    "minLength": 6
+                },
                 "perPage": {
-                    "type": "integer"
+                    "description": "0 keeps the current value",
+                    "type": "integer",
+                    "maximum": 100,
+                    "minimum": 1
                 },
                 "positionChangedInLastMonth": {
                     "type": "boolean"
                 },
                 "positionNcPkPost": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "positionTitle": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "preSsoID": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "rememberCreatedAt": {
                     "type": "string"
@@ -880,27 +3156,83 @@ const docTemplate = `{
                 "resetPasswordSentAt": {
                     "type": "string"
                 },
-                "resetPasswordToken": {
-                    "type": "string"
-                },
                 "signInCount": {
-                    "type": "integer"
+                    "type": "integer",
+                    "minimum": 0
                 },
                 "unconfirmedEmail": {
-                    "type": "string"
-                },
-                "unlockToken": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "wecomID": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
                 },
                 "windowsSid": {
+                    "type": "string",
+                    "maxLength": 255
+                }
+            }
+        },
+        "types.UpdateUsersWebhookReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "webhook": {
+                            "$ref": "#/definitions/types.UsersWebhookObjDetail"
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
                     "type": "string"
                 }
             }
         },
-        "types.UsersObjDetail": {
+        "types.UpdateUsersWebhookRequest": {
+            "type": "object",
+            "required": [
+                "url"
+            ],
+            "properties": {
+                "active": {
+                    "description": "null keeps the current state",
+                    "type": "boolean"
+                },
+                "columns": {
+                    "description": "empty for any",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "events": {
+                    "description": "empty for all of them",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "secret": {
+                    "description": "empty keeps the current one",
+                    "type": "string",
+                    "maxLength": 255,
+                    "minLength": 16
+                },
+                "url": {
+                    "type": "string",
+                    "maxLength": 2048
+                }
+            }
+        },
+        "types.UsersAdminObjDetail": {
             "type": "object",
             "properties": {
                 "chineseName": {
@@ -912,9 +3244,6 @@ const docTemplate = `{
                 "confirmationSentAt": {
                     "type": "string"
                 },
-                "confirmationToken": {
-                    "type": "string"
-                },
                 "confirmedAt": {
                     "type": "string"
                 },
@@ -927,13 +3256,14 @@ const docTemplate = `{
                 "currentSignInIP": {
                     "type": "string"
                 },
-                "deskPhone": {
+                "deactivatedAt": {
+                    "description": "set while the users is in the trash",
                     "type": "string"
                 },
-                "email": {
+                "deskPhone": {
                     "type": "string"
                 },
-                "encryptedPassword": {
+                "email": {
                     "type": "string"
                 },
                 "entryCompanyDate": {
@@ -961,9 +3291,6 @@ const docTemplate = `{
                 "invitationSentAt": {
                     "type": "string"
                 },
-                "invitationToken": {
-                    "type": "string"
-                },
                 "invitationsCount": {
                     "type": "integer"
                 },
@@ -1021,16 +3348,121 @@ const docTemplate = `{
                 "resetPasswordSentAt": {
                     "type": "string"
                 },
-                "resetPasswordToken": {
-                    "type": "string"
-                },
                 "signInCount": {
                     "type": "integer"
                 },
                 "unconfirmedEmail": {
                     "type": "string"
                 },
-                "unlockToken": {
+                "updatedAt": {
+                    "type": "string"
+                },
+                "wecomID": {
+                    "type": "string"
+                },
+                "windowsSid": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.UsersAuditObjDetail": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "description": "create, update, delete, restore, revert or purge",
+                    "type": "string"
+                },
+                "actorID": {
+                    "type": "integer"
+                },
+                "actorType": {
+                    "description": "jwt or rails_session, empty when the write had no authenticated caller",
+                    "type": "string"
+                },
+                "changes": {
+                    "description": "by column name",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/types.UsersChangeObj"
+                    }
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "requestID": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.UsersChangeObj": {
+            "type": "object",
+            "properties": {
+                "after": {},
+                "before": {}
+            }
+        },
+        "types.UsersObjDetail": {
+            "type": "object",
+            "properties": {
+                "chineseName": {
+                    "type": "string"
+                },
+                "clerkCode": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "deskPhone": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "entryCompanyDate": {
+                    "type": "string"
+                },
+                "gender": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "description": "convert to uint64 id",
+                    "type": "integer"
+                },
+                "jobLevel": {
+                    "type": "string"
+                },
+                "majorCode": {
+                    "type": "string"
+                },
+                "majorName": {
+                    "type": "string"
+                },
+                "mobile": {
+                    "type": "string"
+                },
+                "newUI": {
+                    "type": "boolean"
+                },
+                "openInNewTab": {
+                    "type": "boolean"
+                },
+                "perPage": {
+                    "type": "integer"
+                },
+                "positionChangedInLastMonth": {
+                    "type": "boolean"
+                },
+                "positionNcPkPost": {
+                    "type": "string"
+                },
+                "positionTitle": {
+                    "type": "string"
+                },
+                "preSsoID": {
                     "type": "string"
                 },
                 "updatedAt": {
@@ -1043,6 +3475,111 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "types.UsersVersionObjDetail": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "description": "the write that made it, as in UsersAuditObjDetail",
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "users": {
+                    "description": "the record after the write, the secret columns are empty",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/types.UsersAdminObjDetail"
+                        }
+                    ]
+                },
+                "version": {
+                    "description": "numbered from 1 in the order of the writes",
+                    "type": "integer"
+                }
+            }
+        },
+        "types.UsersWebhookDeliveryObjDetail": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "description": "requests sent so far",
+                    "type": "integer"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "deliveredAt": {
+                    "type": "string"
+                },
+                "event": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "lastError": {
+                    "description": "why the last attempt failed",
+                    "type": "string"
+                },
+                "nextAttemptAt": {
+                    "description": "null unless pending",
+                    "type": "string"
+                },
+                "offset": {
+                    "description": "of the event, a redelivery has the offset of the delivery it repeats",
+                    "type": "integer"
+                },
+                "responseStatus": {
+                    "description": "http status of the last attempt, 0 when there was no response",
+                    "type": "integer"
+                },
+                "status": {
+                    "description": "pending, succeeded or dead",
+                    "type": "string"
+                },
+                "usersID": {
+                    "type": "integer"
+                },
+                "webhookID": {
+                    "type": "integer"
+                }
+            }
+        },
+        "types.UsersWebhookObjDetail": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "type": "boolean"
+                },
+                "columns": {
+                    "description": "empty for any",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "events": {
+                    "description": "empty for all of them",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "updatedAt": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
         }
     },
     "securityDefinitions": {
@@ -17,6 +17,8 @@ import (
 const (
 	// cache prefix key, must end with a colon
 	usersCachePrefixKey = "users:"
+	// cache prefix key of the natural key indexes, users:by:<key>:<value> holds the id
+	usersKeyIndexPrefixKey = "users:by:"
 	// UsersExpireTime expire time
 	UsersExpireTime = 5 * time.Minute
 )
@@ -32,6 +34,11 @@ type UsersCache interface {
 	Del(ctx context.Context, id uint64) error
 	SetPlaceholder(ctx context.Context, id uint64) error
 	IsPlaceholderErr(err error) bool
+
+	SetKeyIndex(ctx context.Context, key string, value string, id uint64, duration time.Duration) error
+	GetKeyIndex(ctx context.Context, key string, value string) (uint64, error)
+	DelKeyIndex(ctx context.Context, key string, value string) error
+	SetKeyIndexPlaceholder(ctx context.Context, key string, value string) error
 }
 
 // usersCache define a cache struct
@@ -151,3 +158,43 @@ func (c *usersCache) SetPlaceholder(ctx context.Context, id uint64) error {
 func (c *usersCache) IsPlaceholderErr(err error) bool {
 	return errors.Is(err, cache.ErrPlaceholder)
 }
+
+// GetUsersKeyIndexCacheKey cache key of a natural key index, values are compared case-insensitively like the mysql collation
+func (c *usersCache) GetUsersKeyIndexCacheKey(key string, value string) string {
+	return usersKeyIndexPrefixKey + key + ":" + strings.ToLower(value)
+}
+
+// SetKeyIndex map the value of a natural key column to the id
+func (c *usersCache) SetKeyIndex(ctx context.Context, key string, value string, id uint64, duration time.Duration) error {
+	if value == "" || id == 0 {
+		return nil
+	}
+	cacheKey := c.GetUsersKeyIndexCacheKey(key, value)
+	return c.cache.Set(ctx, cacheKey, &id, duration)
+}
+
+// GetKeyIndex get the id a natural key value maps to
+func (c *usersCache) GetKeyIndex(ctx context.Context, key string, value string) (uint64, error) {
+	var id uint64
+	cacheKey := c.GetUsersKeyIndexCacheKey(key, value)
+	err := c.cache.Get(ctx, cacheKey, &id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// DelKeyIndex delete the index of a natural key value
+func (c *usersCache) DelKeyIndex(ctx context.Context, key string, value string) error {
+	if value == "" {
+		return nil
+	}
+	cacheKey := c.GetUsersKeyIndexCacheKey(key, value)
+	return c.cache.Del(ctx, cacheKey)
+}
+
+// SetKeyIndexPlaceholder remember that no users has the natural key value
+func (c *usersCache) SetKeyIndexPlaceholder(ctx context.Context, key string, value string) error {
+	cacheKey := c.GetUsersKeyIndexCacheKey(key, value)
+	return c.cache.SetCacheWithNotFound(ctx, cacheKey)
+}
@@ -145,3 +145,36 @@ func TestNewUsersCache(t *testing.T) {
 	})
 	assert.NotNil(t, c)
 }
+
+func Test_usersCache_KeyIndex(t *testing.T) {
+	c := newUsersCache()
+	defer c.Close()
+
+	cache := c.ICache.(UsersCache)
+	record := c.TestDataSlice[0].(*model.Users)
+	err := cache.SetKeyIndex(c.Ctx, "email", "Foo@Bar.com", record.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// values are compared case-insensitively
+	id, err := cache.GetKeyIndex(c.Ctx, "email", "foo@bar.com")
+	assert.NoError(t, err)
+	assert.Equal(t, record.ID, id)
+
+	err = cache.DelKeyIndex(c.Ctx, "email", "FOO@BAR.COM")
+	assert.NoError(t, err)
+	_, err = cache.GetKeyIndex(c.Ctx, "email", "foo@bar.com")
+	assert.ErrorIs(t, err, database.ErrCacheNotFound)
+
+	// placeholder of a value no users has
+	err = cache.SetKeyIndexPlaceholder(c.Ctx, "clerk_code", "nobody")
+	assert.NoError(t, err)
+	_, err = cache.GetKeyIndex(c.Ctx, "clerk_code", "nobody")
+	assert.True(t, cache.IsPlaceholderErr(err))
+
+	// empty values are not indexed
+	assert.NoError(t, cache.SetKeyIndex(c.Ctx, "wecom_id", "", record.ID, time.Hour))
+	_, err = cache.GetKeyIndex(c.Ctx, "wecom_id", "")
+	assert.Error(t, err)
+}
@@ -30,13 +30,20 @@ func Set(conf *Config) {
 
 type Config struct {
 	App      App      `yaml:"app" json:"app"`
+	Authz    Authz    `yaml:"authz" json:"authz"`
+	Cursor   Cursor   `yaml:"cursor" json:"cursor"`
 	Database Database `yaml:"database" json:"database"`
+	Devise   Devise   `yaml:"devise" json:"devise"`
 	HTTP     HTTP     `yaml:"http" json:"http"`
 	Jaeger   Jaeger   `yaml:"jaeger" json:"jaeger"`
 	JWT      JWT      `yaml:"jwt" json:"jwt"`
 	Logger   Logger   `yaml:"logger" json:"logger"`
+	Mailer   Mailer   `yaml:"mailer" json:"mailer"`
+	Outbox   Outbox   `yaml:"outbox" json:"outbox"`
 	Rails    Rails    `yaml:"rails" json:"rails"`
 	Redis    Redis    `yaml:"redis" json:"redis"`
+	Trash    Trash    `yaml:"trash" json:"trash"`
+	Webhook  Webhook  `yaml:"webhook" json:"webhook"`
 }
 
 type TLS struct {
@@ -74,10 +81,67 @@ type Mysql struct {
 	MaxOpenConns    int    `yaml:"maxOpenConns" json:"maxOpenConns"`
 }
 
+type Authz struct {
+	AdminIDs []uint64 `yaml:"adminIDs" json:"adminIDs"`
+}
+
+type Cursor struct {
+	SigningKey string `yaml:"signingKey" json:"signingKey"`
+}
+
+type Trash struct {
+	RetentionDays int `yaml:"retentionDays" json:"retentionDays"`
+	PurgeInterval int `yaml:"purgeInterval" json:"purgeInterval"`
+}
+
+type Webhook struct {
+	Backoff      int `yaml:"backoff" json:"backoff"`
+	MaxAttempts  int `yaml:"maxAttempts" json:"maxAttempts"`
+	MaxBackoff   int `yaml:"maxBackoff" json:"maxBackoff"`
+	PollInterval int `yaml:"pollInterval" json:"pollInterval"`
+	Timeout      int `yaml:"timeout" json:"timeout"`
+}
+
+type Mailer struct {
+	ConfirmationURL  string `yaml:"confirmationURL" json:"confirmationURL"`
+	Driver           string `yaml:"driver" json:"driver"`
+	FileDir          string `yaml:"fileDir" json:"fileDir"`
+	From             string `yaml:"from" json:"from"`
+	InvitationURL    string `yaml:"invitationURL" json:"invitationURL"`
+	ResetPasswordURL string `yaml:"resetPasswordURL" json:"resetPasswordURL"`
+	SMTP             SMTP   `yaml:"smtp" json:"smtp"`
+	UnlockURL        string `yaml:"unlockURL" json:"unlockURL"`
+}
+
+type Outbox struct {
+	Driver        string `yaml:"driver" json:"driver"`
+	Kafka         Kafka  `yaml:"kafka" json:"kafka"`
+	Nats          Nats   `yaml:"nats" json:"nats"`
+	PollInterval  int    `yaml:"pollInterval" json:"pollInterval"`
+	PurgeInterval int    `yaml:"purgeInterval" json:"purgeInterval"`
+	RetentionDays int    `yaml:"retentionDays" json:"retentionDays"`
+}
+
+type Nats struct {
+	SubjectPrefix string `yaml:"subjectPrefix" json:"subjectPrefix"`
+	URL           string `yaml:"url" json:"url"`
+}
+
+type Kafka struct {
+	RestURL string `yaml:"restURL" json:"restURL"`
+	Topic   string `yaml:"topic" json:"topic"`
+}
+
+type SMTP struct {
+	Host     string `yaml:"host" json:"host"`
+	Password string `yaml:"password" json:"password"`
+	Port     int    `yaml:"port" json:"port"`
+	Username string `yaml:"username" json:"username"`
+}
+
 type Rails struct {
 	CookieName    string `yaml:"cookieName" json:"cookieName"`
 	SecretKeyBase string `yaml:"secretKeyBase" json:"secretKeyBase"`
-	UserID        int64  `yaml:"userID" json:"userID"`
 }
 
 type Redis struct {
@@ -92,6 +156,24 @@ type Database struct {
 	Mysql  Mysql  `yaml:"mysql" json:"mysql"`
 }
 
+type Devise struct {
+	AllowUnconfirmedAccessFor int    `yaml:"allowUnconfirmedAccessFor" json:"allowUnconfirmedAccessFor"`
+	ConfirmWithin             int    `yaml:"confirmWithin" json:"confirmWithin"`
+	InvitationLimit           int    `yaml:"invitationLimit" json:"invitationLimit"`
+	InviteFor                 int    `yaml:"inviteFor" json:"inviteFor"`
+	KeyGeneratorHashDigest    string `yaml:"keyGeneratorHashDigest" json:"keyGeneratorHashDigest"`
+	LockStrategy              string `yaml:"lockStrategy" json:"lockStrategy"`
+	MaximumAttempts           int    `yaml:"maximumAttempts" json:"maximumAttempts"`
+	Pepper                    string `yaml:"pepper" json:"pepper"`
+	Reconfirmable             bool   `yaml:"reconfirmable" json:"reconfirmable"`
+	RefuseUnconfirmed         bool   `yaml:"refuseUnconfirmed" json:"refuseUnconfirmed"`
+	ResetPasswordWithin       int    `yaml:"resetPasswordWithin" json:"resetPasswordWithin"`
+	SecretKey                 string `yaml:"secretKey" json:"secretKey"`
+	Stretches                 int    `yaml:"stretches" json:"stretches"`
+	UnlockIn                  int    `yaml:"unlockIn" json:"unlockIn"`
+	UnlockStrategy            string `yaml:"unlockStrategy" json:"unlockStrategy"`
+}
+
 type JWT struct {
 	Expire     int    `yaml:"expire" json:"expire"`
 	SigningKey string `yaml:"signingKey" json:"signingKey"`
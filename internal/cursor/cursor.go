@@ -0,0 +1,90 @@
+// Package cursor encodes the position of a keyset page into an opaque token, the token is signed
+// so that clients can pass it back but cannot forge the sort tuple it carries.
+package cursor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidCursor the token is malformed or its signature does not match
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+var (
+	mu  sync.RWMutex
+	key = randomKey()
+)
+
+// Cursor the boundary row of a page, Value is the sort column of that row as json and ID breaks
+// the ties between rows having the same Value
+type Cursor struct {
+	Sort     string          `json:"s"`           // sort column, "-" before it means descending
+	Value    json.RawMessage `json:"v"`           // sort column value of the boundary row
+	ID       uint64          `json:"i"`           // id of the boundary row
+	Backward bool            `json:"b,omitempty"` // page before the boundary row instead of after it
+}
+
+// Page the cursors of the pages around a page, nil when there is no page in that direction
+type Page struct {
+	Next *Cursor
+	Prev *Cursor
+}
+
+// Init set the signing key, an empty key makes a random one, then the cursors are only valid
+// for the current process.
+func Init(signingKey []byte) {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(signingKey) == 0 {
+		key = randomKey()
+		return
+	}
+	key = signingKey
+}
+
+func randomKey() []byte {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return b
+}
+
+func sign(payload string) string {
+	mu.RLock()
+	mac := hmac.New(sha256.New, key)
+	mu.RUnlock()
+	_, _ = mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Encode get the opaque token of a cursor
+func Encode(c *Cursor) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + sign(payload), nil
+}
+
+// Decode verify the signature of a token and get the cursor it carries
+func Decode(token string) (*Cursor, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(sign(payload))) {
+		return nil, ErrInvalidCursor
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &Cursor{}
+	if err = json.Unmarshal(data, c); err != nil || c.Sort == "" || len(c.Value) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,51 @@
+package cursor
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	Init([]byte("secret"))
+	defer Init(nil)
+
+	c := &Cursor{Sort: "-updated_at", Value: json.RawMessage(`"2024-01-02T03:04:05Z"`), ID: 7, Backward: true}
+	token, err := Encode(c)
+	assert.NoError(t, err)
+	assert.NotContains(t, token, "updated_at")
+
+	got, err := Decode(token)
+	assert.NoError(t, err)
+	assert.Equal(t, c, got)
+
+	// another key
+	Init([]byte("other"))
+	_, err = Decode(token)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestDecode_tampered(t *testing.T) {
+	Init([]byte("secret"))
+	defer Init(nil)
+
+	token, err := Encode(&Cursor{Sort: "id", Value: json.RawMessage(`7`), ID: 7})
+	assert.NoError(t, err)
+	payload, signature, _ := strings.Cut(token, ".")
+
+	forged, _ := Encode(&Cursor{Sort: "id", Value: json.RawMessage(`1`), ID: 1})
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	for _, token := range []string{
+		"",
+		payload,
+		payload + ".",
+		forgedPayload + "." + signature,
+		"!!!." + signature,
+	} {
+		_, err = Decode(token)
+		assert.ErrorIs(t, err, ErrInvalidCursor, token)
+	}
+}
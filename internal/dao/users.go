@@ -2,73 +2,197 @@ package dao
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/sync/singleflight"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
 
 	"github.com/go-dev-frame/sponge/pkg/logger"
 	"github.com/go-dev-frame/sponge/pkg/sgorm/query"
 	"github.com/go-dev-frame/sponge/pkg/utils"
 
 	"test-user-server/internal/cache"
+	"test-user-server/internal/cursor"
 	"test-user-server/internal/database"
 	"test-user-server/internal/model"
+	"test-user-server/internal/search"
 )
 
 var _ UsersDao = (*usersDao)(nil)
 
+// ErrUpdateConflict the record was changed since it was read, returned by the conditional updates
+var ErrUpdateConflict = errors.New("record was modified by another request")
+
+// activeUsers the default scope of the reads and the writes, the deactivated userss are only seen by the trash
+func activeUsers(db *gorm.DB) *gorm.DB {
+	return db.Where("deactivated_at IS NULL")
+}
+
+// ErrQueryParams the conditions or the sort of a StreamByColumns are invalid
+var ErrQueryParams = errors.New("query params error")
+
+// NotFoundIDsError the ids passed to DeleteByIDs that match no record, nothing is deleted then
+type NotFoundIDsError struct {
+	IDs []uint64
+}
+
+func (e *NotFoundIDsError) Error() string {
+	return fmt.Sprintf("%v: %v", database.ErrRecordNotFound, e.IDs)
+}
+
+// Unwrap make errors.Is(err, database.ErrRecordNotFound) hold
+func (e *NotFoundIDsError) Unwrap() error {
+	return database.ErrRecordNotFound
+}
+
 // UsersDao defining the dao interface
 type UsersDao interface {
 	Create(ctx context.Context, table *model.Users) error
 	DeleteByID(ctx context.Context, id uint64) error
 	UpdateByID(ctx context.Context, table *model.Users) error
-	GetByID(ctx context.Context, id uint64) (*model.Users, error)
-	GetByColumns(ctx context.Context, params *query.Params) ([]*model.Users, int64, error)
+	UpdateByIDIfUnmodified(ctx context.Context, table *model.Users, precondition func(current *model.Users) error) (*model.Users, error)
+	PatchByID(ctx context.Context, id uint64, columns map[string]interface{}) error
+	GetByID(ctx context.Context, id uint64, columns ...string) (*model.Users, error)
+	GetByKey(ctx context.Context, key string, value string) (*model.Users, error)
+	GetByColumns(ctx context.Context, params *query.Params, columns ...string) ([]*model.Users, int64, error)
+	StreamByColumns(ctx context.Context, params *query.Params, fn func(*model.Users) error, columns ...string) error
 
 	DeleteByIDs(ctx context.Context, ids []uint64) error
+	GetDeactivated(ctx context.Context, page int, limit int) ([]*model.Users, int64, error)
+	RestoreByID(ctx context.Context, id uint64) error
+	PurgeDeactivated(ctx context.Context, before time.Time, limit int) (int64, error)
+	GetHistory(ctx context.Context, usersID uint64, page int, limit int) ([]*model.UsersAudit, int64, error)
+	GetVersions(ctx context.Context, usersID uint64, page int, limit int) ([]*model.UsersVersion, int64, error)
+	GetVersion(ctx context.Context, usersID uint64, version int) (*model.Users, error)
+	DiffVersions(ctx context.Context, usersID uint64, from int, to int) (map[string]model.UsersChange, error)
+	GetAsOf(ctx context.Context, id uint64, at time.Time) (*model.Users, error)
+	RevertToVersion(ctx context.Context, id uint64, version int) error
+	PublishEvents(ctx context.Context, limit int, publish func(ctx context.Context, events []*model.UsersOutbox) []uint64) (int, error)
+	ReplayEvents(ctx context.Context, offset uint64) (int64, error)
+	PurgeEvents(ctx context.Context, before time.Time, unpublished bool, limit int) (int64, error)
+	CreateWebhook(ctx context.Context, webhook *model.UsersWebhook) error
+	UpdateWebhook(ctx context.Context, webhook *model.UsersWebhook) error
+	DeleteWebhook(ctx context.Context, id uint64) error
+	GetWebhook(ctx context.Context, id uint64) (*model.UsersWebhook, error)
+	GetWebhooks(ctx context.Context) ([]*model.UsersWebhook, error)
+	GetWebhookDeliveries(ctx context.Context, webhookID uint64, status string, page int, limit int) ([]*model.UsersWebhookDelivery, int64, error)
+	RedeliverWebhook(ctx context.Context, webhookID uint64, deliveryID uint64) (*model.UsersWebhookDelivery, error)
+	DeliverWebhooks(ctx context.Context, limit int, deliver func(ctx context.Context, delivery *model.UsersWebhookDelivery, webhook *model.UsersWebhook)) (int, error)
 	GetByCondition(ctx context.Context, condition *query.Conditions) (*model.Users, error)
-	GetByIDs(ctx context.Context, ids []uint64) (map[uint64]*model.Users, error)
-	GetByLastID(ctx context.Context, lastID uint64, limit int, sort string) ([]*model.Users, error)
+	GetByIDs(ctx context.Context, ids []uint64, columns ...string) (map[uint64]*model.Users, error)
+	GetByCursor(ctx context.Context, sort string, c *cursor.Cursor, limit int, columns ...string) ([]*model.Users, *cursor.Page, error)
+	Search(ctx context.Context, q string, page int, limit int) ([]*UsersSearchResult, int, error)
+	UpdatePasswordByID(ctx context.Context, id uint64, encryptedPassword string) error
+	UpdateTrackedFieldsByID(ctx context.Context, table *model.Users) error
+	IncrementFailedAttemptsByID(ctx context.Context, id uint64) (int, error)
+	UpdateLockableByID(ctx context.Context, table *model.Users) error
+	UpdateResetPasswordTokenByID(ctx context.Context, table *model.Users) error
+	ConfirmByID(ctx context.Context, id uint64, confirmedAt time.Time, unconfirmedEmail string) error
+	AcceptInvitationByID(ctx context.Context, id uint64, encryptedPassword string, acceptedAt time.Time) error
 
 	CreateByTx(ctx context.Context, tx *gorm.DB, table *model.Users) (uint64, error)
 	DeleteByTx(ctx context.Context, tx *gorm.DB, id uint64) error
 	UpdateByTx(ctx context.Context, tx *gorm.DB, table *model.Users) error
+	GetForUpdateByTx(ctx context.Context, tx *gorm.DB, id uint64) (*model.Users, error)
+	DecrementInvitationLimitByTx(ctx context.Context, tx *gorm.DB, id uint64, defaultLimit int) (bool, error)
 }
 
 type usersDao struct {
 	db    *gorm.DB
 	cache cache.UsersCache    // if nil, the cache is not used.
 	sfg   *singleflight.Group // if cache is nil, the sfg is not used.
+	index *search.Index       // shared by the daos, see usersSearchIndex
 }
 
 // NewUsersDao creating the dao interface
 func NewUsersDao(db *gorm.DB, xCache cache.UsersCache) UsersDao {
 	if xCache == nil {
-		return &usersDao{db: db}
+		return &usersDao{db: db, index: usersSearchIndex}
 	}
 	return &usersDao{
 		db:    db,
 		cache: xCache,
 		sfg:   new(singleflight.Group),
+		index: usersSearchIndex,
 	}
 }
 
 func (d *usersDao) deleteCache(ctx context.Context, id uint64) error {
+	d.index.Invalidate(id)
 	if d.cache != nil {
+		// the natural key indexes of the cached record, indexes of a record that is not cached are checked by GetByKey
+		if record, err := d.cache.Get(ctx, id); err == nil && record != nil {
+			for key := range model.UsersNaturalKeyColumnNames {
+				_ = d.cache.DelKeyIndex(ctx, key, naturalKeyValue(record, key))
+			}
+		}
 		return d.cache.Del(ctx, id)
 	}
 	return nil
 }
 
+// deleteKeyIndexCache delete the indexes of the natural key values a write is setting, they may hold a not found placeholder
+func (d *usersDao) deleteKeyIndexCache(ctx context.Context, columns map[string]interface{}) {
+	if d.cache == nil {
+		return
+	}
+	for key := range model.UsersNaturalKeyColumnNames {
+		if value, ok := columns[key].(string); ok {
+			_ = d.cache.DelKeyIndex(ctx, key, value)
+		}
+	}
+}
+
+func naturalKeyValue(table *model.Users, key string) string {
+	switch key {
+	case "email":
+		return table.Email
+	case "clerk_code":
+		return table.ClerkCode
+	case "wecom_id":
+		return table.WecomID
+	case "windows_sid":
+		return table.WindowsSid
+	case "pre_sso_id":
+		return table.PreSsoID
+	}
+	return ""
+}
+
+func naturalKeyColumns(table *model.Users) map[string]interface{} {
+	columns := make(map[string]interface{}, len(model.UsersNaturalKeyColumnNames))
+	for key := range model.UsersNaturalKeyColumnNames {
+		columns[key] = naturalKeyValue(table, key)
+	}
+	return columns
+}
+
 // Create a new users, insert the record and the id value is written back to the table
 func (d *usersDao) Create(ctx context.Context, table *model.Users) error {
-	return d.db.WithContext(ctx).Create(table).Error
+	err := createByTx(ctx, d.db, table)
+	if err != nil {
+		return err
+	}
+
+	// delete cache
+	d.deleteKeyIndexCache(ctx, naturalKeyColumns(table))
+	d.index.Invalidate(table.ID)
+
+	return nil
 }
 
-// DeleteByID delete a users by id
+// DeleteByID deactivate a users by id, it is left out of the reads and hard deleted by PurgeDeactivated
 func (d *usersDao) DeleteByID(ctx context.Context, id uint64) error {
-	err := d.db.WithContext(ctx).Where("id = ?", id).Delete(&model.Users{}).Error
+	err := d.deleteByID(ctx, d.db, id)
 	if err != nil {
 		return err
 	}
@@ -81,7 +205,7 @@ func (d *usersDao) DeleteByID(ctx context.Context, id uint64) error {
 
 // UpdateByID update a users by ids
 func (d *usersDao) UpdateByID(ctx context.Context, table *model.Users) error {
-	err := d.updateDataByID(ctx, d.db, table)
+	err := d.auditedUpdateByID(ctx, d.db, table)
 
 	// delete cache
 	_ = d.deleteCache(ctx, table.ID)
@@ -89,6 +213,79 @@ func (d *usersDao) UpdateByID(ctx context.Context, table *model.Users) error {
 	return err
 }
 
+// UpdateByIDIfUnmodified update a users by id only while precondition accepts the record read from the database
+// under the row lock, not the cached one, it may still complete table from that record. The errors of
+// precondition are returned as they are, ErrUpdateConflict with that record when another writer got there first.
+func (d *usersDao) UpdateByIDIfUnmodified(ctx context.Context, table *model.Users, precondition func(current *model.Users) error) (*model.Users, error) {
+	if table.ID < 1 {
+		return nil, errors.New("id cannot be 0")
+	}
+
+	var current *model.Users
+	err := d.audited(ctx, d.db, model.UsersAuditUpdate, []uint64{table.ID}, func(tx *gorm.DB) error {
+		record := &model.Users{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(activeUsers).Where("id = ?", table.ID).First(record).Error
+		if err != nil {
+			return err
+		}
+		if err = precondition(record); err != nil {
+			if errors.Is(err, ErrUpdateConflict) {
+				current = record
+			}
+			return err
+		}
+		return d.updateDataByID(ctx, tx, table)
+	})
+
+	// delete cache
+	_ = d.deleteCache(ctx, table.ID)
+
+	return current, err
+}
+
+// PatchByID write the given columns of a users as they are, unlike UpdateByID zero values are written
+// and a nil value sets the column to NULL
+func (d *usersDao) PatchByID(ctx context.Context, id uint64, columns map[string]interface{}) error {
+	if id < 1 {
+		return errors.New("id cannot be 0")
+	}
+	if len(columns) == 0 {
+		return errors.New("columns cannot be empty")
+	}
+	for column := range columns {
+		if !model.UsersColumnNames[column] {
+			return fmt.Errorf("unknown column %q", column)
+		}
+	}
+
+	err := d.audited(ctx, d.db, model.UsersAuditUpdate, []uint64{id}, func(tx *gorm.DB) error {
+		result := tx.Model(&model.Users{}).Scopes(activeUsers).Where("id = ?", id).Updates(columns)
+		if result.Error != nil {
+			return database.TranslateError(result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return checkUnaffected(ctx, tx, id)
+		}
+		return nil
+	})
+
+	// delete cache
+	_ = d.deleteCache(ctx, id)
+	d.deleteKeyIndexCache(ctx, columns)
+
+	return err
+}
+
+// auditedUpdateByID updateDataByID with the audit of the changed columns
+func (d *usersDao) auditedUpdateByID(ctx context.Context, db *gorm.DB, table *model.Users) error {
+	if table.ID < 1 {
+		return errors.New("id cannot be 0")
+	}
+	return d.audited(ctx, db, model.UsersAuditUpdate, []uint64{table.ID}, func(tx *gorm.DB) error {
+		return d.updateDataByID(ctx, tx, table)
+	})
+}
+
 func (d *usersDao) updateDataByID(ctx context.Context, db *gorm.DB, table *model.Users) error {
 	if table.ID < 1 {
 		return errors.New("id cannot be 0")
@@ -226,18 +423,48 @@ func (d *usersDao) updateDataByID(ctx context.Context, db *gorm.DB, table *model
 		update["windows_sid"] = table.WindowsSid
 	}
 
-	return db.WithContext(ctx).Model(table).Updates(update).Error
+	result := db.WithContext(ctx).Model(table).Scopes(activeUsers).Updates(update)
+	if result.Error != nil {
+		return database.TranslateError(result.Error)
+	}
+	d.deleteKeyIndexCache(ctx, update)
+	if result.RowsAffected == 0 {
+		return checkUnaffected(ctx, db, table.ID)
+	}
+
+	return nil
+}
+
+// checkUnaffected mysql counts the changed rows only, so an update that matched no record is
+// told apart from one that wrote the values the record already had, a deactivated record is not found
+func checkUnaffected(ctx context.Context, db *gorm.DB, id uint64) error {
+	record := &model.Users{}
+	return db.WithContext(ctx).Select("id").Scopes(activeUsers).Where("id = ?", id).First(record).Error
 }
 
-// GetByID get a users by id
-func (d *usersDao) GetByID(ctx context.Context, id uint64) (*model.Users, error) {
+// GetByID get a users by id, only the given columns and the id are filled when columns are given,
+// they are selected from the database or projected from the cached record
+func (d *usersDao) GetByID(ctx context.Context, id uint64, columns ...string) (*model.Users, error) {
+	db, err := selectUsersColumns(d.db.WithContext(ctx), columns)
+	if err != nil {
+		return nil, err
+	}
+
 	// no cache
 	if d.cache == nil {
 		record := &model.Users{}
-		err := d.db.WithContext(ctx).Where("id = ?", id).First(record).Error
+		err = db.Scopes(activeUsers).Where("id = ?", id).First(record).Error
 		return record, err
 	}
 
+	record, err := d.getCachedByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return projectUsers(record, columns), nil
+}
+
+func (d *usersDao) getCachedByID(ctx context.Context, id uint64) (*model.Users, error) {
 	// get from cache
 	record, err := d.cache.Get(ctx, id)
 	if err == nil {
@@ -249,7 +476,7 @@ func (d *usersDao) GetByID(ctx context.Context, id uint64) (*model.Users, error)
 		// for the same id, prevent high concurrent simultaneous access to database
 		val, err, _ := d.sfg.Do(utils.Uint64ToStr(id), func() (interface{}, error) {
 			table := &model.Users{}
-			err = d.db.WithContext(ctx).Where("id = ?", id).First(table).Error
+			err = d.db.WithContext(ctx).Scopes(activeUsers).Where("id = ?", id).First(table).Error
 			if err != nil {
 				// set placeholder cache to prevent cache penetration, default expiration time 10 minutes
 				if errors.Is(err, database.ErrRecordNotFound) {
@@ -283,17 +510,89 @@ func (d *usersDao) GetByID(ctx context.Context, id uint64) (*model.Users, error)
 	return nil, err
 }
 
+// GetByKey get a users by the value of a natural key column of model.UsersNaturalKeyColumnNames,
+// the secondary cache index maps the value to the id and the record itself comes from GetByID
+func (d *usersDao) GetByKey(ctx context.Context, key string, value string) (*model.Users, error) {
+	if !model.UsersNaturalKeyColumnNames[key] {
+		return nil, fmt.Errorf("%q is not a natural key column", key)
+	}
+	if value == "" {
+		return nil, database.ErrRecordNotFound
+	}
+
+	// no cache
+	if d.cache == nil {
+		record := &model.Users{}
+		err := d.db.WithContext(ctx).Scopes(activeUsers).Where(key+" = ?", value).First(record).Error
+		return record, err
+	}
+
+	// get from cache
+	id, err := d.cache.GetKeyIndex(ctx, key, value)
+	if err == nil {
+		record, err := d.GetByID(ctx, id)
+		// the index of a record that changed while it was not cached is stale, look it up again
+		if err == nil && strings.EqualFold(naturalKeyValue(record, key), value) {
+			return record, nil
+		}
+		if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+			return nil, err
+		}
+		_ = d.cache.DelKeyIndex(ctx, key, value)
+	} else if d.cache.IsPlaceholderErr(err) {
+		return nil, database.ErrRecordNotFound
+	} else if !errors.Is(err, database.ErrCacheNotFound) {
+		return nil, err
+	}
+
+	// get from database, for the same value, prevent high concurrent simultaneous access to database
+	val, err, _ := d.sfg.Do(key+":"+strings.ToLower(value), func() (interface{}, error) {
+		table := &model.Users{}
+		err := d.db.WithContext(ctx).Scopes(activeUsers).Where(key+" = ?", value).First(table).Error
+		if err != nil {
+			// set placeholder cache to prevent cache penetration
+			if errors.Is(err, database.ErrRecordNotFound) {
+				if err = d.cache.SetKeyIndexPlaceholder(ctx, key, value); err != nil {
+					logger.Warn("cache.SetKeyIndexPlaceholder error", logger.Err(err), logger.String("key", key))
+				}
+				return nil, database.ErrRecordNotFound
+			}
+			return nil, err
+		}
+		// set cache
+		if err = d.cache.Set(ctx, table.ID, table, cache.UsersExpireTime); err != nil {
+			logger.Warn("cache.Set error", logger.Err(err), logger.Any("id", table.ID))
+		}
+		if err = d.cache.SetKeyIndex(ctx, key, value, table.ID, cache.UsersExpireTime); err != nil {
+			logger.Warn("cache.SetKeyIndex error", logger.Err(err), logger.String("key", key))
+		}
+		return table, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	table, ok := val.(*model.Users)
+	if !ok {
+		return nil, database.ErrRecordNotFound
+	}
+	return table, nil
+}
+
 // GetByColumns get a paginated list of userss by custom conditions.
 // For more details, please refer to https://go-sponge.com/component/data/custom-page-query.html
-func (d *usersDao) GetByColumns(ctx context.Context, params *query.Params) ([]*model.Users, int64, error) {
+func (d *usersDao) GetByColumns(ctx context.Context, params *query.Params, columns ...string) ([]*model.Users, int64, error) {
 	queryStr, args, err := params.ConvertToGormConditions(query.WithWhitelistNames(model.UsersColumnNames))
 	if err != nil {
 		return nil, 0, errors.New("query params error: " + err.Error())
 	}
+	db, err := selectUsersColumns(d.db.WithContext(ctx), columns)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	var total int64
 	if params.Sort != "ignore count" { // determine if count is required
-		err = d.db.WithContext(ctx).Model(&model.Users{}).Where(queryStr, args...).Count(&total).Error
+		err = d.db.WithContext(ctx).Model(&model.Users{}).Scopes(activeUsers).Where(queryStr, args...).Count(&total).Error
 		if err != nil {
 			return nil, 0, err
 		}
@@ -304,7 +603,7 @@ func (d *usersDao) GetByColumns(ctx context.Context, params *query.Params) ([]*m
 
 	records := []*model.Users{}
 	order, limit, offset := params.ConvertToPage()
-	err = d.db.WithContext(ctx).Order(order).Limit(limit).Offset(offset).Where(queryStr, args...).Find(&records).Error
+	err = db.Scopes(activeUsers).Order(order).Limit(limit).Offset(offset).Where(queryStr, args...).Find(&records).Error
 	if err != nil {
 		return nil, 0, err
 	}
@@ -312,9 +611,74 @@ func (d *usersDao) GetByColumns(ctx context.Context, params *query.Params) ([]*m
 	return records, total, err
 }
 
-// DeleteByIDs batch delete users by ids
+// StreamByColumns call fn with each users matching the conditions of params in the order of params.Sort,
+// id by default, the page and limit are ignored. The rows are read one at a time through a database
+// cursor so any number of them takes the same memory, fn must not use the database while it runs.
+func (d *usersDao) StreamByColumns(ctx context.Context, params *query.Params, fn func(*model.Users) error, columns ...string) error {
+	queryStr, args, err := params.ConvertToGormConditions(query.WithWhitelistNames(model.UsersColumnNames))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrQueryParams, err)
+	}
+	order, err := streamOrder(params.Sort)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrQueryParams, err)
+	}
+	db, err := selectUsersColumns(d.db.WithContext(ctx), columns)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Model(&model.Users{}).Scopes(activeUsers).Where(queryStr, args...).Order(order).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close() //nolint
+	for rows.Next() {
+		record := &model.Users{}
+		if err = db.ScanRows(rows, record); err != nil {
+			return err
+		}
+		if err = fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// streamOrder the order by of a comma separated list of columns, each descending with a leading -
+func streamOrder(sort string) (string, error) {
+	if sort == "" {
+		return "id", nil
+	}
+	var orders []string
+	for _, column := range strings.Split(sort, ",") {
+		column = strings.TrimSpace(column)
+		order := column + " ASC"
+		if name, ok := strings.CutPrefix(column, "-"); ok {
+			column, order = name, name+" DESC"
+		}
+		if !model.UsersColumnNames[column] {
+			return "", fmt.Errorf("unknown sort column %q", column)
+		}
+		orders = append(orders, order)
+	}
+	return strings.Join(orders, ","), nil
+}
+
+// DeleteByIDs batch deactivate users by ids
 func (d *usersDao) DeleteByIDs(ctx context.Context, ids []uint64) error {
-	err := d.db.WithContext(ctx).Where("id IN (?)", ids).Delete(&model.Users{}).Error
+	// all or nothing, the ids that do not exist or are already deactivated are reported in a *NotFoundIDsError
+	err := d.audited(ctx, d.db, model.UsersAuditDelete, ids, func(tx *gorm.DB) error {
+		var found []uint64
+		err := tx.Model(&model.Users{}).Scopes(activeUsers).Where("id IN (?)", ids).Pluck("id", &found).Error
+		if err != nil {
+			return database.TranslateError(err)
+		}
+		if missing := missingIDs(ids, found); len(missing) > 0 {
+			return &NotFoundIDsError{IDs: missing}
+		}
+		return database.TranslateError(tx.Model(&model.Users{}).Where("id IN (?)", ids).Update("deactivated_at", time.Now()).Error)
+	})
 	if err != nil {
 		return err
 	}
@@ -327,6 +691,100 @@ func (d *usersDao) DeleteByIDs(ctx context.Context, ids []uint64) error {
 	return nil
 }
 
+func missingIDs(ids []uint64, found []uint64) []uint64 {
+	exists := make(map[uint64]bool, len(found))
+	for _, id := range found {
+		exists[id] = true
+	}
+	var missing []uint64
+	for _, id := range ids {
+		if !exists[id] {
+			missing = append(missing, id)
+			exists[id] = true // report duplicates once
+		}
+	}
+	return missing
+}
+
+// GetDeactivated get a page of the deactivated userss, the last deactivated first
+func (d *usersDao) GetDeactivated(ctx context.Context, page int, limit int) ([]*model.Users, int64, error) {
+	db := d.db.WithContext(ctx).Model(&model.Users{}).Where("deactivated_at IS NOT NULL")
+
+	var total int64
+	err := db.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	records := []*model.Users{}
+	err = db.Order("deactivated_at DESC, id DESC").Limit(limit).Offset(page * limit).Find(&records).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return records, total, nil
+}
+
+// RestoreByID clear the deactivated_at of a users, database.ErrRecordNotFound is returned when no
+// deactivated record has the id
+func (d *usersDao) RestoreByID(ctx context.Context, id uint64) error {
+	if id < 1 {
+		return errors.New("id cannot be 0")
+	}
+
+	err := d.audited(ctx, d.db, model.UsersAuditRestore, []uint64{id}, func(tx *gorm.DB) error {
+		result := tx.Model(&model.Users{}).Where("id = ? AND deactivated_at IS NOT NULL", id).Update("deactivated_at", nil)
+		if result.Error != nil {
+			return database.TranslateError(result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return database.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// delete cache, the placeholders of the id and of the natural keys hide the restored record
+	_ = d.deleteCache(ctx, id)
+	record := &model.Users{}
+	if err := d.db.WithContext(ctx).Where("id = ?", id).First(record).Error; err == nil {
+		d.deleteKeyIndexCache(ctx, naturalKeyColumns(record))
+	}
+
+	return nil
+}
+
+// PurgeDeactivated hard delete at most limit userss deactivated before the given time, the number
+// deleted is returned, call it again while it equals limit. Each one is audited as a purge with its last
+// state as the version. Nothing of them is cached since they were deactivated.
+func (d *usersDao) PurgeDeactivated(ctx context.Context, before time.Time, limit int) (int64, error) {
+	var ids []uint64
+	err := d.db.WithContext(ctx).Model(&model.Users{}).Where("deactivated_at < ?", before).
+		Order("id").Limit(limit).Pluck("id", &ids).Error
+	if err != nil {
+		return 0, database.TranslateError(err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+	err = d.audited(ctx, d.db, model.UsersAuditPurge, ids, func(tx *gorm.DB) error {
+		// a users restored since it was picked is left alone
+		result := tx.Where("id IN (?) AND deactivated_at < ?", ids, before).Delete(&model.Users{})
+		n = result.RowsAffected
+		return database.TranslateError(result.Error)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 // GetByCondition get a users by custom condition
 // For more details, please refer to https://go-sponge.com/component/data/custom-page-query.html#_2-condition-parameters-optional
 func (d *usersDao) GetByCondition(ctx context.Context, c *query.Conditions) (*model.Users, error) {
@@ -336,7 +794,7 @@ func (d *usersDao) GetByCondition(ctx context.Context, c *query.Conditions) (*mo
 	}
 
 	table := &model.Users{}
-	err = d.db.WithContext(ctx).Where(queryStr, args...).First(table).Error
+	err = d.db.WithContext(ctx).Scopes(activeUsers).Where(queryStr, args...).First(table).Error
 	if err != nil {
 		return nil, err
 	}
@@ -344,12 +802,17 @@ func (d *usersDao) GetByCondition(ctx context.Context, c *query.Conditions) (*mo
 	return table, nil
 }
 
-// GetByIDs Batch get users by ids
-func (d *usersDao) GetByIDs(ctx context.Context, ids []uint64) (map[uint64]*model.Users, error) {
+// GetByIDs Batch get users by ids, only the given columns and the id are filled when columns are given
+func (d *usersDao) GetByIDs(ctx context.Context, ids []uint64, columns ...string) (map[uint64]*model.Users, error) {
+	db, err := selectUsersColumns(d.db.WithContext(ctx), columns)
+	if err != nil {
+		return nil, err
+	}
+
 	// no cache
 	if d.cache == nil {
 		var records []*model.Users
-		err := d.db.WithContext(ctx).Where("id IN (?)", ids).Find(&records).Error
+		err = db.Scopes(activeUsers).Where("id IN (?)", ids).Find(&records).Error
 		if err != nil {
 			return nil, err
 		}
@@ -360,6 +823,19 @@ func (d *usersDao) GetByIDs(ctx context.Context, ids []uint64) (map[uint64]*mode
 		return itemMap, nil
 	}
 
+	itemMap, err := d.getCachedByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) > 0 {
+		for id, record := range itemMap {
+			itemMap[id] = projectUsers(record, columns)
+		}
+	}
+	return itemMap, nil
+}
+
+func (d *usersDao) getCachedByIDs(ctx context.Context, ids []uint64) (map[uint64]*model.Users, error) {
 	// get form cache
 	itemMap, err := d.cache.MultiGet(ctx, ids)
 	if err != nil {
@@ -389,7 +865,7 @@ func (d *usersDao) GetByIDs(ctx context.Context, ids []uint64) (map[uint64]*mode
 		if len(realMissedIDs) > 0 {
 			var records []*model.Users
 			var recordIDMap = make(map[uint64]struct{})
-			err = d.db.WithContext(ctx).Where("id IN (?)", realMissedIDs).Find(&records).Error
+			err = d.db.WithContext(ctx).Scopes(activeUsers).Where("id IN (?)", realMissedIDs).Find(&records).Error
 			if err != nil {
 				return nil, err
 			}
@@ -418,27 +894,489 @@ func (d *usersDao) GetByIDs(ctx context.Context, ids []uint64) (map[uint64]*mode
 	return itemMap, nil
 }
 
-// GetByLastID Get a paginated list of userss by last id
-func (d *usersDao) GetByLastID(ctx context.Context, lastID uint64, limit int, sort string) ([]*model.Users, error) {
-	page := query.NewPage(0, limit, sort)
+// GetByCursor get a page of userss in the keyset order of sort, a column of model.UsersCursorColumnNames
+// and the "-" sign before it indicates reverse order. c is nil for the first page, otherwise its sort
+// takes the place of the given one, the returned page holds the cursors of the pages around.
+func (d *usersDao) GetByCursor(ctx context.Context, sort string, c *cursor.Cursor, limit int, columns ...string) ([]*model.Users, *cursor.Page, error) {
+	if c != nil {
+		sort = c.Sort
+	}
+	if sort == "" {
+		sort = "-id"
+	}
+	column := strings.TrimPrefix(sort, "-")
+	if !model.UsersCursorColumnNames[column] {
+		return nil, nil, fmt.Errorf("column %q cannot be sorted by cursor", column)
+	}
+	if limit < 1 {
+		return nil, nil, errors.New("limit must be greater than 0")
+	}
+
+	// a backward page is read in the reverse order and flipped afterwards
+	desc := strings.HasPrefix(sort, "-")
+	backward := c != nil && c.Backward
+	op, direction := ">", "ASC"
+	if desc != backward {
+		op, direction = "<", "DESC"
+	}
+
+	// the next cursors are made of the sort column of the rows
+	if len(columns) > 0 {
+		columns = append(slices.Clip(columns), column) // never into the array of the caller
+	}
+	db, err := selectUsersColumns(d.db.WithContext(ctx), columns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expr := cursorSortExpr(column)
+	if c != nil {
+		value, err := cursorSortValueOf(column, c.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		if column == "id" {
+			db = db.Where("id "+op+" ?", c.ID)
+		} else {
+			db = db.Where(fmt.Sprintf("(%s, id) %s (?, ?)", expr, op), value, c.ID)
+		}
+	}
+	order := "id " + direction
+	if column != "id" {
+		order = expr + " " + direction + ", " + order
+	}
 
 	records := []*model.Users{}
-	err := d.db.WithContext(ctx).Order(page.Sort()).Limit(page.Limit()).Where("id < ?", lastID).Find(&records).Error
+	err = db.Scopes(activeUsers).Order(order).Limit(limit + 1).Find(&records).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	more := len(records) > limit
+	if more {
+		records = records[:limit]
+	}
+	if backward {
+		for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+			records[i], records[j] = records[j], records[i]
+		}
+	}
+
+	page := &cursor.Page{}
+	if len(records) == 0 {
+		return records, page, nil
+	}
+	if more || backward {
+		page.Next, err = newUsersCursor(sort, records[len(records)-1], false)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if (more && backward) || (c != nil && !backward) {
+		page.Prev, err = newUsersCursor(sort, records[0], true)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return records, page, nil
+}
+
+func newUsersCursor(sort string, table *model.Users, backward bool) (*cursor.Cursor, error) {
+	value, err := json.Marshal(cursorSortValue(table, strings.TrimPrefix(sort, "-")))
 	if err != nil {
 		return nil, err
 	}
-	return records, nil
+	return &cursor.Cursor{Sort: sort, Value: value, ID: table.ID, Backward: backward}, nil
+}
+
+// cursorSortExpr the nullable columns are compared as empty strings, a NULL would drop out of
+// the row comparison
+func cursorSortExpr(column string) string {
+	switch column {
+	case "chinese_name", "clerk_code":
+		return "COALESCE(" + column + ", '')"
+	}
+	return column
+}
+
+func cursorSortValue(table *model.Users, column string) interface{} {
+	switch column {
+	case "id":
+		return table.ID
+	case "created_at":
+		return table.CreatedAt
+	case "updated_at":
+		return table.UpdatedAt
+	case "email":
+		return table.Email
+	case "chinese_name":
+		return table.ChineseName
+	case "clerk_code":
+		return table.ClerkCode
+	case "sign_in_count":
+		return table.SignInCount
+	}
+	return nil
+}
+
+// cursorSortValueOf decode the value of a cursor into the type of the column
+func cursorSortValueOf(column string, data json.RawMessage) (interface{}, error) {
+	value := reflect.New(reflect.TypeOf(cursorSortValue(&model.Users{}, column)))
+	err := json.Unmarshal(data, value.Interface())
+	if err != nil {
+		return nil, cursor.ErrInvalidCursor
+	}
+	return value.Elem().Interface(), nil
+}
+
+// UsersSearchRebuildInterval the search index is rebuilt from the table when it is older, this picks up
+// the writes of the rails app sharing the table, the writes through this dao are reloaded on the next search
+var UsersSearchRebuildInterval = 10 * time.Minute
+
+// usersSearchIndex the people search index of the process
+var usersSearchIndex = search.NewIndex(
+	search.Field{Name: "email", Weight: 3},
+	search.Field{Name: "chinese_name", Weight: 3, Pinyin: true},
+	search.Field{Name: "clerk_code", Weight: 3},
+	search.Field{Name: "mobile", Weight: 2},
+	search.Field{Name: "position_title", Weight: 1},
+	search.Field{Name: "major_name", Weight: 1},
+)
+
+var usersSearchSfg = new(singleflight.Group)
+
+// UsersSearchResult a users matching a search and its score
+type UsersSearchResult struct {
+	Users *model.Users
+	Score float64
+}
+
+// Search get a page of the userss matching every term of q by their email, chinese_name (also by its
+// pinyin and initials), clerk_code, mobile, position_title and major_name, the best matches first,
+// page starts from 0. The total number of matches is returned as well.
+func (d *usersDao) Search(ctx context.Context, q string, page int, limit int) ([]*UsersSearchResult, int, error) {
+	if page < 0 || limit < 1 {
+		return nil, 0, errors.New("page must not be negative and limit must be greater than 0")
+	}
+	if err := d.syncSearchIndex(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	hits := d.index.Search(q)
+	total := len(hits)
+	start := min(page*limit, total)
+	hits = hits[start:min(start+limit, total)]
+	if len(hits) == 0 {
+		return []*UsersSearchResult{}, total, nil
+	}
+
+	ids := make([]uint64, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+	records, err := d.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	results := make([]*UsersSearchResult, 0, len(hits))
+	for _, hit := range hits {
+		if record, ok := records[hit.ID]; ok {
+			results = append(results, &UsersSearchResult{Users: record, Score: hit.Score})
+		}
+	}
+	return results, total, nil
+}
+
+// syncSearchIndex rebuild the search index when it is too old, otherwise reload the changed records
+func (d *usersDao) syncSearchIndex(ctx context.Context) error {
+	_, err, _ := usersSearchSfg.Do("sync", func() (interface{}, error) {
+		dirty := d.index.TakeDirty()
+		columns := append([]string{"id"}, d.index.Fields()...)
+
+		if time.Since(d.index.BuiltAt()) > UsersSearchRebuildInterval {
+			records := []*model.Users{}
+			err := d.db.WithContext(ctx).Select(columns).Scopes(activeUsers).Find(&records).Error
+			if err != nil {
+				d.index.Invalidate(dirty...)
+				return nil, err
+			}
+			docs := make([]search.Document, 0, len(records))
+			for _, record := range records {
+				docs = append(docs, usersSearchDocument(record))
+			}
+			d.index.Rebuild(docs)
+			return nil, nil
+		}
+
+		if len(dirty) == 0 {
+			return nil, nil
+		}
+		records := []*model.Users{}
+		err := d.db.WithContext(ctx).Select(columns).Scopes(activeUsers).Where("id IN (?)", dirty).Find(&records).Error
+		if err != nil {
+			d.index.Invalidate(dirty...)
+			return nil, err
+		}
+		found := map[uint64]bool{}
+		for _, record := range records {
+			d.index.Put(usersSearchDocument(record))
+			found[record.ID] = true
+		}
+		for _, id := range dirty {
+			if !found[id] {
+				d.index.Delete(id)
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
+func usersSearchDocument(record *model.Users) search.Document {
+	return search.Document{ID: record.ID, Values: map[string]string{
+		"email":          record.Email,
+		"chinese_name":   record.ChineseName,
+		"clerk_code":     record.ClerkCode,
+		"mobile":         record.Mobile,
+		"position_title": record.PositionTitle,
+		"major_name":     record.MajorName,
+	}}
+}
+
+// selectUsersColumns select the given columns and the id, all of them when there are none
+func selectUsersColumns(db *gorm.DB, columns []string) (*gorm.DB, error) {
+	if len(columns) == 0 {
+		return db, nil
+	}
+	selects := []string{"id"}
+	for _, column := range columns {
+		if !model.UsersColumnNames[column] {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+		if !slices.Contains(selects, column) {
+			selects = append(selects, column)
+		}
+	}
+	return db.Select(selects), nil
+}
+
+var usersSchema = func() *schema.Schema {
+	s, err := schema.Parse(&model.Users{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}()
+
+// projectUsers copy the given columns and the id of a cached record, the record itself is shared
+// with the cache and is left alone
+func projectUsers(record *model.Users, columns []string) *model.Users {
+	if len(columns) == 0 {
+		return record
+	}
+	to := &model.Users{}
+	to.ID = record.ID
+	from, toValue := reflect.ValueOf(record).Elem(), reflect.ValueOf(to).Elem()
+	for _, column := range columns {
+		if field := usersSchema.LookUpField(column); field != nil {
+			toValue.FieldByIndex(field.StructField.Index).Set(from.FieldByIndex(field.StructField.Index))
+		}
+	}
+	return to
+}
+
+// UpdatePasswordByID set a new encrypted password, like devise recoverable any pending
+// reset password token is cleared at the same time. database.ErrRecordNotFound is returned when
+// the users is not active
+func (d *usersDao) UpdatePasswordByID(ctx context.Context, id uint64, encryptedPassword string) error {
+	if id < 1 {
+		return errors.New("id cannot be 0")
+	}
+	if encryptedPassword == "" {
+		return errors.New("encrypted password cannot be empty")
+	}
+
+	err := d.audited(ctx, d.db, model.UsersAuditUpdate, []uint64{id}, func(tx *gorm.DB) error {
+		result := tx.Model(&model.Users{}).Scopes(activeUsers).Where("id = ?", id).Updates(map[string]interface{}{
+			"encrypted_password":     encryptedPassword,
+			"reset_password_token":   nil,
+			"reset_password_sent_at": nil,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return checkUnaffected(ctx, tx, id)
+		}
+		return nil
+	})
+
+	// delete cache
+	_ = d.deleteCache(ctx, id)
+
+	return err
+}
+
+// UpdateTrackedFieldsByID write the devise trackable columns of a sign in, sign_in_count is
+// incremented in the database so that concurrent sign ins are all counted
+func (d *usersDao) UpdateTrackedFieldsByID(ctx context.Context, table *model.Users) error {
+	if table.ID < 1 {
+		return errors.New("id cannot be 0")
+	}
+
+	err := d.audited(ctx, d.db, model.UsersAuditUpdate, []uint64{table.ID}, func(tx *gorm.DB) error {
+		return tx.Model(&model.Users{}).Scopes(activeUsers).Where("id = ?", table.ID).Updates(map[string]interface{}{
+			"sign_in_count":      gorm.Expr("sign_in_count + ?", 1),
+			"current_sign_in_at": table.CurrentSignInAt,
+			"last_sign_in_at":    table.LastSignInAt,
+			"current_sign_in_ip": table.CurrentSignInIP,
+			"last_sign_in_ip":    table.LastSignInIP,
+		}).Error
+	})
+
+	// delete cache
+	_ = d.deleteCache(ctx, table.ID)
+
+	return err
+}
+
+// IncrementFailedAttemptsByID increment failed_attempts in the database and return the new value,
+// like devise increment_counter updated_at is not touched
+func (d *usersDao) IncrementFailedAttemptsByID(ctx context.Context, id uint64) (int, error) {
+	if id < 1 {
+		return 0, errors.New("id cannot be 0")
+	}
+
+	var failedAttempts int
+	err := d.audited(ctx, d.db, model.UsersAuditUpdate, []uint64{id}, func(tx *gorm.DB) error {
+		err := tx.Model(&model.Users{}).Scopes(activeUsers).Where("id = ?", id).
+			UpdateColumn("failed_attempts", gorm.Expr("failed_attempts + ?", 1)).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&model.Users{}).Select("failed_attempts").Where("id = ?", id).Scan(&failedAttempts).Error
+	})
+
+	// delete cache
+	_ = d.deleteCache(ctx, id)
+
+	return failedAttempts, err
+}
+
+// UpdateLockableByID write the devise lockable columns failed_attempts, locked_at and unlock_token,
+// an empty unlock token is stored as NULL
+func (d *usersDao) UpdateLockableByID(ctx context.Context, table *model.Users) error {
+	if table.ID < 1 {
+		return errors.New("id cannot be 0")
+	}
+
+	var unlockToken interface{}
+	if table.UnlockToken != "" {
+		unlockToken = table.UnlockToken
+	}
+	err := d.audited(ctx, d.db, model.UsersAuditUpdate, []uint64{table.ID}, func(tx *gorm.DB) error {
+		return tx.Model(&model.Users{}).Scopes(activeUsers).Where("id = ?", table.ID).Updates(map[string]interface{}{
+			"failed_attempts": table.FailedAttempts,
+			"locked_at":       table.LockedAt,
+			"unlock_token":    unlockToken,
+		}).Error
+	})
+
+	// delete cache
+	_ = d.deleteCache(ctx, table.ID)
+
+	return err
+}
+
+// UpdateResetPasswordTokenByID write the devise recoverable columns reset_password_token and reset_password_sent_at
+func (d *usersDao) UpdateResetPasswordTokenByID(ctx context.Context, table *model.Users) error {
+	if table.ID < 1 {
+		return errors.New("id cannot be 0")
+	}
+	if table.ResetPasswordToken == "" {
+		return errors.New("reset password token cannot be empty")
+	}
+
+	err := d.audited(ctx, d.db, model.UsersAuditUpdate, []uint64{table.ID}, func(tx *gorm.DB) error {
+		return tx.Model(&model.Users{}).Scopes(activeUsers).Where("id = ?", table.ID).Updates(map[string]interface{}{
+			"reset_password_token":   table.ResetPasswordToken,
+			"reset_password_sent_at": table.ResetPasswordSentAt,
+		}).Error
+	})
+
+	// delete cache
+	_ = d.deleteCache(ctx, table.ID)
+
+	return err
+}
+
+// ConfirmByID set confirmed_at, a non empty unconfirmedEmail replaces email and clears unconfirmed_email,
+// same as Devise::Models::Confirmable#confirm
+func (d *usersDao) ConfirmByID(ctx context.Context, id uint64, confirmedAt time.Time, unconfirmedEmail string) error {
+	if id < 1 {
+		return errors.New("id cannot be 0")
+	}
+
+	update := map[string]interface{}{
+		"confirmed_at": confirmedAt,
+	}
+	if unconfirmedEmail != "" {
+		update["email"] = unconfirmedEmail
+		update["unconfirmed_email"] = nil
+	}
+	err := d.audited(ctx, d.db, model.UsersAuditUpdate, []uint64{id}, func(tx *gorm.DB) error {
+		// the unconfirmed email may have been taken by another users since it was requested
+		return database.TranslateError(tx.Model(&model.Users{}).Scopes(activeUsers).Where("id = ?", id).Updates(update).Error)
+	})
+
+	// delete cache
+	_ = d.deleteCache(ctx, id)
+	d.deleteKeyIndexCache(ctx, update)
+
+	return err
+}
+
+// AcceptInvitationByID set the password of an invited users, stamp invitation_accepted_at and clear invitation_token,
+// confirmed_at is set if it was not, same as Devise::Models::Invitable#accept_invitation!
+func (d *usersDao) AcceptInvitationByID(ctx context.Context, id uint64, encryptedPassword string, acceptedAt time.Time) error {
+	if id < 1 {
+		return errors.New("id cannot be 0")
+	}
+	if encryptedPassword == "" {
+		return errors.New("encrypted password cannot be empty")
+	}
+
+	err := d.audited(ctx, d.db, model.UsersAuditUpdate, []uint64{id}, func(tx *gorm.DB) error {
+		return tx.Model(&model.Users{}).Scopes(activeUsers).Where("id = ?", id).Updates(map[string]interface{}{
+			"encrypted_password":     encryptedPassword,
+			"invitation_accepted_at": acceptedAt,
+			"invitation_token":       nil,
+			"confirmed_at":           gorm.Expr("COALESCE(confirmed_at, ?)", acceptedAt),
+		}).Error
+	})
+
+	// delete cache
+	_ = d.deleteCache(ctx, id)
+
+	return err
 }
 
 // CreateByTx create a record in the database using the provided transaction
 func (d *usersDao) CreateByTx(ctx context.Context, tx *gorm.DB, table *model.Users) (uint64, error) {
-	err := tx.WithContext(ctx).Create(table).Error
-	return table.ID, err
+	err := createByTx(ctx, tx, table)
+	if err != nil {
+		return 0, err
+	}
+
+	// delete cache
+	d.deleteKeyIndexCache(ctx, naturalKeyColumns(table))
+	d.index.Invalidate(table.ID)
+
+	return table.ID, nil
 }
 
-// DeleteByTx delete a record by id in the database using the provided transaction
+// DeleteByTx deactivate a record by id in the database using the provided transaction
 func (d *usersDao) DeleteByTx(ctx context.Context, tx *gorm.DB, id uint64) error {
-	err := tx.WithContext(ctx).Where("id = ?", id).Delete(&model.Users{}).Error
+	err := d.deleteByID(ctx, tx, id)
 	if err != nil {
 		return err
 	}
@@ -449,12 +1387,69 @@ func (d *usersDao) DeleteByTx(ctx context.Context, tx *gorm.DB, id uint64) error
 	return nil
 }
 
+// createByTx insert a record and its audit row in a transaction of db
+func createByTx(ctx context.Context, db *gorm.DB, table *model.Users) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(table).Error
+		if err != nil {
+			return database.TranslateError(err)
+		}
+		return recordUsersChanges(ctx, tx, model.UsersAuditCreate, nil, []*model.Users{table})
+	})
+}
+
+// deleteByID set the deactivated_at of a record, database.ErrRecordNotFound is returned when no active record has the id
+func (d *usersDao) deleteByID(ctx context.Context, db *gorm.DB, id uint64) error {
+	if id < 1 {
+		return errors.New("id cannot be 0")
+	}
+
+	return d.audited(ctx, db, model.UsersAuditDelete, []uint64{id}, func(tx *gorm.DB) error {
+		result := tx.Model(&model.Users{}).Scopes(activeUsers).Where("id = ?", id).Update("deactivated_at", time.Now())
+		if result.Error != nil {
+			return database.TranslateError(result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return database.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
 // UpdateByTx update a record by id in the database using the provided transaction
 func (d *usersDao) UpdateByTx(ctx context.Context, tx *gorm.DB, table *model.Users) error {
-	err := d.updateDataByID(ctx, tx, table)
+	err := d.auditedUpdateByID(ctx, tx, table)
 
 	// delete cache
 	_ = d.deleteCache(ctx, table.ID)
 
 	return err
 }
+
+// GetForUpdateByTx get a record by id and lock it until the end of the provided transaction
+func (d *usersDao) GetForUpdateByTx(ctx context.Context, tx *gorm.DB, id uint64) (*model.Users, error) {
+	table := &model.Users{}
+	err := tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(activeUsers).Where("id = ?", id).First(table).Error
+	return table, err
+}
+
+// DecrementInvitationLimitByTx use one invitation of the inviter, a NULL invitation_limit starts from defaultLimit,
+// false is returned when the inviter has no invitations left
+func (d *usersDao) DecrementInvitationLimitByTx(ctx context.Context, tx *gorm.DB, id uint64, defaultLimit int) (bool, error) {
+	var decremented bool
+	err := d.audited(ctx, tx, model.UsersAuditUpdate, []uint64{id}, func(tx *gorm.DB) error {
+		result := tx.Model(&model.Users{}).Scopes(activeUsers).
+			Where("id = ? AND COALESCE(invitation_limit, ?) > 0", id, defaultLimit).
+			Update("invitation_limit", gorm.Expr("COALESCE(invitation_limit, ?) - 1", defaultLimit))
+		decremented = result.RowsAffected > 0
+		return result.Error
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// delete cache
+	_ = d.deleteCache(ctx, id)
+
+	return decremented, nil
+}
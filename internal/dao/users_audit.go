@@ -0,0 +1,205 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/go-dev-frame/sponge/pkg/gin/middleware"
+
+	"test-user-server/internal/database"
+	"test-user-server/internal/model"
+	"test-user-server/internal/policy"
+)
+
+// usersAuditIgnoredColumns are not part of the recorded changes, the audit row has its own time
+var usersAuditIgnoredColumns = map[string]bool{
+	"id":         true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+// audited run write in a transaction of db and record the changes it made to the userss of ids, they are
+// locked and read before it and read again after it. The errors of write are returned as they are, so
+// write translates its own.
+func (d *usersDao) audited(ctx context.Context, db *gorm.DB, action string, ids []uint64, write func(tx *gorm.DB) error) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var before []*model.Users
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN (?)", ids).Find(&before).Error
+		if err != nil {
+			return database.TranslateError(err)
+		}
+
+		if err = write(tx); err != nil {
+			return err
+		}
+
+		var after []*model.Users
+		err = tx.Where("id IN (?)", ids).Find(&after).Error
+		if err != nil {
+			return database.TranslateError(err)
+		}
+		return recordUsersChanges(ctx, tx, action, before, after)
+	})
+}
+
+// recordUsersChanges insert an audit row, a version and an outbox event with its webhook deliveries for
+// each record of after that differs from its record in before, a record missing from before is a created
+// one and a record of before missing from after a hard deleted one, its version keeps its last state
+func recordUsersChanges(ctx context.Context, tx *gorm.DB, action string, before []*model.Users, after []*model.Users) error {
+	previous := make(map[uint64]*model.Users, len(before))
+	for _, record := range before {
+		previous[record.ID] = record
+	}
+	current := make(map[uint64]bool, len(after))
+	for _, record := range after {
+		current[record.ID] = true
+	}
+
+	var actorType string
+	var actorID uint64
+	if s, ok := policy.SubjectFromContext(ctx); ok {
+		actorType, actorID = s.Source, s.ID
+	}
+	requestID := middleware.CtxRequestID(ctx)
+	now := time.Now()
+
+	audits := make([]*model.UsersAudit, 0, len(after))
+	changed := make([]*model.Users, 0, len(after))
+	changesByID := make(map[uint64]map[string]model.UsersChange, len(after))
+	record := func(users *model.Users, changes map[string]model.UsersChange) error {
+		if len(changes) == 0 {
+			return nil
+		}
+		data, err := json.Marshal(changes)
+		if err != nil {
+			return err
+		}
+		changed = append(changed, users)
+		changesByID[users.ID] = changes
+		audits = append(audits, &model.UsersAudit{
+			UsersID:   users.ID,
+			Action:    action,
+			ActorType: actorType,
+			ActorID:   actorID,
+			RequestID: requestID,
+			Changes:   string(data),
+			CreatedAt: now,
+		})
+		return nil
+	}
+	for _, users := range after {
+		if err := record(users, usersChanges(previous[users.ID], users)); err != nil {
+			return err
+		}
+	}
+	for _, users := range before {
+		if !current[users.ID] {
+			if err := record(users, usersChanges(users, nil)); err != nil {
+				return err
+			}
+		}
+	}
+	if len(audits) == 0 {
+		return nil
+	}
+	err := tx.Create(&audits).Error
+	if err != nil {
+		return database.TranslateError(err)
+	}
+	err = writeUsersVersions(tx, action, changed, now)
+	if err != nil {
+		return err
+	}
+	return writeUsersEvents(tx, action, changed, changesByID, requestID, now)
+}
+
+// usersChanges the columns that differ between the two records by name, before is nil for a created
+// record and after for a hard deleted one. The values of the secret columns are replaced by
+// model.UsersAuditFiltered, an empty one is still told apart so that clearing a token shows.
+func usersChanges(before *model.Users, after *model.Users) map[string]model.UsersChange {
+	var beforeValue, afterValue reflect.Value
+	if before != nil {
+		beforeValue = reflect.ValueOf(before).Elem()
+	}
+	if after != nil {
+		afterValue = reflect.ValueOf(after).Elem()
+	}
+
+	changes := map[string]model.UsersChange{}
+	for _, field := range usersSchema.Fields {
+		if field.DBName == "" || usersAuditIgnoredColumns[field.DBName] {
+			continue
+		}
+		var from, to interface{}
+		if before != nil {
+			from = columnValue(beforeValue.FieldByIndex(field.StructField.Index))
+		}
+		if after != nil {
+			to = columnValue(afterValue.FieldByIndex(field.StructField.Index))
+		}
+		if before == nil && isZeroValue(to) || after == nil && isZeroValue(from) || sameValue(from, to) {
+			continue
+		}
+		if model.UsersSecretColumnNames[field.DBName] {
+			from, to = filterValue(from), filterValue(to)
+		}
+		changes[field.DBName] = model.UsersChange{Before: from, After: to}
+	}
+	return changes
+}
+
+// columnValue the value of a field, nil for a nil pointer
+func columnValue(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	return v.Interface()
+}
+
+func isZeroValue(v interface{}) bool {
+	return v == nil || reflect.ValueOf(v).IsZero()
+}
+
+func sameValue(a interface{}, b interface{}) bool {
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func filterValue(v interface{}) interface{} {
+	if isZeroValue(v) {
+		return v
+	}
+	return model.UsersAuditFiltered
+}
+
+// GetHistory get a page of the audit rows of a users, the last write first
+func (d *usersDao) GetHistory(ctx context.Context, usersID uint64, page int, limit int) ([]*model.UsersAudit, int64, error) {
+	db := d.db.WithContext(ctx).Model(&model.UsersAudit{}).Where("users_id = ?", usersID)
+
+	var total int64
+	err := db.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	records := []*model.UsersAudit{}
+	err = db.Order("id DESC").Limit(limit).Offset(page * limit).Find(&records).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return records, total, nil
+}
@@ -0,0 +1,175 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/go-dev-frame/sponge/pkg/gin/middleware"
+	"github.com/go-dev-frame/sponge/pkg/sgorm"
+
+	"test-user-server/internal/model"
+	"test-user-server/internal/policy"
+)
+
+func Test_usersChanges(t *testing.T) {
+	yes := sgorm.TinyBool(true)
+	sentAt := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	// a created record has the columns that are set
+	created := &model.Users{Email: "zhangsan@example.com", EncryptedPassword: "$2a$11$digest", NewUI: &yes}
+	created.ID = 1
+	created.CreatedAt = time.Now()
+	changes := usersChanges(nil, created)
+	assert.Equal(t, map[string]model.UsersChange{
+		"email":              {Before: nil, After: "zhangsan@example.com"},
+		"encrypted_password": {Before: nil, After: model.UsersAuditFiltered},
+		"new_ui":             {Before: nil, After: yes},
+	}, changes)
+
+	// an update has the columns that differ, a cleared secret stays empty
+	before := &model.Users{Email: "zhangsan@example.com", JobLevel: "P5", ResetPasswordToken: "digest", ResetPasswordSentAt: &sentAt}
+	sentAtCopy := sentAt.In(time.Local)
+	after := &model.Users{Email: "zhangsan@example.com", JobLevel: "P6", ResetPasswordSentAt: &sentAtCopy}
+	after.UpdatedAt = time.Now()
+	changes = usersChanges(before, after)
+	assert.Equal(t, map[string]model.UsersChange{
+		"job_level":            {Before: "P5", After: "P6"},
+		"reset_password_token": {Before: model.UsersAuditFiltered, After: ""},
+	}, changes)
+
+	assert.Empty(t, usersChanges(after, after))
+}
+
+func Test_usersDao_audited(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+	testData.PositionTitle = "Engineer"
+
+	// the caller and the request id of the context are recorded
+	ctx := policy.WithSubject(context.Background(), &policy.Subject{ID: 7, Role: policy.RoleAdmin, Source: policy.SourceJWT})
+	ctx = context.WithValue(ctx, middleware.ContextRequestIDKey, "req-1") //nolint
+	var changes string
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "position_title"}).AddRow(testData.ID, "Intern"), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs("Engineer", d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "position_title"}).AddRow(testData.ID, "Engineer"), testData.ID)
+	d.SQLMock.ExpectExec("INSERT INTO `users_audit` \\(`users_id`,`action`,`actor_type`,`actor_id`,`request_id`,`changes`,`created_at`\\)").
+		WithArgs(testData.ID, model.UsersAuditUpdate, policy.SourceJWT, 7, "req-1", &argCapture{&changes}, d.AnyTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersVersion(d, testData.ID, model.UsersAuditUpdate, 3)
+	var payload string
+	d.SQLMock.ExpectExec("INSERT INTO `users_outbox` .*").
+		WithArgs(testData.ID, model.UsersEventUpdated, &argCapture{&payload}, d.AnyTime, nil, nil).
+		WillReturnResult(sqlmock.NewResult(41, 1))
+	// the webhooks of another event type or of other columns get no delivery
+	var delivery string
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook` WHERE active = \\?").
+		WithArgs(true).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "events", "columns", "active"}).
+			AddRow(1, "", "", true).
+			AddRow(2, model.UsersEventCreated, "", true).
+			AddRow(3, "", "job_level,mobile", true).
+			AddRow(4, model.UsersEventCreated+","+model.UsersEventUpdated, "mobile,position_title", true))
+	d.SQLMock.ExpectExec("INSERT INTO `users_webhook_delivery` \\(`webhook_id`,`outbox_id`,`users_id`,`event`,`payload`,`status`,`attempts`,`next_attempt_at`,`response_status`,`last_error`,`delivered_at`,`created_at`,`updated_at`\\)").
+		WithArgs(1, 41, testData.ID, model.UsersEventUpdated, &argCapture{&delivery}, model.UsersWebhookPending, 0, d.AnyTime, 0, "", nil, d.AnyTime, d.AnyTime,
+			4, 41, testData.ID, model.UsersEventUpdated, sqlmock.AnyArg(), model.UsersWebhookPending, 0, d.AnyTime, 0, "", nil, d.AnyTime, d.AnyTime).
+		WillReturnResult(sqlmock.NewResult(1, 2))
+	d.SQLMock.ExpectCommit()
+
+	err := d.IDao.(UsersDao).UpdateByID(ctx, testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+	assert.JSONEq(t, `{"position_title":{"before":"Intern","after":"Engineer"}}`, changes)
+	event := &model.UsersEvent{}
+	assert.NoError(t, json.Unmarshal([]byte(payload), event))
+	assert.Equal(t, model.UsersEventUpdated, event.Type)
+	assert.Equal(t, testData.ID, event.UsersID)
+	assert.Equal(t, []string{"position_title"}, event.Columns)
+	assert.Equal(t, map[string]model.UsersChange{"position_title": {Before: "Intern", After: "Engineer"}}, event.Changes)
+	assert.Equal(t, "req-1", event.RequestID)
+	// the delivery is the event with its offset
+	event = &model.UsersEvent{}
+	assert.NoError(t, json.Unmarshal([]byte(delivery), event))
+	assert.Equal(t, uint64(41), event.Offset)
+	assert.Equal(t, []string{"position_title"}, event.Columns)
+
+	// a failing audit rolls the write back
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "position_title"}).AddRow(testData.ID, "Intern"), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs("Engineer", d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "position_title"}).AddRow(testData.ID, "Engineer"), testData.ID)
+	d.SQLMock.ExpectExec("INSERT INTO `users_audit` .*").WillReturnError(sql.ErrConnDone)
+	d.SQLMock.ExpectRollback()
+
+	err = d.IDao.(UsersDao).UpdateByID(ctx, testData)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+}
+
+// argCapture match any argument and keep it as a string
+type argCapture struct {
+	value *string
+}
+
+func (a *argCapture) Match(v driver.Value) bool {
+	switch s := v.(type) {
+	case string:
+		*a.value = s
+	case []byte:
+		*a.value = string(s)
+	default:
+		data, _ := json.Marshal(v)
+		*a.value = string(data)
+	}
+	return true
+}
+
+func Test_usersDao_GetHistory(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+
+	d.SQLMock.ExpectQuery("SELECT count\\(\\*\\) FROM `users_audit` WHERE users_id = \\?").
+		WithArgs(testData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_audit` WHERE users_id = \\? ORDER BY id DESC LIMIT \\? OFFSET \\?").
+		WithArgs(testData.ID, 2, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "users_id", "action", "changes"}).
+			AddRow(1, testData.ID, model.UsersAuditCreate, `{"email":{"before":null,"after":"zhangsan@example.com"}}`))
+
+	records, total, err := d.IDao.(UsersDao).GetHistory(d.Ctx, testData.ID, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(3), total)
+	assert.Len(t, records, 1)
+	assert.Equal(t, model.UsersAuditCreate, records[0].Action)
+
+	// nothing recorded
+	d.SQLMock.ExpectQuery("SELECT count.*").
+		WithArgs(uint64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	records, total, err = d.IDao.(UsersDao).GetHistory(d.Ctx, 2, 0, 20)
+	assert.NoError(t, err)
+	assert.Zero(t, total)
+	assert.Empty(t, records)
+
+	// error
+	d.SQLMock.ExpectQuery("SELECT count.*").WillReturnError(sql.ErrConnDone)
+	_, _, err = d.IDao.(UsersDao).GetHistory(d.Ctx, testData.ID, 0, 20)
+	assert.Error(t, err)
+}
@@ -0,0 +1,170 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"test-user-server/internal/database"
+	"test-user-server/internal/model"
+)
+
+// writeUsersEvents insert the outbox event of each record and queue its webhook deliveries, changes are
+// the changed columns by id
+func writeUsersEvents(tx *gorm.DB, action string, records []*model.Users, changes map[uint64]map[string]model.UsersChange,
+	requestID string, now time.Time) error {
+	event := model.UsersEventTypes[action]
+	rows := make([]*model.UsersOutbox, len(records))
+	events := make([]*model.UsersEvent, len(records))
+	for i, record := range records {
+		columns := make([]string, 0, len(changes[record.ID]))
+		for column := range changes[record.ID] {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+		events[i] = &model.UsersEvent{
+			Type:       event,
+			UsersID:    record.ID,
+			Columns:    columns,
+			Changes:    changes[record.ID],
+			RequestID:  requestID,
+			OccurredAt: now,
+		}
+		payload, err := json.Marshal(events[i])
+		if err != nil {
+			return err
+		}
+		rows[i] = &model.UsersOutbox{
+			UsersID:   record.ID,
+			Event:     event,
+			Payload:   string(payload),
+			CreatedAt: now,
+		}
+	}
+	err := tx.Create(&rows).Error
+	if err != nil {
+		return database.TranslateError(err)
+	}
+	return queueUsersWebhookDeliveries(tx, rows, events, now)
+}
+
+// usersOutboxClaim how long the events claimed by a relay are left to it, publish is given a context that
+// ends with the claim so that the relay of another instance only claims them again once it stopped trying
+const usersOutboxClaim = 5 * time.Minute
+
+// PublishEvents claim the oldest unpublished events, at most limit, pass them to publish in offset order and
+// mark the ones whose ids it returns as published, the number of events read is returned. The events are
+// claimed in a short transaction and publish runs outside of it, the events claimed by the relay of another
+// instance and the later events of their userss are left to it so that the events of a users stay in order.
+func (d *usersDao) PublishEvents(ctx context.Context, limit int,
+	publish func(ctx context.Context, events []*model.UsersOutbox) []uint64) (int, error) {
+	claimedUntil := time.Now().Add(usersOutboxClaim)
+	n, events, err := d.claimEvents(ctx, limit, claimedUntil)
+	if err != nil || len(events) == 0 {
+		return n, err
+	}
+
+	publishCtx, cancel := context.WithDeadline(ctx, claimedUntil)
+	ids := publish(publishCtx, events)
+	cancel()
+
+	// the claims of the events that were not published are released for the next poll, also when ctx ended
+	// while publishing
+	unpublished := make([]uint64, 0, len(events)-len(ids))
+	for _, event := range events {
+		if !slices.Contains(ids, event.ID) {
+			unpublished = append(unpublished, event.ID)
+		}
+	}
+	err = d.db.WithContext(context.WithoutCancel(ctx)).Transaction(func(tx *gorm.DB) error {
+		if len(ids) > 0 {
+			err := tx.Model(&model.UsersOutbox{}).Where("id IN (?)", ids).
+				Updates(map[string]interface{}{"published_at": time.Now(), "claimed_until": nil}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(unpublished) > 0 {
+			return tx.Model(&model.UsersOutbox{}).Where("id IN (?)", unpublished).Update("claimed_until", nil).Error
+		}
+		return nil
+	})
+	return n, err
+}
+
+// claimEvents lock the oldest unpublished events, at most limit, and set claimed_until on the ones no other
+// relay claimed, the number of events read and the claimed events are returned. The transaction is read
+// committed for the inserts of the writes not to wait.
+func (d *usersDao) claimEvents(ctx context.Context, limit int, claimedUntil time.Time) (int, []*model.UsersOutbox, error) {
+	var n int
+	var claimed []*model.UsersOutbox
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var events []*model.UsersOutbox
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("published_at IS NULL").
+			Order("id").Limit(limit).Find(&events).Error
+		if err != nil {
+			return err
+		}
+		n = len(events)
+
+		now := time.Now()
+		blocked := map[uint64]bool{}
+		ids := make([]uint64, 0, n)
+		for _, event := range events {
+			if blocked[event.UsersID] || event.ClaimedUntil != nil && event.ClaimedUntil.After(now) {
+				blocked[event.UsersID] = true
+				continue
+			}
+			claimed = append(claimed, event)
+			ids = append(ids, event.ID)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		return tx.Model(&model.UsersOutbox{}).Where("id IN (?)", ids).Update("claimed_until", claimedUntil).Error
+	}, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, claimed, nil
+}
+
+// PurgeEvents delete the events published before before, and with unpublished the events created before it that
+// were not published, at most limit, the number of events deleted is returned
+func (d *usersDao) PurgeEvents(ctx context.Context, before time.Time, unpublished bool, limit int) (int64, error) {
+	db := d.db.WithContext(ctx).Model(&model.UsersOutbox{}).Where("published_at < ?", before)
+	if unpublished {
+		db = db.Or("published_at IS NULL AND created_at < ?", before)
+	}
+	var ids []uint64
+	err := db.Order("id").Limit(limit).Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	result := d.db.WithContext(ctx).Where("id IN (?)", ids).Delete(&model.UsersOutbox{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+// ReplayEvents mark the events from offset on as unpublished, the relay publishes them again in order,
+// the number of events is returned
+func (d *usersDao) ReplayEvents(ctx context.Context, offset uint64) (int64, error) {
+	result := d.db.WithContext(ctx).Model(&model.UsersOutbox{}).
+		Where("id >= ? AND published_at IS NOT NULL", offset).Update("published_at", nil)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
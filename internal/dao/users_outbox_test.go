@@ -0,0 +1,224 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/go-dev-frame/sponge/pkg/gotest"
+
+	"test-user-server/internal/model"
+)
+
+var usersOutboxColumns = []string{"id", "users_id", "event", "payload", "created_at", "published_at", "claimed_until"}
+
+// expectUsersEventPayload the insert of the outbox event of an audited write of a users, its payload is kept
+func expectUsersEventPayload(d *gotest.Dao, id uint64, action string, payload *string) {
+	d.SQLMock.ExpectExec("INSERT INTO `users_audit` .*").
+		WithArgs(id, action, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), d.AnyTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersVersion(d, id, action, 1)
+	d.SQLMock.ExpectExec("INSERT INTO `users_outbox` .*").
+		WithArgs(id, model.UsersEventTypes[action], &argCapture{payload}, d.AnyTime, nil, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook` WHERE active = \\?").
+		WithArgs(true).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+}
+
+func Test_usersDao_writeUsersEvents(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+	now := time.Now()
+
+	// locking a users is an update
+	var payload string
+	testData.FailedAttempts = 3
+	testData.LockedAt = &now
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "failed_attempts"}).AddRow(testData.ID, 2), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "failed_attempts", "locked_at"}).AddRow(testData.ID, 3, now), testData.ID)
+	expectUsersEventPayload(d, testData.ID, model.UsersAuditUpdate, &payload)
+	d.SQLMock.ExpectCommit()
+	err := d.IDao.(UsersDao).UpdateLockableByID(d.Ctx, testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+	event := &model.UsersEvent{}
+	assert.NoError(t, json.Unmarshal([]byte(payload), event))
+	assert.Equal(t, model.UsersEventUpdated, event.Type)
+	assert.Equal(t, []string{"failed_attempts", "locked_at"}, event.Columns)
+
+	// purging a users is a delete, its columns change to null
+	before := now.Add(-time.Hour)
+	d.SQLMock.ExpectQuery("SELECT `id` FROM `users` .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testData.ID))
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "email", "deactivated_at"}).
+		AddRow(testData.ID, "zhangsan@example.com", before.Add(-time.Hour)), testData.ID)
+	d.SQLMock.ExpectExec("DELETE .*").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id"}), testData.ID)
+	expectUsersEventPayload(d, testData.ID, model.UsersAuditPurge, &payload)
+	d.SQLMock.ExpectCommit()
+	_, err = d.IDao.(UsersDao).PurgeDeactivated(d.Ctx, before, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+	event = &model.UsersEvent{}
+	assert.NoError(t, json.Unmarshal([]byte(payload), event))
+	assert.Equal(t, model.UsersEventDeleted, event.Type)
+	assert.Equal(t, testData.ID, event.UsersID)
+	assert.Equal(t, []string{"deactivated_at", "email"}, event.Columns)
+	assert.Equal(t, model.UsersChange{Before: "zhangsan@example.com", After: nil}, event.Changes["email"])
+}
+
+func Test_usersDao_PublishEvents(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	now := time.Now()
+
+	// the events are claimed, published outside of the transaction and then marked or released
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_outbox` WHERE published_at IS NULL ORDER BY id LIMIT \\? FOR UPDATE").
+		WithArgs(100).
+		WillReturnRows(sqlmock.NewRows(usersOutboxColumns).
+			AddRow(6, 3, model.UsersEventUpdated, `{"type":"user.updated"}`, now, nil, now.Add(time.Minute)).
+			AddRow(7, 1, model.UsersEventCreated, `{"type":"user.created"}`, now, nil, now.Add(-time.Minute)).
+			AddRow(8, 2, model.UsersEventUpdated, `{"type":"user.updated"}`, now, nil, nil).
+			AddRow(9, 3, model.UsersEventDeleted, `{"type":"user.deleted"}`, now, nil, nil))
+	d.SQLMock.ExpectExec("UPDATE `users_outbox` SET `claimed_until`=\\? WHERE id IN \\(\\?,\\?\\)").
+		WithArgs(d.AnyTime, 7, 8).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	d.SQLMock.ExpectCommit()
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectExec("UPDATE `users_outbox` SET `claimed_until`=\\?,`published_at`=\\? WHERE id IN \\(\\?\\)").
+		WithArgs(nil, d.AnyTime, 8).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	d.SQLMock.ExpectExec("UPDATE `users_outbox` SET `claimed_until`=\\? WHERE id IN \\(\\?\\)").
+		WithArgs(nil, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	d.SQLMock.ExpectCommit()
+
+	var offsets []uint64
+	n, err := d.IDao.(UsersDao).PublishEvents(d.Ctx, 100, func(ctx context.Context, events []*model.UsersOutbox) []uint64 {
+		// publishing ends with the claim
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, now.Add(usersOutboxClaim), deadline, time.Second)
+		for _, event := range events {
+			offsets = append(offsets, event.ID)
+		}
+		return []uint64{8}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 4, n)
+	// the events of users 3 wait for the relay that claimed its first one
+	assert.Equal(t, []uint64{7, 8}, offsets)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// nothing to publish
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows(usersOutboxColumns))
+	d.SQLMock.ExpectCommit()
+	n, err = d.IDao.(UsersDao).PublishEvents(d.Ctx, 100, func(context.Context, []*model.UsersOutbox) []uint64 {
+		t.Fatal("publish called without events")
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// error
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	d.SQLMock.ExpectRollback()
+	_, err = d.IDao.(UsersDao).PublishEvents(d.Ctx, 100, nil)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+
+	// a failed mark is returned, the events are claimed again once their claim ends
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows(usersOutboxColumns).
+		AddRow(8, 2, model.UsersEventUpdated, `{"type":"user.updated"}`, now, nil, nil))
+	d.SQLMock.ExpectExec("UPDATE .*").WillReturnResult(sqlmock.NewResult(0, 1))
+	d.SQLMock.ExpectCommit()
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectExec("UPDATE .*").WillReturnError(sql.ErrConnDone)
+	d.SQLMock.ExpectRollback()
+	_, err = d.IDao.(UsersDao).PublishEvents(d.Ctx, 100, func(context.Context, []*model.UsersOutbox) []uint64 {
+		return []uint64{8}
+	})
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+}
+
+func Test_usersDao_PurgeEvents(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	before := time.Now().Add(-7 * 24 * time.Hour)
+
+	d.SQLMock.ExpectQuery("SELECT `id` FROM `users_outbox` WHERE published_at < \\? ORDER BY id LIMIT \\?").
+		WithArgs(before, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectExec("DELETE FROM `users_outbox` WHERE id IN \\(\\?,\\?\\)").
+		WithArgs(1, 2).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	d.SQLMock.ExpectCommit()
+	n, err := d.IDao.(UsersDao).PurgeEvents(d.Ctx, before, false, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(2), n)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// without a relay the unpublished events are deleted too, nothing to delete
+	d.SQLMock.ExpectQuery("SELECT `id` FROM `users_outbox` WHERE published_at < \\? OR \\(published_at IS NULL AND created_at < \\?\\) ORDER BY id LIMIT \\?").
+		WithArgs(before, before, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	n, err = d.IDao.(UsersDao).PurgeEvents(d.Ctx, before, true, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// error
+	d.SQLMock.ExpectQuery("SELECT `id` FROM `users_outbox` .*").WillReturnError(sql.ErrConnDone)
+	_, err = d.IDao.(UsersDao).PurgeEvents(d.Ctx, before, false, 100)
+	assert.Error(t, err)
+}
+
+func Test_usersDao_ReplayEvents(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectExec("UPDATE `users_outbox` SET `published_at`=\\? WHERE id >= \\? AND published_at IS NOT NULL").
+		WithArgs(nil, 42).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	d.SQLMock.ExpectCommit()
+
+	n, err := d.IDao.(UsersDao).ReplayEvents(d.Ctx, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(5), n)
+
+	// error
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectExec("UPDATE .*").WillReturnError(sql.ErrConnDone)
+	d.SQLMock.ExpectRollback()
+	_, err = d.IDao.(UsersDao).ReplayEvents(d.Ctx, 42)
+	assert.Error(t, err)
+}
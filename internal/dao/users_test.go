@@ -2,7 +2,10 @@ package dao
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -12,8 +15,10 @@ import (
 	"github.com/go-dev-frame/sponge/pkg/utils"
 
 	"test-user-server/internal/cache"
+	"test-user-server/internal/cursor"
 	"test-user-server/internal/database"
 	"test-user-server/internal/model"
+	"test-user-server/internal/search"
 )
 
 func newUsersDao() *gotest.Dao {
@@ -38,65 +43,285 @@ func newUsersDao() *gotest.Dao {
 	return d
 }
 
+// expectUsersLocked the read of an audited write before it, rows are the records found
+func expectUsersLocked(d *gotest.Dao, rows *sqlmock.Rows, ids ...driver.Value) {
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE id IN \\(.*\\) FOR UPDATE").
+		WithArgs(ids...).
+		WillReturnRows(rows)
+}
+
+// expectUsersLockedRead the read of the row locked for a conditional update
+func expectUsersLockedRead(d *gotest.Dao, rows *sqlmock.Rows, id uint64) {
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE .*deactivated_at IS NULL.* FOR UPDATE").
+		WithArgs(id, 1).
+		WillReturnRows(rows)
+}
+
+// expectUsersReread the read of an audited write after it
+func expectUsersReread(d *gotest.Dao, rows *sqlmock.Rows, ids ...driver.Value) {
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE id IN \\(.*\\)$").
+		WithArgs(ids...).
+		WillReturnRows(rows)
+}
+
+// expectUsersAudit the insert of the audit row and the version of a users
+func expectUsersAudit(d *gotest.Dao, id uint64, action string) {
+	d.SQLMock.ExpectExec("INSERT INTO `users_audit` .*").
+		WithArgs(id, action, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), d.AnyTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersVersion(d, id, action, 1)
+	expectUsersEvent(d, id, model.UsersEventTypes[action])
+}
+
+// expectUsersEvent the insert of the outbox event of a users, no webhook is subscribed
+func expectUsersEvent(d *gotest.Dao, id uint64, event string) {
+	d.SQLMock.ExpectExec("INSERT INTO `users_outbox` \\(`users_id`,`event`,`payload`,`created_at`,`published_at`,`claimed_until`\\)").
+		WithArgs(id, event, sqlmock.AnyArg(), d.AnyTime, nil, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook` WHERE active = \\?").
+		WithArgs(true).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+}
+
+// expectUsersVersion the insert of the version of a users, numbered after the latest one
+func expectUsersVersion(d *gotest.Dao, id uint64, action string, version int) {
+	rows := sqlmock.NewRows([]string{"users_id", "version"})
+	if version > 1 {
+		rows.AddRow(id, version-1)
+	}
+	d.SQLMock.ExpectQuery("SELECT users_id, MAX\\(version\\) AS version FROM `users_version` WHERE users_id IN \\(\\?\\) GROUP BY `users_id`").
+		WithArgs(id).
+		WillReturnRows(rows)
+	d.SQLMock.ExpectExec("INSERT INTO `users_version` \\(`users_id`,`version`,`action`,`snapshot`,`created_at`\\)").
+		WithArgs(id, version, action, sqlmock.AnyArg(), d.AnyTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
 func Test_usersDao_Create(t *testing.T) {
 	d := newUsersDao()
 	defer d.Close()
 	testData := d.TestData.(*model.Users)
 
 	d.SQLMock.ExpectBegin()
-	d.SQLMock.ExpectExec("INSERT INTO .*").
+	d.SQLMock.ExpectExec("INSERT INTO `users` .*").
 		WithArgs(d.GetAnyArgs(testData)...).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	d.SQLMock.ExpectExec("INSERT INTO `users_audit` .*").
+		WithArgs(testData.ID, model.UsersAuditCreate, "", 0, "", sqlmock.AnyArg(), d.AnyTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersVersion(d, testData.ID, model.UsersAuditCreate, 1)
+	expectUsersEvent(d, testData.ID, model.UsersEventCreated)
 	d.SQLMock.ExpectCommit()
 
 	err := d.IDao.(UsersDao).Create(d.Ctx, testData)
 	if err != nil {
 		t.Fatal(err)
 	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
 }
 
 func Test_usersDao_DeleteByID(t *testing.T) {
 	d := newUsersDao()
 	defer d.Close()
 	testData := d.TestData.(*model.Users)
-	expectedSQLForDeletion := "UPDATE .*"
+	expectedSQLForDeletion := "UPDATE `users` SET `deactivated_at`=\\?,`updated_at`=\\? WHERE id = \\? AND deactivated_at IS NULL"
 
 	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
 	d.SQLMock.ExpectExec(expectedSQLForDeletion).
-		WithArgs(d.AnyTime, testData.ID).
+		WithArgs(d.AnyTime, d.AnyTime, testData.ID).
 		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "deactivated_at"}).AddRow(testData.ID, time.Now()), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditDelete)
 	d.SQLMock.ExpectCommit()
 
 	err := d.IDao.(UsersDao).DeleteByID(d.Ctx, testData.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
 
 	// zero id error
 	err = d.IDao.(UsersDao).DeleteByID(d.Ctx, 0)
 	assert.Error(t, err)
+
+	// not found error
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}), uint64(111))
+	d.SQLMock.ExpectExec(expectedSQLForDeletion).
+		WithArgs(d.AnyTime, d.AnyTime, uint64(111)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	d.SQLMock.ExpectRollback()
+	err = d.IDao.(UsersDao).DeleteByID(d.Ctx, 111)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
 }
 
 func Test_usersDao_UpdateByID(t *testing.T) {
 	d := newUsersDao()
 	defer d.Close()
 	testData := d.TestData.(*model.Users)
+	testData.JobLevel = "P6"
 
 	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "job_level"}).AddRow(testData.ID, "P5"), testData.ID)
 	d.SQLMock.ExpectExec("UPDATE .*").
-		WithArgs(d.AnyTime, testData.ID).
+		WithArgs("P6", d.AnyTime, testData.ID).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "job_level"}).AddRow(testData.ID, "P6"), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditUpdate)
 	d.SQLMock.ExpectCommit()
 
 	err := d.IDao.(UsersDao).UpdateByID(d.Ctx, testData)
 	if err != nil {
 		t.Fatal(err)
 	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// writing the values the record has leaves no audit row
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "job_level"}).AddRow(testData.ID, "P6"), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs("P6", d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "job_level"}).AddRow(testData.ID, "P6"), testData.ID)
+	d.SQLMock.ExpectCommit()
+	err = d.IDao.(UsersDao).UpdateByID(d.Ctx, testData)
+	assert.NoError(t, err)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
 
 	// zero id error
 	err = d.IDao.(UsersDao).UpdateByID(d.Ctx, &model.Users{})
 	assert.Error(t, err)
 
+	// not found error, a users in the trash is not written
+	missing := &model.Users{}
+	missing.ID = 111
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "deactivated_at"}).AddRow(missing.ID, time.Now()), missing.ID)
+	d.SQLMock.ExpectExec("UPDATE `users` SET `updated_at`=\\? WHERE deactivated_at IS NULL AND `id` = \\?").
+		WithArgs(d.AnyTime, missing.ID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	d.SQLMock.ExpectQuery("SELECT `id` FROM `users` WHERE id = \\? AND deactivated_at IS NULL").
+		WithArgs(missing.ID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	d.SQLMock.ExpectRollback()
+	err = d.IDao.(UsersDao).UpdateByID(d.Ctx, missing)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+}
+
+func Test_usersDao_UpdateByIDIfUnmodified(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+	updatedAt := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	unmodified := func(current *model.Users) error {
+		if !current.UpdatedAt.Equal(updatedAt) {
+			return ErrUpdateConflict
+		}
+		return nil
+	}
+
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(testData.ID, updatedAt), testData.ID)
+	expectUsersLockedRead(d, sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(testData.ID, updatedAt), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE `users` SET `updated_at`=\\? WHERE deactivated_at IS NULL AND `id` = \\?").
+		WithArgs(d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(testData.ID, time.Now()), testData.ID)
+	d.SQLMock.ExpectCommit()
+
+	current, err := d.IDao.(UsersDao).UpdateByIDIfUnmodified(d.Ctx, testData, unmodified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, current)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// changed by another writer, the locked row is returned
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(testData.ID, updatedAt.Add(time.Second)), testData.ID)
+	expectUsersLockedRead(d, sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(testData.ID, updatedAt.Add(time.Second)), testData.ID)
+	d.SQLMock.ExpectRollback()
+
+	current, err = d.IDao.(UsersDao).UpdateByIDIfUnmodified(d.Ctx, testData, unmodified)
+	assert.ErrorIs(t, err, ErrUpdateConflict)
+	assert.Equal(t, updatedAt.Add(time.Second), current.UpdatedAt)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// deleted
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}), testData.ID)
+	expectUsersLockedRead(d, sqlmock.NewRows([]string{"id"}), testData.ID)
+	d.SQLMock.ExpectRollback()
+	_, err = d.IDao.(UsersDao).UpdateByIDIfUnmodified(d.Ctx, testData, unmodified)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+
+	// any other error of the precondition is returned as it is, without the record
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(testData.ID, updatedAt), testData.ID)
+	expectUsersLockedRead(d, sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(testData.ID, updatedAt), testData.ID)
+	d.SQLMock.ExpectRollback()
+	current, err = d.IDao.(UsersDao).UpdateByIDIfUnmodified(d.Ctx, testData, func(*model.Users) error {
+		return sql.ErrConnDone
+	})
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.Nil(t, current)
+
+	// zero id error
+	_, err = d.IDao.(UsersDao).UpdateByIDIfUnmodified(d.Ctx, &model.Users{}, unmodified)
+	assert.Error(t, err)
+}
+
+func Test_usersDao_PatchByID(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+
+	// zero values and NULL are written
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "failed_attempts", "locked_at", "mobile"}).
+		AddRow(testData.ID, 5, time.Now(), "13800000000"), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE `users` SET `failed_attempts`=\\?,`locked_at`=\\?,`mobile`=\\?,`updated_at`=\\? WHERE id = \\? AND deactivated_at IS NULL").
+		WithArgs(0, nil, "", d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "failed_attempts", "locked_at", "mobile"}).
+		AddRow(testData.ID, 0, nil, ""), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditUpdate)
+	d.SQLMock.ExpectCommit()
+
+	err := d.IDao.(UsersDao).PatchByID(d.Ctx, testData.ID, map[string]interface{}{
+		"failed_attempts": 0,
+		"locked_at":       nil,
+		"mobile":          "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// not found error
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}), uint64(111))
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(nil, d.AnyTime, uint64(111)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	d.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(uint64(111), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "updated_at"}))
+	d.SQLMock.ExpectRollback()
+	err = d.IDao.(UsersDao).PatchByID(d.Ctx, 111, map[string]interface{}{"locked_at": nil})
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// unknown column, zero id and no columns errors
+	err = d.IDao.(UsersDao).PatchByID(d.Ctx, testData.ID, map[string]interface{}{"is_admin": true})
+	assert.Error(t, err)
+	err = d.IDao.(UsersDao).PatchByID(d.Ctx, 0, map[string]interface{}{"locked_at": nil})
+	assert.Error(t, err)
+	err = d.IDao.(UsersDao).PatchByID(d.Ctx, testData.ID, nil)
+	assert.Error(t, err)
 }
 
 func Test_usersDao_GetByID(t *testing.T) {
@@ -136,6 +361,65 @@ func Test_usersDao_GetByID(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func Test_usersDao_GetByID_columns(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+
+	// the full record is cached and projected
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users`").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "chinese_name"}).
+			AddRow(testData.ID, "foo@bar.com", "zhang"))
+
+	record, err := d.IDao.(UsersDao).GetByID(d.Ctx, testData.ID, "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testData.ID, record.ID)
+	assert.Equal(t, "foo@bar.com", record.Email)
+	assert.Empty(t, record.ChineseName)
+
+	cached, err := d.IDao.(UsersDao).GetByID(d.Ctx, testData.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "zhang", cached.ChineseName)
+
+	// without cache only the columns are selected
+	noCache := NewUsersDao(d.DB, nil)
+	d.SQLMock.ExpectQuery("SELECT `id`,`email` FROM `users`").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(testData.ID, "foo@bar.com"))
+	record, err = noCache.GetByID(d.Ctx, testData.ID, "email", "id")
+	assert.NoError(t, err)
+	assert.Equal(t, "foo@bar.com", record.Email)
+
+	err = d.SQLMock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown column
+	_, err = noCache.GetByID(d.Ctx, testData.ID, "email; drop table users")
+	assert.Error(t, err)
+}
+
+func Test_projectUsers(t *testing.T) {
+	now := time.Now()
+	record := &model.Users{Email: "foo@bar.com", ChineseName: "zhang", LockedAt: &now}
+	record.ID = 1
+	record.UpdatedAt = now
+
+	got := projectUsers(record, []string{"updated_at", "locked_at", "email"})
+	assert.Equal(t, uint64(1), got.ID)
+	assert.Equal(t, now, got.UpdatedAt)
+	assert.Equal(t, &now, got.LockedAt)
+	assert.Equal(t, "foo@bar.com", got.Email)
+	assert.Empty(t, got.ChineseName)
+	assert.True(t, got.CreatedAt.IsZero())
+
+	assert.Same(t, record, projectUsers(record, nil))
+}
+
 func Test_usersDao_GetByColumns(t *testing.T) {
 	d := newUsersDao()
 	defer d.Close()
@@ -180,27 +464,291 @@ func Test_usersDao_GetByColumns(t *testing.T) {
 	t.Log(err)
 }
 
+func Test_usersDao_StreamByColumns(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+
+	d.SQLMock.ExpectQuery("SELECT `id`,`email` FROM `users` WHERE email LIKE \\? AND deactivated_at IS NULL ORDER BY created_at DESC,id ASC").
+		WithArgs("%@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).
+			AddRow(2, "lisi@example.com").
+			AddRow(1, "zhangsan@example.com"))
+
+	var ids []uint64
+	params := &query.Params{
+		Sort:    "-created_at,id",
+		Columns: []query.Column{{Name: "email", Exp: "like", Value: "%@example.com"}},
+	}
+	err := d.IDao.(UsersDao).StreamByColumns(d.Ctx, params, func(record *model.Users) error {
+		ids = append(ids, record.ID)
+		return nil
+	}, "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []uint64{2, 1}, ids)
+
+	// fn stops the stream
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE deactivated_at IS NULL ORDER BY id").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	calls := 0
+	err = d.IDao.(UsersDao).StreamByColumns(d.Ctx, &query.Params{}, func(record *model.Users) error {
+		calls++
+		return sql.ErrConnDone
+	})
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.Equal(t, 1, calls)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// params error
+	for _, params := range []*query.Params{{Sort: "-password"}, {Columns: []query.Column{{Name: "password", Value: "x"}}}} {
+		err = d.IDao.(UsersDao).StreamByColumns(d.Ctx, params, func(*model.Users) error { return nil })
+		assert.Error(t, err)
+	}
+}
+
 func Test_usersDao_DeleteByIDs(t *testing.T) {
 	d := newUsersDao()
 	defer d.Close()
 	testData := d.TestData.(*model.Users)
 
 	d.SQLMock.ExpectBegin()
-	d.SQLMock.ExpectExec("UPDATE .*").
-		WithArgs(d.AnyTime, testData.ID).
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	d.SQLMock.ExpectQuery("SELECT `id` FROM `users` WHERE id IN \\(\\?\\) AND deactivated_at IS NULL").
+		WithArgs(testData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testData.ID))
+	d.SQLMock.ExpectExec("UPDATE `users` SET `deactivated_at`=\\?,`updated_at`=\\? WHERE id IN \\(\\?\\)").
+		WithArgs(d.AnyTime, d.AnyTime, testData.ID).
 		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "deactivated_at"}).AddRow(testData.ID, time.Now()), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditDelete)
 	d.SQLMock.ExpectCommit()
 
-	err := d.IDao.(UsersDao).DeleteByID(d.Ctx, testData.ID)
+	err := d.IDao.(UsersDao).DeleteByIDs(d.Ctx, []uint64{testData.ID})
 	if err != nil {
 		t.Fatal(err)
 	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// the missing and deactivated ids are reported and nothing is deleted
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID, uint64(111), uint64(111))
+	d.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID, uint64(111), uint64(111)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testData.ID))
+	d.SQLMock.ExpectRollback()
+
+	err = d.IDao.(UsersDao).DeleteByIDs(d.Ctx, []uint64{testData.ID, 111, 111})
+	var notFound *NotFoundIDsError
+	if assert.ErrorAs(t, err, &notFound) {
+		assert.Equal(t, []uint64{111}, notFound.IDs)
+	}
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
 
 	// zero id error
 	err = d.IDao.(UsersDao).DeleteByIDs(d.Ctx, []uint64{0})
 	assert.Error(t, err)
 }
 
+func Test_usersDao_GetByKey(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+	iDao := d.IDao.(UsersDao)
+
+	// first lookup reads the database and fills the index
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE clerk_code = \\?").
+		WithArgs("C0042", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "clerk_code"}).AddRow(testData.ID, "C0042"))
+	record, err := iDao.GetByKey(d.Ctx, "clerk_code", "C0042")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testData.ID, record.ID)
+
+	// then it is served from the cache, case-insensitively
+	record, err = iDao.GetByKey(d.Ctx, "clerk_code", "c0042")
+	assert.NoError(t, err)
+	assert.Equal(t, "C0042", record.ClerkCode)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// updating the key invalidates the index of the old value
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "clerk_code"}).AddRow(testData.ID, "C0042"), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs("C0043", d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "clerk_code"}).AddRow(testData.ID, "C0043"), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditUpdate)
+	d.SQLMock.ExpectCommit()
+	update := &model.Users{ClerkCode: "C0043"}
+	update.ID = testData.ID
+	assert.NoError(t, iDao.UpdateByID(d.Ctx, update))
+
+	d.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("C0042", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "clerk_code"}))
+	_, err = iDao.GetByKey(d.Ctx, "clerk_code", "C0042")
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+
+	// a value no users has is remembered
+	_, err = iDao.GetByKey(d.Ctx, "clerk_code", "C0042")
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// until a write sets it
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "clerk_code"}).AddRow(testData.ID, "C0043"), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs("C0042", d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "clerk_code"}).AddRow(testData.ID, "C0042"), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditUpdate)
+	d.SQLMock.ExpectCommit()
+	update.ClerkCode = "C0042"
+	assert.NoError(t, iDao.UpdateByID(d.Ctx, update))
+	d.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("C0042", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "clerk_code"}).AddRow(testData.ID, "C0042"))
+	record, err = iDao.GetByKey(d.Ctx, "clerk_code", "C0042")
+	assert.NoError(t, err)
+	assert.Equal(t, testData.ID, record.ID)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// an index left by a change made while the record was not cached is checked against the record
+	assert.NoError(t, d.Cache.ICache.(cache.UsersCache).SetKeyIndex(d.Ctx, "wecom_id", "stale", testData.ID, time.Minute))
+	d.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("stale", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = iDao.GetByKey(d.Ctx, "wecom_id", "stale")
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+
+	// not a natural key
+	_, err = iDao.GetByKey(d.Ctx, "mobile", "13812345678")
+	assert.Error(t, err)
+}
+
+func Test_usersDao_GetDeactivated(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+
+	d.SQLMock.ExpectQuery("SELECT count\\(\\*\\) FROM `users` WHERE deactivated_at IS NOT NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE deactivated_at IS NOT NULL ORDER BY deactivated_at DESC, id DESC LIMIT \\? OFFSET \\?").
+		WithArgs(2, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "deactivated_at"}).AddRow(1, time.Now()))
+
+	records, total, err := d.IDao.(UsersDao).GetDeactivated(d.Ctx, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(3), total)
+	assert.Len(t, records, 1)
+	assert.NotNil(t, records[0].DeactivatedAt)
+
+	// empty trash
+	d.SQLMock.ExpectQuery("SELECT count.*").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	records, total, err = d.IDao.(UsersDao).GetDeactivated(d.Ctx, 0, 2)
+	assert.NoError(t, err)
+	assert.Zero(t, total)
+	assert.Empty(t, records)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+}
+
+func Test_usersDao_RestoreByID(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+	ctx := d.Ctx
+	dao := d.IDao.(*usersDao)
+
+	// a deleted users left a not found placeholder for its id and its email
+	_ = dao.cache.SetPlaceholder(ctx, 2)
+	_ = dao.cache.SetKeyIndexPlaceholder(ctx, "email", "lisi@example.com")
+
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "email", "deactivated_at"}).AddRow(2, "lisi@example.com", time.Now()), 2)
+	d.SQLMock.ExpectExec("UPDATE `users` SET `deactivated_at`=\\?,`updated_at`=\\? WHERE id = \\? AND deactivated_at IS NOT NULL").
+		WithArgs(nil, d.AnyTime, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "email", "deactivated_at"}).AddRow(2, "lisi@example.com", nil), 2)
+	expectUsersAudit(d, 2, model.UsersAuditRestore)
+	d.SQLMock.ExpectCommit()
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE id = \\?").
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(2, "lisi@example.com"))
+
+	err := dao.RestoreByID(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dao.cache.Get(ctx, 2)
+	assert.ErrorIs(t, err, database.ErrCacheNotFound)
+	_, err = dao.cache.GetKeyIndex(ctx, "email", "lisi@example.com")
+	assert.ErrorIs(t, err, database.ErrCacheNotFound)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// not in the trash
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(nil, d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	d.SQLMock.ExpectRollback()
+	err = dao.RestoreByID(ctx, testData.ID)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// zero id error
+	err = dao.RestoreByID(ctx, 0)
+	assert.Error(t, err)
+}
+
+func Test_usersDao_PurgeDeactivated(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	before := time.Now().Add(-24 * time.Hour)
+
+	d.SQLMock.ExpectQuery("SELECT `id` FROM `users` WHERE deactivated_at < \\? ORDER BY id LIMIT \\?").
+		WithArgs(before, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "deactivated_at"}).AddRow(1, before.Add(-time.Hour)), 1)
+	d.SQLMock.ExpectExec("DELETE FROM `users` WHERE id IN \\(\\?\\) AND deactivated_at < \\?").
+		WithArgs(1, before).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id"}), 1)
+	// the purged users is audited with its last state
+	expectUsersAudit(d, 1, model.UsersAuditPurge)
+	d.SQLMock.ExpectCommit()
+
+	n, err := d.IDao.(UsersDao).PurgeDeactivated(d.Ctx, before, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(1), n)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// nothing to purge
+	d.SQLMock.ExpectQuery("SELECT `id` FROM `users` .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	n, err = d.IDao.(UsersDao).PurgeDeactivated(d.Ctx, before, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+
+	// error test
+	d.SQLMock.ExpectQuery("SELECT `id` FROM `users` .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(1), 1)
+	d.SQLMock.ExpectExec("DELETE .*").WillReturnError(sql.ErrConnDone)
+	d.SQLMock.ExpectRollback()
+	_, err = d.IDao.(UsersDao).PurgeDeactivated(d.Ctx, before, 100)
+	assert.Error(t, err)
+}
+
 func Test_usersDao_GetByCondition(t *testing.T) {
 	d := newUsersDao()
 	defer d.Close()
@@ -211,7 +759,7 @@ func Test_usersDao_GetByCondition(t *testing.T) {
 		AddRow(testData.ID)
 
 	d.SQLMock.ExpectQuery("SELECT .*").
-		WithArgs(testData.ID).
+		WithArgs(testData.ID, 1).
 		WillReturnRows(rows)
 
 	_, err := d.IDao.(UsersDao).GetByCondition(d.Ctx, &query.Conditions{
@@ -272,20 +820,69 @@ func Test_usersDao_GetByIDs(t *testing.T) {
 	}
 }
 
-func Test_usersDao_GetByLastID(t *testing.T) {
+func Test_usersDao_GetByCursor(t *testing.T) {
 	d := newUsersDao()
 	defer d.Close()
 	testData := d.TestData.(*model.Users)
+	updatedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
 
-	rows := sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).
-		AddRow(testData.ID, testData.CreatedAt, testData.UpdatedAt)
+	// first page, one row more than the limit tells there is a next page
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE deactivated_at IS NULL ORDER BY updated_at DESC, id DESC LIMIT \\?").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "updated_at"}).
+			AddRow(testData.ID, updatedAt).
+			AddRow(2, updatedAt))
 
-	d.SQLMock.ExpectQuery("SELECT .*").WillReturnRows(rows)
+	records, page, err := d.IDao.(UsersDao).GetByCursor(d.Ctx, "-updated_at", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, records, 1)
+	assert.Nil(t, page.Prev)
+	assert.Equal(t, &cursor.Cursor{Sort: "-updated_at", Value: []byte(`"2024-01-02T03:04:05Z"`), ID: testData.ID}, page.Next)
 
-	_, err := d.IDao.(UsersDao).GetByLastID(d.Ctx, 0, 10, "")
+	// next page, the cursor sort is used and there is no page after it
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE \\(updated_at, id\\) < \\(\\?, \\?\\) AND deactivated_at IS NULL ORDER BY updated_at DESC, id DESC LIMIT \\?").
+		WithArgs(updatedAt, testData.ID, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(2, updatedAt))
+
+	records, page, err = d.IDao.(UsersDao).GetByCursor(d.Ctx, "", page.Next, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, records, 1)
+	assert.Nil(t, page.Next)
+	assert.Equal(t, &cursor.Cursor{Sort: "-updated_at", Value: []byte(`"2024-01-02T03:04:05Z"`), ID: 2, Backward: true}, page.Prev)
+
+	// previous page, read in the reverse order and flipped
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE \\(COALESCE\\(chinese_name, ''\\), id\\) < \\(\\?, \\?\\) AND deactivated_at IS NULL ORDER BY COALESCE\\(chinese_name, ''\\) DESC, id DESC LIMIT \\?").
+		WithArgs("zhang", 5, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "chinese_name"}).
+			AddRow(4, "wang").
+			AddRow(3, "li"))
+
+	records, page, err = d.IDao.(UsersDao).GetByCursor(d.Ctx, "chinese_name",
+		&cursor.Cursor{Sort: "chinese_name", Value: []byte(`"zhang"`), ID: 5, Backward: true}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(3), records[0].ID)
+	assert.Equal(t, uint64(4), records[1].ID)
+	assert.Nil(t, page.Prev)
+	assert.Equal(t, &cursor.Cursor{Sort: "chinese_name", Value: []byte(`"wang"`), ID: 4}, page.Next)
+
+	// the sort column is added to the selected ones without writing into the array of the caller
+	d.SQLMock.ExpectQuery("SELECT `id`,`email`,`chinese_name` FROM `users` WHERE deactivated_at IS NULL ORDER BY COALESCE\\(chinese_name, ''\\) ASC, id ASC LIMIT \\?").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "chinese_name"}).AddRow(3, "li@bar.com", "li"))
+	fields := make([]string, 1, 2)
+	fields[0] = "email"
+	spare := append(fields, "mobile")
+	_, _, err = d.IDao.(UsersDao).GetByCursor(d.Ctx, "chinese_name", nil, 1, fields...)
 	if err != nil {
 		t.Fatal(err)
 	}
+	assert.Equal(t, []string{"email", "mobile"}, spare)
 
 	err = d.SQLMock.ExpectationsWereMet()
 	if err != nil {
@@ -293,7 +890,273 @@ func Test_usersDao_GetByLastID(t *testing.T) {
 	}
 
 	// err test
-	_, err = d.IDao.(UsersDao).GetByLastID(d.Ctx, 0, 10, "unknown-column")
+	_, _, err = d.IDao.(UsersDao).GetByCursor(d.Ctx, "unknown-column", nil, 10)
+	assert.Error(t, err)
+	_, _, err = d.IDao.(UsersDao).GetByCursor(d.Ctx, "", &cursor.Cursor{Sort: "id", Value: []byte(`"x"`), ID: 1}, 10)
+	assert.ErrorIs(t, err, cursor.ErrInvalidCursor)
+}
+
+func Test_usersDao_Search(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	dao := NewUsersDao(d.DB, nil).(*usersDao)
+	dao.index = search.NewIndex(
+		search.Field{Name: "email", Weight: 3},
+		search.Field{Name: "chinese_name", Weight: 3, Pinyin: true},
+	)
+
+	// the index is built from the table on the first search
+	d.SQLMock.ExpectQuery("SELECT `id`,`email`,`chinese_name` FROM `users`").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "chinese_name"}).
+			AddRow(1, "zhangsan@example.com", "张三").
+			AddRow(2, "lisi@example.com", "李四"))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE id IN \\(\\?\\)").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "chinese_name"}).AddRow(1, "zhangsan@example.com", "张三"))
+
+	results, total, err := dao.Search(d.Ctx, "zs", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, total)
+	assert.Equal(t, uint64(1), results[0].Users.ID)
+	assert.Greater(t, results[0].Score, 0.0)
+
+	// a write marks the record, it is reloaded on the next search
+	dao.index.Invalidate(2)
+	d.SQLMock.ExpectQuery("SELECT `id`,`email`,`chinese_name` FROM `users` WHERE id IN \\(\\?\\)").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "chinese_name"}).AddRow(2, "lisi@example.com", "张四"))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE id IN \\(\\?\\)").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "chinese_name"}).AddRow(2, "lisi@example.com", "张四"))
+
+	results, total, err = dao.Search(d.Ctx, "zs", 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, uint64(2), results[0].Users.ID)
+
+	err = d.SQLMock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// error test
+	_, _, err = dao.Search(d.Ctx, "zs", -1, 10)
+	assert.Error(t, err)
+}
+
+func Test_usersDao_UpdatePasswordByID(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs("$2a$12$digest", nil, nil, d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "encrypted_password"}).AddRow(testData.ID, "$2a$12$digest"), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditUpdate)
+	d.SQLMock.ExpectCommit()
+
+	err := d.IDao.(UsersDao).UpdatePasswordByID(d.Ctx, testData.ID, "$2a$12$digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// deleted since it was read
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	d.SQLMock.ExpectQuery("SELECT `id` FROM `users` WHERE id = \\? AND deactivated_at IS NULL").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	d.SQLMock.ExpectRollback()
+	err = d.IDao.(UsersDao).UpdatePasswordByID(d.Ctx, testData.ID, "$2a$12$digest")
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// zero id error
+	err = d.IDao.(UsersDao).UpdatePasswordByID(d.Ctx, 0, "$2a$12$digest")
+	assert.Error(t, err)
+
+	// empty password error
+	err = d.IDao.(UsersDao).UpdatePasswordByID(d.Ctx, testData.ID, "")
+	assert.Error(t, err)
+}
+
+func Test_usersDao_UpdateTrackedFieldsByID(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+	now := time.Now()
+	testData.CurrentSignInAt = &now
+	testData.LastSignInAt = &now
+	testData.CurrentSignInIP = "127.0.0.1"
+	testData.LastSignInIP = "127.0.0.1"
+
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*`sign_in_count`=sign_in_count \\+ .*").
+		WithArgs(d.AnyTime, "127.0.0.1", d.AnyTime, "127.0.0.1", 1, d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "sign_in_count"}).AddRow(testData.ID, 1), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditUpdate)
+	d.SQLMock.ExpectCommit()
+
+	err := d.IDao.(UsersDao).UpdateTrackedFieldsByID(d.Ctx, testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// zero id error
+	err = d.IDao.(UsersDao).UpdateTrackedFieldsByID(d.Ctx, &model.Users{})
+	assert.Error(t, err)
+}
+
+func Test_usersDao_IncrementFailedAttemptsByID(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*`failed_attempts`=failed_attempts \\+ .*").
+		WithArgs(1, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	d.SQLMock.ExpectQuery("SELECT `failed_attempts` FROM .*").
+		WithArgs(testData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_attempts"}).AddRow(3))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "failed_attempts"}).AddRow(testData.ID, 3), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditUpdate)
+	d.SQLMock.ExpectCommit()
+
+	failedAttempts, err := d.IDao.(UsersDao).IncrementFailedAttemptsByID(d.Ctx, testData.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, failedAttempts)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// zero id error
+	_, err = d.IDao.(UsersDao).IncrementFailedAttemptsByID(d.Ctx, 0)
+	assert.Error(t, err)
+}
+
+func Test_usersDao_UpdateLockableByID(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+	now := time.Now()
+	testData.FailedAttempts = 20
+	testData.LockedAt = &now
+	testData.UnlockToken = "digest"
+
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(20, d.AnyTime, "digest", d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "failed_attempts", "locked_at"}).AddRow(testData.ID, 20, now), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditUpdate)
+	d.SQLMock.ExpectCommit()
+
+	err := d.IDao.(UsersDao).UpdateLockableByID(d.Ctx, testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// zero id error
+	err = d.IDao.(UsersDao).UpdateLockableByID(d.Ctx, &model.Users{})
+	assert.Error(t, err)
+}
+
+func Test_usersDao_UpdateResetPasswordTokenByID(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+	now := time.Now()
+	testData.ResetPasswordToken = "digest"
+	testData.ResetPasswordSentAt = &now
+
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(d.AnyTime, "digest", d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "reset_password_sent_at"}).AddRow(testData.ID, now), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditUpdate)
+	d.SQLMock.ExpectCommit()
+
+	err := d.IDao.(UsersDao).UpdateResetPasswordTokenByID(d.Ctx, testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// zero id and empty token error
+	err = d.IDao.(UsersDao).UpdateResetPasswordTokenByID(d.Ctx, &model.Users{})
+	assert.Error(t, err)
+	table := &model.Users{}
+	table.ID = 1
+	err = d.IDao.(UsersDao).UpdateResetPasswordTokenByID(d.Ctx, table)
+	assert.Error(t, err)
+}
+
+func Test_usersDao_ConfirmByID(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(d.AnyTime, "new@bar.com", nil, d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "email", "confirmed_at"}).AddRow(testData.ID, "new@bar.com", time.Now()), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditUpdate)
+	d.SQLMock.ExpectCommit()
+
+	err := d.IDao.(UsersDao).ConfirmByID(d.Ctx, testData.ID, time.Now(), "new@bar.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// zero id error
+	err = d.IDao.(UsersDao).ConfirmByID(d.Ctx, 0, time.Now(), "")
+	assert.Error(t, err)
+}
+
+func Test_usersDao_AcceptInvitationByID(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*`confirmed_at`=COALESCE\\(confirmed_at, .*").
+		WithArgs(d.AnyTime, "digest", d.AnyTime, nil, d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "invitation_accepted_at"}).AddRow(testData.ID, time.Now()), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditUpdate)
+	d.SQLMock.ExpectCommit()
+
+	err := d.IDao.(UsersDao).AcceptInvitationByID(d.Ctx, testData.ID, "digest", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// zero id and empty password error
+	err = d.IDao.(UsersDao).AcceptInvitationByID(d.Ctx, 0, "digest", time.Now())
+	assert.Error(t, err)
+	err = d.IDao.(UsersDao).AcceptInvitationByID(d.Ctx, testData.ID, "", time.Now())
 	assert.Error(t, err)
 }
 
@@ -303,33 +1166,39 @@ func Test_usersDao_CreateByTx(t *testing.T) {
 	testData := d.TestData.(*model.Users)
 
 	d.SQLMock.ExpectBegin()
-	d.SQLMock.ExpectExec("INSERT INTO .*").
+	d.SQLMock.ExpectExec("INSERT INTO `users` .*").
 		WithArgs(d.GetAnyArgs(testData)...).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersAudit(d, testData.ID, model.UsersAuditCreate)
 	d.SQLMock.ExpectCommit()
 
 	_, err := d.IDao.(UsersDao).CreateByTx(d.Ctx, d.DB, testData)
 	if err != nil {
 		t.Fatal(err)
 	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
 }
 
 func Test_usersDao_DeleteByTx(t *testing.T) {
 	d := newUsersDao()
 	defer d.Close()
 	testData := d.TestData.(*model.Users)
-	expectedSQLForDeletion := "UPDATE .*"
+	expectedSQLForDeletion := "UPDATE `users` SET `deactivated_at`.*"
 
 	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
 	d.SQLMock.ExpectExec(expectedSQLForDeletion).
-		WithArgs(d.AnyTime, testData.ID).
+		WithArgs(d.AnyTime, d.AnyTime, testData.ID).
 		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "deactivated_at"}).AddRow(testData.ID, time.Now()), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditDelete)
 	d.SQLMock.ExpectCommit()
 
 	err := d.IDao.(UsersDao).DeleteByTx(d.Ctx, d.DB, testData.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
 }
 
 func Test_usersDao_UpdateByTx(t *testing.T) {
@@ -338,13 +1207,64 @@ func Test_usersDao_UpdateByTx(t *testing.T) {
 	testData := d.TestData.(*model.Users)
 
 	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
 	d.SQLMock.ExpectExec("UPDATE .*").
 		WithArgs(d.AnyTime, testData.ID).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
 	d.SQLMock.ExpectCommit()
 
 	err := d.IDao.(UsersDao).UpdateByTx(d.Ctx, d.DB, testData)
 	if err != nil {
 		t.Fatal(err)
 	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+}
+
+func Test_usersDao_GetForUpdateByTx(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+
+	d.SQLMock.ExpectQuery("SELECT .* FOR UPDATE").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testData.ID))
+
+	record, err := d.IDao.(UsersDao).GetForUpdateByTx(d.Ctx, d.DB, testData.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testData.ID, record.ID)
+}
+
+func Test_usersDao_DecrementInvitationLimitByTx(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*COALESCE\\(invitation_limit, .*\\) - 1.*").
+		WithArgs(5, d.AnyTime, testData.ID, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "invitation_limit"}).AddRow(testData.ID, 4), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditUpdate)
+	d.SQLMock.ExpectCommit()
+	ok, err := d.IDao.(UsersDao).DecrementInvitationLimitByTx(d.Ctx, d.DB, testData.ID, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, ok)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// no invitations left, nothing changed is not audited
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	d.SQLMock.ExpectCommit()
+	ok, err = d.IDao.(UsersDao).DecrementInvitationLimitByTx(d.Ctx, d.DB, testData.ID, 5)
+	assert.NoError(t, err)
+	assert.False(t, ok)
 }
@@ -0,0 +1,219 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+
+	"test-user-server/internal/database"
+	"test-user-server/internal/model"
+)
+
+// ErrHistoryUnavailable the users existed at the time asked for but was written since without a version
+// recorded until then, its state at that time is not known
+var ErrHistoryUnavailable = errors.New("no version of the record was recorded until that time")
+
+// writeUsersVersions insert the next version of each record, the records are locked by the write so
+// their version numbers cannot be taken by another one
+func writeUsersVersions(tx *gorm.DB, action string, records []*model.Users, now time.Time) error {
+	ids := make([]uint64, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+	var latest []struct {
+		UsersID uint64
+		Version int
+	}
+	err := tx.Model(&model.UsersVersion{}).Select("users_id, MAX(version) AS version").
+		Where("users_id IN (?)", ids).Group("users_id").Scan(&latest).Error
+	if err != nil {
+		return database.TranslateError(err)
+	}
+	next := make(map[uint64]int, len(latest))
+	for _, v := range latest {
+		next[v.UsersID] = v.Version
+	}
+
+	versions := make([]*model.UsersVersion, len(records))
+	for i, record := range records {
+		snapshot, err := usersSnapshot(record)
+		if err != nil {
+			return err
+		}
+		next[record.ID]++
+		versions[i] = &model.UsersVersion{
+			UsersID:   record.ID,
+			Version:   next[record.ID],
+			Action:    action,
+			Snapshot:  snapshot,
+			CreatedAt: now,
+		}
+	}
+	return database.TranslateError(tx.Create(&versions).Error)
+}
+
+// usersSnapshot the record as json without the values of the secret columns
+func usersSnapshot(record *model.Users) (string, error) {
+	snapshot := *record
+	value := reflect.ValueOf(&snapshot).Elem()
+	for column := range model.UsersSecretColumnNames {
+		if field := usersSchema.LookUpField(column); field != nil {
+			f := value.FieldByIndex(field.StructField.Index)
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+	data, err := json.Marshal(&snapshot)
+	return string(data), err
+}
+
+// decodeUsersSnapshot the record of a version
+func decodeUsersSnapshot(version *model.UsersVersion) (*model.Users, error) {
+	record := &model.Users{}
+	err := json.Unmarshal([]byte(version.Snapshot), record)
+	return record, err
+}
+
+// GetVersions get a page of the versions of a users, the last one first
+func (d *usersDao) GetVersions(ctx context.Context, usersID uint64, page int, limit int) ([]*model.UsersVersion, int64, error) {
+	db := d.db.WithContext(ctx).Model(&model.UsersVersion{}).Where("users_id = ?", usersID)
+
+	var total int64
+	err := db.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	records := []*model.UsersVersion{}
+	err = db.Order("version DESC").Limit(limit).Offset(page * limit).Find(&records).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return records, total, nil
+}
+
+// GetVersion get the record of a users as it was at one of its versions, the version 0 is the nil record
+// before the users was created
+func (d *usersDao) GetVersion(ctx context.Context, usersID uint64, version int) (*model.Users, error) {
+	return getVersion(ctx, d.db, usersID, version)
+}
+
+func getVersion(ctx context.Context, db *gorm.DB, usersID uint64, version int) (*model.Users, error) {
+	if version == 0 {
+		return nil, nil
+	}
+	record := &model.UsersVersion{}
+	err := db.WithContext(ctx).Where("users_id = ? AND version = ?", usersID, version).First(record).Error
+	if err != nil {
+		return nil, err
+	}
+	return decodeUsersSnapshot(record)
+}
+
+// DiffVersions the columns that changed from one version of a users to another, the secret columns
+// are never part of the versions
+func (d *usersDao) DiffVersions(ctx context.Context, usersID uint64, from int, to int) (map[string]model.UsersChange, error) {
+	if from < 0 || to < 1 {
+		return nil, database.ErrRecordNotFound
+	}
+	before, err := d.GetVersion(ctx, usersID, from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := d.GetVersion(ctx, usersID, to)
+	if err != nil {
+		return nil, err
+	}
+	return usersChanges(before, after), nil
+}
+
+// GetAsOf get a users as it was at the given time, from the last version recorded until then. Without
+// one the record is returned when it has not been written since, the userss written before the versions
+// were recorded have no earlier state and ErrHistoryUnavailable is returned for them.
+// database.ErrRecordNotFound is returned when the users did not exist or was deleted at that time.
+func (d *usersDao) GetAsOf(ctx context.Context, id uint64, at time.Time) (*model.Users, error) {
+	version := &model.UsersVersion{}
+	err := d.db.WithContext(ctx).Where("users_id = ? AND created_at <= ?", id, at).Order("version DESC").First(version).Error
+	if err == nil {
+		record, err := decodeUsersSnapshot(version)
+		if err != nil {
+			return nil, err
+		}
+		if record.DeactivatedAt != nil {
+			return nil, database.ErrRecordNotFound
+		}
+		return record, nil
+	}
+	if !errors.Is(err, database.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	record := &model.Users{}
+	err = d.db.WithContext(ctx).Scopes(activeUsers).Where("id = ?", id).First(record).Error
+	if err != nil {
+		return nil, err
+	}
+	if record.CreatedAt.After(at) {
+		return nil, database.ErrRecordNotFound
+	}
+	if record.UpdatedAt.After(at) {
+		return nil, ErrHistoryUnavailable
+	}
+	return record, nil
+}
+
+// RevertToVersion write the model.UsersRevertibleColumnNames of a version back to a users, the revert is
+// audited and recorded as a new version. database.ErrRecordNotFound is returned when the users is not
+// active or has no such version.
+func (d *usersDao) RevertToVersion(ctx context.Context, id uint64, version int) error {
+	if id < 1 {
+		return errors.New("id cannot be 0")
+	}
+	if version < 1 {
+		return database.ErrRecordNotFound
+	}
+
+	var columns map[string]interface{}
+	err := d.audited(ctx, d.db, model.UsersAuditRevert, []uint64{id}, func(tx *gorm.DB) error {
+		record, err := getVersion(ctx, tx, id, version)
+		if err != nil {
+			return database.TranslateError(err)
+		}
+		columns = revertColumns(record)
+		result := tx.Model(&model.Users{}).Where("id = ? AND deactivated_at IS NULL", id).Updates(columns)
+		if result.Error != nil {
+			return database.TranslateError(result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return database.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// delete cache
+	_ = d.deleteCache(ctx, id)
+	d.deleteKeyIndexCache(ctx, columns)
+
+	return nil
+}
+
+// revertColumns the values of the revertible columns of a record, nil for NULL
+func revertColumns(record *model.Users) map[string]interface{} {
+	value := reflect.ValueOf(record).Elem()
+	columns := make(map[string]interface{}, len(model.UsersRevertibleColumnNames))
+	for column := range model.UsersRevertibleColumnNames {
+		if field := usersSchema.LookUpField(column); field != nil {
+			columns[column] = columnValue(value.FieldByIndex(field.StructField.Index))
+		}
+	}
+	return columns
+}
@@ -0,0 +1,292 @@
+package dao
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"test-user-server/internal/database"
+	"test-user-server/internal/model"
+)
+
+var usersVersionColumns = []string{"id", "users_id", "version", "action", "snapshot", "created_at"}
+
+func Test_usersSnapshot(t *testing.T) {
+	record := &model.Users{Email: "zhangsan@example.com", EncryptedPassword: "$2a$11$digest", ResetPasswordToken: "digest", JobLevel: "P6"}
+	record.ID = 1
+
+	snapshot, err := usersSnapshot(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, snapshot, "digest")
+	assert.Equal(t, "$2a$11$digest", record.EncryptedPassword) // the record is left alone
+
+	decoded, err := decodeUsersSnapshot(&model.UsersVersion{Snapshot: snapshot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(1), decoded.ID)
+	assert.Equal(t, "zhangsan@example.com", decoded.Email)
+	assert.Equal(t, "P6", decoded.JobLevel)
+	assert.Empty(t, decoded.EncryptedPassword)
+}
+
+func Test_usersDao_GetVersions(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+
+	d.SQLMock.ExpectQuery("SELECT count\\(\\*\\) FROM `users_version` WHERE users_id = \\?").
+		WithArgs(testData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` WHERE users_id = \\? ORDER BY version DESC LIMIT \\?").
+		WithArgs(testData.ID, 20).
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns).
+			AddRow(2, testData.ID, 2, model.UsersAuditUpdate, `{"id":1,"jobLevel":"P6"}`, time.Now()).
+			AddRow(1, testData.ID, 1, model.UsersAuditCreate, `{"id":1,"jobLevel":"P5"}`, time.Now()))
+
+	records, total, err := d.IDao.(UsersDao).GetVersions(d.Ctx, testData.ID, 0, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(2), total)
+	assert.Len(t, records, 2)
+	assert.Equal(t, 2, records[0].Version)
+
+	// nothing recorded
+	d.SQLMock.ExpectQuery("SELECT count.*").
+		WithArgs(uint64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	records, total, err = d.IDao.(UsersDao).GetVersions(d.Ctx, 2, 0, 20)
+	assert.NoError(t, err)
+	assert.Zero(t, total)
+	assert.Empty(t, records)
+
+	// error
+	d.SQLMock.ExpectQuery("SELECT count.*").WillReturnError(sql.ErrConnDone)
+	_, _, err = d.IDao.(UsersDao).GetVersions(d.Ctx, testData.ID, 0, 20)
+	assert.Error(t, err)
+}
+
+func Test_usersDao_DiffVersions(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` WHERE users_id = \\? AND version = \\?").
+		WithArgs(testData.ID, 1, 1).
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns).
+			AddRow(1, testData.ID, 1, model.UsersAuditCreate, `{"id":1,"email":"zhangsan@example.com","jobLevel":"P5"}`, time.Now()))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` WHERE users_id = \\? AND version = \\?").
+		WithArgs(testData.ID, 3, 1).
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns).
+			AddRow(3, testData.ID, 3, model.UsersAuditUpdate, `{"id":1,"email":"zhangsan@example.com","jobLevel":"P6","updatedAt":"2026-02-01T00:00:00Z"}`, time.Now()))
+
+	changes, err := d.IDao.(UsersDao).DiffVersions(d.Ctx, testData.ID, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]model.UsersChange{"job_level": {Before: "P5", After: "P6"}}, changes)
+
+	// the version 0 is before the users was created
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WithArgs(testData.ID, 1, 1).
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns).
+			AddRow(1, testData.ID, 1, model.UsersAuditCreate, `{"id":1,"email":"zhangsan@example.com"}`, time.Now()))
+	changes, err = d.IDao.(UsersDao).DiffVersions(d.Ctx, testData.ID, 0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]model.UsersChange{"email": {Before: nil, After: "zhangsan@example.com"}}, changes)
+
+	// no such version
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WithArgs(testData.ID, 9, 1).
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns))
+	_, err = d.IDao.(UsersDao).DiffVersions(d.Ctx, testData.ID, 9, 1)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+	_, err = d.IDao.(UsersDao).DiffVersions(d.Ctx, testData.ID, 0, 0)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+}
+
+func Test_usersDao_GetAsOf(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// the last version until then
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` WHERE users_id = \\? AND created_at <= \\? ORDER BY version DESC").
+		WithArgs(testData.ID, at, 1).
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns).
+			AddRow(4, testData.ID, 2, model.UsersAuditUpdate, `{"id":1,"positionTitle":"Engineer"}`, at.Add(-time.Hour)))
+
+	record, err := d.IDao.(UsersDao).GetAsOf(d.Ctx, testData.ID, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Engineer", record.PositionTitle)
+
+	// deleted then
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WithArgs(testData.ID, at, 1).
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns).
+			AddRow(5, testData.ID, 3, model.UsersAuditDelete, `{"id":1,"deactivatedAt":"2025-12-01T00:00:00Z"}`, at.Add(-time.Hour)))
+	_, err = d.IDao.(UsersDao).GetAsOf(d.Ctx, testData.ID, at)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+
+	// written before the versions were recorded and not since
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WithArgs(testData.ID, at, 1).
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE id = \\? AND deactivated_at IS NULL").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "position_title", "created_at", "updated_at"}).
+			AddRow(testData.ID, "Intern", at.Add(-48*time.Hour), at.Add(-time.Hour)))
+	record, err = d.IDao.(UsersDao).GetAsOf(d.Ctx, testData.ID, at)
+	assert.NoError(t, err)
+	assert.Equal(t, "Intern", record.PositionTitle)
+
+	// written before the versions were recorded and since, the state then is unknown
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).
+			AddRow(testData.ID, at.Add(-48*time.Hour), at.Add(time.Hour)))
+	_, err = d.IDao.(UsersDao).GetAsOf(d.Ctx, testData.ID, at)
+	assert.ErrorIs(t, err, ErrHistoryUnavailable)
+
+	// did not exist then
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).
+			AddRow(testData.ID, at.Add(time.Hour), at.Add(time.Hour)))
+	_, err = d.IDao.(UsersDao).GetAsOf(d.Ctx, testData.ID, at)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+
+	// does not exist
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = d.IDao.(UsersDao).GetAsOf(d.Ctx, testData.ID, at)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+
+	// error
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").WillReturnError(sql.ErrConnDone)
+	_, err = d.IDao.(UsersDao).GetAsOf(d.Ctx, testData.ID, at)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+}
+
+func Test_usersDao_RevertToVersion(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+	ctx := d.Ctx
+	dao := d.IDao.(*usersDao)
+
+	// the wecom id is reverted, its index may hold a not found placeholder
+	_ = dao.cache.SetKeyIndexPlaceholder(ctx, "wecom_id", "zhangsan")
+
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "email", "position_title", "wecom_id"}).
+		AddRow(testData.ID, "zhangsan@example.com", "Manager", "zs"), testData.ID)
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` WHERE users_id = \\? AND version = \\?").
+		WithArgs(testData.ID, 2, 1).
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns).
+			AddRow(2, testData.ID, 2, model.UsersAuditUpdate,
+				`{"id":1,"email":"old@example.com","positionTitle":"Engineer","wecomID":"zhangsan"}`, time.Now()))
+	d.SQLMock.ExpectExec("UPDATE `users` SET .*`position_title`=\\?.*`wecom_id`=\\?.*`updated_at`=\\? WHERE id = \\? AND deactivated_at IS NULL").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "email", "position_title", "wecom_id"}).
+		AddRow(testData.ID, "zhangsan@example.com", "Engineer", "zhangsan"), testData.ID)
+	expectUsersAudit(d, testData.ID, model.UsersAuditRevert)
+	d.SQLMock.ExpectCommit()
+
+	err := dao.RevertToVersion(ctx, testData.ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dao.cache.Get(ctx, testData.ID)
+	assert.ErrorIs(t, err, database.ErrCacheNotFound)
+	_, err = dao.cache.GetKeyIndex(ctx, "wecom_id", "zhangsan")
+	assert.ErrorIs(t, err, database.ErrCacheNotFound)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// no such version
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WithArgs(testData.ID, 9, 1).
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns))
+	d.SQLMock.ExpectRollback()
+	err = dao.RevertToVersion(ctx, testData.ID, 9)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// in the trash
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "deactivated_at"}).AddRow(testData.ID, time.Now()), testData.ID)
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WillReturnRows(sqlmock.NewRows(usersVersionColumns).
+			AddRow(1, testData.ID, 1, model.UsersAuditCreate, `{"id":1}`, time.Now()))
+	d.SQLMock.ExpectExec("UPDATE .*").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	d.SQLMock.ExpectRollback()
+	err = dao.RevertToVersion(ctx, testData.ID, 1)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// params error
+	err = dao.RevertToVersion(ctx, 0, 1)
+	assert.Error(t, err)
+	err = dao.RevertToVersion(ctx, testData.ID, 0)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+}
+
+func Test_usersDao_ConfirmByID_version(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	testData := d.TestData.(*model.Users)
+	confirmedAt := time.Now()
+
+	// the confirmed email change is versioned after the latest version
+	var snapshot string
+	d.SQLMock.ExpectBegin()
+	expectUsersLocked(d, sqlmock.NewRows([]string{"id", "email", "unconfirmed_email"}).
+		AddRow(testData.ID, "old@bar.com", "new@bar.com"), testData.ID)
+	d.SQLMock.ExpectExec("UPDATE `users` SET .*`confirmed_at`=\\?.*").
+		WithArgs(d.AnyTime, "new@bar.com", nil, d.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUsersReread(d, sqlmock.NewRows([]string{"id", "email", "confirmed_at"}).
+		AddRow(testData.ID, "new@bar.com", confirmedAt), testData.ID)
+	d.SQLMock.ExpectExec("INSERT INTO `users_audit` .*").
+		WithArgs(testData.ID, model.UsersAuditUpdate, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), d.AnyTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	d.SQLMock.ExpectQuery("SELECT users_id, MAX\\(version\\) AS version FROM `users_version` .*").
+		WithArgs(testData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"users_id", "version"}).AddRow(testData.ID, 2))
+	d.SQLMock.ExpectExec("INSERT INTO `users_version` .*").
+		WithArgs(testData.ID, 3, model.UsersAuditUpdate, &argCapture{&snapshot}, d.AnyTime).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	expectUsersEvent(d, testData.ID, model.UsersEventUpdated)
+	d.SQLMock.ExpectCommit()
+
+	err := d.IDao.(UsersDao).ConfirmByID(d.Ctx, testData.ID, confirmedAt, "new@bar.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	record, err := decodeUsersSnapshot(&model.UsersVersion{Snapshot: snapshot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "new@bar.com", record.Email)
+	assert.NotNil(t, record.ConfirmedAt)
+}
@@ -0,0 +1,284 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"test-user-server/internal/database"
+	"test-user-server/internal/model"
+)
+
+// usersWebhookAttemptColumns the columns an attempt of a delivery sets
+var usersWebhookAttemptColumns = []string{
+	"status", "attempts", "next_attempt_at", "response_status", "last_error", "delivered_at", "updated_at",
+}
+
+// queueUsersWebhookDeliveries insert a pending delivery of each event for each active webhook it matches,
+// rows are the outbox rows of the events, inserted and holding their offsets
+func queueUsersWebhookDeliveries(tx *gorm.DB, rows []*model.UsersOutbox, events []*model.UsersEvent, now time.Time) error {
+	var webhooks []*model.UsersWebhook
+	err := tx.Where("active = ?", true).Find(&webhooks).Error
+	if err != nil {
+		return database.TranslateError(err)
+	}
+
+	var deliveries []*model.UsersWebhookDelivery
+	for i, event := range events {
+		var payload []byte
+		for _, webhook := range webhooks {
+			if !usersWebhookMatches(webhook, event) {
+				continue
+			}
+			if payload == nil {
+				event.Offset = rows[i].ID
+				if payload, err = json.Marshal(event); err != nil {
+					return err
+				}
+			}
+			deliveries = append(deliveries, &model.UsersWebhookDelivery{
+				WebhookID:     webhook.ID,
+				OutboxID:      rows[i].ID,
+				UsersID:       event.UsersID,
+				Event:         event.Type,
+				Payload:       string(payload),
+				Status:        model.UsersWebhookPending,
+				NextAttemptAt: &now,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return database.TranslateError(tx.Create(&deliveries).Error)
+}
+
+// usersWebhookMatches report whether the webhook takes the event, by its type and by the columns it changed
+func usersWebhookMatches(webhook *model.UsersWebhook, event *model.UsersEvent) bool {
+	if webhook.Events != "" && !slices.Contains(strings.Split(webhook.Events, ","), event.Type) {
+		return false
+	}
+	if webhook.Columns == "" {
+		return true
+	}
+	for _, column := range strings.Split(webhook.Columns, ",") {
+		if _, ok := event.Changes[column]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateWebhook subscribe a webhook, it gets the events of the writes that follow
+func (d *usersDao) CreateWebhook(ctx context.Context, webhook *model.UsersWebhook) error {
+	return database.TranslateError(d.db.WithContext(ctx).Create(webhook).Error)
+}
+
+// UpdateWebhook save the url, the secret, the filters and the state of a webhook, the deliveries already
+// queued are left as they are. database.ErrRecordNotFound is returned when there is no such webhook.
+func (d *usersDao) UpdateWebhook(ctx context.Context, webhook *model.UsersWebhook) error {
+	if webhook.ID < 1 {
+		return errors.New("id cannot be 0")
+	}
+	result := d.db.WithContext(ctx).Model(webhook).
+		Select("url", "secret", "events", "columns", "active", "updated_at").Updates(webhook)
+	if result.Error != nil {
+		return database.TranslateError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return database.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteWebhook delete a webhook with its delivery log, database.ErrRecordNotFound is returned when there
+// is no such webhook
+func (d *usersDao) DeleteWebhook(ctx context.Context, id uint64) error {
+	if id < 1 {
+		return errors.New("id cannot be 0")
+	}
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("webhook_id = ?", id).Delete(&model.UsersWebhookDelivery{}).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Where("id = ?", id).Delete(&model.UsersWebhook{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return database.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// GetWebhook get a webhook by id
+func (d *usersDao) GetWebhook(ctx context.Context, id uint64) (*model.UsersWebhook, error) {
+	webhook := &model.UsersWebhook{}
+	err := d.db.WithContext(ctx).Where("id = ?", id).First(webhook).Error
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// GetWebhooks get all the webhooks in the order they were created
+func (d *usersDao) GetWebhooks(ctx context.Context) ([]*model.UsersWebhook, error) {
+	webhooks := []*model.UsersWebhook{}
+	err := d.db.WithContext(ctx).Order("id").Find(&webhooks).Error
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// GetWebhookDeliveries get a page of the delivery log of a webhook, the last queued first, status keeps
+// the deliveries in that state when it is not empty
+func (d *usersDao) GetWebhookDeliveries(ctx context.Context, webhookID uint64, status string, page int, limit int) ([]*model.UsersWebhookDelivery, int64, error) {
+	db := d.db.WithContext(ctx).Model(&model.UsersWebhookDelivery{}).Where("webhook_id = ?", webhookID)
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+
+	var total int64
+	err := db.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	deliveries := []*model.UsersWebhookDelivery{}
+	err = db.Order("id DESC").Limit(limit).Offset(page * limit).Find(&deliveries).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return deliveries, total, nil
+}
+
+// RedeliverWebhook queue a new delivery of the event of a delivery of the webhook, it is sent at the next
+// poll with all its attempts whatever the state of the original. database.ErrRecordNotFound is returned
+// when the webhook has no such delivery.
+func (d *usersDao) RedeliverWebhook(ctx context.Context, webhookID uint64, deliveryID uint64) (*model.UsersWebhookDelivery, error) {
+	delivery := &model.UsersWebhookDelivery{}
+	err := d.db.WithContext(ctx).Where("id = ? AND webhook_id = ?", deliveryID, webhookID).First(delivery).Error
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	redelivery := &model.UsersWebhookDelivery{
+		WebhookID:     delivery.WebhookID,
+		OutboxID:      delivery.OutboxID,
+		UsersID:       delivery.UsersID,
+		Event:         delivery.Event,
+		Payload:       delivery.Payload,
+		Status:        model.UsersWebhookPending,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	err = d.db.WithContext(ctx).Create(redelivery).Error
+	if err != nil {
+		return nil, database.TranslateError(err)
+	}
+	return redelivery, nil
+}
+
+// usersWebhookClaim how long the deliveries claimed by a dispatcher are left to it, their next attempt is
+// pushed out by it and deliver is given a context that ends with it
+const usersWebhookClaim = 5 * time.Minute
+
+// DeliverWebhooks claim the pending deliveries that are due, at most limit, pass each with its webhook to
+// deliver and save the outcome of the attempt deliver sets on it, the number of deliveries claimed is
+// returned. They are claimed in a short transaction that pushes their next attempt out so that the
+// dispatcher of another instance takes the next ones, deliver runs outside of it and the outcomes are saved
+// in another one. The deliveries not attempted before ctx ends are due again at once.
+func (d *usersDao) DeliverWebhooks(ctx context.Context, limit int,
+	deliver func(ctx context.Context, delivery *model.UsersWebhookDelivery, webhook *model.UsersWebhook)) (int, error) {
+	claimedUntil := time.Now().Add(usersWebhookClaim)
+	var deliveries []*model.UsersWebhookDelivery
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", model.UsersWebhookPending, time.Now()).
+			Order("next_attempt_at, id").Limit(limit).Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+
+		ids := make([]uint64, len(deliveries))
+		for i, delivery := range deliveries {
+			ids[i] = delivery.ID
+		}
+		return tx.Model(&model.UsersWebhookDelivery{}).Where("id IN (?)", ids).Update("next_attempt_at", claimedUntil).Error
+	}, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return 0, err
+	}
+	n := len(deliveries)
+	if n == 0 {
+		return 0, nil
+	}
+
+	ids := make([]uint64, 0, n)
+	for _, delivery := range deliveries {
+		if !slices.Contains(ids, delivery.WebhookID) {
+			ids = append(ids, delivery.WebhookID)
+		}
+	}
+	var webhooks []*model.UsersWebhook
+	err = d.db.WithContext(ctx).Where("id IN (?)", ids).Find(&webhooks).Error
+	if err != nil {
+		return n, err
+	}
+	byID := make(map[uint64]*model.UsersWebhook, len(webhooks))
+	for _, webhook := range webhooks {
+		byID[webhook.ID] = webhook
+	}
+
+	deliverCtx, cancel := context.WithDeadline(ctx, claimedUntil)
+	defer cancel()
+	attempted := make([]*model.UsersWebhookDelivery, 0, n)
+	var due []*model.UsersWebhookDelivery
+	for _, delivery := range deliveries {
+		nextAttemptAt := delivery.NextAttemptAt
+		if deliverCtx.Err() == nil {
+			deliver(deliverCtx, delivery, byID[delivery.WebhookID])
+		}
+		// an attempt cut short by the end of ctx is not counted
+		if deliverCtx.Err() != nil {
+			due = append(due, &model.UsersWebhookDelivery{ID: delivery.ID, NextAttemptAt: nextAttemptAt})
+			continue
+		}
+		attempted = append(attempted, delivery)
+	}
+
+	// the outcomes are saved also when ctx ended while delivering
+	err = d.db.WithContext(context.WithoutCancel(ctx)).Transaction(func(tx *gorm.DB) error {
+		for _, delivery := range attempted {
+			err := tx.Model(delivery).Select(usersWebhookAttemptColumns).Updates(delivery).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, delivery := range due {
+			err := tx.Model(&model.UsersWebhookDelivery{}).Where("id = ?", delivery.ID).Update("next_attempt_at", delivery.NextAttemptAt).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,316 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"test-user-server/internal/database"
+	"test-user-server/internal/model"
+)
+
+var (
+	usersWebhookColumns         = []string{"id", "url", "secret", "events", "columns", "active", "created_at", "updated_at"}
+	usersWebhookDeliveryColumns = []string{"id", "webhook_id", "outbox_id", "users_id", "event", "payload", "status", "attempts", "next_attempt_at"}
+)
+
+func Test_usersWebhookMatches(t *testing.T) {
+	event := &model.UsersEvent{
+		Type:    model.UsersEventUpdated,
+		Columns: []string{"position_title"},
+		Changes: map[string]model.UsersChange{"position_title": {Before: "Intern", After: "Engineer"}},
+	}
+	assert.True(t, usersWebhookMatches(&model.UsersWebhook{}, event))
+	assert.True(t, usersWebhookMatches(&model.UsersWebhook{Events: "user.created,user.updated"}, event))
+	assert.False(t, usersWebhookMatches(&model.UsersWebhook{Events: "user.deleted"}, event))
+	assert.True(t, usersWebhookMatches(&model.UsersWebhook{Columns: "job_level,position_title"}, event))
+	assert.False(t, usersWebhookMatches(&model.UsersWebhook{Columns: "job_level"}, event))
+	assert.False(t, usersWebhookMatches(&model.UsersWebhook{Events: "user.deleted", Columns: "position_title"}, event))
+}
+
+func Test_usersDao_CreateWebhook(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+
+	webhook := &model.UsersWebhook{URL: "https://hr.example.com/hooks", Secret: "secret", Columns: "position_title", Active: true}
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectExec("INSERT INTO `users_webhook` \\(`url`,`secret`,`events`,`columns`,`active`,`created_at`,`updated_at`\\)").
+		WithArgs(webhook.URL, "secret", "", "position_title", true, d.AnyTime, d.AnyTime).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	d.SQLMock.ExpectCommit()
+
+	err := d.IDao.(UsersDao).CreateWebhook(d.Ctx, webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(3), webhook.ID)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+}
+
+func Test_usersDao_UpdateWebhook(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+
+	webhook := &model.UsersWebhook{ID: 3, URL: "https://hr.example.com/hooks", Secret: "secret", Events: model.UsersEventUpdated}
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectExec("UPDATE `users_webhook` SET `url`=\\?,`secret`=\\?,`events`=\\?,`columns`=\\?,`active`=\\?,`updated_at`=\\? WHERE `id` = \\?").
+		WithArgs(webhook.URL, "secret", model.UsersEventUpdated, "", false, d.AnyTime, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	d.SQLMock.ExpectCommit()
+
+	err := d.IDao.(UsersDao).UpdateWebhook(d.Ctx, webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not found
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectExec("UPDATE .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	d.SQLMock.ExpectCommit()
+	err = d.IDao.(UsersDao).UpdateWebhook(d.Ctx, webhook)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+
+	err = d.IDao.(UsersDao).UpdateWebhook(d.Ctx, &model.UsersWebhook{})
+	assert.Error(t, err)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+}
+
+func Test_usersDao_DeleteWebhook(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectExec("DELETE FROM `users_webhook_delivery` WHERE webhook_id = \\?").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	d.SQLMock.ExpectExec("DELETE FROM `users_webhook` WHERE id = \\?").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	d.SQLMock.ExpectCommit()
+
+	err := d.IDao.(UsersDao).DeleteWebhook(d.Ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not found
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectExec("DELETE FROM `users_webhook_delivery` .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	d.SQLMock.ExpectExec("DELETE FROM `users_webhook` .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	d.SQLMock.ExpectRollback()
+	err = d.IDao.(UsersDao).DeleteWebhook(d.Ctx, 3)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+
+	err = d.IDao.(UsersDao).DeleteWebhook(d.Ctx, 0)
+	assert.Error(t, err)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+}
+
+func Test_usersDao_GetWebhook(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook` WHERE id = \\? ORDER BY `users_webhook`.`id` LIMIT \\?").
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows(usersWebhookColumns).
+			AddRow(3, "https://hr.example.com/hooks", "secret", "", "position_title", true, time.Now(), time.Now()))
+
+	webhook, err := d.IDao.(UsersDao).GetWebhook(d.Ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "https://hr.example.com/hooks", webhook.URL)
+	assert.Equal(t, "position_title", webhook.Columns)
+
+	// not found
+	d.SQLMock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows(usersWebhookColumns))
+	_, err = d.IDao.(UsersDao).GetWebhook(d.Ctx, 4)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+}
+
+func Test_usersDao_GetWebhooks(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook` ORDER BY id").
+		WillReturnRows(sqlmock.NewRows(usersWebhookColumns).
+			AddRow(1, "https://a.example.com", "s1", "", "", true, time.Now(), time.Now()).
+			AddRow(2, "https://b.example.com", "s2", model.UsersEventDeleted, "", false, time.Now(), time.Now()))
+
+	webhooks, err := d.IDao.(UsersDao).GetWebhooks(d.Ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, webhooks, 2)
+	assert.False(t, webhooks[1].Active)
+
+	// error
+	d.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	_, err = d.IDao.(UsersDao).GetWebhooks(d.Ctx)
+	assert.Error(t, err)
+}
+
+func Test_usersDao_GetWebhookDeliveries(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+
+	d.SQLMock.ExpectQuery("SELECT count\\(\\*\\) FROM `users_webhook_delivery` WHERE webhook_id = \\? AND status = \\?").
+		WithArgs(3, model.UsersWebhookDead).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook_delivery` WHERE webhook_id = \\? AND status = \\? ORDER BY id DESC LIMIT \\? OFFSET \\?").
+		WithArgs(3, model.UsersWebhookDead, 2, 2).
+		WillReturnRows(sqlmock.NewRows(usersWebhookDeliveryColumns).
+			AddRow(9, 3, 41, 1, model.UsersEventUpdated, `{}`, model.UsersWebhookDead, 8, nil))
+
+	deliveries, total, err := d.IDao.(UsersDao).GetWebhookDeliveries(d.Ctx, 3, model.UsersWebhookDead, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(3), total)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, 8, deliveries[0].Attempts)
+
+	// all the states, nothing delivered yet
+	d.SQLMock.ExpectQuery("SELECT count\\(\\*\\) FROM `users_webhook_delivery` WHERE webhook_id = \\?$").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	deliveries, total, err = d.IDao.(UsersDao).GetWebhookDeliveries(d.Ctx, 4, "", 0, 20)
+	assert.NoError(t, err)
+	assert.Zero(t, total)
+	assert.Empty(t, deliveries)
+
+	// error
+	d.SQLMock.ExpectQuery("SELECT count.*").WillReturnError(sql.ErrConnDone)
+	_, _, err = d.IDao.(UsersDao).GetWebhookDeliveries(d.Ctx, 3, "", 0, 20)
+	assert.Error(t, err)
+}
+
+func Test_usersDao_RedeliverWebhook(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook_delivery` WHERE id = \\? AND webhook_id = \\? ORDER BY .* LIMIT \\?").
+		WithArgs(9, 3, 1).
+		WillReturnRows(sqlmock.NewRows(usersWebhookDeliveryColumns).
+			AddRow(9, 3, 41, 1, model.UsersEventUpdated, `{"offset":41}`, model.UsersWebhookDead, 8, nil))
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectExec("INSERT INTO `users_webhook_delivery` .*").
+		WithArgs(3, 41, 1, model.UsersEventUpdated, `{"offset":41}`, model.UsersWebhookPending, 0, d.AnyTime, 0, "", nil, d.AnyTime, d.AnyTime).
+		WillReturnResult(sqlmock.NewResult(10, 1))
+	d.SQLMock.ExpectCommit()
+
+	delivery, err := d.IDao.(UsersDao).RedeliverWebhook(d.Ctx, 3, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(10), delivery.ID)
+	assert.Equal(t, model.UsersWebhookPending, delivery.Status)
+	assert.NotNil(t, delivery.NextAttemptAt)
+
+	// a delivery of another webhook
+	d.SQLMock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows(usersWebhookDeliveryColumns))
+	_, err = d.IDao.(UsersDao).RedeliverWebhook(d.Ctx, 4, 9)
+	assert.ErrorIs(t, err, database.ErrRecordNotFound)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+}
+
+func Test_usersDao_DeliverWebhooks(t *testing.T) {
+	d := newUsersDao()
+	defer d.Close()
+	now := time.Now()
+
+	// the deliveries are claimed, attempted outside of the transaction and then saved
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook_delivery` WHERE status = \\? AND next_attempt_at <= \\? ORDER BY next_attempt_at, id LIMIT \\? FOR UPDATE SKIP LOCKED").
+		WithArgs(model.UsersWebhookPending, d.AnyTime, 20).
+		WillReturnRows(sqlmock.NewRows(usersWebhookDeliveryColumns).
+			AddRow(9, 3, 41, 1, model.UsersEventUpdated, `{}`, model.UsersWebhookPending, 0, now).
+			AddRow(10, 4, 41, 1, model.UsersEventUpdated, `{}`, model.UsersWebhookPending, 2, now))
+	d.SQLMock.ExpectExec("UPDATE `users_webhook_delivery` SET `next_attempt_at`=\\?,`updated_at`=\\? WHERE id IN \\(\\?,\\?\\)").
+		WithArgs(d.AnyTime, d.AnyTime, 9, 10).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	d.SQLMock.ExpectCommit()
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook` WHERE id IN \\(\\?,\\?\\)").
+		WithArgs(3, 4).
+		WillReturnRows(sqlmock.NewRows(usersWebhookColumns).
+			AddRow(3, "https://hr.example.com/hooks", "secret", "", "", true, now, now))
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectExec("UPDATE `users_webhook_delivery` SET `status`=\\?,`attempts`=\\?,`next_attempt_at`=\\?,`response_status`=\\?,`last_error`=\\?,`delivered_at`=\\?,`updated_at`=\\? WHERE `id` = \\?").
+		WithArgs(model.UsersWebhookSucceeded, 1, nil, 204, "", d.AnyTime, d.AnyTime, 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	d.SQLMock.ExpectExec("UPDATE `users_webhook_delivery` .*").
+		WithArgs(model.UsersWebhookDead, 2, nil, 0, "webhook was deleted", nil, d.AnyTime, 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	d.SQLMock.ExpectCommit()
+
+	n, err := d.IDao.(UsersDao).DeliverWebhooks(d.Ctx, 20, func(ctx context.Context, delivery *model.UsersWebhookDelivery, webhook *model.UsersWebhook) {
+		// delivering ends with the claim
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, now.Add(usersWebhookClaim), deadline, time.Second)
+		delivery.NextAttemptAt = nil
+		if webhook == nil {
+			delivery.Status, delivery.LastError = model.UsersWebhookDead, "webhook was deleted"
+			return
+		}
+		assert.Equal(t, "secret", webhook.Secret)
+		now := time.Now()
+		delivery.Status, delivery.Attempts, delivery.ResponseStatus, delivery.DeliveredAt = model.UsersWebhookSucceeded, 1, 204, &now
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, n)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// an attempt cut short by the end of ctx is not saved, it is due again with the ones left
+	ctx, cancel := context.WithCancel(d.Ctx)
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectQuery("SELECT .*").
+		WillReturnRows(sqlmock.NewRows(usersWebhookDeliveryColumns).
+			AddRow(9, 3, 41, 1, model.UsersEventUpdated, `{}`, model.UsersWebhookPending, 0, now).
+			AddRow(10, 3, 42, 1, model.UsersEventUpdated, `{}`, model.UsersWebhookPending, 0, now))
+	d.SQLMock.ExpectExec("UPDATE .*").WillReturnResult(sqlmock.NewResult(0, 2))
+	d.SQLMock.ExpectCommit()
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook` .*").
+		WillReturnRows(sqlmock.NewRows(usersWebhookColumns).AddRow(3, "https://hr.example.com/hooks", "secret", "", "", true, now, now))
+	d.SQLMock.ExpectBegin()
+	for _, id := range []uint64{9, 10} {
+		d.SQLMock.ExpectExec("UPDATE `users_webhook_delivery` SET `next_attempt_at`=\\?,`updated_at`=\\? WHERE id = \\?").
+			WithArgs(d.AnyTime, d.AnyTime, id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	d.SQLMock.ExpectCommit()
+	attempts := 0
+	n, err = d.IDao.(UsersDao).DeliverWebhooks(ctx, 20, func(_ context.Context, delivery *model.UsersWebhookDelivery, _ *model.UsersWebhook) {
+		attempts++
+		delivery.Attempts, delivery.LastError = 1, "context canceled"
+		cancel()
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 1, attempts)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// nothing due
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows(usersWebhookDeliveryColumns))
+	d.SQLMock.ExpectCommit()
+	n, err = d.IDao.(UsersDao).DeliverWebhooks(d.Ctx, 20, func(context.Context, *model.UsersWebhookDelivery, *model.UsersWebhook) {
+		t.Fatal("deliver called without deliveries")
+	})
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+
+	// error
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	d.SQLMock.ExpectRollback()
+	_, err = d.IDao.(UsersDao).DeliverWebhooks(d.Ctx, 20, nil)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+}
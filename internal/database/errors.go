@@ -0,0 +1,95 @@
+package database
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// classes of the mysql errors a caller can act on, test them with errors.Is,
+// errors.As with *Error also gives the column named by the server
+var (
+	ErrDuplicateKey = errors.New("duplicate key")
+	ErrForeignKey   = errors.New("foreign key constraint fails")
+	ErrDataTooLong  = errors.New("data too long for column")
+	ErrDeadlock     = errors.New("deadlock found when trying to get lock")
+)
+
+// mysql server error numbers, https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+const (
+	erDupEntry         = 1062
+	erRowIsReferenced  = 1451
+	erNoReferencedRow  = 1452
+	erRowIsReferenced2 = 1217
+	erDataTooLong      = 1406
+	erLockDeadlock     = 1213
+	erLockWaitTimeout  = 1205
+)
+
+var (
+	foreignKeyColumnRe  = regexp.MustCompile("FOREIGN KEY \\(`([^`]+)`\\)")
+	tooLongColumnRe     = regexp.MustCompile(`for column '([^']+)'`)
+	duplicateKeyIndexRe = regexp.MustCompile(`for key '([^']+)'$`)
+)
+
+// Error a mysql error translated into one of the classes above
+type Error struct {
+	Kind   error  // ErrDuplicateKey, ErrForeignKey, ErrDataTooLong or ErrDeadlock
+	Column string // column named by the server, empty when it cannot be told
+	Err    error  // the driver error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap make errors.Is hold for both the class and the driver error
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// TranslateError classify a mysql driver error, nil and any other error are returned unchanged
+func TranslateError(err error) error {
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		return err
+	}
+
+	switch myErr.Number {
+	case erDupEntry:
+		return &Error{Kind: ErrDuplicateKey, Column: duplicateKeyColumn(myErr.Message), Err: err}
+	case erRowIsReferenced, erNoReferencedRow, erRowIsReferenced2:
+		return &Error{Kind: ErrForeignKey, Column: submatch(foreignKeyColumnRe, myErr.Message), Err: err}
+	case erDataTooLong:
+		return &Error{Kind: ErrDataTooLong, Column: submatch(tooLongColumnRe, myErr.Message), Err: err}
+	case erLockDeadlock, erLockWaitTimeout:
+		return &Error{Kind: ErrDeadlock, Err: err}
+	}
+	return err
+}
+
+// duplicateKeyColumn the column of the unique index in "Duplicate entry 'x' for key 'users.index_users_on_email'",
+// indexes named by rails are index_<table>_on_<columns>, other names are returned as they are
+func duplicateKeyColumn(msg string) string {
+	key := submatch(duplicateKeyIndexRe, msg)
+	if i := strings.LastIndexByte(key, '.'); i >= 0 { // mysql 8 prefixes the table
+		key = key[i+1:]
+	}
+	if key == "PRIMARY" {
+		return "id"
+	}
+	if i := strings.Index(key, "_on_"); strings.HasPrefix(key, "index_") && i > 0 {
+		return key[i+len("_on_"):]
+	}
+	return key
+}
+
+func submatch(re *regexp.Regexp, s string) string {
+	m := re.FindStringSubmatch(s)
+	if len(m) < 2 {
+		return ""
+	}
+	return m[1]
+}
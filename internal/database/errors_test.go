@@ -0,0 +1,43 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslateError(t *testing.T) {
+	tests := []struct {
+		err    *mysql.MySQLError
+		kind   error
+		column string
+	}{
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'foo@bar.com' for key 'users.index_users_on_email'"}, ErrDuplicateKey, "email"},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'index_users_on_windows_sid'"}, ErrDuplicateKey, "windows_sid"},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}, ErrDuplicateKey, "id"},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'users.uniq_mobile'"}, ErrDuplicateKey, "uniq_mobile"},
+		{&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (`db`.`users`, CONSTRAINT `fk_rails_ae14a5013f` FOREIGN KEY (`invited_by_id`) REFERENCES `users` (`id`))"}, ErrForeignKey, "invited_by_id"},
+		{&mysql.MySQLError{Number: 1451, Message: "Cannot delete or update a parent row: a foreign key constraint fails (`db`.`posts`, CONSTRAINT `fk_rails_5b5ddfd518` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))"}, ErrForeignKey, "user_id"},
+		{&mysql.MySQLError{Number: 1406, Message: "Data too long for column 'email' at row 1"}, ErrDataTooLong, "email"},
+		{&mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock; try restarting transaction"}, ErrDeadlock, ""},
+	}
+	for _, tt := range tests {
+		err := TranslateError(fmt.Errorf("wrapped: %w", tt.err))
+		assert.ErrorIs(t, err, tt.kind, tt.err.Message)
+		assert.ErrorIs(t, err, tt.err)
+		var dbErr *Error
+		if assert.ErrorAs(t, err, &dbErr) {
+			assert.Equal(t, tt.column, dbErr.Column, tt.err.Message)
+		}
+	}
+
+	// other errors are left unchanged
+	assert.Nil(t, TranslateError(nil))
+	assert.Equal(t, ErrRecordNotFound, TranslateError(ErrRecordNotFound))
+	other := &mysql.MySQLError{Number: 1146, Message: "Table 'db.users' doesn't exist"}
+	assert.Equal(t, error(other), TranslateError(other))
+	assert.False(t, errors.Is(TranslateError(other), ErrDuplicateKey))
+}
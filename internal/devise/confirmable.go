@@ -0,0 +1,58 @@
+package devise
+
+import (
+	"time"
+
+	"test-user-server/internal/model"
+)
+
+// ConfirmationTokenColumn column holding the confirmation token, devise stores it in clear since 3.5
+const ConfirmationTokenColumn = "confirmation_token"
+
+// ReconfirmableEnabled report whether an email change must be confirmed before it takes effect
+func ReconfirmableEnabled() bool {
+	return opts.reconfirmable
+}
+
+// Confirmed report whether the account was ever confirmed, same as Devise::Models::Confirmable#confirmed?
+func Confirmed(users *model.Users) bool {
+	return users.ConfirmedAt != nil && !users.ConfirmedAt.IsZero()
+}
+
+// PendingReconfirmation report whether an email change waits for confirmation
+func PendingReconfirmation(users *model.Users) bool {
+	return opts.reconfirmable && users.UnconfirmedEmail != ""
+}
+
+// ConfirmationPeriodExpired report whether the confirmation token is older than config.confirm_within,
+// same as Devise::Models::Confirmable#confirmation_period_expired?
+func ConfirmationPeriodExpired(users *model.Users, now time.Time) bool {
+	if opts.confirmWithin == 0 || users.ConfirmationSentAt == nil || users.ConfirmationSentAt.IsZero() {
+		return false
+	}
+	return now.After(users.ConfirmationSentAt.Add(opts.confirmWithin))
+}
+
+// ActiveForAuthentication report whether an unconfirmed account may still sign in, same as the
+// confirmable part of Devise::Models::Confirmable#active_for_authentication?
+func ActiveForAuthentication(users *model.Users, now time.Time) bool {
+	if Confirmed(users) || opts.allowUnconfirmedAccessFor < 0 {
+		return true
+	}
+	if opts.allowUnconfirmedAccessFor == 0 || users.ConfirmationSentAt == nil || users.ConfirmationSentAt.IsZero() {
+		return false
+	}
+	return !users.ConfirmationSentAt.Before(now.Add(-opts.allowUnconfirmedAccessFor))
+}
+
+// GenerateConfirmationToken set a new confirmation token, same as Devise::Models::Confirmable#generate_confirmation_token,
+// the token is stored in clear and also returned to be mailed
+func GenerateConfirmationToken(users *model.Users, now time.Time) (string, error) {
+	token, err := FriendlyToken(20)
+	if err != nil {
+		return "", err
+	}
+	users.ConfirmationToken = token
+	users.ConfirmationSentAt = &now
+	return token, nil
+}
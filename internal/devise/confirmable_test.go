@@ -0,0 +1,57 @@
+package devise
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"test-user-server/internal/model"
+)
+
+func TestActiveForAuthentication(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-time.Minute)
+	old := now.Add(-2 * time.Hour)
+
+	// unconfirmed accounts are never refused by default
+	Init()
+	assert.True(t, ActiveForAuthentication(&model.Users{}, now))
+
+	Init(WithConfirmable(true, 0, 0))
+	defer Init()
+	assert.False(t, ActiveForAuthentication(&model.Users{ConfirmationSentAt: &recent}, now))
+	assert.True(t, ActiveForAuthentication(&model.Users{ConfirmedAt: &old}, now))
+
+	Init(WithConfirmable(true, time.Hour, 0))
+	assert.True(t, ActiveForAuthentication(&model.Users{ConfirmationSentAt: &recent}, now))
+	assert.False(t, ActiveForAuthentication(&model.Users{ConfirmationSentAt: &old}, now))
+	assert.False(t, ActiveForAuthentication(&model.Users{}, now))
+}
+
+func TestConfirmationPeriodExpired(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+
+	Init()
+	assert.False(t, ConfirmationPeriodExpired(&model.Users{ConfirmationSentAt: &old}, now))
+
+	Init(WithConfirmable(true, -1, time.Hour))
+	defer Init()
+	assert.True(t, ConfirmationPeriodExpired(&model.Users{ConfirmationSentAt: &old}, now))
+	assert.False(t, ConfirmationPeriodExpired(&model.Users{}, now))
+}
+
+func TestGenerateConfirmationToken(t *testing.T) {
+	Init()
+	users := &model.Users{UnconfirmedEmail: "new@bar.com"}
+	now := time.Now()
+
+	token, err := GenerateConfirmationToken(users, now)
+	assert.NoError(t, err)
+	assert.Len(t, token, 20)
+	assert.Equal(t, token, users.ConfirmationToken)
+	assert.Equal(t, now, *users.ConfirmationSentAt)
+	assert.True(t, PendingReconfirmation(users))
+	assert.False(t, Confirmed(users))
+}
@@ -0,0 +1,177 @@
+// Package devise implements the parts of the Rails devise gem that this service shares with the
+// Rails application through the users table, so that records written here stay valid there.
+package devise
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// DefaultStretches bcrypt cost used by devise outside of the test environment
+	DefaultStretches = 12
+	// DefaultMaximumAttempts devise default of config.maximum_attempts
+	DefaultMaximumAttempts = 20
+	// DefaultUnlockIn devise default of config.unlock_in
+	DefaultUnlockIn = time.Hour
+	// DefaultResetPasswordWithin devise default of config.reset_password_within
+	DefaultResetPasswordWithin = 6 * time.Hour
+
+	// LockStrategyFailedAttempts lock the account after too many failed sign in attempts
+	LockStrategyFailedAttempts = "failed_attempts"
+	// LockStrategyNone never lock the account
+	LockStrategyNone = "none"
+
+	// UnlockStrategyEmail unlock with the token sent by email
+	UnlockStrategyEmail = "email"
+	// UnlockStrategyTime unlock automatically after unlockIn
+	UnlockStrategyTime = "time"
+	// UnlockStrategyBoth unlock by email or time, whichever comes first
+	UnlockStrategyBoth = "both"
+	// UnlockStrategyNone only an administrator can unlock
+	UnlockStrategyNone = "none"
+)
+
+var opts = defaultOptions()
+
+type options struct {
+	stretches int
+	pepper    string
+
+	secretKey       string
+	keyHashDigest   string
+	lockStrategy    string
+	unlockStrategy  string
+	maximumAttempts int
+	unlockIn        time.Duration
+
+	resetPasswordWithin time.Duration
+
+	reconfirmable             bool
+	allowUnconfirmedAccessFor time.Duration // negative means forever
+	confirmWithin             time.Duration // zero means confirmation tokens never expire
+
+	invitationLimit int           // zero means unlimited
+	inviteFor       time.Duration // zero means invitations never expire
+
+	dummyOnce sync.Once // hashes dummyHash at the first sign in with an unknown email
+	dummyHash []byte
+}
+
+func defaultOptions() *options {
+	return &options{
+		stretches:       DefaultStretches,
+		keyHashDigest:   "SHA256",
+		lockStrategy:    LockStrategyFailedAttempts,
+		unlockStrategy:  UnlockStrategyBoth,
+		maximumAttempts: DefaultMaximumAttempts,
+		unlockIn:        DefaultUnlockIn,
+
+		resetPasswordWithin: DefaultResetPasswordWithin,
+
+		reconfirmable:             true,
+		allowUnconfirmedAccessFor: -1,
+	}
+}
+
+// Option set the devise options.
+type Option func(*options)
+
+func (o *options) apply(opts ...Option) {
+	for _, opt := range opts {
+		opt(o)
+	}
+}
+
+// WithStretches set the bcrypt cost, same as config.stretches
+func WithStretches(cost int) Option {
+	return func(o *options) {
+		if cost >= bcrypt.MinCost && cost <= bcrypt.MaxCost {
+			o.stretches = cost
+		}
+	}
+}
+
+// WithPepper set the string appended to passwords before hashing, same as config.pepper
+func WithPepper(pepper string) Option {
+	return func(o *options) {
+		o.pepper = pepper
+	}
+}
+
+// WithSecretKey set the secret used to derive token digest keys, same as config.secret_key
+// (defaults to secret_key_base in rails). hashDigest is the ActiveSupport::KeyGenerator digest,
+// SHA256 since rails 7.0 defaults, SHA1 before.
+func WithSecretKey(secretKey string, hashDigest string) Option {
+	return func(o *options) {
+		o.secretKey = secretKey
+		if hashDigest != "" {
+			o.keyHashDigest = hashDigest
+		}
+	}
+}
+
+// WithLockable set the lockable options, same as config.lock_strategy, config.unlock_strategy,
+// config.maximum_attempts and config.unlock_in
+func WithLockable(lockStrategy string, unlockStrategy string, maximumAttempts int, unlockIn time.Duration) Option {
+	return func(o *options) {
+		if lockStrategy != "" {
+			o.lockStrategy = lockStrategy
+		}
+		if unlockStrategy != "" {
+			o.unlockStrategy = unlockStrategy
+		}
+		if maximumAttempts > 0 {
+			o.maximumAttempts = maximumAttempts
+		}
+		if unlockIn > 0 {
+			o.unlockIn = unlockIn
+		}
+	}
+}
+
+// WithRecoverable set how long a reset password token is valid, same as config.reset_password_within
+func WithRecoverable(resetPasswordWithin time.Duration) Option {
+	return func(o *options) {
+		if resetPasswordWithin > 0 {
+			o.resetPasswordWithin = resetPasswordWithin
+		}
+	}
+}
+
+// WithConfirmable set the confirmable options, same as config.reconfirmable, config.allow_unconfirmed_access_for
+// and config.confirm_within. A negative allowUnconfirmedAccessFor never refuses unconfirmed accounts (nil in devise),
+// zero refuses them right away, a zero confirmWithin means confirmation tokens never expire.
+func WithConfirmable(reconfirmable bool, allowUnconfirmedAccessFor time.Duration, confirmWithin time.Duration) Option {
+	return func(o *options) {
+		o.reconfirmable = reconfirmable
+		o.allowUnconfirmedAccessFor = allowUnconfirmedAccessFor
+		if confirmWithin >= 0 {
+			o.confirmWithin = confirmWithin
+		}
+	}
+}
+
+// WithInvitable set the devise_invitable options, same as config.invitation_limit and config.invite_for,
+// a zero invitationLimit means unlimited (nil in devise_invitable), a zero inviteFor means invitations never expire
+func WithInvitable(invitationLimit int, inviteFor time.Duration) Option {
+	return func(o *options) {
+		if invitationLimit >= 0 {
+			o.invitationLimit = invitationLimit
+		}
+		if inviteFor >= 0 {
+			o.inviteFor = inviteFor
+		}
+	}
+}
+
+// Init set the devise options, they must be the same as config/initializers/devise.rb of
+// the Rails app, options that are not set fall back to the devise defaults.
+func Init(opt ...Option) {
+	o := defaultOptions()
+	o.apply(opt...)
+	opts = o
+	resetTokenKeys()
+}
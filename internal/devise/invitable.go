@@ -0,0 +1,56 @@
+package devise
+
+import (
+	"time"
+
+	"test-user-server/internal/model"
+)
+
+const (
+	// InvitationTokenColumn column holding the digest of the invitation token
+	InvitationTokenColumn = "invitation_token"
+	// InvitedByTypeUsers class name stored in the polymorphic invited_by_type by the rails app
+	InvitedByTypeUsers = "User"
+)
+
+// InvitationLimit the number of invitations of an inviter whose invitation_limit is NULL, zero means unlimited
+func InvitationLimit() int {
+	return opts.invitationLimit
+}
+
+// InvitedToSignUp report whether the users has a pending invitation, same as
+// Devise::Models::Invitable#invited_to_sign_up?
+func InvitedToSignUp(users *model.Users) bool {
+	return users.InvitationToken != "" && (users.InvitationAcceptedAt == nil || users.InvitationAcceptedAt.IsZero())
+}
+
+// InvitationPeriodValid report whether the invitation was created within config.invite_for,
+// same as Devise::Models::Invitable#invitation_period_valid?
+func InvitationPeriodValid(users *model.Users, now time.Time) bool {
+	if opts.inviteFor == 0 {
+		return true
+	}
+	t := users.InvitationCreatedAt
+	if t == nil || t.IsZero() {
+		t = users.InvitationSentAt
+	}
+	if t == nil || t.IsZero() {
+		return false
+	}
+	return !t.Before(now.Add(-opts.inviteFor))
+}
+
+// GenerateInvitationToken set the digest of a new invitation token and the invitation timestamps,
+// the raw token is returned to be mailed, same as Devise::Models::Invitable#invite!
+func GenerateInvitationToken(users *model.Users, now time.Time) (string, error) {
+	raw, enc, err := GenerateToken(InvitationTokenColumn)
+	if err != nil {
+		return "", err
+	}
+	users.InvitationToken = enc
+	if users.InvitationCreatedAt == nil || users.InvitationCreatedAt.IsZero() {
+		users.InvitationCreatedAt = &now
+	}
+	users.InvitationSentAt = &now
+	return raw, nil
+}
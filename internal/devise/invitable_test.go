@@ -0,0 +1,50 @@
+package devise
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"test-user-server/internal/model"
+)
+
+func TestInvitationPeriodValid(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+
+	Init()
+	assert.True(t, InvitationPeriodValid(&model.Users{}, now))
+	assert.Equal(t, 0, InvitationLimit())
+
+	Init(WithInvitable(5, time.Hour))
+	defer Init()
+	assert.Equal(t, 5, InvitationLimit())
+	assert.False(t, InvitationPeriodValid(&model.Users{InvitationCreatedAt: &old}, now))
+	assert.True(t, InvitationPeriodValid(&model.Users{InvitationCreatedAt: &now}, now))
+	assert.True(t, InvitationPeriodValid(&model.Users{InvitationSentAt: &now}, now))
+	assert.False(t, InvitationPeriodValid(&model.Users{}, now))
+}
+
+func TestGenerateInvitationToken(t *testing.T) {
+	Init(WithSecretKey("secret", ""))
+	defer Init()
+	users := &model.Users{}
+	now := time.Now()
+
+	raw, err := GenerateInvitationToken(users, now)
+	assert.NoError(t, err)
+	digest, _ := DigestToken(InvitationTokenColumn, raw)
+	assert.Equal(t, digest, users.InvitationToken)
+	assert.True(t, InvitedToSignUp(users))
+
+	// resending keeps the creation time
+	later := now.Add(time.Hour)
+	_, err = GenerateInvitationToken(users, later)
+	assert.NoError(t, err)
+	assert.Equal(t, now, *users.InvitationCreatedAt)
+	assert.Equal(t, later, *users.InvitationSentAt)
+
+	users.InvitationAcceptedAt = &later
+	assert.False(t, InvitedToSignUp(users))
+}
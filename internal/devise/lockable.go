@@ -0,0 +1,40 @@
+package devise
+
+import (
+	"time"
+
+	"test-user-server/internal/model"
+)
+
+// UnlockTokenColumn column holding the digest of the unlock token
+const UnlockTokenColumn = "unlock_token"
+
+// LockStrategyEnabled report whether accounts are locked after too many failed attempts
+func LockStrategyEnabled() bool {
+	return opts.lockStrategy == LockStrategyFailedAttempts
+}
+
+// UnlockStrategyEnabled report whether the unlock strategy (email or time) is enabled,
+// same as Devise::Models::Lockable#unlock_strategy_enabled?
+func UnlockStrategyEnabled(strategy string) bool {
+	return opts.unlockStrategy == strategy || opts.unlockStrategy == UnlockStrategyBoth
+}
+
+// AttemptsExceeded report whether the failed attempts reached config.maximum_attempts
+func AttemptsExceeded(failedAttempts int) bool {
+	return failedAttempts >= opts.maximumAttempts
+}
+
+// LockExpired report whether a lock has expired with the time unlock strategy,
+// same as Devise::Models::Lockable#lock_expired?
+func LockExpired(users *model.Users, now time.Time) bool {
+	if !UnlockStrategyEnabled(UnlockStrategyTime) || users.LockedAt == nil || users.LockedAt.IsZero() {
+		return false
+	}
+	return users.LockedAt.Before(now.Add(-opts.unlockIn))
+}
+
+// AccessLocked report whether the account is locked, same as Devise::Models::Lockable#access_locked?
+func AccessLocked(users *model.Users, now time.Time) bool {
+	return users.LockedAt != nil && !users.LockedAt.IsZero() && !LockExpired(users, now)
+}
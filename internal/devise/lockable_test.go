@@ -0,0 +1,45 @@
+package devise
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"test-user-server/internal/model"
+)
+
+func TestAccessLocked(t *testing.T) {
+	Init(WithLockable("", UnlockStrategyTime, 3, time.Hour))
+	defer Init()
+
+	now := time.Now()
+	recent := now.Add(-time.Minute)
+	expired := now.Add(-2 * time.Hour)
+
+	assert.False(t, AccessLocked(&model.Users{}, now))
+	assert.True(t, AccessLocked(&model.Users{LockedAt: &recent}, now))
+	assert.False(t, AccessLocked(&model.Users{LockedAt: &expired}, now))
+	assert.True(t, LockExpired(&model.Users{LockedAt: &expired}, now))
+
+	// without the time strategy a lock never expires
+	Init(WithLockable("", UnlockStrategyEmail, 3, time.Hour))
+	assert.True(t, AccessLocked(&model.Users{LockedAt: &expired}, now))
+	assert.False(t, LockExpired(&model.Users{LockedAt: &expired}, now))
+}
+
+func TestAttemptsExceeded(t *testing.T) {
+	Init(WithLockable(LockStrategyFailedAttempts, "", 3, 0))
+	defer Init()
+
+	assert.True(t, LockStrategyEnabled())
+	assert.True(t, UnlockStrategyEnabled(UnlockStrategyEmail))
+	assert.True(t, UnlockStrategyEnabled(UnlockStrategyTime))
+	assert.False(t, AttemptsExceeded(2))
+	assert.True(t, AttemptsExceeded(3))
+
+	Init(WithLockable(LockStrategyNone, UnlockStrategyNone, 0, 0))
+	assert.False(t, LockStrategyEnabled())
+	assert.False(t, UnlockStrategyEnabled(UnlockStrategyEmail))
+	assert.Equal(t, DefaultMaximumAttempts, opts.maximumAttempts)
+}
@@ -0,0 +1,49 @@
+package devise
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt only uses the first 72 bytes of the input, ruby bcrypt truncates silently while
+// golang bcrypt returns an error, truncate here so that both sides hash the same bytes.
+const maxPasswordBytes = 72
+
+// DigestPassword hash the plaintext password the same way as Devise::Encryptor.digest,
+// the result is a $2a$ bcrypt hash that can be stored in encrypted_password.
+func DigestPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(peppered(password), opts.stretches)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// ValidPassword compare the plaintext password with encrypted_password the same way as
+// Devise::Encryptor.compare, an empty encrypted password never matches.
+func ValidPassword(encryptedPassword string, password string) bool {
+	if encryptedPassword == "" || password == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(encryptedPassword), peppered(password)) == nil
+}
+
+// ComparePasswordWithoutUser spend the time of ValidPassword when no account has the email, so that
+// the time of the reply does not reveal which emails have an account, like devise paranoid mode.
+// It always returns false.
+func ComparePasswordWithoutUser(password string) bool {
+	o := opts
+	o.dummyOnce.Do(func() {
+		// the cost is the configured one so that both paths take the same time
+		o.dummyHash, _ = bcrypt.GenerateFromPassword([]byte("devise dummy password"), o.stretches)
+	})
+	_ = bcrypt.CompareHashAndPassword(o.dummyHash, peppered(password))
+	return false
+}
+
+func peppered(password string) []byte {
+	b := []byte(password + opts.pepper)
+	if len(b) > maxPasswordBytes {
+		b = b[:maxPasswordBytes]
+	}
+	return b
+}
@@ -0,0 +1,62 @@
+package devise
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDigestPassword(t *testing.T) {
+	Init(WithStretches(bcrypt.MinCost))
+	defer Init()
+
+	hash, err := DigestPassword("123456")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$2a$04$"))
+	assert.True(t, ValidPassword(hash, "123456"))
+	assert.False(t, ValidPassword(hash, "1234567"))
+
+	// longer than 72 bytes is truncated like ruby bcrypt instead of failing
+	long := strings.Repeat("a", 100)
+	hash, err = DigestPassword(long)
+	assert.NoError(t, err)
+	assert.True(t, ValidPassword(hash, long[:72]))
+}
+
+func TestValidPassword(t *testing.T) {
+	Init()
+
+	// openbsd bcrypt test vector, the same format that ruby bcrypt writes
+	assert.True(t, ValidPassword("$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*U"))
+	assert.False(t, ValidPassword("", "U*U"))
+	assert.False(t, ValidPassword("$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", ""))
+}
+
+func TestInit_pepper(t *testing.T) {
+	Init(WithStretches(bcrypt.MinCost), WithPepper("pepper"))
+	defer Init()
+
+	hash, err := DigestPassword("123456")
+	assert.NoError(t, err)
+	assert.True(t, ValidPassword(hash, "123456"))
+
+	Init(WithStretches(bcrypt.MinCost))
+	assert.False(t, ValidPassword(hash, "123456"))
+
+	// invalid cost falls back to the devise default
+	Init(WithStretches(100))
+	assert.Equal(t, DefaultStretches, opts.stretches)
+}
+
+func TestComparePasswordWithoutUser(t *testing.T) {
+	Init(WithStretches(bcrypt.MinCost))
+	defer Init()
+
+	assert.False(t, ComparePasswordWithoutUser("123456"))
+	assert.False(t, ComparePasswordWithoutUser(""))
+	cost, err := bcrypt.Cost(opts.dummyHash)
+	assert.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost, cost)
+}
@@ -0,0 +1,19 @@
+package devise
+
+import (
+	"time"
+
+	"test-user-server/internal/model"
+)
+
+// ResetPasswordTokenColumn column holding the digest of the reset password token
+const ResetPasswordTokenColumn = "reset_password_token"
+
+// ResetPasswordPeriodValid report whether the reset password token was sent within
+// config.reset_password_within, same as Devise::Models::Recoverable#reset_password_period_valid?
+func ResetPasswordPeriodValid(users *model.Users, now time.Time) bool {
+	if users.ResetPasswordSentAt == nil || users.ResetPasswordSentAt.IsZero() {
+		return false
+	}
+	return !users.ResetPasswordSentAt.Before(now.Add(-opts.resetPasswordWithin))
+}
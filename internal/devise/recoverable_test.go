@@ -0,0 +1,23 @@
+package devise
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"test-user-server/internal/model"
+)
+
+func TestResetPasswordPeriodValid(t *testing.T) {
+	Init(WithRecoverable(time.Hour))
+	defer Init()
+
+	now := time.Now()
+	recent := now.Add(-time.Minute)
+	expired := now.Add(-2 * time.Hour)
+
+	assert.False(t, ResetPasswordPeriodValid(&model.Users{}, now))
+	assert.True(t, ResetPasswordPeriodValid(&model.Users{ResetPasswordSentAt: &recent}, now))
+	assert.False(t, ResetPasswordPeriodValid(&model.Users{ResetPasswordSentAt: &expired}, now))
+}
@@ -0,0 +1,87 @@
+package devise
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ActiveSupport::KeyGenerator defaults
+const (
+	keyIterations = 1 << 16
+	keySize       = 64
+)
+
+// ErrSecretKeyNotSet the devise secret key is required to generate and digest tokens
+var ErrSecretKeyNotSet = errors.New("devise secret key is not set")
+
+var tokenKeys sync.Map // column -> derived key
+
+func resetTokenKeys() {
+	tokenKeys = sync.Map{}
+}
+
+// keyFor derive the hmac key of a column the same way as Devise::TokenGenerator#key_for,
+// derivation is slow on purpose so the keys are cached.
+func keyFor(column string) []byte {
+	if v, ok := tokenKeys.Load(column); ok {
+		return v.([]byte)
+	}
+	h := sha256.New
+	if strings.EqualFold(opts.keyHashDigest, "SHA1") {
+		h = sha1.New
+	}
+	key := pbkdf2.Key([]byte(opts.secretKey), []byte("Devise "+column), keyIterations, keySize, h)
+	tokenKeys.Store(column, key)
+	return key
+}
+
+func hexHMAC(h func() hash.Hash, key []byte, value string) string {
+	mac := hmac.New(h, key)
+	_, _ = mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// DigestToken digest a raw token the same way as Devise.token_generator.digest, the result is
+// what is stored in the token column (unlock_token, reset_password_token).
+func DigestToken(column string, value string) (string, error) {
+	if opts.secretKey == "" {
+		return "", ErrSecretKeyNotSet
+	}
+	if value == "" {
+		return "", nil
+	}
+	return hexHMAC(sha256.New, keyFor(column), value), nil
+}
+
+// GenerateToken generate a raw token and its digest the same way as Devise.token_generator.generate,
+// the raw token is sent to the user and only the digest is stored.
+func GenerateToken(column string) (raw string, enc string, err error) {
+	if opts.secretKey == "" {
+		return "", "", ErrSecretKeyNotSet
+	}
+	raw, err = FriendlyToken(20)
+	if err != nil {
+		return "", "", err
+	}
+	return raw, hexHMAC(sha256.New, keyFor(column), raw), nil
+}
+
+// FriendlyToken generate a random url safe token the same way as Devise.friendly_token
+func FriendlyToken(length int) (string, error) {
+	b := make([]byte, length*3/4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return strings.NewReplacer("l", "s", "I", "x", "O", "y", "0", "z").Replace(token), nil
+}
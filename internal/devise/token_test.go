@@ -0,0 +1,45 @@
+package devise
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDigestToken(t *testing.T) {
+	Init()
+	_, err := DigestToken(UnlockTokenColumn, "abcdef")
+	assert.ErrorIs(t, err, ErrSecretKeyNotSet)
+
+	// expected values are from Devise.token_generator.digest(User, :unlock_token, "abcdef")
+	// with secret_key_base "secret"
+	Init(WithSecretKey("secret", "SHA256"))
+	defer Init()
+	enc, err := DigestToken(UnlockTokenColumn, "abcdef")
+	assert.NoError(t, err)
+	assert.Equal(t, "55aec829c2fa4986f2edaa1e6e38265b397577e5180188665b9d23249890b19d", enc)
+
+	enc, err = DigestToken(UnlockTokenColumn, "")
+	assert.NoError(t, err)
+	assert.Empty(t, enc)
+
+	Init(WithSecretKey("secret", "SHA1"))
+	enc, err = DigestToken(UnlockTokenColumn, "abcdef")
+	assert.NoError(t, err)
+	assert.Equal(t, "94b7e905cd6ae0c115b5b9a6ea7d4c6e172cf9874ea74f79f26669c737de947b", enc)
+}
+
+func TestGenerateToken(t *testing.T) {
+	Init(WithSecretKey("secret", ""))
+	defer Init()
+
+	raw, enc, err := GenerateToken(UnlockTokenColumn)
+	assert.NoError(t, err)
+	assert.Len(t, raw, 20)
+	assert.False(t, strings.ContainsAny(raw, "lIO0"))
+
+	digest, err := DigestToken(UnlockTokenColumn, raw)
+	assert.NoError(t, err)
+	assert.Equal(t, enc, digest)
+}
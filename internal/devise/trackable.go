@@ -0,0 +1,26 @@
+package devise
+
+import (
+	"time"
+
+	"test-user-server/internal/model"
+)
+
+// UpdateTrackedFields set the trackable columns for a new sign in the same way as
+// Devise::Models::Trackable#update_tracked_fields, sign_in_count is left to the caller
+// so that it can be incremented atomically in the database.
+func UpdateTrackedFields(users *model.Users, ip string, now time.Time) {
+	oldCurrentAt := users.CurrentSignInAt
+	if oldCurrentAt == nil || oldCurrentAt.IsZero() {
+		oldCurrentAt = &now
+	}
+	users.LastSignInAt = oldCurrentAt
+	users.CurrentSignInAt = &now
+
+	oldCurrentIP := users.CurrentSignInIP
+	if oldCurrentIP == "" {
+		oldCurrentIP = ip
+	}
+	users.LastSignInIP = oldCurrentIP
+	users.CurrentSignInIP = ip
+}
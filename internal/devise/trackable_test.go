@@ -0,0 +1,30 @@
+package devise
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"test-user-server/internal/model"
+)
+
+func TestUpdateTrackedFields(t *testing.T) {
+	first := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+
+	// first sign in, last equals current
+	users := &model.Users{}
+	UpdateTrackedFields(users, "10.0.0.1", first)
+	assert.Equal(t, first, *users.CurrentSignInAt)
+	assert.Equal(t, first, *users.LastSignInAt)
+	assert.Equal(t, "10.0.0.1", users.CurrentSignInIP)
+	assert.Equal(t, "10.0.0.1", users.LastSignInIP)
+
+	// next sign in, previous current becomes last
+	UpdateTrackedFields(users, "10.0.0.2", second)
+	assert.Equal(t, second, *users.CurrentSignInAt)
+	assert.Equal(t, first, *users.LastSignInAt)
+	assert.Equal(t, "10.0.0.2", users.CurrentSignInIP)
+	assert.Equal(t, "10.0.0.1", users.LastSignInIP)
+}
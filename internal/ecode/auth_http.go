@@ -0,0 +1,27 @@
+package ecode
+
+import (
+	"github.com/go-dev-frame/sponge/pkg/errcode"
+)
+
+// auth business-level http error codes.
+// the authNO value range is 1~999, if the same error code is used, it will cause panic.
+var (
+	authNO       = 79
+	authName     = "auth"
+	authBaseCode = errcode.HCode(authNO)
+
+	ErrSignInAuth              = errcode.NewError(authBaseCode+1, "invalid email or password")
+	ErrGenerateTokenAuth       = errcode.NewError(authBaseCode+2, "failed to generate "+authName+" token")
+	ErrLockedAuth              = errcode.NewError(authBaseCode+3, "your account is locked")
+	ErrUnlockTokenAuth         = errcode.NewError(authBaseCode+4, "unlock token is invalid")
+	ErrResetTokenAuth          = errcode.NewError(authBaseCode+5, "reset password token is invalid")
+	ErrResetExpiredAuth        = errcode.NewError(authBaseCode+6, "reset password token has expired, please request a new one")
+	ErrSendMailAuth            = errcode.NewError(authBaseCode+7, "failed to send instructions")
+	ErrUnconfirmedAuth         = errcode.NewError(authBaseCode+8, "you have to confirm your email address before continuing")
+	ErrConfirmationTokenAuth   = errcode.NewError(authBaseCode+9, "confirmation token is invalid")
+	ErrConfirmationExpiredAuth = errcode.NewError(authBaseCode+10, "confirmation token has expired, please request a new one")
+	ErrAlreadyConfirmedAuth    = errcode.NewError(authBaseCode+11, "email was already confirmed")
+
+	// error codes are globally unique, adding 1 to the previous error code
+)
@@ -0,0 +1,19 @@
+package ecode
+
+import (
+	"github.com/go-dev-frame/sponge/pkg/errcode"
+)
+
+// database business-level http error codes, the translated mysql errors of database.TranslateError.
+// the databaseNO value range is 1~999, if the same error code is used, it will cause panic.
+var (
+	databaseNO       = 81
+	databaseBaseCode = errcode.HCode(databaseNO)
+
+	ErrDuplicateKey = errcode.NewError(databaseBaseCode+1, "value has already been taken")
+	ErrForeignKey   = errcode.NewError(databaseBaseCode+2, "record is referenced by or references a missing record")
+	ErrDataTooLong  = errcode.NewError(databaseBaseCode+3, "value is too long")
+	ErrDeadlock     = errcode.NewError(databaseBaseCode+4, "record is locked by another request, try again")
+
+	// error codes are globally unique, adding 1 to the previous error code
+)
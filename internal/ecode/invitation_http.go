@@ -0,0 +1,23 @@
+package ecode
+
+import (
+	"github.com/go-dev-frame/sponge/pkg/errcode"
+)
+
+// invitation business-level http error codes.
+// the invitationNO value range is 1~999, if the same error code is used, it will cause panic.
+var (
+	invitationNO       = 80
+	invitationName     = "invitation"
+	invitationBaseCode = errcode.HCode(invitationNO)
+
+	ErrInviteInvitation       = errcode.NewError(invitationBaseCode+1, "failed to create "+invitationName)
+	ErrNoInvitationsLeft      = errcode.NewError(invitationBaseCode+2, "no invitations left")
+	ErrEmailTakenInvitation   = errcode.NewError(invitationBaseCode+3, "email has already been taken")
+	ErrNotInvitedInvitation   = errcode.NewError(invitationBaseCode+4, "users has no pending "+invitationName)
+	ErrTokenInvitation        = errcode.NewError(invitationBaseCode+5, invitationName+" token is invalid")
+	ErrExpiredInvitation      = errcode.NewError(invitationBaseCode+6, invitationName+" has expired")
+	ErrListInviteesInvitation = errcode.NewError(invitationBaseCode+7, "failed to list invitees")
+
+	// error codes are globally unique, adding 1 to the previous error code
+)
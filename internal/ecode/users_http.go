@@ -22,5 +22,17 @@ var (
 	ErrListByIDsUsers      = errcode.NewError(usersBaseCode+8, "failed to list by batch ids "+usersName)
 	ErrListByLastIDUsers   = errcode.NewError(usersBaseCode+9, "failed to list by last id "+usersName)
 
+	ErrChangePasswordUsers     = errcode.NewError(usersBaseCode+10, "failed to change password of "+usersName)
+	ErrCurrentPasswordUsers    = errcode.NewError(usersBaseCode+11, "current password of "+usersName+" is incorrect")
+	ErrSearchUsers             = errcode.NewError(usersBaseCode+12, "failed to search "+usersName)
+	ErrImportUsers             = errcode.NewError(usersBaseCode+13, "failed to import "+usersName)
+	ErrListTrashUsers          = errcode.NewError(usersBaseCode+14, "failed to list the trash of "+usersName)
+	ErrListHistoryUsers        = errcode.NewError(usersBaseCode+15, "failed to list the history of "+usersName)
+	ErrListVersionsUsers       = errcode.NewError(usersBaseCode+16, "failed to list the versions of "+usersName)
+	ErrDiffVersionsUsers       = errcode.NewError(usersBaseCode+17, "failed to diff the versions of "+usersName)
+	ErrRevertUsers             = errcode.NewError(usersBaseCode+18, "failed to revert "+usersName)
+	ErrCreateWebhookUsers      = errcode.NewError(usersBaseCode+19, "failed to create a webhook of "+usersName)
+	ErrHistoryUnavailableUsers = errcode.NewError(usersBaseCode+20, "no version of "+usersName+" was recorded until that time")
+
 	// error codes are globally unique, adding 1 to the previous error code
 )
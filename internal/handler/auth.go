@@ -0,0 +1,484 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/go-dev-frame/sponge/pkg/gin/middleware"
+	ginAuth "github.com/go-dev-frame/sponge/pkg/gin/middleware/auth"
+	"github.com/go-dev-frame/sponge/pkg/gin/response"
+	"github.com/go-dev-frame/sponge/pkg/logger"
+	"github.com/go-dev-frame/sponge/pkg/sgorm/query"
+	"github.com/go-dev-frame/sponge/pkg/utils"
+
+	"test-user-server/internal/cache"
+	"test-user-server/internal/config"
+	"test-user-server/internal/dao"
+	"test-user-server/internal/database"
+	"test-user-server/internal/devise"
+	"test-user-server/internal/ecode"
+	"test-user-server/internal/mailer"
+	"test-user-server/internal/model"
+	"test-user-server/internal/types"
+)
+
+var _ AuthHandler = (*authHandler)(nil)
+
+// AuthHandler defining the handler interface
+type AuthHandler interface {
+	SignIn(c *gin.Context)
+	Unlock(c *gin.Context)
+	ForgotPassword(c *gin.Context)
+	ResetPassword(c *gin.Context)
+	Confirm(c *gin.Context)
+}
+
+type authHandler struct {
+	iDao   dao.UsersDao
+	expire int // token lifetime in seconds
+
+	mailer           mailer.Mailer
+	resetPasswordURL string // links of the instructions, the raw token is added as query
+	unlockURL        string
+}
+
+// NewAuthHandler creating the handler interface
+func NewAuthHandler() AuthHandler {
+	return &authHandler{
+		iDao: dao.NewUsersDao(
+			database.GetDB(), // db driver is mysql
+			cache.NewUsersCache(database.GetCacheType()),
+		),
+		expire: config.Get().JWT.Expire,
+
+		mailer:           mailer.Get(),
+		resetPasswordURL: config.Get().Mailer.ResetPasswordURL,
+		unlockURL:        config.Get().Mailer.UnlockURL,
+	}
+}
+
+// SignIn sign in with email and password
+// @Summary Sign in with email and password
+// @Description Verifies the email and password against encrypted_password, updates the devise trackable columns and returns a jwt signed with the configured signing key. Failed attempts are counted and the account is locked after devise.maximumAttempts, like devise lockable. Unconfirmed accounts are refused when devise.refuseUnconfirmed is set.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param data body types.SignInRequest true "email and password"
+// @Success 200 {object} types.SignInReply{}
+// @Router /api/v1/auth/sign_in [post]
+func (h *authHandler) SignIn(c *gin.Context) {
+	form := &types.SignInRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		logger.Warn("ShouldBindJSON error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	users, err := h.iDao.GetByCondition(ctx, &query.Conditions{
+		Columns: []query.Column{{Name: "email", Value: form.Email}},
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			// pay the cost of a password comparison, an early reply would tell which emails have an account
+			devise.ComparePasswordWithoutUser(form.Password)
+			logger.Warn("SignIn email not found", logger.String("email", form.Email), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.ErrSignInAuth)
+		} else {
+			logger.Error("GetByCondition error", logger.Err(err), logger.String("email", form.Email), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	now := time.Now()
+	if devise.LockStrategyEnabled() && devise.LockExpired(users, now) {
+		// unlock the users if the lock is expired, no matter if the password is valid
+		err = h.unlockAccess(ctx, users)
+		if err != nil {
+			logger.Error("UpdateLockableByID error", logger.Err(err), logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+			return
+		}
+	}
+
+	if !devise.ValidPassword(users.EncryptedPassword, form.Password) || devise.AccessLocked(users, now) {
+		logger.Warn("SignIn refused", logger.Any("id", users.ID), logger.Bool("locked", devise.AccessLocked(users, now)), middleware.GCtxRequestIDField(c))
+		if devise.LockStrategyEnabled() {
+			err = h.failedAttempt(ctx, users, now)
+			if err != nil {
+				logger.Error("failedAttempt error", logger.Err(err), logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+				response.Output(c, ecode.InternalServerError.ToHTTPCode())
+				return
+			}
+		}
+		if devise.AccessLocked(users, now) {
+			response.Error(c, ecode.ErrLockedAuth)
+		} else {
+			response.Error(c, ecode.ErrSignInAuth)
+		}
+		return
+	}
+
+	if !devise.ActiveForAuthentication(users, now) {
+		logger.Warn("SignIn unconfirmed", logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrUnconfirmedAuth)
+		return
+	}
+
+	if users.FailedAttempts != 0 {
+		// devise resets the counter after every successful authentication
+		users.FailedAttempts = 0
+		err = h.iDao.UpdateLockableByID(ctx, users)
+		if err != nil {
+			logger.Error("UpdateLockableByID error", logger.Err(err), logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+			return
+		}
+	}
+
+	devise.UpdateTrackedFields(users, c.ClientIP(), now)
+	err = h.iDao.UpdateTrackedFieldsByID(ctx, users)
+	if err != nil {
+		logger.Error("UpdateTrackedFieldsByID error", logger.Err(err), logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	token, err := generateUsersToken(users)
+	if err != nil {
+		logger.Error("GenerateToken error", logger.Err(err), logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrGenerateTokenAuth)
+		return
+	}
+
+	response.Success(c, gin.H{
+		"id":        users.ID,
+		"token":     token,
+		"expiresIn": h.expire,
+	})
+}
+
+// Unlock unlock an account with the unlock token
+// @Summary Unlock an account
+// @Description Unlocks an account locked after too many failed sign in attempts, the token is the raw unlock token sent with the unlock instructions, like devise unlock_access_by_token.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param data body types.UnlockUsersRequest true "unlock token"
+// @Success 200 {object} types.UnlockUsersReply{}
+// @Router /api/v1/users/unlock [post]
+func (h *authHandler) Unlock(c *gin.Context) {
+	form := &types.UnlockUsersRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		logger.Warn("ShouldBindJSON error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	digest, err := devise.DigestToken(devise.UnlockTokenColumn, form.Token)
+	if err != nil {
+		logger.Error("DigestToken error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	users, err := h.iDao.GetByCondition(ctx, &query.Conditions{
+		Columns: []query.Column{{Name: devise.UnlockTokenColumn, Value: digest}},
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("Unlock token not found", middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.ErrUnlockTokenAuth)
+		} else {
+			logger.Error("GetByCondition error", logger.Err(err), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	err = h.unlockAccess(ctx, users)
+	if err != nil {
+		logger.Error("UpdateLockableByID error", logger.Err(err), logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	response.Success(c)
+}
+
+// ForgotPassword send the reset password instructions
+// @Summary Send the reset password instructions
+// @Description Stores the digest of a new reset password token and mails the raw token to the users, like devise send_reset_password_instructions. The reply is the same whether the email exists or not and is sent before the token is stored and mailed, a failure of either is only logged.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param data body types.ForgotPasswordRequest true "email"
+// @Success 200 {object} types.ForgotPasswordReply{}
+// @Router /api/v1/users/password/forgot [post]
+func (h *authHandler) ForgotPassword(c *gin.Context) {
+	form := &types.ForgotPasswordRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		logger.Warn("ShouldBindJSON error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	users, err := h.iDao.GetByCondition(ctx, &query.Conditions{
+		Columns: []query.Column{{Name: "email", Value: form.Email}},
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			// do not reveal whether the email exists, same as devise paranoid mode
+			logger.Warn("ForgotPassword email not found", logger.String("email", form.Email), middleware.GCtxRequestIDField(c))
+			response.Success(c)
+		} else {
+			logger.Error("GetByCondition error", logger.Err(err), logger.String("email", form.Email), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	// the token is written and mailed after the reply, a known email is answered as fast as an unknown one
+	requestIDField := middleware.GCtxRequestIDField(c)
+	go h.sendResetPasswordInstructions(context.WithoutCancel(ctx), users, requestIDField)
+
+	response.Success(c)
+}
+
+// sendResetPasswordInstructions store the digest of a new reset password token and mail the raw token, the
+// errors are only logged, the reply was sent already
+func (h *authHandler) sendResetPasswordInstructions(ctx context.Context, users *model.Users, requestIDField logger.Field) {
+	raw, enc, err := devise.GenerateToken(devise.ResetPasswordTokenColumn)
+	if err != nil {
+		logger.Error("GenerateToken error", logger.Err(err), requestIDField)
+		return
+	}
+	now := time.Now()
+	users.ResetPasswordToken = enc
+	users.ResetPasswordSentAt = &now
+	err = h.iDao.UpdateResetPasswordTokenByID(ctx, users)
+	if err != nil {
+		logger.Error("UpdateResetPasswordTokenByID error", logger.Err(err), logger.Any("id", users.ID), requestIDField)
+		return
+	}
+
+	err = h.mailer.Send(ctx, mailer.ResetPasswordInstructions(users.Email, h.resetPasswordURL, raw))
+	if err != nil {
+		logger.Error("Send reset password instructions error", logger.Err(err), logger.Any("id", users.ID), requestIDField)
+	}
+}
+
+// ResetPassword reset the password with the reset password token
+// @Summary Reset the password
+// @Description Verifies the reset password token and its expiry window, sets the new password and clears reset_password_token and reset_password_sent_at, like devise reset_password_by_token. A locked account is unlocked when the email unlock strategy is enabled.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param data body types.ResetPasswordRequest true "reset password token and new password"
+// @Success 200 {object} types.ResetPasswordReply{}
+// @Router /api/v1/users/password/reset [put]
+func (h *authHandler) ResetPassword(c *gin.Context) {
+	form := &types.ResetPasswordRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		logger.Warn("ShouldBindJSON error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	digest, err := devise.DigestToken(devise.ResetPasswordTokenColumn, form.Token)
+	if err != nil {
+		logger.Error("DigestToken error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	users, err := h.iDao.GetByCondition(ctx, &query.Conditions{
+		Columns: []query.Column{{Name: devise.ResetPasswordTokenColumn, Value: digest}},
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("ResetPassword token not found", middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.ErrResetTokenAuth)
+		} else {
+			logger.Error("GetByCondition error", logger.Err(err), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	now := time.Now()
+	if !devise.ResetPasswordPeriodValid(users, now) {
+		logger.Warn("ResetPassword token expired", logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrResetExpiredAuth)
+		return
+	}
+
+	encryptedPassword, err := devise.DigestPassword(form.Password)
+	if err != nil {
+		logger.Error("DigestPassword error", logger.Err(err), logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+	// also clears reset_password_token and reset_password_sent_at
+	err = h.iDao.UpdatePasswordByID(ctx, users.ID, encryptedPassword)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			// deleted since the token was found, the token went with it
+			logger.Warn("UpdatePasswordByID not found", logger.Err(err), logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.ErrResetTokenAuth)
+			return
+		}
+		logger.Error("UpdatePasswordByID error", logger.Err(err), logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	if devise.UnlockStrategyEnabled(devise.UnlockStrategyEmail) && users.LockedAt != nil {
+		// receiving the reset email proves the same as receiving the unlock email
+		err = h.unlockAccess(ctx, users)
+		if err != nil {
+			logger.Error("UpdateLockableByID error", logger.Err(err), logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+			return
+		}
+	}
+
+	response.Success(c)
+}
+
+// Confirm confirm an account or an email change
+// @Summary Confirm an account or an email change
+// @Description Sets confirmedAt and, for a pending email change, replaces email with unconfirmedEmail, like devise confirm_by_token.
+// @Tags auth
+// @Param token query string true "confirmation token from the confirmation instructions"
+// @Produce json
+// @Success 200 {object} types.ConfirmUsersReply{}
+// @Router /api/v1/users/confirmation [get]
+func (h *authHandler) Confirm(c *gin.Context) {
+	form := &types.ConfirmUsersRequest{}
+	err := c.ShouldBindQuery(form)
+	if err != nil {
+		logger.Warn("ShouldBindQuery error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	users, err := h.getByConfirmationToken(ctx, form.Token)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("Confirm token not found", middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.ErrConfirmationTokenAuth)
+		} else {
+			logger.Error("GetByCondition error", logger.Err(err), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	if devise.Confirmed(users) && !devise.PendingReconfirmation(users) {
+		response.Error(c, ecode.ErrAlreadyConfirmedAuth)
+		return
+	}
+	now := time.Now()
+	if devise.ConfirmationPeriodExpired(users, now) {
+		logger.Warn("Confirm token expired", logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrConfirmationExpiredAuth)
+		return
+	}
+
+	unconfirmedEmail := ""
+	if devise.PendingReconfirmation(users) {
+		unconfirmedEmail = users.UnconfirmedEmail
+	}
+	err = h.iDao.ConfirmByID(ctx, users.ID, now, unconfirmedEmail)
+	if err != nil {
+		if respondDBError(c, err) {
+			return
+		}
+		logger.Error("ConfirmByID error", logger.Err(err), logger.Any("id", users.ID), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	response.Success(c)
+}
+
+// getByConfirmationToken devise stores the token in clear, tokens written by devise 3.1 to 3.4 were digested
+func (h *authHandler) getByConfirmationToken(ctx context.Context, token string) (*model.Users, error) {
+	users, err := h.iDao.GetByCondition(ctx, &query.Conditions{
+		Columns: []query.Column{{Name: devise.ConfirmationTokenColumn, Value: token}},
+	})
+	if !errors.Is(err, database.ErrRecordNotFound) {
+		return users, err
+	}
+
+	digest, err := devise.DigestToken(devise.ConfirmationTokenColumn, token)
+	if err != nil {
+		// without a secret key only clear tokens can be found
+		return nil, database.ErrRecordNotFound
+	}
+	return h.iDao.GetByCondition(ctx, &query.Conditions{
+		Columns: []query.Column{{Name: devise.ConfirmationTokenColumn, Value: digest}},
+	})
+}
+
+// failedAttempt count a failed sign in and lock the account when the maximum attempts
+// are exceeded, same as Devise::Models::Lockable#valid_for_authentication?
+func (h *authHandler) failedAttempt(ctx context.Context, users *model.Users, now time.Time) error {
+	failedAttempts, err := h.iDao.IncrementFailedAttemptsByID(ctx, users.ID)
+	if err != nil {
+		return err
+	}
+	users.FailedAttempts = failedAttempts
+	if !devise.AttemptsExceeded(failedAttempts) || devise.AccessLocked(users, now) {
+		return nil
+	}
+
+	users.LockedAt = &now
+	if !devise.UnlockStrategyEnabled(devise.UnlockStrategyEmail) {
+		return h.iDao.UpdateLockableByID(ctx, users)
+	}
+
+	// only the digest is stored, the raw token is mailed to the users
+	raw, enc, err := devise.GenerateToken(devise.UnlockTokenColumn)
+	if err != nil {
+		return err
+	}
+	users.UnlockToken = enc
+	err = h.iDao.UpdateLockableByID(ctx, users)
+	if err != nil {
+		return err
+	}
+	err = h.mailer.Send(ctx, mailer.UnlockInstructions(users.Email, h.unlockURL, raw))
+	if err != nil {
+		// the account stays locked, it can still be unlocked by time or by resetting the password
+		logger.Error("Send unlock instructions error", logger.Err(err), logger.Any("id", users.ID))
+	}
+	return nil
+}
+
+// unlockAccess same as Devise::Models::Lockable#unlock_access!
+func (h *authHandler) unlockAccess(ctx context.Context, users *model.Users) error {
+	users.LockedAt = nil
+	users.FailedAttempts = 0
+	users.UnlockToken = ""
+	return h.iDao.UpdateLockableByID(ctx, users)
+}
+
+func generateUsersToken(users *model.Users) (string, error) {
+	return ginAuth.GenerateToken(utils.Uint64ToStr(users.ID), ginAuth.WithGenerateTokenFields(map[string]interface{}{
+		"email": users.Email,
+	}))
+}
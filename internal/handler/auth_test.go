@@ -0,0 +1,531 @@
+package handler
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	ginAuth "github.com/go-dev-frame/sponge/pkg/gin/middleware/auth"
+	"github.com/go-dev-frame/sponge/pkg/gotest"
+	"github.com/go-dev-frame/sponge/pkg/httpcli"
+	"github.com/go-dev-frame/sponge/pkg/utils"
+
+	"test-user-server/internal/cache"
+	"test-user-server/internal/dao"
+	"test-user-server/internal/database"
+	"test-user-server/internal/devise"
+	"test-user-server/internal/ecode"
+	"test-user-server/internal/mailer"
+	"test-user-server/internal/model"
+	"test-user-server/internal/types"
+)
+
+// the instructions of the tests are written by the file mailer
+var testMailDir = filepath.Join(os.TempDir(), "user_server_test_mails")
+
+// lastMailToken return the token of the last mail sent to testMailDir
+func lastMailToken(t *testing.T, key string) string {
+	entries, err := os.ReadDir(testMailDir)
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("no mail sent: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(testMailDir, entries[len(entries)-1].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches := regexp.MustCompile(key + `=([\w-]+)`).FindStringSubmatch(string(data))
+	if len(matches) != 2 {
+		t.Fatalf("no %s in mail: %s", key, data)
+	}
+	return matches[1]
+}
+
+func newAuthHandler() *gotest.Handler {
+	testData := &model.Users{}
+	testData.ID = 1
+	testData.Email = "foo@bar.com"
+
+	// init mock cache
+	c := gotest.NewCache(map[string]interface{}{utils.Uint64ToStr(testData.ID): testData})
+	c.ICache = cache.NewUsersCache(&database.CacheType{
+		CType: "redis",
+		Rdb:   c.RedisClient,
+	})
+
+	// init mock dao
+	d := gotest.NewDao(c, testData)
+	d.IDao = dao.NewUsersDao(d.DB, c.ICache.(cache.UsersCache))
+
+	// init mock handler
+	h := gotest.NewHandler(d, testData)
+	h.IHandler = &authHandler{
+		iDao:             d.IDao.(dao.UsersDao),
+		expire:           3600,
+		mailer:           mailer.NewFileMailer(testMailDir),
+		resetPasswordURL: "http://localhost:3000/users/password/edit",
+		unlockURL:        "http://localhost:3000/users/unlock",
+	}
+	iHandler := h.IHandler.(AuthHandler)
+
+	testFns := []gotest.RouterInfo{
+		{
+			FuncName:    "SignIn",
+			Method:      http.MethodPost,
+			Path:        "/auth/sign_in",
+			HandlerFunc: iHandler.SignIn,
+		},
+		{
+			FuncName:    "Unlock",
+			Method:      http.MethodPost,
+			Path:        "/users/unlock",
+			HandlerFunc: iHandler.Unlock,
+		},
+		{
+			FuncName:    "ForgotPassword",
+			Method:      http.MethodPost,
+			Path:        "/users/password/forgot",
+			HandlerFunc: iHandler.ForgotPassword,
+		},
+		{
+			FuncName:    "ResetPassword",
+			Method:      http.MethodPut,
+			Path:        "/users/password/reset",
+			HandlerFunc: iHandler.ResetPassword,
+		},
+		{
+			FuncName:    "Confirm",
+			Method:      http.MethodGet,
+			Path:        "/users/confirmation",
+			HandlerFunc: iHandler.Confirm,
+		},
+	}
+
+	h.GoRunHTTPServer(testFns)
+
+	time.Sleep(time.Millisecond * 200)
+	return h
+}
+
+func Test_authHandler_SignIn(t *testing.T) {
+	h := newAuthHandler()
+	defer h.Close()
+	defer os.RemoveAll(testMailDir)
+	testData := h.TestData.(*model.Users)
+	ginAuth.InitAuth([]byte("test-signing-key"), time.Hour)
+	devise.Init(devise.WithStretches(bcrypt.MinCost), devise.WithSecretKey("secret", ""),
+		devise.WithLockable(devise.LockStrategyFailedAttempts, devise.UnlockStrategyBoth, 3, time.Hour))
+	defer devise.Init()
+	encryptedPassword, _ := devise.DigestPassword("123456")
+
+	rows := sqlmock.NewRows([]string{"id", "email", "encrypted_password"}).
+		AddRow(testData.ID, testData.Email, encryptedPassword)
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.Email, 1).
+		WillReturnRows(rows)
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersWritten(h, testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersRecorded(h, testData.ID, "sign_in_count", 1)
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("SignIn"), &types.SignInRequest{
+		Email:    testData.Email,
+		Password: "123456",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	data := result.Data.(map[string]interface{})
+	claims, err := ginAuth.ParseToken(data["token"].(string))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", claims.UID)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// wrong password
+	rows = sqlmock.NewRows([]string{"id", "email", "encrypted_password"}).
+		AddRow(testData.ID, testData.Email, encryptedPassword)
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.Email, 1).
+		WillReturnRows(rows)
+	expectFailedAttempt(h, testData.ID, 1)
+	err = httpcli.Post(result, h.GetRequestURL("SignIn"), &types.SignInRequest{
+		Email:    testData.Email,
+		Password: "654321",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrSignInAuth.Code(), result.Code)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// wrong password reaching the maximum attempts locks the account
+	rows = sqlmock.NewRows([]string{"id", "email", "encrypted_password", "failed_attempts"}).
+		AddRow(testData.ID, testData.Email, encryptedPassword, 2)
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.Email, 1).
+		WillReturnRows(rows)
+	expectFailedAttempt(h, testData.ID, 3)
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersWritten(h, testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(3, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), testData.ID).
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersRecorded(h, testData.ID, "failed_attempts", 3)
+	h.MockDao.SQLMock.ExpectCommit()
+	err = httpcli.Post(result, h.GetRequestURL("SignIn"), &types.SignInRequest{
+		Email:    testData.Email,
+		Password: "654321",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrLockedAuth.Code(), result.Code)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+	assert.NotEmpty(t, lastMailToken(t, "unlock_token"))
+
+	// a locked account is refused even with the right password
+	rows = sqlmock.NewRows([]string{"id", "email", "encrypted_password", "failed_attempts", "locked_at"}).
+		AddRow(testData.ID, testData.Email, encryptedPassword, 3, time.Now())
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.Email, 1).
+		WillReturnRows(rows)
+	expectFailedAttempt(h, testData.ID, 4)
+	err = httpcli.Post(result, h.GetRequestURL("SignIn"), &types.SignInRequest{
+		Email:    testData.Email,
+		Password: "123456",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrLockedAuth.Code(), result.Code)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// an expired lock is lifted and the counter reset before checking the password
+	rows = sqlmock.NewRows([]string{"id", "email", "encrypted_password", "failed_attempts", "locked_at"}).
+		AddRow(testData.ID, testData.Email, encryptedPassword, 3, time.Now().Add(-2*time.Hour))
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.Email, 1).
+		WillReturnRows(rows)
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id", "failed_attempts", "locked_at"}).AddRow(testData.ID, 3, time.Now()), testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(0, nil, nil, sqlmock.AnyArg(), testData.ID).
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	expectUsersAudit(h, testData.ID, model.UsersAuditUpdate)
+	h.MockDao.SQLMock.ExpectCommit()
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersWritten(h, testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersRecorded(h, testData.ID, "sign_in_count", 1)
+	h.MockDao.SQLMock.ExpectCommit()
+	err = httpcli.Post(result, h.GetRequestURL("SignIn"), &types.SignInRequest{
+		Email:    testData.Email,
+		Password: "123456",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Code)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// unknown email
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("nobody@bar.com", 1).
+		WillReturnError(database.ErrRecordNotFound)
+	err = httpcli.Post(result, h.GetRequestURL("SignIn"), &types.SignInRequest{
+		Email:    "nobody@bar.com",
+		Password: "123456",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrSignInAuth.Code(), result.Code)
+
+	// invalid params
+	err = httpcli.Post(result, h.GetRequestURL("SignIn"), &types.SignInRequest{Email: "not-an-email"})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+}
+
+func expectFailedAttempt(h *gotest.Handler, id uint64, failedAttempts int) {
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id"}).AddRow(id), id)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*failed_attempts.*").
+		WillReturnResult(sqlmock.NewResult(int64(id), 1))
+	h.MockDao.SQLMock.ExpectQuery("SELECT `failed_attempts` FROM .*").
+		WillReturnRows(sqlmock.NewRows([]string{"failed_attempts"}).AddRow(failedAttempts))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id", "failed_attempts"}).AddRow(id, failedAttempts), id)
+	expectUsersAudit(h, id, model.UsersAuditUpdate)
+	h.MockDao.SQLMock.ExpectCommit()
+}
+
+func Test_authHandler_Unlock(t *testing.T) {
+	h := newAuthHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+	devise.Init(devise.WithSecretKey("secret", ""))
+	defer devise.Init()
+	raw, enc, _ := devise.GenerateToken(devise.UnlockTokenColumn)
+
+	rows := sqlmock.NewRows([]string{"id", "email", "failed_attempts", "locked_at", "unlock_token"}).
+		AddRow(testData.ID, testData.Email, 20, time.Now(), enc)
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(enc, 1).
+		WillReturnRows(rows)
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id", "failed_attempts", "locked_at"}).AddRow(testData.ID, 3, time.Now()), testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(0, nil, nil, sqlmock.AnyArg(), testData.ID).
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	expectUsersAudit(h, testData.ID, model.UsersAuditUpdate)
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("Unlock"), &types.UnlockUsersRequest{Token: raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// unknown token
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WillReturnError(database.ErrRecordNotFound)
+	err = httpcli.Post(result, h.GetRequestURL("Unlock"), &types.UnlockUsersRequest{Token: "unknown"})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrUnlockTokenAuth.Code(), result.Code)
+
+	// invalid params
+	err = httpcli.Post(result, h.GetRequestURL("Unlock"), &types.UnlockUsersRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+}
+
+func Test_authHandler_ForgotPassword(t *testing.T) {
+	h := newAuthHandler()
+	defer h.Close()
+	defer os.RemoveAll(testMailDir)
+	testData := h.TestData.(*model.Users)
+	devise.Init(devise.WithSecretKey("secret", ""))
+	defer devise.Init()
+
+	rows := sqlmock.NewRows([]string{"id", "email"}).AddRow(testData.ID, testData.Email)
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.Email, 1).
+		WillReturnRows(rows)
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersWritten(h, testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersRecorded(h, testData.ID, "reset_password_sent_at", time.Now())
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("ForgotPassword"), &types.ForgotPasswordRequest{Email: testData.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	// the token is stored and mailed after the reply
+	require.Eventually(t, func() bool {
+		entries, _ := os.ReadDir(testMailDir)
+		if len(entries) == 0 {
+			return false
+		}
+		data, _ := os.ReadFile(filepath.Join(testMailDir, entries[0].Name()))
+		return strings.Contains(string(data), "reset_password_token=")
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+	assert.NotEmpty(t, lastMailToken(t, "reset_password_token"))
+
+	// unknown email gets the same reply
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("nobody@bar.com", 1).
+		WillReturnError(database.ErrRecordNotFound)
+	err = httpcli.Post(result, h.GetRequestURL("ForgotPassword"), &types.ForgotPasswordRequest{Email: "nobody@bar.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Code)
+
+	// a mail that cannot be sent gets the same reply too
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.Email, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(testData.ID, testData.Email))
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersWritten(h, testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersRecorded(h, testData.ID, "reset_password_sent_at", time.Now())
+	h.MockDao.SQLMock.ExpectCommit()
+	h.IHandler.(*authHandler).mailer = mailer.NewFileMailer(os.DevNull + "/mails")
+	err = httpcli.Post(result, h.GetRequestURL("ForgotPassword"), &types.ForgotPasswordRequest{Email: testData.Email})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Code)
+	require.Eventually(t, func() bool {
+		return h.MockDao.SQLMock.ExpectationsWereMet() == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// invalid params
+	err = httpcli.Post(result, h.GetRequestURL("ForgotPassword"), &types.ForgotPasswordRequest{Email: "not-an-email"})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+}
+
+func Test_authHandler_ResetPassword(t *testing.T) {
+	h := newAuthHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+	devise.Init(devise.WithStretches(bcrypt.MinCost), devise.WithSecretKey("secret", ""))
+	defer devise.Init()
+	raw, enc, _ := devise.GenerateToken(devise.ResetPasswordTokenColumn)
+
+	// a locked account is also unlocked
+	rows := sqlmock.NewRows([]string{"id", "email", "reset_password_token", "reset_password_sent_at", "locked_at"}).
+		AddRow(testData.ID, testData.Email, enc, time.Now(), time.Now())
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(enc, 1).
+		WillReturnRows(rows)
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersWritten(h, testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(sqlmock.AnyArg(), nil, nil, sqlmock.AnyArg(), testData.ID).
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersRecorded(h, testData.ID, "encrypted_password", "digest")
+	h.MockDao.SQLMock.ExpectCommit()
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id", "failed_attempts", "locked_at"}).AddRow(testData.ID, 3, time.Now()), testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(0, nil, nil, sqlmock.AnyArg(), testData.ID).
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	expectUsersAudit(h, testData.ID, model.UsersAuditUpdate)
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Put(result, h.GetRequestURL("ResetPassword"), &types.ResetPasswordRequest{Token: raw, Password: "654321"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// expired token
+	rows = sqlmock.NewRows([]string{"id", "email", "reset_password_token", "reset_password_sent_at"}).
+		AddRow(testData.ID, testData.Email, enc, time.Now().Add(-devise.DefaultResetPasswordWithin-time.Minute))
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(enc, 1).
+		WillReturnRows(rows)
+	err = httpcli.Put(result, h.GetRequestURL("ResetPassword"), &types.ResetPasswordRequest{Token: raw, Password: "654321"})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrResetExpiredAuth.Code(), result.Code)
+
+	// unknown token
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WillReturnError(database.ErrRecordNotFound)
+	err = httpcli.Put(result, h.GetRequestURL("ResetPassword"), &types.ResetPasswordRequest{Token: "unknown", Password: "654321"})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrResetTokenAuth.Code(), result.Code)
+
+	// invalid params
+	err = httpcli.Put(result, h.GetRequestURL("ResetPassword"), &types.ResetPasswordRequest{Token: raw, Password: "123"})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+}
+
+func Test_authHandler_Confirm(t *testing.T) {
+	h := newAuthHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+	devise.Init()
+	defer devise.Init()
+	url := h.GetRequestURL("Confirm")
+
+	// pending email change is promoted
+	rows := sqlmock.NewRows([]string{"id", "email", "confirmation_token", "confirmed_at", "unconfirmed_email"}).
+		AddRow(testData.ID, testData.Email, "abc", time.Now(), "new@bar.com")
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("abc", 1).
+		WillReturnRows(rows)
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersWritten(h, testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(sqlmock.AnyArg(), "new@bar.com", nil, sqlmock.AnyArg(), testData.ID).
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersRecorded(h, testData.ID, "email", "new@bar.com")
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Get(result, url, httpcli.WithParams(map[string]interface{}{"token": "abc"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// already confirmed
+	rows = sqlmock.NewRows([]string{"id", "email", "confirmation_token", "confirmed_at"}).
+		AddRow(testData.ID, testData.Email, "abc", time.Now())
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("abc", 1).
+		WillReturnRows(rows)
+	err = httpcli.Get(result, url, httpcli.WithParams(map[string]interface{}{"token": "abc"}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrAlreadyConfirmedAuth.Code(), result.Code)
+
+	// unknown token
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WillReturnError(database.ErrRecordNotFound)
+	err = httpcli.Get(result, url, httpcli.WithParams(map[string]interface{}{"token": "unknown"}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrConfirmationTokenAuth.Code(), result.Code)
+
+	// invalid params
+	err = httpcli.Get(result, url)
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+}
+
+func Test_authHandler_SignIn_unconfirmed(t *testing.T) {
+	h := newAuthHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+	devise.Init(devise.WithStretches(bcrypt.MinCost), devise.WithConfirmable(true, 0, 0))
+	defer devise.Init()
+	encryptedPassword, _ := devise.DigestPassword("123456")
+
+	rows := sqlmock.NewRows([]string{"id", "email", "encrypted_password", "confirmation_sent_at"}).
+		AddRow(testData.ID, testData.Email, encryptedPassword, time.Now())
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.Email, 1).
+		WillReturnRows(rows)
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("SignIn"), &types.SignInRequest{
+		Email:    testData.Email,
+		Password: "123456",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrUnconfirmedAuth.Code(), result.Code)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+}
+
+func TestNewAuthHandler(t *testing.T) {
+	defer func() {
+		recover()
+	}()
+	_ = NewAuthHandler()
+}
@@ -0,0 +1,373 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/go-dev-frame/sponge/pkg/copier"
+	"github.com/go-dev-frame/sponge/pkg/gin/middleware"
+	"github.com/go-dev-frame/sponge/pkg/gin/response"
+	"github.com/go-dev-frame/sponge/pkg/logger"
+	"github.com/go-dev-frame/sponge/pkg/sgorm/query"
+	"github.com/go-dev-frame/sponge/pkg/utils"
+
+	"test-user-server/internal/cache"
+	"test-user-server/internal/config"
+	"test-user-server/internal/dao"
+	"test-user-server/internal/database"
+	"test-user-server/internal/devise"
+	"test-user-server/internal/ecode"
+	"test-user-server/internal/mailer"
+	"test-user-server/internal/model"
+	"test-user-server/internal/policy"
+	"test-user-server/internal/types"
+)
+
+var _ InvitationHandler = (*invitationHandler)(nil)
+
+// errNoInvitationsLeft rolls back the invitation transaction
+var errNoInvitationsLeft = errors.New("no invitations left")
+
+// InvitationHandler defining the handler interface
+type InvitationHandler interface {
+	Invite(c *gin.Context)
+	Resend(c *gin.Context)
+	Accept(c *gin.Context)
+	ListInvitees(c *gin.Context)
+}
+
+type invitationHandler struct {
+	db   *gorm.DB // the invitee and the inviter are written in one transaction
+	iDao dao.UsersDao
+
+	mailer        mailer.Mailer
+	invitationURL string // link of the invitation instructions, the raw token is added as query
+}
+
+// NewInvitationHandler creating the handler interface
+func NewInvitationHandler() InvitationHandler {
+	return &invitationHandler{
+		db: database.GetDB(),
+		iDao: dao.NewUsersDao(
+			database.GetDB(), // db driver is mysql
+			cache.NewUsersCache(database.GetCacheType()),
+		),
+
+		mailer:        mailer.Get(),
+		invitationURL: config.Get().Mailer.InvitationURL,
+	}
+}
+
+// Invite invite a new users by email
+// @Summary Invite a new users
+// @Description Creates the invitee with a digested invitation token and mails the raw token, like devise_invitable invite!. The invitation limit of the caller is enforced and its invitations count incremented in the same transaction.
+// @Tags invitation
+// @Accept json
+// @Produce json
+// @Param data body types.InviteUsersRequest true "email of the invitee"
+// @Success 200 {object} types.InviteUsersReply{}
+// @Router /api/v1/users/invitation [post]
+// @Security BearerAuth
+func (h *invitationHandler) Invite(c *gin.Context) {
+	form := &types.InviteUsersRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		logger.Warn("ShouldBindJSON error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+	caller, ok := policy.CurrentSubject(c)
+	if !ok {
+		response.Error(c, ecode.Unauthorized)
+		return
+	}
+	inviterID := caller.ID
+
+	ctx := middleware.WrapCtx(c)
+	_, err = h.iDao.GetByCondition(ctx, &query.Conditions{
+		Columns: []query.Column{{Name: "email", Value: form.Email}},
+	})
+	if err == nil {
+		response.Error(c, ecode.ErrEmailTakenInvitation)
+		return
+	}
+	if !errors.Is(err, database.ErrRecordNotFound) {
+		logger.Error("GetByCondition error", logger.Err(err), logger.String("email", form.Email), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	invitee := &model.Users{
+		Email:         form.Email,
+		InvitedByType: devise.InvitedByTypeUsers,
+		InvitedByID:   int64(inviterID),
+	}
+	raw, err := devise.GenerateInvitationToken(invitee, time.Now())
+	if err != nil {
+		logger.Error("GenerateInvitationToken error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrInviteInvitation)
+		return
+	}
+
+	err = h.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return h.createInvitee(ctx, tx, inviterID, invitee)
+	})
+	if err != nil {
+		if errors.Is(err, errNoInvitationsLeft) {
+			response.Error(c, ecode.ErrNoInvitationsLeft)
+		} else if errors.Is(err, database.ErrDuplicateKey) {
+			// invited by someone else since the email was checked
+			response.Error(c, ecode.ErrEmailTakenInvitation)
+		} else {
+			logger.Error("Invite error", logger.Err(err), logger.Uint64("inviterID", inviterID), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	h.sendInvitationInstructions(c, invitee.Email, raw)
+
+	response.Success(c, gin.H{"id": invitee.ID})
+}
+
+// createInvitee lock the inviter, use one of its invitations, create the invitee and count it
+func (h *invitationHandler) createInvitee(ctx context.Context, tx *gorm.DB, inviterID uint64, invitee *model.Users) error {
+	inviter, err := h.iDao.GetForUpdateByTx(ctx, tx, inviterID)
+	if err != nil {
+		return err
+	}
+
+	if limit := devise.InvitationLimit(); limit > 0 {
+		ok, err := h.iDao.DecrementInvitationLimitByTx(ctx, tx, inviterID, limit)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errNoInvitationsLeft
+		}
+	}
+
+	_, err = h.iDao.CreateByTx(ctx, tx, invitee)
+	if err != nil {
+		return err
+	}
+
+	update := &model.Users{}
+	update.ID = inviterID
+	update.InvitationsCount = inviter.InvitationsCount + 1
+	return h.iDao.UpdateByTx(ctx, tx, update)
+}
+
+// Resend resend the invitation instructions
+// @Summary Resend an invitation
+// @Description Replaces the invitation token of a pending invitee and mails the new raw token, the invitation limit is not used again. Only the inviter of the invitee or an admin may resend it.
+// @Tags invitation
+// @Accept json
+// @Produce json
+// @Param data body types.ResendInvitationRequest true "email of the invitee"
+// @Success 200 {object} types.ResendInvitationReply{}
+// @Router /api/v1/users/invitation/resend [post]
+// @Security BearerAuth
+func (h *invitationHandler) Resend(c *gin.Context) {
+	form := &types.ResendInvitationRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		logger.Warn("ShouldBindJSON error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+	caller, ok := policy.CurrentSubject(c)
+	if !ok {
+		response.Error(c, ecode.Unauthorized)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	invitee, err := h.iDao.GetByCondition(ctx, &query.Conditions{
+		Columns: []query.Column{{Name: "email", Value: form.Email}},
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			response.Error(c, ecode.ErrNotInvitedInvitation)
+		} else {
+			logger.Error("GetByCondition error", logger.Err(err), logger.String("email", form.Email), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+	if !devise.InvitedToSignUp(invitee) {
+		response.Error(c, ecode.ErrNotInvitedInvitation)
+		return
+	}
+	if !invitedBy(invitee, caller) {
+		// the new token replaces the link that was sent, only the inviter or an admin may do that, the
+		// invitees of others are answered like users without an invitation
+		logger.Warn("Resend invitation of another inviter", logger.Any("id", invitee.ID), logger.Uint64("callerID", caller.ID), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrNotInvitedInvitation)
+		return
+	}
+
+	update := &model.Users{}
+	update.ID = invitee.ID
+	update.InvitationCreatedAt = invitee.InvitationCreatedAt
+	raw, err := devise.GenerateInvitationToken(update, time.Now())
+	if err != nil {
+		logger.Error("GenerateInvitationToken error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrInviteInvitation)
+		return
+	}
+	err = h.iDao.UpdateByID(ctx, update)
+	if err != nil {
+		logger.Error("UpdateByID error", logger.Err(err), logger.Any("id", invitee.ID), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	h.sendInvitationInstructions(c, invitee.Email, raw)
+
+	response.Success(c)
+}
+
+// invitedBy report whether the caller invited the invitee, admins may act for any inviter
+func invitedBy(invitee *model.Users, caller *policy.Subject) bool {
+	if caller.IsAdmin() {
+		return true
+	}
+	return invitee.InvitedByType == devise.InvitedByTypeUsers && uint64(invitee.InvitedByID) == caller.ID
+}
+
+// Accept accept an invitation
+// @Summary Accept an invitation
+// @Description Sets the password of the invitee, stamps invitationAcceptedAt and clears the invitation token, like devise_invitable accept_invitation!.
+// @Tags invitation
+// @Accept json
+// @Produce json
+// @Param data body types.AcceptInvitationRequest true "invitation token and password"
+// @Success 200 {object} types.AcceptInvitationReply{}
+// @Router /api/v1/users/invitation/accept [put]
+func (h *invitationHandler) Accept(c *gin.Context) {
+	form := &types.AcceptInvitationRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		logger.Warn("ShouldBindJSON error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	digest, err := devise.DigestToken(devise.InvitationTokenColumn, form.Token)
+	if err != nil {
+		logger.Error("DigestToken error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	invitee, err := h.iDao.GetByCondition(ctx, &query.Conditions{
+		Columns: []query.Column{{Name: devise.InvitationTokenColumn, Value: digest}},
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("Accept invitation token not found", middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.ErrTokenInvitation)
+		} else {
+			logger.Error("GetByCondition error", logger.Err(err), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	now := time.Now()
+	if !devise.InvitationPeriodValid(invitee, now) {
+		response.Error(c, ecode.ErrExpiredInvitation)
+		return
+	}
+
+	encryptedPassword, err := devise.DigestPassword(form.Password)
+	if err != nil {
+		logger.Error("DigestPassword error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+	err = h.iDao.AcceptInvitationByID(ctx, invitee.ID, encryptedPassword, now)
+	if err != nil {
+		logger.Error("AcceptInvitationByID error", logger.Err(err), logger.Any("id", invitee.ID), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	response.Success(c)
+}
+
+// ListInvitees list the users invited by the caller
+// @Summary List my invitees
+// @Description Returns a paginated list of the users invited by the caller, accepted or not.
+// @Tags invitation
+// @Accept json
+// @Produce json
+// @Param page query int false "page number, starting from 0" default(0)
+// @Param limit query int false "number per page, at most 100" default(10)
+// @Success 200 {object} types.ListInviteesReply{}
+// @Router /api/v1/users/invitees [get]
+// @Security BearerAuth
+func (h *invitationHandler) ListInvitees(c *gin.Context) {
+	caller, ok := policy.CurrentSubject(c)
+	if !ok {
+		response.Error(c, ecode.Unauthorized)
+		return
+	}
+	inviterID := caller.ID
+	page := utils.StrToInt(c.Query("page"))
+	limit := utils.StrToInt(c.Query("limit"))
+	if limit == 0 {
+		limit = 10
+	}
+	if page < 0 || limit < 1 || limit > 100 {
+		logger.Warn("ListInvitees params error", logger.Int("page", page), logger.Int("limit", limit), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	invitees, total, err := h.iDao.GetByColumns(ctx, &query.Params{
+		Page:  page,
+		Limit: limit,
+		Sort:  "-id",
+		Columns: []query.Column{
+			{Name: "invited_by_id", Value: inviterID},
+			{Name: "invited_by_type", Value: devise.InvitedByTypeUsers},
+		},
+	})
+	if err != nil {
+		logger.Error("GetByColumns error", logger.Err(err), logger.Uint64("inviterID", inviterID), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	data := []*types.InviteeObjDetail{}
+	for _, invitee := range invitees {
+		detail := &types.InviteeObjDetail{}
+		err = copier.Copy(detail, invitee)
+		if err != nil {
+			response.Error(c, ecode.ErrListInviteesInvitation)
+			return
+		}
+		data = append(data, detail)
+	}
+
+	response.Success(c, gin.H{
+		"invitees": data,
+		"total":    total,
+	})
+}
+
+// sendInvitationInstructions a failure is only logged, the invitation can be resent
+func (h *invitationHandler) sendInvitationInstructions(c *gin.Context, email string, token string) {
+	err := h.mailer.Send(middleware.WrapCtx(c), mailer.InvitationInstructions(email, h.invitationURL, token))
+	if err != nil {
+		logger.Error("Send invitation instructions error", logger.Err(err), logger.String("email", email), middleware.GCtxRequestIDField(c))
+	}
+}
@@ -0,0 +1,326 @@
+package handler
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/go-dev-frame/sponge/pkg/gotest"
+	"github.com/go-dev-frame/sponge/pkg/httpcli"
+	"github.com/go-dev-frame/sponge/pkg/jwt"
+	"github.com/go-dev-frame/sponge/pkg/utils"
+
+	"test-user-server/internal/cache"
+	"test-user-server/internal/dao"
+	"test-user-server/internal/database"
+	"test-user-server/internal/devise"
+	"test-user-server/internal/ecode"
+	"test-user-server/internal/mailer"
+	"test-user-server/internal/model"
+	"test-user-server/internal/policy"
+	"test-user-server/internal/types"
+)
+
+func newInvitationHandler() *gotest.Handler {
+	testData := &model.Users{}
+	testData.ID = 1
+	testData.Email = "foo@bar.com"
+
+	// init mock cache
+	c := gotest.NewCache(map[string]interface{}{utils.Uint64ToStr(testData.ID): testData})
+	c.ICache = cache.NewUsersCache(&database.CacheType{
+		CType: "redis",
+		Rdb:   c.RedisClient,
+	})
+
+	// init mock dao
+	d := gotest.NewDao(c, testData)
+	d.IDao = dao.NewUsersDao(d.DB, c.ICache.(cache.UsersCache))
+
+	// init mock handler
+	h := gotest.NewHandler(d, testData)
+	h.IHandler = &invitationHandler{
+		db:            d.DB,
+		iDao:          d.IDao.(dao.UsersDao),
+		mailer:        mailer.NewFileMailer(testMailDir),
+		invitationURL: "http://localhost:3000/users/invitation/accept",
+	}
+	iHandler := h.IHandler.(InvitationHandler)
+
+	// the caller is the users of testData
+	signedIn := func(next gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("claims", &jwt.Claims{UID: utils.Uint64ToStr(testData.ID)})
+			next(c)
+		}
+	}
+
+	testFns := []gotest.RouterInfo{
+		{
+			FuncName:    "Invite",
+			Method:      http.MethodPost,
+			Path:        "/users/invitation",
+			HandlerFunc: signedIn(iHandler.Invite),
+		},
+		{
+			FuncName:    "Resend",
+			Method:      http.MethodPost,
+			Path:        "/users/invitation/resend",
+			HandlerFunc: signedIn(iHandler.Resend),
+		},
+		{
+			FuncName:    "Accept",
+			Method:      http.MethodPut,
+			Path:        "/users/invitation/accept",
+			HandlerFunc: iHandler.Accept,
+		},
+		{
+			FuncName:    "ListInvitees",
+			Method:      http.MethodGet,
+			Path:        "/users/invitees",
+			HandlerFunc: signedIn(iHandler.ListInvitees),
+		},
+	}
+
+	h.GoRunHTTPServer(testFns)
+
+	time.Sleep(time.Millisecond * 200)
+	return h
+}
+
+func Test_invitationHandler_Invite(t *testing.T) {
+	h := newInvitationHandler()
+	defer h.Close()
+	defer os.RemoveAll(testMailDir)
+	testData := h.TestData.(*model.Users)
+	devise.Init(devise.WithSecretKey("secret", ""), devise.WithInvitable(5, 0))
+	defer devise.Init()
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("new@bar.com", 1).
+		WillReturnError(database.ErrRecordNotFound)
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectQuery("SELECT .* FOR UPDATE").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "invitations_count"}).AddRow(testData.ID, 2))
+	h.MockDao.SQLMock.ExpectExec("SAVEPOINT .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectUsersWritten(h, testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*invitation_limit.*").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUsersRecorded(h, testData.ID, "invitation_limit", 4)
+	h.MockDao.SQLMock.ExpectExec("SAVEPOINT .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	h.MockDao.SQLMock.ExpectExec("INSERT INTO `users` .*").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	h.MockDao.SQLMock.ExpectExec("INSERT INTO `users_audit` .*").
+		WithArgs(2, model.UsersAuditCreate, "jwt", testData.ID, sqlmock.AnyArg(), sqlmock.AnyArg(), h.MockDao.AnyTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersVersion(h.MockDao, 2, model.UsersAuditCreate)
+	expectUsersEvent(h.MockDao, 2, model.UsersEventCreated)
+	h.MockDao.SQLMock.ExpectExec("SAVEPOINT .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	h.MockDao.SQLMock.ExpectQuery("SELECT .* FOR UPDATE").
+		WithArgs(testData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "invitations_count"}).AddRow(testData.ID, 2))
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(3, h.MockDao.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "invitations_count"}).AddRow(testData.ID, 3))
+	h.MockDao.SQLMock.ExpectExec("INSERT INTO `users_audit` .*").
+		WithArgs(testData.ID, model.UsersAuditUpdate, "jwt", testData.ID, sqlmock.AnyArg(), sqlmock.AnyArg(), h.MockDao.AnyTime).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	expectUsersVersion(h.MockDao, testData.ID, model.UsersAuditUpdate)
+	expectUsersEvent(h.MockDao, testData.ID, model.UsersEventUpdated)
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("Invite"), &types.InviteUsersRequest{Email: "new@bar.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+	assert.NotEmpty(t, lastMailToken(t, "invitation_token"))
+
+	// no invitations left rolls back
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("new@bar.com", 1).
+		WillReturnError(database.ErrRecordNotFound)
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectQuery("SELECT .* FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "invitation_limit"}).AddRow(testData.ID, 0))
+	h.MockDao.SQLMock.ExpectExec("SAVEPOINT .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectUsersWritten(h, testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*invitation_limit.*").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	h.MockDao.SQLMock.ExpectRollback()
+	err = httpcli.Post(result, h.GetRequestURL("Invite"), &types.InviteUsersRequest{Email: "new@bar.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrNoInvitationsLeft.Code(), result.Code)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// email taken
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.Email, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(testData.ID, testData.Email))
+	err = httpcli.Post(result, h.GetRequestURL("Invite"), &types.InviteUsersRequest{Email: testData.Email})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrEmailTakenInvitation.Code(), result.Code)
+}
+
+func Test_invitationHandler_Resend(t *testing.T) {
+	h := newInvitationHandler()
+	defer h.Close()
+	defer os.RemoveAll(testMailDir)
+	devise.Init(devise.WithSecretKey("secret", ""))
+	defer devise.Init()
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("new@bar.com", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "invitation_token", "invitation_created_at", "invited_by_type", "invited_by_id"}).
+			AddRow(2, "new@bar.com", "digest", time.Now(), devise.InvitedByTypeUsers, 1))
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectQuery("SELECT .* FOR UPDATE").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "invitation_token"}).AddRow(2, "digest"))
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "invitation_token"}).AddRow(2, "new digest"))
+	h.MockDao.SQLMock.ExpectExec("INSERT INTO `users_audit` .*").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersVersion(h.MockDao, 2, model.UsersAuditUpdate)
+	expectUsersEvent(h.MockDao, 2, model.UsersEventUpdated)
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("Resend"), &types.ResendInvitationRequest{Email: "new@bar.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+	assert.NotEmpty(t, lastMailToken(t, "invitation_token"))
+
+	// already accepted
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("new@bar.com", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "invitation_accepted_at"}).
+			AddRow(2, "new@bar.com", time.Now()))
+	err = httpcli.Post(result, h.GetRequestURL("Resend"), &types.ResendInvitationRequest{Email: "new@bar.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrNotInvitedInvitation.Code(), result.Code)
+
+	// invited by someone else, the token is left alone
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("other@bar.com", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "invitation_token", "invitation_created_at", "invited_by_type", "invited_by_id"}).
+			AddRow(3, "other@bar.com", "digest", time.Now(), devise.InvitedByTypeUsers, 7))
+	err = httpcli.Post(result, h.GetRequestURL("Resend"), &types.ResendInvitationRequest{Email: "other@bar.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrNotInvitedInvitation.Code(), result.Code)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+}
+
+func Test_invitedBy(t *testing.T) {
+	invitee := &model.Users{InvitedByType: devise.InvitedByTypeUsers, InvitedByID: 1}
+	assert.True(t, invitedBy(invitee, &policy.Subject{ID: 1, Role: policy.RoleUser}))
+	assert.False(t, invitedBy(invitee, &policy.Subject{ID: 2, Role: policy.RoleUser}))
+	assert.True(t, invitedBy(invitee, &policy.Subject{ID: 2, Role: policy.RoleAdmin}))
+	assert.False(t, invitedBy(&model.Users{InvitedByType: "Admin", InvitedByID: 1}, &policy.Subject{ID: 1, Role: policy.RoleUser}))
+}
+
+func Test_invitationHandler_Accept(t *testing.T) {
+	h := newInvitationHandler()
+	defer h.Close()
+	devise.Init(devise.WithStretches(bcrypt.MinCost), devise.WithSecretKey("secret", ""), devise.WithInvitable(0, time.Hour))
+	defer devise.Init()
+	invitee := &model.Users{}
+	raw, _ := devise.GenerateInvitationToken(invitee, time.Now())
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(invitee.InvitationToken, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "invitation_token", "invitation_created_at"}).
+			AddRow(2, "new@bar.com", invitee.InvitationToken, time.Now()))
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersWritten(h, 2)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUsersRecorded(h, 2, "invitation_accepted_at", time.Now())
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Put(result, h.GetRequestURL("Accept"), &types.AcceptInvitationRequest{Token: raw, Password: "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// expired invitation
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "invitation_token", "invitation_created_at"}).
+			AddRow(2, "new@bar.com", invitee.InvitationToken, time.Now().Add(-2*time.Hour)))
+	err = httpcli.Put(result, h.GetRequestURL("Accept"), &types.AcceptInvitationRequest{Token: raw, Password: "123456"})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrExpiredInvitation.Code(), result.Code)
+
+	// unknown token
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WillReturnError(database.ErrRecordNotFound)
+	err = httpcli.Put(result, h.GetRequestURL("Accept"), &types.AcceptInvitationRequest{Token: "unknown", Password: "123456"})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrTokenInvitation.Code(), result.Code)
+}
+
+func Test_invitationHandler_ListInvitees(t *testing.T) {
+	h := newInvitationHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT count\\(\\*\\) .*").
+		WithArgs(testData.ID, devise.InvitedByTypeUsers).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "invitation_token"}).AddRow(2, "new@bar.com", "digest"))
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Get(result, h.GetRequestURL("ListInvitees"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	data := result.Data.(map[string]interface{})
+	invitees := data["invitees"].([]interface{})
+	assert.Len(t, invitees, 1)
+	assert.NotContains(t, invitees[0], "invitationToken")
+
+	// too many per page
+	err = httpcli.Get(result, h.GetRequestURL("ListInvitees"), httpcli.WithParams(map[string]interface{}{"limit": 101}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+}
+
+func TestNewInvitationHandler(t *testing.T) {
+	defer func() {
+		recover()
+	}()
+	_ = NewInvitationHandler()
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+
+	"test-user-server/internal/model"
+	"test-user-server/internal/types"
+)
+
+// mergePatchContentType the media type of a json merge patch, RFC 7396
+const mergePatchContentType = "application/merge-patch+json"
+
+// patchDeniedColumns columns that have their own flows, email goes through devise reconfirmable on PUT,
+// the password through the change password route and deactivated_at through delete and restore
+var patchDeniedColumns = map[string]bool{
+	"id":                 true,
+	"created_at":         true,
+	"updated_at":         true,
+	"email":              true,
+	"encrypted_password": true,
+	"deactivated_at":     true,
+}
+
+type patchColumn struct {
+	name      string // column name
+	nullable  bool   // false for the not null columns
+	formIndex int    // field of types.UpdateUsersByIDRequest holding the decoded value
+}
+
+// usersPatchColumns the patchable columns by json name, the columns of model.UsersColumnNames
+// that are also fields of types.UpdateUsersByIDRequest, the model.UsersSecretColumnNames never are
+var usersPatchColumns = func() map[string]patchColumn {
+	formIndexes := map[string]int{}
+	formType := reflect.TypeOf(types.UpdateUsersByIDRequest{})
+	for i := 0; i < formType.NumField(); i++ {
+		name, _, _ := strings.Cut(formType.Field(i).Tag.Get("json"), ",")
+		formIndexes[name] = i
+	}
+
+	columns := map[string]patchColumn{}
+	modelType := reflect.TypeOf(model.Users{})
+	for i := 0; i < modelType.NumField(); i++ {
+		field := modelType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		formIndex, ok := formIndexes[name]
+		if !ok {
+			continue
+		}
+		column, nullable := "", true
+		for _, setting := range strings.Split(field.Tag.Get("gorm"), ";") {
+			if v, ok := strings.CutPrefix(setting, "column:"); ok {
+				column = v
+			}
+			if setting == "not null" {
+				nullable = false
+			}
+		}
+		if !model.UsersColumnNames[column] || patchDeniedColumns[column] || model.UsersSecretColumnNames[column] {
+			continue
+		}
+		columns[name] = patchColumn{name: column, nullable: nullable, formIndex: formIndex}
+	}
+	return columns
+}()
+
+// mergePatchColumns turn a json merge patch into the columns to write, a member set to null clears the
+// column, any other member is decoded and validated like the body of UpdateByID, absent members are left alone
+func mergePatchColumns(body []byte) (map[string]interface{}, []types.FieldError) {
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+		return nil, []types.FieldError{{Rule: "json", Message: "merge patch must be a json object"}}
+	}
+	if len(patch) == 0 {
+		return nil, []types.FieldError{{Rule: "json", Message: "merge patch has no members"}}
+	}
+
+	keys := make([]string, 0, len(patch))
+	for key := range patch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var fieldErrs []types.FieldError
+	for _, key := range keys {
+		if _, ok := usersPatchColumns[key]; !ok {
+			fieldErrs = append(fieldErrs, types.FieldError{Field: key, Rule: "column", Message: "is not a patchable column"})
+		}
+	}
+	if len(fieldErrs) > 0 {
+		return nil, fieldErrs
+	}
+
+	form := &types.UpdateUsersByIDRequest{}
+	if err := json.Unmarshal(body, form); err != nil {
+		return nil, fieldErrors(err)
+	}
+	if err := binding.Validator.ValidateStruct(form); err != nil {
+		return nil, fieldErrors(err)
+	}
+
+	formValue := reflect.ValueOf(form).Elem()
+	columns := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		column := usersPatchColumns[key]
+		if bytes.Equal(bytes.TrimSpace(patch[key]), []byte("null")) {
+			if !column.nullable {
+				fieldErrs = append(fieldErrs, types.FieldError{Field: key, Rule: "nullable", Message: "cannot be null"})
+				continue
+			}
+			columns[column.name] = nil
+			continue
+		}
+		v := formValue.Field(column.formIndex)
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		columns[column.name] = v.Interface()
+	}
+	if len(fieldErrs) > 0 {
+		return nil, fieldErrs
+	}
+
+	return columns, nil
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"test-user-server/internal/model"
+	"test-user-server/internal/types"
+)
+
+// usersFieldColumns the columns of model.UsersColumnNames by json name, the secret columns are
+// never returned so they cannot be asked for either
+var usersFieldColumns = func() map[string]string {
+	columns := map[string]string{}
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous {
+				walk(field.Type)
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			column := ""
+			for _, setting := range strings.Split(field.Tag.Get("gorm"), ";") {
+				if v, ok := strings.CutPrefix(setting, "column:"); ok {
+					column = v
+				}
+			}
+			if name == "id" {
+				column = "id"
+			}
+			if model.UsersColumnNames[column] && !model.UsersSecretColumnNames[column] {
+				columns[name] = column
+			}
+		}
+	}
+	walk(reflect.TypeOf(model.Users{}))
+	return columns
+}()
+
+// parseFields read the fields query parameter, a comma separated list of json names, into the
+// names to keep in the response and the columns to read, both are empty when it is not set
+func parseFields(c *gin.Context) ([]string, []string, []types.FieldError) {
+	value := c.Query("fields")
+	if value == "" {
+		return nil, nil, nil
+	}
+
+	var names, columns []string
+	var fieldErrs []types.FieldError
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		column, ok := usersFieldColumns[name]
+		if !ok {
+			fieldErrs = append(fieldErrs, types.FieldError{
+				Field: "fields", Rule: "oneof", Param: name, Message: "fields has no field " + name,
+			})
+			continue
+		}
+		names = append(names, name)
+		columns = append(columns, column)
+	}
+	return names, columns, fieldErrs
+}
+
+// sparseFields keep the given json names and the id of a converted users or list of them,
+// data is returned as it is when there are no names
+func sparseFields(data interface{}, names []string) (interface{}, error) {
+	if len(names) == 0 {
+		return data, nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err = json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+
+	keep := map[string]bool{"id": true}
+	for _, name := range names {
+		keep[name] = true
+	}
+	filter := func(v interface{}) {
+		if obj, ok := v.(map[string]interface{}); ok {
+			for name := range obj {
+				if !keep[name] {
+					delete(obj, name)
+				}
+			}
+		}
+	}
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			filter(item)
+		}
+	} else {
+		filter(v)
+	}
+	return v, nil
+}
@@ -1,22 +1,35 @@
 package handler
 
 import (
+	"encoding/json"
 	"errors"
-	"math"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/go-dev-frame/sponge/pkg/copier"
+	"github.com/go-dev-frame/sponge/pkg/errcode"
 	"github.com/go-dev-frame/sponge/pkg/gin/middleware"
 	"github.com/go-dev-frame/sponge/pkg/gin/response"
 	"github.com/go-dev-frame/sponge/pkg/logger"
+	"github.com/go-dev-frame/sponge/pkg/sgorm/query"
 	"github.com/go-dev-frame/sponge/pkg/utils"
 
 	"test-user-server/internal/cache"
+	"test-user-server/internal/config"
+	"test-user-server/internal/cursor"
 	"test-user-server/internal/dao"
 	"test-user-server/internal/database"
+	"test-user-server/internal/devise"
 	"test-user-server/internal/ecode"
+	"test-user-server/internal/mailer"
 	"test-user-server/internal/model"
+	"test-user-server/internal/policy"
 	"test-user-server/internal/types"
 )
 
@@ -27,17 +40,45 @@ type UsersHandler interface {
 	Create(c *gin.Context)
 	DeleteByID(c *gin.Context)
 	UpdateByID(c *gin.Context)
+	PatchByID(c *gin.Context)
 	GetByID(c *gin.Context)
+	GetByKey(c *gin.Context)
 	List(c *gin.Context)
 
 	DeleteByIDs(c *gin.Context)
 	GetByCondition(c *gin.Context)
 	ListByIDs(c *gin.Context)
 	ListByLastID(c *gin.Context)
+	Search(c *gin.Context)
+	Import(c *gin.Context)
+	Export(c *gin.Context)
+	ListTrash(c *gin.Context)
+	Restore(c *gin.Context)
+	History(c *gin.Context)
+	ListVersions(c *gin.Context)
+	DiffVersions(c *gin.Context)
+	Revert(c *gin.Context)
+	ReplayEvents(c *gin.Context)
+	CreateWebhook(c *gin.Context)
+	ListWebhooks(c *gin.Context)
+	GetWebhook(c *gin.Context)
+	UpdateWebhook(c *gin.Context)
+	DeleteWebhook(c *gin.Context)
+	ListWebhookDeliveries(c *gin.Context)
+	RedeliverWebhook(c *gin.Context)
+
+	ChangePassword(c *gin.Context)
+
+	GetMe(c *gin.Context)
+	UpdateMe(c *gin.Context)
 }
 
 type usersHandler struct {
-	iDao dao.UsersDao
+	iDao     dao.UsersDao
+	importer *UsersImporter
+
+	mailer          mailer.Mailer
+	confirmationURL string // link of the confirmation instructions, the token is added as query
 }
 
 // NewUsersHandler creating the handler interface
@@ -47,12 +88,16 @@ func NewUsersHandler() UsersHandler {
 			database.GetDB(), // db driver is mysql
 			cache.NewUsersCache(database.GetCacheType()),
 		),
+		importer: NewUsersImporter(),
+
+		mailer:          mailer.Get(),
+		confirmationURL: config.Get().Mailer.ConfirmationURL,
 	}
 }
 
 // Create a new users
 // @Summary Create a new users
-// @Description Creates a new users entity using the provided data in the request body.
+// @Description Creates a new users entity using the provided data in the request body, the plaintext password is stored as a devise compatible bcrypt digest. A request that fails validation gets InvalidParams with data.errors listing each field, see types.FieldError.
 // @Tags users
 // @Accept json
 // @Produce json
@@ -64,8 +109,7 @@ func (h *usersHandler) Create(c *gin.Context) {
 	form := &types.CreateUsersRequest{}
 	err := c.ShouldBindJSON(form)
 	if err != nil {
-		logger.Warn("ShouldBindJSON error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
-		response.Error(c, ecode.InvalidParams)
+		respondBindError(c, err)
 		return
 	}
 
@@ -76,21 +120,43 @@ func (h *usersHandler) Create(c *gin.Context) {
 		return
 	}
 	// Note: if copier.Copy cannot assign a value to a field, add it here
+	users.EncryptedPassword, err = devise.DigestPassword(form.Password)
+	if err != nil {
+		logger.Error("DigestPassword error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrCreateUsers)
+		return
+	}
+	var confirmationToken string
+	if !devise.Confirmed(users) {
+		confirmationToken, err = devise.GenerateConfirmationToken(users, time.Now())
+		if err != nil {
+			logger.Error("GenerateConfirmationToken error", logger.Err(err), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.ErrCreateUsers)
+			return
+		}
+	}
 
 	ctx := middleware.WrapCtx(c)
 	err = h.iDao.Create(ctx, users)
 	if err != nil {
-		logger.Error("Create error", logger.Err(err), logger.Any("form", form), middleware.GCtxRequestIDField(c))
+		if respondDBError(c, err) {
+			return
+		}
+		logger.Error("Create error", logger.Err(err), logger.String("email", form.Email), middleware.GCtxRequestIDField(c))
 		response.Output(c, ecode.InternalServerError.ToHTTPCode())
 		return
 	}
 
+	if confirmationToken != "" {
+		h.sendConfirmationInstructions(c, users.Email, confirmationToken)
+	}
+
 	response.Success(c, gin.H{"id": users.ID})
 }
 
 // DeleteByID delete a users by id
 // @Summary Delete a users by id
-// @Description Deletes a existing users identified by the given id in the path.
+// @Description Deactivates a existing users identified by the given id in the path, it moves to the trash where it can be restored until it is purged after the retention period.
 // @Tags users
 // @Accept json
 // @Produce json
@@ -108,8 +174,15 @@ func (h *usersHandler) DeleteByID(c *gin.Context) {
 	ctx := middleware.WrapCtx(c)
 	err := h.iDao.DeleteByID(ctx, id)
 	if err != nil {
-		logger.Error("DeleteByID error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
-		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("DeleteByID not found", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+		} else if respondDBError(c, err) {
+			return
+		} else {
+			logger.Error("DeleteByID error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
 		return
 	}
 
@@ -118,13 +191,15 @@ func (h *usersHandler) DeleteByID(c *gin.Context) {
 
 // UpdateByID update a users by id
 // @Summary Update a users by id
-// @Description Updates the specified users by given id in the path, support partial update.
+// @Description Updates the specified users by given id in the path, support partial update, for admins only, users update their own record with PUT /api/v1/users/me. A new email is stored in unconfirmedEmail and only replaces email once confirmed with the mailed token, like devise reconfirmable. A request that fails validation gets InvalidParams with data.errors listing each field, see types.FieldError.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param id path string true "id"
+// @Param If-Match header string false "etag returned by GetByID, the update is refused with 412 and the current users when the row has changed since"
 // @Param data body types.UpdateUsersByIDRequest true "users information"
 // @Success 200 {object} types.UpdateUsersByIDReply{}
+// @Failure 412 {object} types.GetUsersByIDReply{}
 // @Router /api/v1/users/{id} [put]
 // @Security BearerAuth
 func (h *usersHandler) UpdateByID(c *gin.Context) {
@@ -137,8 +212,7 @@ func (h *usersHandler) UpdateByID(c *gin.Context) {
 	form := &types.UpdateUsersByIDRequest{}
 	err := c.ShouldBindJSON(form)
 	if err != nil {
-		logger.Warn("ShouldBindJSON error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
-		response.Error(c, ecode.InvalidParams)
+		respondBindError(c, err)
 		return
 	}
 	form.ID = id
@@ -150,11 +224,118 @@ func (h *usersHandler) UpdateByID(c *gin.Context) {
 		return
 	}
 	// Note: if copier.Copy cannot assign a value to a field, add it here
+	if form.Password != "" {
+		users.EncryptedPassword, err = devise.DigestPassword(form.Password)
+		if err != nil {
+			logger.Error("DigestPassword error", logger.Err(err), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.ErrUpdateByIDUsers)
+			return
+		}
+	}
 
 	ctx := middleware.WrapCtx(c)
-	err = h.iDao.UpdateByID(ctx, users)
+	reconfirm := form.Email != "" && devise.ReconfirmableEnabled()
+	var confirmationToken string
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		// the etag is checked against the row locked for the update, the cached one may miss writes of the rails app,
+		// a new email is only postponed once it matched
+		var current *model.Users
+		current, err = h.iDao.UpdateByIDIfUnmodified(ctx, users, func(locked *model.Users) error {
+			if !matchETag(ifMatch, usersETag(locked)) {
+				return dao.ErrUpdateConflict
+			}
+			if !reconfirm {
+				return nil
+			}
+			var postponeErr error
+			confirmationToken, postponeErr = postponeEmailChange(users, locked)
+			return postponeErr
+		})
+		if errors.Is(err, dao.ErrUpdateConflict) {
+			logger.Warn("UpdateByID conflict", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			h.preconditionFailed(c, current)
+			return
+		}
+	} else {
+		if reconfirm {
+			var record *model.Users
+			record, err = h.iDao.GetByID(ctx, id)
+			if err == nil {
+				confirmationToken, err = postponeEmailChange(users, record)
+			}
+		}
+		if err == nil {
+			err = h.iDao.UpdateByID(ctx, users)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("UpdateByID not found", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+			return
+		}
+		if respondDBError(c, err) {
+			return
+		}
+		logger.Error("UpdateByID error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	if confirmationToken != "" {
+		h.sendConfirmationInstructions(c, users.UnconfirmedEmail, confirmationToken)
+	}
+
+	response.Success(c)
+}
+
+// PatchByID patch a users by id
+// @Summary Patch a users by id
+// @Description Applies a json merge patch (RFC 7396) to the specified users, a member set to null clears the column and zero values are written, absent members are left alone. Members must be patchable columns, email and password have their own routes.
+// @Tags users
+// @Accept application/merge-patch+json
+// @Produce json
+// @Param id path string true "id"
+// @Param data body object true "members of types.UpdateUsersByIDRequest"
+// @Success 200 {object} types.UpdateUsersByIDReply{}
+// @Router /api/v1/users/{id} [patch]
+// @Security BearerAuth
+func (h *usersHandler) PatchByID(c *gin.Context) {
+	_, id, isAbort := getUsersIDFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+	if c.ContentType() != mergePatchContentType {
+		response.Output(c, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		logger.Warn("read body error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+	columns, fieldErrs := mergePatchColumns(body)
+	if len(fieldErrs) > 0 {
+		logger.Warn("merge patch error", logger.Any("errors", fieldErrs), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams, gin.H{"errors": fieldErrs})
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	err = h.iDao.PatchByID(ctx, id, columns)
 	if err != nil {
-		logger.Error("UpdateByID error", logger.Err(err), logger.Any("form", form), middleware.GCtxRequestIDField(c))
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("PatchByID not found", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+			return
+		}
+		if respondDBError(c, err) {
+			return
+		}
+		logger.Error("PatchByID error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
 		response.Output(c, ecode.InternalServerError.ToHTTPCode())
 		return
 	}
@@ -164,12 +345,16 @@ func (h *usersHandler) UpdateByID(c *gin.Context) {
 
 // GetByID get a users by id
 // @Summary Get a users by id
-// @Description Gets detailed information of a users specified by the given id in the path.
+// @Description Gets detailed information of a users specified by the given id in the path. Secret columns are never returned, callers with the admin role claim get the admin projection.
 // @Tags users
 // @Param id path string true "id"
+// @Param If-None-Match header string false "etag of a cached copy, answered with 304 while it is still current"
+// @Param fields query string false "comma separated json names of the fields to return, e.g. email,chineseName,clerkCode, the id is always returned"
+// @Param asOf query string false "RFC3339 time, the users is returned as it was then from its versions, NotFound when it did not exist or was deleted then, ErrHistoryUnavailable when it was written since without a version recorded until then. Not cached, there is no etag."
 // @Accept json
 // @Produce json
 // @Success 200 {object} types.GetUsersByIDReply{}
+// @Success 304 "not modified"
 // @Router /api/v1/users/{id} [get]
 // @Security BearerAuth
 func (h *usersHandler) GetByID(c *gin.Context) {
@@ -178,7 +363,25 @@ func (h *usersHandler) GetByID(c *gin.Context) {
 		response.Error(c, ecode.InvalidParams)
 		return
 	}
+	fields, _, fieldErrs := parseFields(c)
+	if len(fieldErrs) > 0 {
+		response.Error(c, ecode.InvalidParams, gin.H{"errors": fieldErrs})
+		return
+	}
+	if asOf := c.Query("asOf"); asOf != "" {
+		at, err := time.Parse(time.RFC3339, asOf)
+		if err != nil {
+			logger.Warn("asOf params error", logger.String("asOf", asOf), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.InvalidParams, gin.H{"errors": []types.FieldError{
+				{Field: "asOf", Rule: "datetime", Param: time.RFC3339, Message: "asOf must be a RFC3339 time"},
+			}})
+			return
+		}
+		h.getAsOf(c, id, at, fields)
+		return
+	}
 
+	// the whole record is read and the fields are cut from the reply, the etag does not depend on them
 	ctx := middleware.WrapCtx(c)
 	users, err := h.iDao.GetByID(ctx, id)
 	if err != nil {
@@ -192,13 +395,92 @@ func (h *usersHandler) GetByID(c *gin.Context) {
 		return
 	}
 
-	data := &types.UsersObjDetail{}
-	err = copier.Copy(data, users)
+	etag := usersETag(users)
+	c.Header("ETag", etag)
+	if matchETag(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	data, err := convertUsersByRole(c, users)
+	if err == nil {
+		data, err = sparseFields(data, fields)
+	}
+	if err != nil {
+		response.Error(c, ecode.ErrGetByIDUsers)
+		return
+	}
+
+	response.Success(c, gin.H{"users": data})
+}
+
+// getAsOf respond with the users of id as it was at the given time
+func (h *usersHandler) getAsOf(c *gin.Context, id uint64, at time.Time, fields []string) {
+	ctx := middleware.WrapCtx(c)
+	users, err := h.iDao.GetAsOf(ctx, id, at)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("GetAsOf not found", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+		} else if errors.Is(err, dao.ErrHistoryUnavailable) {
+			logger.Warn("GetAsOf history unavailable", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.ErrHistoryUnavailableUsers)
+		} else {
+			logger.Error("GetAsOf error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	data, err := convertUsersByRole(c, users)
+	if err == nil {
+		data, err = sparseFields(data, fields)
+	}
+	if err != nil {
+		response.Error(c, ecode.ErrGetByIDUsers)
+		return
+	}
+
+	response.Success(c, gin.H{"users": data})
+}
+
+// GetByKey get a users by a natural key
+// @Summary Get a users by a natural key
+// @Description Gets detailed information of the users whose key column has the value, key is one of email, clerk_code, wecom_id, windows_sid and pre_sso_id. Lookups go through a cache index so integrations need not know the id.
+// @Tags users
+// @Param key path string true "natural key column" Enums(email, clerk_code, wecom_id, windows_sid, pre_sso_id)
+// @Param value path string true "value of the column"
+// @Accept json
+// @Produce json
+// @Success 200 {object} types.GetUsersByIDReply{}
+// @Router /api/v1/users/by/{key}/{value} [get]
+// @Security BearerAuth
+func (h *usersHandler) GetByKey(c *gin.Context) {
+	key, value := c.Param("key"), c.Param("value")
+	if !model.UsersNaturalKeyColumnNames[key] || value == "" {
+		logger.Warn("GetByKey params error", logger.String("key", key), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	users, err := h.iDao.GetByKey(ctx, key, value)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("GetByKey not found", logger.Err(err), logger.String("key", key), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+		} else {
+			logger.Error("GetByKey error", logger.Err(err), logger.String("key", key), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	data, err := convertUsersByRole(c, users)
 	if err != nil {
 		response.Error(c, ecode.ErrGetByIDUsers)
 		return
 	}
-	// Note: if copier.Copy cannot assign a value to a field, add it here
 
 	response.Success(c, gin.H{"users": data})
 }
@@ -210,6 +492,7 @@ func (h *usersHandler) GetByID(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param data body types.Params true "query parameters"
+// @Param fields query string false "comma separated json names of the fields to return, e.g. email,chineseName,clerkCode, the id is always returned"
 // @Success 200 {object} types.ListUserssReply{}
 // @Router /api/v1/users/list [post]
 // @Security BearerAuth
@@ -221,16 +504,24 @@ func (h *usersHandler) List(c *gin.Context) {
 		response.Error(c, ecode.InvalidParams)
 		return
 	}
+	fields, columns, fieldErrs := parseFields(c)
+	if len(fieldErrs) > 0 {
+		response.Error(c, ecode.InvalidParams, gin.H{"errors": fieldErrs})
+		return
+	}
 
 	ctx := middleware.WrapCtx(c)
-	userss, total, err := h.iDao.GetByColumns(ctx, &form.Params)
+	userss, total, err := h.iDao.GetByColumns(ctx, &form.Params, columns...)
 	if err != nil {
 		logger.Error("GetByColumns error", logger.Err(err), logger.Any("form", form), middleware.GCtxRequestIDField(c))
 		response.Output(c, ecode.InternalServerError.ToHTTPCode())
 		return
 	}
 
-	data, err := convertUserss(userss)
+	data, err := convertUserssByRole(c, userss)
+	if err == nil {
+		data, err = sparseFields(data, fields)
+	}
 	if err != nil {
 		response.Error(c, ecode.ErrListUsers)
 		return
@@ -244,7 +535,7 @@ func (h *usersHandler) List(c *gin.Context) {
 
 // DeleteByIDs batch delete users by ids
 // @Summary Batch delete users by ids
-// @Description Deletes multiple users by a list of id
+// @Description Moves multiple users to the trash by a list of id, nothing is deleted when an id does not exist or is already in the trash and those ids are returned in data.notFoundIDs
 // @Tags users
 // @Param data body types.DeleteUserssByIDsRequest true "id array"
 // @Accept json
@@ -264,8 +555,16 @@ func (h *usersHandler) DeleteByIDs(c *gin.Context) {
 	ctx := middleware.WrapCtx(c)
 	err = h.iDao.DeleteByIDs(ctx, form.IDs)
 	if err != nil {
-		logger.Error("GetByIDs error", logger.Err(err), logger.Any("form", form), middleware.GCtxRequestIDField(c))
-		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		var notFound *dao.NotFoundIDsError
+		if errors.As(err, &notFound) {
+			logger.Warn("DeleteByIDs not found", logger.Err(err), logger.Any("form", form), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound, gin.H{"notFoundIDs": notFound.IDs})
+		} else if respondDBError(c, err) {
+			return
+		} else {
+			logger.Error("DeleteByIDs error", logger.Err(err), logger.Any("form", form), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
 		return
 	}
 
@@ -310,13 +609,11 @@ func (h *usersHandler) GetByCondition(c *gin.Context) {
 		return
 	}
 
-	data := &types.UsersObjDetail{}
-	err = copier.Copy(data, users)
+	data, err := convertUsersByRole(c, users)
 	if err != nil {
-		response.Error(c, ecode.ErrGetByIDUsers)
+		response.Error(c, ecode.ErrGetByConditionUsers)
 		return
 	}
-	// Note: if copier.Copy cannot assign a value to a field, add it here
 
 	response.Success(c, gin.H{"users": data})
 }
@@ -326,6 +623,7 @@ func (h *usersHandler) GetByCondition(c *gin.Context) {
 // @Description Returns a list of users that match the list of id.
 // @Tags users
 // @Param data body types.ListUserssByIDsRequest true "id array"
+// @Param fields query string false "comma separated json names of the fields to return, e.g. email,chineseName,clerkCode, the id is always returned"
 // @Accept json
 // @Produce json
 // @Success 200 {object} types.ListUserssByIDsReply{}
@@ -339,105 +637,999 @@ func (h *usersHandler) ListByIDs(c *gin.Context) {
 		response.Error(c, ecode.InvalidParams)
 		return
 	}
+	fields, columns, fieldErrs := parseFields(c)
+	if len(fieldErrs) > 0 {
+		response.Error(c, ecode.InvalidParams, gin.H{"errors": fieldErrs})
+		return
+	}
 
 	ctx := middleware.WrapCtx(c)
-	usersMap, err := h.iDao.GetByIDs(ctx, form.IDs)
+	usersMap, err := h.iDao.GetByIDs(ctx, form.IDs, columns...)
 	if err != nil {
 		logger.Error("GetByIDs error", logger.Err(err), logger.Any("form", form), middleware.GCtxRequestIDField(c))
 		response.Output(c, ecode.InternalServerError.ToHTTPCode())
 		return
 	}
 
-	userss := []*types.UsersObjDetail{}
+	records := []*model.Users{}
 	for _, id := range form.IDs {
 		if v, ok := usersMap[id]; ok {
-			record, err := convertUsers(v)
-			if err != nil {
-				response.Error(c, ecode.ErrListUsers)
-				return
-			}
-			userss = append(userss, record)
+			records = append(records, v)
 		}
 	}
 
+	userss, err := convertUserssByRole(c, records)
+	if err == nil {
+		userss, err = sparseFields(userss, fields)
+	}
+	if err != nil {
+		response.Error(c, ecode.ErrListByIDsUsers)
+		return
+	}
+
 	response.Success(c, gin.H{
 		"userss": userss,
 	})
 }
 
-// ListByLastID get a paginated list of userss by last id
-// @Summary Get a paginated list of userss by last id
-// @Description Returns a paginated list of userss starting after a given last id, useful for cursor-based pagination.
+// ListByLastID get a page of userss by cursor
+// @Summary Get a page of userss by cursor
+// @Description Returns a page of userss in keyset order, pass nextCursor or prevCursor of a response as cursor to get the page after or before it. The cursor is signed and carries its sort, a sort parameter different from it is refused.
 // @Tags users
 // @Accept json
 // @Produce json
-// @Param lastID query int false "last id, default is MaxInt32" default(0)
-// @Param limit query int false "number per page" default(10)
-// @Param sort query string false "sort by column name of table, and the "-" sign before column name indicates reverse order" default(-id)
-// @Success 200 {object} types.ListUserssReply{}
+// @Param cursor query string false "nextCursor or prevCursor of the previous page, empty for the first page"
+// @Param limit query int false "number per page, at most 100" default(10)
+// @Param fields query string false "comma separated json names of the fields to return, e.g. email,chineseName,clerkCode, the id is always returned"
+// @Param sort query string false "sort by id, created_at, updated_at, email, chinese_name, clerk_code or sign_in_count, and the "-" sign before column name indicates reverse order" default(-id)
+// @Success 200 {object} types.ListUserssByCursorReply{}
 // @Router /api/v1/users/list [get]
 // @Security BearerAuth
 func (h *usersHandler) ListByLastID(c *gin.Context) {
-	lastID := utils.StrToUint64(c.Query("lastID"))
-	if lastID == 0 {
-		lastID = math.MaxInt32
-	}
 	limit := utils.StrToInt(c.Query("limit"))
 	if limit == 0 {
 		limit = 10
 	}
+	if limit < 1 || limit > 100 {
+		logger.Warn("ListByLastID params error", logger.Int("limit", limit), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
 	sort := c.Query("sort")
+	if column := strings.TrimPrefix(sort, "-"); sort != "" && !model.UsersCursorColumnNames[column] {
+		logger.Warn("sort error", logger.String("sort", sort), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams, gin.H{"errors": []types.FieldError{
+			{Field: "sort", Rule: "oneof", Message: "sort cannot be by " + column},
+		}})
+		return
+	}
+
+	fields, columns, fieldErrs := parseFields(c)
+	if len(fieldErrs) > 0 {
+		response.Error(c, ecode.InvalidParams, gin.H{"errors": fieldErrs})
+		return
+	}
+
+	var from *cursor.Cursor
+	if token := c.Query("cursor"); token != "" {
+		var err error
+		from, err = cursor.Decode(token)
+		if err != nil || (sort != "" && sort != from.Sort) {
+			logger.Warn("cursor error", logger.Err(err), logger.String("sort", sort), middleware.GCtxRequestIDField(c))
+			respondInvalidCursor(c)
+			return
+		}
+	}
 
 	ctx := middleware.WrapCtx(c)
-	userss, err := h.iDao.GetByLastID(ctx, lastID, limit, sort)
+	userss, page, err := h.iDao.GetByCursor(ctx, sort, from, limit, columns...)
 	if err != nil {
-		logger.Error("GetByLastID error", logger.Err(err), logger.Uint64("lastID", lastID), logger.Int("limit", limit), middleware.GCtxRequestIDField(c))
+		if errors.Is(err, cursor.ErrInvalidCursor) {
+			respondInvalidCursor(c)
+			return
+		}
+		logger.Error("GetByCursor error", logger.Err(err), logger.String("sort", sort), logger.Int("limit", limit), middleware.GCtxRequestIDField(c))
 		response.Output(c, ecode.InternalServerError.ToHTTPCode())
 		return
 	}
 
-	data, err := convertUserss(userss)
+	data, err := convertUserssByRole(c, userss)
+	if err == nil {
+		data, err = sparseFields(data, fields)
+	}
+	if err != nil {
+		response.Error(c, ecode.ErrListByLastIDUsers)
+		return
+	}
+	nextCursor, prevCursor, err := encodeCursors(page)
 	if err != nil {
 		response.Error(c, ecode.ErrListByLastIDUsers)
 		return
 	}
 
 	response.Success(c, gin.H{
-		"userss": data,
+		"userss":     data,
+		"nextCursor": nextCursor,
+		"prevCursor": prevCursor,
 	})
 }
 
-func getUsersIDFromPath(c *gin.Context) (string, uint64, bool) {
-	idStr := c.Param("id")
-	id, err := utils.StrToUint64E(idStr)
-	if err != nil || id == 0 {
-		logger.Warn("StrToUint64E error: ", logger.String("idStr", idStr), middleware.GCtxRequestIDField(c))
-		return "", 0, true
+// respondInvalidCursor a cursor that was tampered with, or was made for another sort than requested
+func respondInvalidCursor(c *gin.Context) {
+	response.Error(c, ecode.InvalidParams, gin.H{"errors": []types.FieldError{
+		{Field: "cursor", Rule: "cursor", Message: "cursor is invalid or was made for another sort"},
+	}})
+}
+
+func encodeCursors(page *cursor.Page) (next string, prev string, err error) {
+	if page.Next != nil {
+		if next, err = cursor.Encode(page.Next); err != nil {
+			return "", "", err
+		}
 	}
+	if page.Prev != nil {
+		if prev, err = cursor.Encode(page.Prev); err != nil {
+			return "", "", err
+		}
+	}
+	return next, prev, nil
+}
 
-	return idStr, id, false
+// Search search userss by keywords
+// @Summary Search userss by keywords
+// @Description Returns a page of the userss matching every keyword of q by email, chineseName, clerkCode, mobile, positionTitle or majorName, the best matches first. Exact matches rank above prefix, substring and one typo matches, chineseName also matches by its full pinyin and initials, e.g. zs finds 张三.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param q query string true "keywords separated by spaces"
+// @Param page query int false "page number, starting from 0" default(0)
+// @Param limit query int false "number per page, at most 100" default(10)
+// @Success 200 {object} types.SearchUserssReply{}
+// @Router /api/v1/users/search [get]
+// @Security BearerAuth
+func (h *usersHandler) Search(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	page := utils.StrToInt(c.Query("page"))
+	limit := utils.StrToInt(c.Query("limit"))
+	if limit == 0 {
+		limit = 10
+	}
+	if q == "" || page < 0 || limit < 1 || limit > 100 {
+		logger.Warn("Search params error", logger.String("q", q), logger.Int("page", page), logger.Int("limit", limit), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	results, total, err := h.iDao.Search(ctx, q, page, limit)
+	if err != nil {
+		logger.Error("Search error", logger.Err(err), logger.String("q", q), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	data := make([]gin.H, 0, len(results))
+	for _, result := range results {
+		users, err := convertUsersByRole(c, result.Users)
+		if err != nil {
+			response.Error(c, ecode.ErrSearchUsers)
+			return
+		}
+		data = append(data, gin.H{"score": result.Score, "users": users})
+	}
+
+	response.Success(c, gin.H{
+		"results": data,
+		"total":   total,
+	})
 }
 
-func convertUsers(users *model.Users) (*types.UsersObjDetail, error) {
-	data := &types.UsersObjDetail{}
-	err := copier.Copy(data, users)
+// Import create and update userss from the rows of a csv or xlsx file
+// @Summary Import userss from a csv or xlsx file
+// @Description Upserts a users for each row of the uploaded sheet, found by the key column, and reports what became of each row. The header of a column is the json name, the column name or the chinese title of a field, such as 工号, 姓名 and 邮箱, see types.ImportUsersRow. Empty cells are left alone, created userss have no password and set it through the reset password instructions. A row that fails validation or a unique index is reported with its errors and the others go on. A dryRun writes nothing and reports what would be done. A sheet has at most 5000 rows.
+// @Tags users
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "csv or xlsx file"
+// @Param key formData string false "column finding the users of a row, clerk_code or email" default(clerk_code)
+// @Param dryRun formData bool false "report without writing" default(false)
+// @Success 200 {object} types.ImportUserssReply{}
+// @Router /api/v1/users/import [post]
+// @Security BearerAuth
+func (h *usersHandler) Import(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, usersImportMaxBytes)
+	file, err := c.FormFile("file")
 	if err != nil {
-		return nil, err
+		logger.Warn("FormFile error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams, gin.H{"errors": []types.FieldError{
+			{Field: "file", Rule: "required", Message: "file is required, at most 10MB"},
+		}})
+		return
 	}
-	// Note: if copier.Copy cannot assign a value to a field, add it here
+	key := c.DefaultPostForm("key", "clerk_code")
+	dryRun := c.PostForm("dryRun") == "true"
 
-	return data, nil
+	rows, err := readUploadedSheet(file)
+	if err != nil {
+		logger.Warn("read sheet error", logger.Err(err), logger.String("filename", file.Filename), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams, gin.H{"errors": []types.FieldError{
+			{Field: "file", Rule: "format", Param: "csv xlsx", Message: "file must be a csv or xlsx sheet"},
+		}})
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	report, fieldErrs, err := h.importer.Import(ctx, rows, key, dryRun)
+	if len(fieldErrs) > 0 {
+		response.Error(c, ecode.InvalidParams, gin.H{"errors": fieldErrs})
+		return
+	}
+	if err != nil {
+		logger.Error("Import error", logger.Err(err), logger.String("filename", file.Filename), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrImportUsers)
+		return
+	}
+
+	response.Success(c, report)
 }
 
-func convertUserss(fromValues []*model.Users) ([]*types.UsersObjDetail, error) {
-	toValues := []*types.UsersObjDetail{}
-	for _, v := range fromValues {
-		data, err := convertUsers(v)
+// Export stream the userss matching the conditions as csv, ndjson or xlsx
+// @Summary Export userss as csv, ndjson or xlsx
+// @Description Streams every users matching the conditions as a file download, the rows are read through a database cursor so the memory stays flat for any number of them. The filters are the columns of types.Params as json, without page and limit. The fields are those of the list, the secret columns are never exported. A csv starts with the utf-8 BOM for excel, the dates are written as 2006-01-02 and the times as RFC3339 so that the file can be imported back. An error after the first row cuts the file short.
+// @Tags users
+// @Produce text/csv,application/x-ndjson,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param format query string false "csv, ndjson or xlsx" default(csv)
+// @Param fields query string false "comma separated json names of the fields to export in that order, all of them by default"
+// @Param columns query string false "conditions as a json array of types.Column, e.g. [{\"name\":\"major_code\",\"value\":\"CS01\"}]"
+// @Param sort query string false "comma separated columns, descending with a leading -, e.g. -created_at" default(id)
+// @Success 200 {file} file
+// @Router /api/v1/users/export [get]
+// @Security BearerAuth
+func (h *usersHandler) Export(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	contentType, ok := usersExportContentTypes[format]
+	if !ok {
+		response.Error(c, ecode.InvalidParams, gin.H{"errors": []types.FieldError{
+			{Field: "format", Rule: "oneof", Param: "csv ndjson xlsx", Message: "format must be one of csv ndjson xlsx"},
+		}})
+		return
+	}
+	fields, columns, fieldErrs := parseFields(c)
+	if len(fieldErrs) > 0 {
+		response.Error(c, ecode.InvalidParams, gin.H{"errors": fieldErrs})
+		return
+	}
+	params := &query.Params{Sort: c.Query("sort")}
+	if v := c.Query("columns"); v != "" {
+		if err := json.Unmarshal([]byte(v), &params.Columns); err != nil {
+			response.Error(c, ecode.InvalidParams, gin.H{"errors": []types.FieldError{
+				{Field: "columns", Rule: "json", Message: "columns must be a json array of conditions"},
+			}})
+			return
+		}
+	}
+
+	projection := reflect.TypeOf(types.UsersObjDetail{})
+	if isAdminCaller(c) {
+		projection = reflect.TypeOf(types.UsersAdminObjDetail{})
+	}
+	exporter := newUsersExporter(format, c.Writer, fields, projection)
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		// the export lasts longer than the write timeout of the server
+		_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="userss-%s.%s"`, time.Now().Format("20060102150405"), format))
+		c.Status(http.StatusOK)
+	}
+
+	ctx := middleware.WrapCtx(c)
+	err := h.iDao.StreamByColumns(ctx, params, func(record *model.Users) error {
+		data, err := convertUsersByRole(c, record)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		toValues = append(toValues, data)
+		start()
+		return exporter.Write(data)
+	}, columns...)
+	if err == nil {
+		start()
+		err = exporter.Close()
+	}
+	if err != nil {
+		if started {
+			logger.Error("Export error, the file was cut short", logger.Err(err), logger.String("format", format), middleware.GCtxRequestIDField(c))
+			return
+		}
+		if errors.Is(err, dao.ErrQueryParams) {
+			logger.Warn("Export params error", logger.Err(err), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.InvalidParams)
+			return
+		}
+		logger.Error("StreamByColumns error", logger.Err(err), logger.Any("params", params), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
 	}
+}
 
-	return toValues, nil
+// ListTrash list the deleted userss
+// @Summary List the deleted userss
+// @Description Returns a page of the deactivated userss, the last deleted first. They are restored by their id until they are purged after the retention period.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param page query int false "page number, starting from 0" default(0)
+// @Param limit query int false "number per page, at most 100" default(20)
+// @Success 200 {object} types.ListTrashUserssReply{}
+// @Router /api/v1/users/trash [get]
+// @Security BearerAuth
+func (h *usersHandler) ListTrash(c *gin.Context) {
+	page := utils.StrToInt(c.Query("page"))
+	limit := utils.StrToInt(c.Query("limit"))
+	if limit == 0 {
+		limit = 20
+	}
+	if page < 0 || limit < 1 || limit > 100 {
+		logger.Warn("ListTrash params error", logger.Int("page", page), logger.Int("limit", limit), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	userss, total, err := h.iDao.GetDeactivated(ctx, page, limit)
+	if err != nil {
+		logger.Error("GetDeactivated error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	data, err := convertUserssAdmin(userss)
+	if err != nil {
+		response.Error(c, ecode.ErrListTrashUsers)
+		return
+	}
+
+	response.Success(c, gin.H{
+		"userss": data,
+		"total":  total,
+	})
+}
+
+// Restore take a deleted users out of the trash
+// @Summary Restore a deleted users
+// @Description Clears the deactivation of the users identified by the given id in the path, NotFound when it is not in the trash.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "id"
+// @Success 200 {object} types.RestoreUsersByIDReply{}
+// @Router /api/v1/users/{id}/restore [post]
+// @Security BearerAuth
+func (h *usersHandler) Restore(c *gin.Context) {
+	_, id, isAbort := getUsersIDFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	err := h.iDao.RestoreByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("RestoreByID not found", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+		} else {
+			logger.Error("RestoreByID error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	response.Success(c)
+}
+
+// History list the writes of a users
+// @Summary List the writes of a users
+// @Description Returns a page of the audit trail of the users identified by the given id in the path, the last write first. Each entry has the caller, the request id and the columns it changed with their values before and after, the secret columns read [FILTERED]. The trail of a deleted users stays readable.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "id"
+// @Param page query int false "page number, starting from 0" default(0)
+// @Param limit query int false "number per page, at most 100" default(20)
+// @Success 200 {object} types.ListUsersHistoryReply{}
+// @Router /api/v1/users/{id}/history [get]
+// @Security BearerAuth
+func (h *usersHandler) History(c *gin.Context) {
+	_, id, isAbort := getUsersIDFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+	page := utils.StrToInt(c.Query("page"))
+	limit := utils.StrToInt(c.Query("limit"))
+	if limit == 0 {
+		limit = 20
+	}
+	if page < 0 || limit < 1 || limit > 100 {
+		logger.Warn("History params error", logger.Int("page", page), logger.Int("limit", limit), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	audits, total, err := h.iDao.GetHistory(ctx, id, page, limit)
+	if err != nil {
+		logger.Error("GetHistory error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	data, err := convertUsersAudits(audits)
+	if err != nil {
+		logger.Error("convertUsersAudits error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrListHistoryUsers)
+		return
+	}
+
+	response.Success(c, gin.H{
+		"history": data,
+		"total":   total,
+	})
+}
+
+// ListVersions list the versions of a users
+// @Summary List the versions of a users
+// @Description Returns a page of the versions of the users identified by the given id in the path, the last one first. A version is the record as it was after a write, the secret columns are left empty. The versions of a deleted users stay readable.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "id"
+// @Param page query int false "page number, starting from 0" default(0)
+// @Param limit query int false "number per page, at most 100" default(20)
+// @Success 200 {object} types.ListUsersVersionsReply{}
+// @Router /api/v1/users/{id}/versions [get]
+// @Security BearerAuth
+func (h *usersHandler) ListVersions(c *gin.Context) {
+	_, id, isAbort := getUsersIDFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+	page := utils.StrToInt(c.Query("page"))
+	limit := utils.StrToInt(c.Query("limit"))
+	if limit == 0 {
+		limit = 20
+	}
+	if page < 0 || limit < 1 || limit > 100 {
+		logger.Warn("ListVersions params error", logger.Int("page", page), logger.Int("limit", limit), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	versions, total, err := h.iDao.GetVersions(ctx, id, page, limit)
+	if err != nil {
+		logger.Error("GetVersions error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	data, err := convertUsersVersions(versions)
+	if err != nil {
+		logger.Error("convertUsersVersions error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrListVersionsUsers)
+		return
+	}
+
+	response.Success(c, gin.H{
+		"versions": data,
+		"total":    total,
+	})
+}
+
+// DiffVersions compare two versions of a users
+// @Summary Compare two versions of a users
+// @Description Returns the columns that differ from the version against to the version in the path, with their values in both. Against defaults to the version before, 0 is before the users was created. NotFound when either version does not exist.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "id"
+// @Param version path int true "version number"
+// @Param against query int false "version number to compare with, the version before by default"
+// @Success 200 {object} types.DiffUsersVersionsReply{}
+// @Router /api/v1/users/{id}/versions/{version}/diff [get]
+// @Security BearerAuth
+func (h *usersHandler) DiffVersions(c *gin.Context) {
+	_, id, isAbort := getUsersIDFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+	version, isAbort := getUsersVersionFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+	against := version - 1
+	if s := c.Query("against"); s != "" {
+		var err error
+		against, err = utils.StrToIntE(s)
+		if err != nil || against < 0 {
+			logger.Warn("DiffVersions params error", logger.String("against", s), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.InvalidParams)
+			return
+		}
+	}
+
+	ctx := middleware.WrapCtx(c)
+	changes, err := h.iDao.DiffVersions(ctx, id, against, version)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("DiffVersions not found", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+		} else {
+			logger.Error("DiffVersions error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	data := make(map[string]types.UsersChangeObj, len(changes))
+	for column, change := range changes {
+		data[column] = types.UsersChangeObj{Before: change.Before, After: change.After}
+	}
+
+	response.Success(c, gin.H{
+		"from":    against,
+		"to":      version,
+		"changes": data,
+	})
+}
+
+// Revert write a version of a users back
+// @Summary Revert a users to one of its versions
+// @Description Writes the profile columns of the version in the path back to the users in one transaction, the revert is audited and recorded as a new version. Email, password, sign in and confirmation columns are left alone. NotFound when the users is in the trash or has no such version.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "id"
+// @Param version path int true "version number"
+// @Success 200 {object} types.RevertUsersReply{}
+// @Router /api/v1/users/{id}/versions/{version}/revert [post]
+// @Security BearerAuth
+func (h *usersHandler) Revert(c *gin.Context) {
+	_, id, isAbort := getUsersIDFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+	version, isAbort := getUsersVersionFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	err := h.iDao.RevertToVersion(ctx, id, version)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("RevertToVersion not found", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+			return
+		}
+		if respondDBError(c, err) {
+			return
+		}
+		logger.Error("RevertToVersion error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	response.Success(c)
+}
+
+// ReplayEvents publish the users events again from an offset
+// @Summary Publish the users events again from an offset
+// @Description Marks the events of the outbox from the offset on as unpublished, the relay publishes them again in offset order and in the order of the writes of each users. The offset is in each event, consumers that lost events replay from the last one they handled, the events older than outbox.retentionDays are gone.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param data body types.ReplayUsersEventsRequest true "offset"
+// @Success 200 {object} types.ReplayUsersEventsReply{}
+// @Router /api/v1/users/events/replay [post]
+// @Security BearerAuth
+func (h *usersHandler) ReplayEvents(c *gin.Context) {
+	form := &types.ReplayUsersEventsRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	replayed, err := h.iDao.ReplayEvents(ctx, form.Offset)
+	if err != nil {
+		logger.Error("ReplayEvents error", logger.Err(err), logger.Uint64("offset", form.Offset), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+	logger.Info("replay users events", logger.Uint64("offset", form.Offset), logger.Int64("replayed", replayed), middleware.GCtxRequestIDField(c))
+
+	response.Success(c, gin.H{"replayed": replayed})
+}
+
+// ChangePassword change the password of a users after checking the current password
+// @Summary Change the password of a users
+// @Description Verifies the current password of the users identified by the given id in the path, then stores the bcrypt digest of the new password.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "id"
+// @Param data body types.ChangeUsersPasswordRequest true "current and new password"
+// @Success 200 {object} types.ChangeUsersPasswordReply{}
+// @Router /api/v1/users/{id}/password [post]
+// @Security BearerAuth
+func (h *usersHandler) ChangePassword(c *gin.Context) {
+	_, id, isAbort := getUsersIDFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	form := &types.ChangeUsersPasswordRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		logger.Warn("ShouldBindJSON error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	users, err := h.iDao.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("GetByID not found", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+		} else {
+			logger.Error("GetByID error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	if !devise.ValidPassword(users.EncryptedPassword, form.CurrentPassword) {
+		logger.Warn("ChangePassword current password mismatch", logger.Any("id", id), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrCurrentPasswordUsers)
+		return
+	}
+
+	encryptedPassword, err := devise.DigestPassword(form.Password)
+	if err != nil {
+		logger.Error("DigestPassword error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrChangePasswordUsers)
+		return
+	}
+
+	err = h.iDao.UpdatePasswordByID(ctx, id, encryptedPassword)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("UpdatePasswordByID not found", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+			return
+		}
+		logger.Error("UpdatePasswordByID error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	response.Success(c)
+}
+
+// GetMe get the signed in users
+// @Summary Get the current users
+// @Description Gets detailed information of the caller, resolved from the jwt claims or from the warden user of the rails session.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Success 200 {object} types.GetUsersByIDReply{}
+// @Router /api/v1/users/me [get]
+// @Security BearerAuth
+func (h *usersHandler) GetMe(c *gin.Context) {
+	caller, ok := policy.CurrentSubject(c)
+	if !ok {
+		response.Error(c, ecode.Unauthorized)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	users, err := h.iDao.GetByID(ctx, caller.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("GetByID not found", logger.Err(err), logger.Any("id", caller.ID), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+		} else {
+			logger.Error("GetByID error", logger.Err(err), logger.Any("id", caller.ID), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	data, err := convertUsersByRole(c, users)
+	if err != nil {
+		response.Error(c, ecode.ErrGetByIDUsers)
+		return
+	}
+
+	response.Success(c, gin.H{"users": data})
+}
+
+// UpdateMe update the signed in users
+// @Summary Update the current users
+// @Description Updates the preferences and phone numbers of the caller, any other field in the request body is ignored.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param data body types.UpdateMeRequest true "users information"
+// @Success 200 {object} types.UpdateUsersByIDReply{}
+// @Router /api/v1/users/me [put]
+// @Security BearerAuth
+func (h *usersHandler) UpdateMe(c *gin.Context) {
+	caller, ok := policy.CurrentSubject(c)
+	if !ok {
+		response.Error(c, ecode.Unauthorized)
+		return
+	}
+
+	form := &types.UpdateMeRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	users := &model.Users{}
+	err = copier.Copy(users, form)
+	if err != nil {
+		response.Error(c, ecode.ErrUpdateByIDUsers)
+		return
+	}
+	users.ID = caller.ID
+
+	ctx := middleware.WrapCtx(c)
+	err = h.iDao.UpdateByID(ctx, users)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("UpdateByID not found", logger.Err(err), logger.Any("id", caller.ID), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+			return
+		}
+		if respondDBError(c, err) {
+			return
+		}
+		logger.Error("UpdateByID error", logger.Err(err), logger.Any("id", caller.ID), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	response.Success(c)
+}
+
+// preconditionFailed answer 412 with the current representation, so the client can merge and retry with its etag
+func (h *usersHandler) preconditionFailed(c *gin.Context, current *model.Users) {
+	data, err := convertUsersByRole(c, current)
+	if err != nil {
+		response.Output(c, http.StatusPreconditionFailed)
+		return
+	}
+	c.Header("ETag", usersETag(current))
+	response.Output(c, http.StatusPreconditionFailed, gin.H{"users": data})
+}
+
+// postponeEmailChange keep the current email of record and move the new one to unconfirmed_email with a new
+// confirmation token, same as Devise::Models::Confirmable#postpone_email_change_until_confirmation_and_regenerate_confirmation_token
+func postponeEmailChange(users *model.Users, record *model.Users) (string, error) {
+	if users.Email == record.Email {
+		return "", nil
+	}
+
+	users.UnconfirmedEmail = users.Email
+	users.Email = "" // empty fields are not updated
+	return devise.GenerateConfirmationToken(users, time.Now())
+}
+
+// sendConfirmationInstructions a failure is only logged, the instructions can be requested again by changing the email
+func (h *usersHandler) sendConfirmationInstructions(c *gin.Context, email string, token string) {
+	err := h.mailer.Send(middleware.WrapCtx(c), mailer.ConfirmationInstructions(email, h.confirmationURL, token))
+	if err != nil {
+		logger.Error("Send confirmation instructions error", logger.Err(err), logger.String("email", email), middleware.GCtxRequestIDField(c))
+	}
+}
+
+func getUsersIDFromPath(c *gin.Context) (string, uint64, bool) {
+	idStr := c.Param("id")
+	id, err := utils.StrToUint64E(idStr)
+	if err != nil || id == 0 {
+		logger.Warn("StrToUint64E error: ", logger.String("idStr", idStr), middleware.GCtxRequestIDField(c))
+		return "", 0, true
+	}
+
+	return idStr, id, false
+}
+
+func getUsersVersionFromPath(c *gin.Context) (int, bool) {
+	versionStr := c.Param("version")
+	version, err := utils.StrToIntE(versionStr)
+	if err != nil || version < 1 {
+		logger.Warn("StrToIntE error: ", logger.String("versionStr", versionStr), middleware.GCtxRequestIDField(c))
+		return 0, true
+	}
+
+	return version, false
+}
+
+func convertUsers(users *model.Users) (*types.UsersObjDetail, error) {
+	data := &types.UsersObjDetail{}
+	err := copier.Copy(data, users)
+	if err != nil {
+		return nil, err
+	}
+	// Note: if copier.Copy cannot assign a value to a field, add it here
+
+	return data, nil
+}
+
+func convertUserss(fromValues []*model.Users) ([]*types.UsersObjDetail, error) {
+	toValues := []*types.UsersObjDetail{}
+	for _, v := range fromValues {
+		data, err := convertUsers(v)
+		if err != nil {
+			return nil, err
+		}
+		toValues = append(toValues, data)
+	}
+
+	return toValues, nil
+}
+
+func convertUsersAdmin(users *model.Users) (*types.UsersAdminObjDetail, error) {
+	data := &types.UsersAdminObjDetail{}
+	err := copier.Copy(data, users)
+	if err != nil {
+		return nil, err
+	}
+	// Note: if copier.Copy cannot assign a value to a field, add it here
+
+	return data, nil
+}
+
+func convertUserssAdmin(fromValues []*model.Users) ([]*types.UsersAdminObjDetail, error) {
+	toValues := []*types.UsersAdminObjDetail{}
+	for _, v := range fromValues {
+		data, err := convertUsersAdmin(v)
+		if err != nil {
+			return nil, err
+		}
+		toValues = append(toValues, data)
+	}
+
+	return toValues, nil
+}
+
+func convertUsersAudits(fromValues []*model.UsersAudit) ([]*types.UsersAuditObjDetail, error) {
+	toValues := []*types.UsersAuditObjDetail{}
+	for _, v := range fromValues {
+		data := &types.UsersAuditObjDetail{
+			ID:        v.ID,
+			Action:    v.Action,
+			ActorType: v.ActorType,
+			ActorID:   v.ActorID,
+			RequestID: v.RequestID,
+			CreatedAt: &v.CreatedAt,
+		}
+		if err := json.Unmarshal([]byte(v.Changes), &data.Changes); err != nil {
+			return nil, err
+		}
+		toValues = append(toValues, data)
+	}
+
+	return toValues, nil
+}
+
+func convertUsersVersions(fromValues []*model.UsersVersion) ([]*types.UsersVersionObjDetail, error) {
+	toValues := []*types.UsersVersionObjDetail{}
+	for _, v := range fromValues {
+		users := &model.Users{}
+		if err := json.Unmarshal([]byte(v.Snapshot), users); err != nil {
+			return nil, err
+		}
+		data, err := convertUsersAdmin(users)
+		if err != nil {
+			return nil, err
+		}
+		toValues = append(toValues, &types.UsersVersionObjDetail{
+			Version:   v.Version,
+			Action:    v.Action,
+			CreatedAt: &v.CreatedAt,
+			Users:     data,
+		})
+	}
+
+	return toValues, nil
+}
+
+// convertUsersByRole returns the admin projection when the caller holds the admin role claim,
+// otherwise the public projection.
+func convertUsersByRole(c *gin.Context, users *model.Users) (interface{}, error) {
+	if isAdminCaller(c) {
+		return convertUsersAdmin(users)
+	}
+	return convertUsers(users)
+}
+
+// convertUserssByRole is the list variant of convertUsersByRole.
+func convertUserssByRole(c *gin.Context, fromValues []*model.Users) (interface{}, error) {
+	if isAdminCaller(c) {
+		return convertUserssAdmin(fromValues)
+	}
+	return convertUserss(fromValues)
+}
+
+// isAdminCaller callers with the admin role get the admin projection
+func isAdminCaller(c *gin.Context) bool {
+	s, ok := policy.CurrentSubject(c)
+	return ok && s.IsAdmin()
+}
+
+// respondDBError answer the mysql errors classified by database.TranslateError, 409 naming the conflicting
+// column, 400 for a value too long for its column and 503 for a deadlock, false if err is none of them
+func respondDBError(c *gin.Context, err error) bool {
+	var dbErr *database.Error
+	if !errors.As(err, &dbErr) {
+		return false
+	}
+
+	var e *errcode.Error
+	status := http.StatusConflict
+	switch dbErr.Kind {
+	case database.ErrDuplicateKey:
+		e = ecode.ErrDuplicateKey
+	case database.ErrForeignKey:
+		e = ecode.ErrForeignKey
+	case database.ErrDataTooLong:
+		e, status = ecode.ErrDataTooLong, http.StatusBadRequest
+	case database.ErrDeadlock:
+		e, status = ecode.ErrDeadlock, http.StatusServiceUnavailable
+		c.Header("Retry-After", "1")
+	default:
+		return false
+	}
+
+	logger.Warn("database error", logger.Err(err), logger.String("column", dbErr.Column), middleware.GCtxRequestIDField(c))
+	c.JSON(status, &response.Result{Code: e.Code(), Msg: e.Msg(), Data: gin.H{"column": dbErr.Column}})
+	return true
+}
+
+// usersETag the entity tag of a users, its id and the updated_at of its last write in microseconds, the
+// precision mysql keeps at most, so a record read back from the database or the cache has the etag of the row.
+// Only updated_at goes into it, the secret columns and the columns of other projections are never exposed by it.
+func usersETag(users *model.Users) string {
+	return fmt.Sprintf(`"%d-%d"`, users.ID, users.UpdatedAt.UnixMicro())
+}
+
+// matchETag report whether the If-Match or If-None-Match header lists the etag, weak tags compare equal to strong ones
+func matchETag(header string, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"test-user-server/internal/sheet"
+)
+
+// usersExportContentTypes the content type of each export format
+var usersExportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"xlsx":   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// usersDateFields the json names of the date columns, they are exported as 2006-01-02 like the import reads them
+var usersDateFields = map[string]bool{"entryCompanyDate": true}
+
+// usersExporter write the converted userss to a csv, ndjson or xlsx stream, the header row is
+// written with the first users, or at Close when there is none
+type usersExporter struct {
+	format string
+	w      io.Writer
+	names  []string // json names of the fields
+	fields []int    // struct field of each name in the projection, -1 when it has none
+
+	sw    sheet.Writer
+	cells []interface{}
+}
+
+// newUsersExporter the names are the fields to write, all the fields of projection when there are none,
+// projection is the type convertUsersByRole gives the caller
+func newUsersExporter(format string, w io.Writer, names []string, projection reflect.Type) *usersExporter {
+	indexes := map[string]int{}
+	var all []string
+	for i := 0; i < projection.NumField(); i++ {
+		name, _, _ := strings.Cut(projection.Field(i).Tag.Get("json"), ",")
+		indexes[name] = i
+		all = append(all, name)
+	}
+	if len(names) == 0 {
+		names = all
+	}
+
+	fields := make([]int, len(names))
+	for i, name := range names {
+		index, ok := indexes[name]
+		if !ok {
+			index = -1
+		}
+		fields[i] = index
+	}
+	return &usersExporter{format: format, w: w, names: names, fields: fields, cells: make([]interface{}, len(names))}
+}
+
+// Write write a users converted by convertUsersByRole
+func (e *usersExporter) Write(data interface{}) error {
+	if e.format == "ndjson" {
+		data, err := sparseFields(data, e.names)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		_, err = e.w.Write(append(b, '\n'))
+		return err
+	}
+
+	if err := e.start(); err != nil {
+		return err
+	}
+	v := reflect.Indirect(reflect.ValueOf(data))
+	for i, field := range e.fields {
+		e.cells[i] = nil
+		if field >= 0 {
+			e.cells[i] = exportCell(v.Field(field), usersDateFields[e.names[i]])
+		}
+	}
+	return e.sw.Write(e.cells)
+}
+
+// Close write the header row if no users was written and flush
+func (e *usersExporter) Close() error {
+	if e.format == "ndjson" {
+		return nil
+	}
+	if err := e.start(); err != nil {
+		return err
+	}
+	return e.sw.Close()
+}
+
+func (e *usersExporter) start() error {
+	if e.sw != nil {
+		return nil
+	}
+	sw, err := sheet.NewWriter(e.format, e.w)
+	if err != nil {
+		return err
+	}
+	e.sw = sw
+	for i, name := range e.names {
+		e.cells[i] = name
+	}
+	return e.sw.Write(e.cells)
+}
+
+// exportCell the value of a field as a sheet cell, nil for a nil pointer
+func exportCell(v reflect.Value, isDate bool) interface{} {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		if isDate {
+			return t.Format(time.DateOnly)
+		}
+		return t.Format(time.RFC3339)
+	}
+	return v.Interface()
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"test-user-server/internal/ecode"
+	"test-user-server/internal/sheet"
+)
+
+func Test_usersHandler_Export(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	entryDate := time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)
+
+	get := func(params url.Values) (*http.Response, []byte) {
+		resp, err := http.Get(h.GetRequestURL("Export") + "?" + params.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close() //nolint
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	// csv of the given fields
+	h.MockDao.SQLMock.ExpectQuery("SELECT `id`,`chinese_name`,`entry_company_date` FROM `users` WHERE major_code = \\? AND deactivated_at IS NULL ORDER BY created_at DESC").
+		WithArgs("CS01").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "chinese_name", "entry_company_date"}).
+			AddRow(2, "李四", nil).
+			AddRow(1, "张三", entryDate))
+	resp, body := get(url.Values{
+		"fields":  {"chineseName,entryCompanyDate"},
+		"columns": {`[{"name":"major_code","value":"CS01"}]`},
+		"sort":    {"-created_at"},
+	})
+	assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), ".csv")
+	rows, err := sheet.Read("userss.csv", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, [][]string{{"chineseName", "entryCompanyDate"}, {"李四", ""}, {"张三", "2024-01-02"}}, rows)
+
+	// ndjson of all the fields
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE deactivated_at IS NULL ORDER BY id").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "encrypted_password"}).
+			AddRow(1, "zhangsan@example.com", "secret").
+			AddRow(2, "lisi@example.com", "secret"))
+	resp, body = get(url.Values{"format": {"ndjson"}})
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	assert.Len(t, lines, 2)
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Equal(t, "lisi@example.com", record["email"])
+	assert.NotContains(t, string(body), "secret")
+
+	// xlsx without rows still has the header
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}))
+	_, body = get(url.Values{"format": {"xlsx"}, "fields": {"email"}})
+	rows, err = sheet.Read("userss.xlsx", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, [][]string{{"email"}}, rows)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// params error
+	for _, params := range []url.Values{
+		{"format": {"xls"}},
+		{"fields": {"encryptedPassword"}},
+		{"columns": {"{"}},
+		{"columns": {`[{"name":"password","value":"x"}]`}},
+		{"sort": {"-password"}},
+	} {
+		_, body = get(params)
+		reply := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(body, &reply))
+		assert.Equal(t, float64(ecode.InvalidParams.Code()), reply["code"], params.Encode())
+	}
+
+	// error test
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnError(io.ErrUnexpectedEOF)
+	resp, _ = get(url.Values{})
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
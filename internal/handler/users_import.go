@@ -0,0 +1,387 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"mime/multipart"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+
+	"github.com/go-dev-frame/sponge/pkg/copier"
+
+	"test-user-server/internal/cache"
+	"test-user-server/internal/dao"
+	"test-user-server/internal/database"
+	"test-user-server/internal/model"
+	"test-user-server/internal/sheet"
+	"test-user-server/internal/types"
+)
+
+const (
+	// UsersImportMaxRows rows a sheet may have besides the header
+	UsersImportMaxRows = 5000
+
+	usersImportBatchSize = 100
+	usersImportMaxBytes  = 10 << 20
+
+	importCreated   = "created"
+	importUpdated   = "updated"
+	importUnchanged = "unchanged"
+	importFailed    = "failed"
+)
+
+// usersImportKeys the columns a row finds its users by, and the field holding them
+var usersImportKeys = map[string]string{
+	"clerk_code": "clerkCode",
+	"email":      "email",
+}
+
+// usersImportTitles the chinese titles the HR sheets use for the fields
+var usersImportTitles = map[string]string{
+	"邮箱":     "email",
+	"电子邮箱":   "email",
+	"姓名":     "chineseName",
+	"中文名":    "chineseName",
+	"工号":     "clerkCode",
+	"员工编号":   "clerkCode",
+	"手机":     "mobile",
+	"手机号":    "mobile",
+	"手机号码":   "mobile",
+	"座机":     "deskPhone",
+	"办公电话":   "deskPhone",
+	"职位":     "positionTitle",
+	"岗位":     "positionTitle",
+	"职级":     "jobLevel",
+	"企业微信":   "wecomID",
+	"企业微信id": "wecomID",
+	"入职日期":   "entryCompanyDate",
+	"专业代码":   "majorCode",
+	"专业":     "majorName",
+	"专业名称":   "majorName",
+}
+
+// importDateLayouts the ways the dates are written, 01-02-06 is how a xlsx date cell of the default format reads
+var importDateLayouts = []string{"2006-01-02", "2006-1-2", "2006/1/2", "2006.1.2", "20060102", "01-02-06"}
+
+// usersImportHeaders the fields of types.ImportUsersRow by lower case json name, column name and chinese title
+var usersImportHeaders = func() map[string]string {
+	headers := map[string]string{}
+	rowType := reflect.TypeOf(types.ImportUsersRow{})
+	for i := 0; i < rowType.NumField(); i++ {
+		name, _, _ := strings.Cut(rowType.Field(i).Tag.Get("json"), ",")
+		headers[strings.ToLower(name)] = name
+		if column, ok := usersFieldColumns[name]; ok {
+			headers[column] = name
+		}
+	}
+	for title, name := range usersImportTitles {
+		headers[title] = name
+	}
+	return headers
+}()
+
+// UsersImporter upsert the rows of a users sheet, shared by the import route and the import command
+type UsersImporter struct {
+	iDao dao.UsersDao
+	db   *gorm.DB
+}
+
+// NewUsersImporter creating an importer writing through the users dao
+func NewUsersImporter() *UsersImporter {
+	return &UsersImporter{
+		iDao: dao.NewUsersDao(
+			database.GetDB(), // db driver is mysql
+			cache.NewUsersCache(database.GetCacheType()),
+		),
+		db: database.GetDB(),
+	}
+}
+
+type importRow struct {
+	result int          // index of the result in the report
+	users  *model.Users // the record to create, or the columns to update with the id set
+}
+
+// Import upsert the rows of a sheet whose first row is the header. key is clerk_code or email, a row
+// updates the users having its value and creates one otherwise. The created userss have no password,
+// they set it through the reset password instructions, and their emails are taken as confirmed.
+//
+// An error of the header or of the options fails the whole sheet and is returned as field errors, a row
+// that fails validation or is refused by the database is reported and the others go on. Nothing is
+// written in a dry run. Rows are written in batches of usersImportBatchSize, each in a transaction,
+// a database error other than a refused row stops the import and the batches before it stay written.
+func (im *UsersImporter) Import(ctx context.Context, rows [][]string, key string, dryRun bool) (*types.ImportUsersReport, []types.FieldError, error) {
+	keyField, ok := usersImportKeys[key]
+	if !ok {
+		return nil, []types.FieldError{{Field: "key", Rule: "oneof", Param: "clerk_code email", Message: "must be one of clerk_code email"}}, nil
+	}
+	if len(rows) == 0 {
+		return nil, []types.FieldError{{Field: "file", Rule: "required", Message: "sheet has no header"}}, nil
+	}
+	if len(rows)-1 > UsersImportMaxRows {
+		return nil, []types.FieldError{{Field: "file", Rule: "max", Param: strconv.Itoa(UsersImportMaxRows),
+			Message: "sheet must have at most " + strconv.Itoa(UsersImportMaxRows) + " rows"}}, nil
+	}
+	fields, fieldErrs := importHeader(rows[0], keyField)
+	if len(fieldErrs) > 0 {
+		return nil, fieldErrs, nil
+	}
+
+	report := &types.ImportUsersReport{DryRun: dryRun, Rows: []types.ImportUsersRowResult{}}
+	var pending []*importRow
+	firstRows := map[string]int{} // row of each key value
+	for i, cells := range rows[1:] {
+		values := map[string]string{}
+		for j, cell := range cells {
+			if j < len(fields) && fields[j] != "" {
+				if v := strings.TrimSpace(cell); v != "" {
+					values[fields[j]] = v
+				}
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		result := types.ImportUsersRowResult{Row: i + 2}
+		row, errs := decodeImportRow(values)
+		keyValue := strings.ToLower(values[keyField])
+		if keyValue == "" {
+			errs = append(errs, types.FieldError{Field: keyField, Rule: "required", Message: "is required"})
+		} else if first, ok := firstRows[keyValue]; ok {
+			errs = append(errs, types.FieldError{Field: keyField, Rule: "unique", Param: strconv.Itoa(first),
+				Message: "is the same as row " + strconv.Itoa(first)})
+		} else {
+			firstRows[keyValue] = result.Row
+		}
+		if len(errs) > 0 {
+			result.Action, result.Errors = importFailed, errs
+			report.Rows = append(report.Rows, result)
+			continue
+		}
+
+		existing, err := im.iDao.GetByKey(ctx, key, values[keyField])
+		if errors.Is(err, database.ErrRecordNotFound) {
+			existing = nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		users, errs := importUsers(row, existing)
+		switch {
+		case len(errs) > 0:
+			result.Action, result.Errors = importFailed, errs
+		case users == nil:
+			result.Action, result.ID = importUnchanged, existing.ID
+		case existing == nil:
+			result.Action = importCreated
+			pending = append(pending, &importRow{result: len(report.Rows), users: users})
+		default:
+			result.Action, result.ID = importUpdated, existing.ID
+			pending = append(pending, &importRow{result: len(report.Rows), users: users})
+		}
+		report.Rows = append(report.Rows, result)
+	}
+
+	if !dryRun {
+		for start := 0; start < len(pending); start += usersImportBatchSize {
+			err := im.writeBatch(ctx, report.Rows, pending[start:min(start+usersImportBatchSize, len(pending))])
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	for _, result := range report.Rows {
+		switch result.Action {
+		case importCreated:
+			report.Created++
+		case importUpdated:
+			report.Updated++
+		case importUnchanged:
+			report.Unchanged++
+		default:
+			report.Failed++
+		}
+	}
+	return report, nil, nil
+}
+
+// writeBatch write each row under a savepoint, a row refused by the database is rolled back alone
+func (im *UsersImporter) writeBatch(ctx context.Context, results []types.ImportUsersRowResult, rows []*importRow) error {
+	return im.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			result := &results[row.result]
+			// the dao writes each row and its audit in a savepoint of tx, a failing row is rolled back alone
+			var err error
+			if result.Action == importCreated {
+				result.ID, err = im.iDao.CreateByTx(ctx, tx, row.users)
+			} else {
+				err = im.iDao.UpdateByTx(ctx, tx, row.users)
+			}
+			if err != nil {
+				fieldErr, ok := importDBError(err)
+				if !ok {
+					return err
+				}
+				result.Action, result.ID, result.Errors = importFailed, 0, []types.FieldError{fieldErr}
+			}
+		}
+		return nil
+	})
+}
+
+// importHeader get the field of each column, "" for the blank headers
+func importHeader(header []string, keyField string) ([]string, []types.FieldError) {
+	fields := make([]string, len(header))
+	columns := map[string]bool{}
+	var fieldErrs []types.FieldError
+	for i, title := range header {
+		title = strings.ToLower(strings.TrimSpace(title))
+		if title == "" {
+			continue
+		}
+		name, ok := usersImportHeaders[title]
+		switch {
+		case !ok:
+			fieldErrs = append(fieldErrs, types.FieldError{Field: "header", Rule: "oneof", Param: header[i],
+				Message: "column " + header[i] + " is not a users field"})
+		case columns[name]:
+			fieldErrs = append(fieldErrs, types.FieldError{Field: "header", Rule: "unique", Param: header[i],
+				Message: "column " + header[i] + " is given twice"})
+		default:
+			fields[i], columns[name] = name, true
+		}
+	}
+	if len(fieldErrs) == 0 && !columns[keyField] {
+		fieldErrs = append(fieldErrs, types.FieldError{Field: "header", Rule: "required", Param: keyField,
+			Message: "column " + keyField + " is required to find the users"})
+	}
+	return fields, fieldErrs
+}
+
+// decodeImportRow fill a row from the cells by field name and validate it like a request body
+func decodeImportRow(values map[string]string) (*types.ImportUsersRow, []types.FieldError) {
+	row := &types.ImportUsersRow{}
+	rowValue := reflect.ValueOf(row).Elem()
+	rowType := rowValue.Type()
+	var fieldErrs []types.FieldError
+	for i := 0; i < rowType.NumField(); i++ {
+		name, _, _ := strings.Cut(rowType.Field(i).Tag.Get("json"), ",")
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		switch field := rowValue.Field(i); field.Interface().(type) {
+		case string:
+			field.SetString(value)
+		case *time.Time:
+			date, ok := parseImportDate(value)
+			if !ok {
+				fieldErrs = append(fieldErrs, types.FieldError{Field: name, Rule: "date", Param: "2006-01-02", Message: "must be a date such as 2024-01-02"})
+				continue
+			}
+			field.Set(reflect.ValueOf(&date))
+		}
+	}
+
+	if err := binding.Validator.ValidateStruct(row); err != nil {
+		fieldErrs = append(fieldErrs, fieldErrors(err)...)
+	}
+	return row, fieldErrs
+}
+
+func parseImportDate(value string) (time.Time, bool) {
+	for _, layout := range importDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// importUsers get the record to create when there is no existing users, otherwise the columns of the
+// row that differ from it, nil when there are none
+func importUsers(row *types.ImportUsersRow, existing *model.Users) (*model.Users, []types.FieldError) {
+	users := &model.Users{}
+	if err := copier.Copy(users, row); err != nil {
+		return nil, []types.FieldError{{Rule: "copy", Message: err.Error()}}
+	}
+
+	if existing == nil {
+		if row.Email == "" {
+			return nil, []types.FieldError{{Field: "email", Rule: "required", Message: "is required to create a users"}}
+		}
+		now := time.Now()
+		users.ConfirmedAt = &now
+		return users, nil
+	}
+
+	// the email is changed through its confirmation, and when it is the key it was matched case-insensitively
+	if strings.EqualFold(users.Email, existing.Email) {
+		users.Email = existing.Email
+	} else if users.Email != "" {
+		return nil, []types.FieldError{{Field: "email", Rule: "immutable",
+			Message: "differs from the email of the users, which is changed through its confirmation"}}
+	}
+
+	// keep the columns that change, the cells left empty were not copied
+	update := &model.Users{}
+	changed := false
+	from, to, current := reflect.ValueOf(users).Elem(), reflect.ValueOf(update).Elem(), reflect.ValueOf(existing).Elem()
+	for i := 0; i < from.NumField(); i++ {
+		value := from.Field(i)
+		if from.Type().Field(i).Anonymous || value.IsZero() || value.Kind() == reflect.String && value.String() == current.Field(i).String() {
+			continue
+		}
+		if t, ok := value.Interface().(*time.Time); ok {
+			if c, _ := current.Field(i).Interface().(*time.Time); c != nil && c.Equal(*t) {
+				continue
+			}
+		}
+		to.Field(i).Set(value)
+		changed = true
+	}
+	if !changed {
+		return nil, nil
+	}
+	update.ID = existing.ID
+	return update, nil
+}
+
+// importDBError the field error of a row refused by the database, false for the other errors
+func importDBError(err error) (types.FieldError, bool) {
+	var dbErr *database.Error
+	if !errors.As(err, &dbErr) {
+		return types.FieldError{}, false
+	}
+	field := dbErr.Column
+	for name, column := range usersFieldColumns {
+		if column == dbErr.Column {
+			field = name
+		}
+	}
+	switch dbErr.Kind {
+	case database.ErrDuplicateKey:
+		return types.FieldError{Field: field, Rule: "unique", Message: "is already taken by another users"}, true
+	case database.ErrDataTooLong:
+		return types.FieldError{Field: field, Rule: "max", Message: "is too long for its column"}, true
+	case database.ErrForeignKey:
+		return types.FieldError{Field: field, Rule: "exists", Message: "refers to a record that does not exist"}, true
+	}
+	return types.FieldError{}, false
+}
+
+// readUploadedSheet read the rows of an uploaded csv or xlsx file
+func readUploadedSheet(file *multipart.FileHeader) ([][]string, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint
+	return sheet.Read(file.Filename, f)
+}
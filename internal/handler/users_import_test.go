@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/go-dev-frame/sponge/pkg/gotest"
+
+	"test-user-server/internal/dao"
+	"test-user-server/internal/ecode"
+	"test-user-server/internal/model"
+	"test-user-server/internal/types"
+)
+
+func newUsersImporter() (*UsersImporter, *gotest.Dao) {
+	testData := &model.Users{}
+	testData.ID = 1
+	d := gotest.NewDao(nil, testData)
+	return &UsersImporter{iDao: dao.NewUsersDao(d.DB, nil), db: d.DB}, d
+}
+
+func expectClerkCode(d *gotest.Dao, clerkCode string, rows *sqlmock.Rows) {
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE clerk_code = \\?").
+		WithArgs(clerkCode, 1).
+		WillReturnRows(rows)
+}
+
+func Test_UsersImporter_Import(t *testing.T) {
+	im, d := newUsersImporter()
+	defer d.Close()
+	columns := []string{"id", "email", "clerk_code", "chinese_name"}
+	sheetRows := [][]string{
+		{"工号", "姓名", "email", "手机", "入职日期", ""},
+		{"A001", "张三", "zhangsan@example.com", "13800000000", "2024/1/2", "ignored"},
+		{"A002", "李四", "lisi@example.com", "123"},
+		{"a001", "张三"},
+		{"", " "},
+		{"A003", "王五"},
+		{"A004", "赵六 "},
+		{"A005", "", "other@example.com"},
+		{"A006", "孙七", "sunqi@example.com"},
+	}
+
+	// dry run
+	expectClerkCode(d, "A001", sqlmock.NewRows(columns))
+	expectClerkCode(d, "A003", sqlmock.NewRows(columns).AddRow(3, "wangwu@example.com", "A003", "王五"))
+	expectClerkCode(d, "A004", sqlmock.NewRows(columns).AddRow(4, "zhaoliu@example.com", "A004", "赵四"))
+	expectClerkCode(d, "A005", sqlmock.NewRows(columns).AddRow(5, "A005@example.com", "A005", ""))
+	expectClerkCode(d, "A006", sqlmock.NewRows(columns))
+	report, fieldErrs, err := im.Import(d.Ctx, sheetRows, "clerk_code", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, fieldErrs)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []int{2, 1, 1, 3}, []int{report.Created, report.Updated, report.Unchanged, report.Failed})
+	actions := map[int]string{}
+	for _, row := range report.Rows {
+		actions[row.Row] = row.Action
+	}
+	assert.Equal(t, map[int]string{2: "created", 3: "failed", 4: "failed", 6: "unchanged", 7: "updated", 8: "failed", 9: "created"}, actions)
+	assert.Equal(t, "mobile", report.Rows[1].Errors[0].Field)
+	assert.Equal(t, "unique", report.Rows[2].Errors[0].Rule)
+	assert.Equal(t, uint64(3), report.Rows[3].ID)
+	assert.Equal(t, "immutable", report.Rows[5].Errors[0].Rule)
+
+	// write, the first insert fails a unique index and is rolled back to its savepoint alone, the others are audited
+	expectClerkCode(d, "A001", sqlmock.NewRows(columns))
+	expectClerkCode(d, "A003", sqlmock.NewRows(columns).AddRow(3, "wangwu@example.com", "A003", "王五"))
+	expectClerkCode(d, "A004", sqlmock.NewRows(columns).AddRow(4, "zhaoliu@example.com", "A004", "赵四"))
+	expectClerkCode(d, "A005", sqlmock.NewRows(columns).AddRow(5, "A005@example.com", "A005", ""))
+	expectClerkCode(d, "A006", sqlmock.NewRows(columns))
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectExec("SAVEPOINT .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	d.SQLMock.ExpectExec("INSERT INTO `users`").
+		WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'zhangsan@example.com' for key 'users.index_users_on_email'"})
+	d.SQLMock.ExpectExec("ROLLBACK TO SAVEPOINT .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	d.SQLMock.ExpectExec("SAVEPOINT .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	d.SQLMock.ExpectQuery("SELECT .* FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(4, "zhaoliu@example.com", "A004", "赵四"))
+	d.SQLMock.ExpectExec("UPDATE `users` SET .*`chinese_name`=\\?").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	d.SQLMock.ExpectQuery("SELECT .*").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(4, "zhaoliu@example.com", "A004", "赵六"))
+	d.SQLMock.ExpectExec("INSERT INTO `users_audit`").WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersVersion(d, 4, model.UsersAuditUpdate)
+	expectUsersEvent(d, 4, model.UsersEventUpdated)
+	d.SQLMock.ExpectExec("SAVEPOINT .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	d.SQLMock.ExpectExec("INSERT INTO `users`").WillReturnResult(sqlmock.NewResult(9, 1))
+	d.SQLMock.ExpectExec("INSERT INTO `users_audit`").WillReturnResult(sqlmock.NewResult(2, 1))
+	expectUsersVersion(d, 9, model.UsersAuditCreate)
+	expectUsersEvent(d, 9, model.UsersEventCreated)
+	d.SQLMock.ExpectCommit()
+	report, fieldErrs, err = im.Import(d.Ctx, sheetRows, "clerk_code", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, fieldErrs)
+	assert.Equal(t, []int{1, 1, 1, 4}, []int{report.Created, report.Updated, report.Unchanged, report.Failed})
+	assert.Equal(t, []types.FieldError{{Field: "email", Rule: "unique", Message: "is already taken by another users"}}, report.Rows[0].Errors)
+	assert.Equal(t, uint64(4), report.Rows[4].ID)
+	assert.Equal(t, "created", report.Rows[6].Action)
+	assert.Equal(t, uint64(9), report.Rows[6].ID)
+	assert.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// errors of the sheet
+	for _, tt := range []struct {
+		rows [][]string
+		key  string
+	}{
+		{[][]string{{"工号", "部门"}}, "clerk_code"},
+		{[][]string{{"工号", "clerk_code"}}, "clerk_code"},
+		{[][]string{{"姓名"}}, "clerk_code"},
+		{[][]string{{"email"}}, "mobile"},
+		{nil, "email"},
+		{make([][]string, UsersImportMaxRows+2), "email"},
+	} {
+		report, fieldErrs, err = im.Import(d.Ctx, tt.rows, tt.key, false)
+		assert.NoError(t, err)
+		assert.Nil(t, report)
+		assert.Len(t, fieldErrs, 1)
+	}
+}
+
+func Test_usersHandler_Import(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+
+	upload := func(filename string, content string, fields map[string]string) *types.ImportUserssReply {
+		body := &bytes.Buffer{}
+		w := multipart.NewWriter(body)
+		part, _ := w.CreateFormFile("file", filename)
+		_, _ = part.Write([]byte(content))
+		for k, v := range fields {
+			_ = w.WriteField(k, v)
+		}
+		_ = w.Close()
+		resp, err := http.Post(h.GetRequestURL("Import"), w.FormDataContentType(), body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close() //nolint
+		reply := &types.ImportUserssReply{}
+		if err = json.NewDecoder(resp.Body).Decode(reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE email = \\?").
+		WithArgs("zhangsan@example.com", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	reply := upload("userss.csv", "邮箱,姓名\nzhangsan@example.com,张三\n", map[string]string{"key": "email", "dryRun": "true"})
+	assert.Equal(t, 0, reply.Code)
+	assert.True(t, reply.Data.DryRun)
+	assert.Equal(t, 1, reply.Data.Created)
+	assert.Equal(t, "created", reply.Data.Rows[0].Action)
+
+	// the sheet is refused
+	reply = upload("userss.csv", "工号,部门\n", nil)
+	assert.Equal(t, ecode.InvalidParams.Code(), reply.Code)
+	reply = upload("userss.txt", "工号\n", nil)
+	assert.Equal(t, ecode.InvalidParams.Code(), reply.Code)
+}
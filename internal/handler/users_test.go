@@ -1,22 +1,37 @@
 package handler
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/go-dev-frame/sponge/pkg/copier"
 	"github.com/go-dev-frame/sponge/pkg/gotest"
 	"github.com/go-dev-frame/sponge/pkg/httpcli"
+	"github.com/go-dev-frame/sponge/pkg/jwt"
 	"github.com/go-dev-frame/sponge/pkg/sgorm/query"
 	"github.com/go-dev-frame/sponge/pkg/utils"
 
 	"test-user-server/internal/cache"
 	"test-user-server/internal/dao"
 	"test-user-server/internal/database"
+	"test-user-server/internal/devise"
+	"test-user-server/internal/ecode"
+	"test-user-server/internal/mailer"
 	"test-user-server/internal/model"
 	"test-user-server/internal/types"
 )
@@ -41,7 +56,12 @@ func newUsersHandler() *gotest.Handler {
 
 	// init mock handler
 	h := gotest.NewHandler(d, testData)
-	h.IHandler = &usersHandler{iDao: d.IDao.(dao.UsersDao)}
+	h.IHandler = &usersHandler{
+		iDao:            d.IDao.(dao.UsersDao),
+		importer:        &UsersImporter{iDao: d.IDao.(dao.UsersDao), db: d.DB},
+		mailer:          mailer.NewFileMailer(testMailDir),
+		confirmationURL: "http://localhost:3000/users/confirmation",
+	}
 	iHandler := h.IHandler.(UsersHandler)
 
 	testFns := []gotest.RouterInfo{
@@ -63,12 +83,126 @@ func newUsersHandler() *gotest.Handler {
 			Path:        "/users/:id",
 			HandlerFunc: iHandler.UpdateByID,
 		},
+		{
+			FuncName:    "PatchByID",
+			Method:      http.MethodPatch,
+			Path:        "/users/:id",
+			HandlerFunc: iHandler.PatchByID,
+		},
 		{
 			FuncName:    "GetByID",
 			Method:      http.MethodGet,
 			Path:        "/users/:id",
 			HandlerFunc: iHandler.GetByID,
 		},
+		{
+			FuncName:    "Search",
+			Method:      http.MethodGet,
+			Path:        "/users/search",
+			HandlerFunc: iHandler.Search,
+		},
+		{
+			FuncName:    "Import",
+			Method:      http.MethodPost,
+			Path:        "/users/import",
+			HandlerFunc: iHandler.Import,
+		},
+		{
+			FuncName:    "Export",
+			Method:      http.MethodGet,
+			Path:        "/users/export",
+			HandlerFunc: iHandler.Export,
+		},
+		{
+			FuncName:    "ListTrash",
+			Method:      http.MethodGet,
+			Path:        "/users/trash",
+			HandlerFunc: iHandler.ListTrash,
+		},
+		{
+			FuncName:    "Restore",
+			Method:      http.MethodPost,
+			Path:        "/users/:id/restore",
+			HandlerFunc: iHandler.Restore,
+		},
+		{
+			FuncName:    "History",
+			Method:      http.MethodGet,
+			Path:        "/users/:id/history",
+			HandlerFunc: iHandler.History,
+		},
+		{
+			FuncName:    "ListVersions",
+			Method:      http.MethodGet,
+			Path:        "/users/:id/versions",
+			HandlerFunc: iHandler.ListVersions,
+		},
+		{
+			FuncName:    "DiffVersions",
+			Method:      http.MethodGet,
+			Path:        "/users/:id/versions/:version/diff",
+			HandlerFunc: iHandler.DiffVersions,
+		},
+		{
+			FuncName:    "Revert",
+			Method:      http.MethodPost,
+			Path:        "/users/:id/versions/:version/revert",
+			HandlerFunc: iHandler.Revert,
+		},
+		{
+			FuncName:    "ReplayEvents",
+			Method:      http.MethodPost,
+			Path:        "/users/events/replay",
+			HandlerFunc: iHandler.ReplayEvents,
+		},
+		{
+			FuncName:    "CreateWebhook",
+			Method:      http.MethodPost,
+			Path:        "/users/webhooks",
+			HandlerFunc: iHandler.CreateWebhook,
+		},
+		{
+			FuncName:    "ListWebhooks",
+			Method:      http.MethodGet,
+			Path:        "/users/webhooks",
+			HandlerFunc: iHandler.ListWebhooks,
+		},
+		{
+			FuncName:    "GetWebhook",
+			Method:      http.MethodGet,
+			Path:        "/users/webhooks/:id",
+			HandlerFunc: iHandler.GetWebhook,
+		},
+		{
+			FuncName:    "UpdateWebhook",
+			Method:      http.MethodPut,
+			Path:        "/users/webhooks/:id",
+			HandlerFunc: iHandler.UpdateWebhook,
+		},
+		{
+			FuncName:    "DeleteWebhook",
+			Method:      http.MethodDelete,
+			Path:        "/users/webhooks/:id",
+			HandlerFunc: iHandler.DeleteWebhook,
+		},
+		{
+			FuncName:    "ListWebhookDeliveries",
+			Method:      http.MethodGet,
+			Path:        "/users/webhooks/:id/deliveries",
+			HandlerFunc: iHandler.ListWebhookDeliveries,
+		},
+		{
+			FuncName:    "RedeliverWebhook",
+			Method:      http.MethodPost,
+			Path:        "/users/webhooks/:id/deliveries/:deliveryID/redeliver",
+			HandlerFunc: iHandler.RedeliverWebhook,
+		},
+		{
+			FuncName:    "GetByKey",
+			Method:      http.MethodGet,
+			Path:        "/users/by/:key/:value",
+			HandlerFunc: iHandler.GetByKey,
+		},
 		{
 			FuncName:    "List",
 			Method:      http.MethodPost,
@@ -99,6 +233,30 @@ func newUsersHandler() *gotest.Handler {
 			Path:        "/users/list",
 			HandlerFunc: iHandler.ListByLastID,
 		},
+		{
+			FuncName:    "ChangePassword",
+			Method:      http.MethodPost,
+			Path:        "/users/:id/password",
+			HandlerFunc: iHandler.ChangePassword,
+		},
+		{
+			FuncName:    "GetMe",
+			Method:      http.MethodGet,
+			Path:        "/users/me",
+			HandlerFunc: railsSignedIn(testData.ID, iHandler.GetMe),
+		},
+		{
+			FuncName:    "UpdateMe",
+			Method:      http.MethodPut,
+			Path:        "/users/me",
+			HandlerFunc: railsSignedIn(testData.ID, iHandler.UpdateMe),
+		},
+		{
+			FuncName:    "GetMeAnonymous",
+			Method:      http.MethodGet,
+			Path:        "/anonymous/me",
+			HandlerFunc: iHandler.GetMe,
+		},
 	}
 
 	h.GoRunHTTPServer(testFns)
@@ -107,17 +265,90 @@ func newUsersHandler() *gotest.Handler {
 	return h
 }
 
+// railsSignedIn the caller is the warden user of a rails session
+func railsSignedIn(id uint64, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("rails_session", map[string]any{"warden.user.user.key": []any{[]any{float64(id)}, "salt"}})
+		next(c)
+	}
+}
+
+// expectUsersLocked the read of an audited write before it, rows are the records found
+func expectUsersLocked(h *gotest.Handler, rows *sqlmock.Rows, ids ...driver.Value) {
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE id IN \\(.*\\) FOR UPDATE").
+		WithArgs(ids...).
+		WillReturnRows(rows)
+}
+
+// expectUsersLockedRead the read of the row locked for a conditional update
+func expectUsersLockedRead(h *gotest.Handler, rows *sqlmock.Rows, id uint64) {
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE .*deactivated_at IS NULL.* FOR UPDATE").
+		WithArgs(id, 1).
+		WillReturnRows(rows)
+}
+
+// expectUsersReread the read of an audited write after it
+func expectUsersReread(h *gotest.Handler, rows *sqlmock.Rows, ids ...driver.Value) {
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE id IN \\(.*\\)$").
+		WithArgs(ids...).
+		WillReturnRows(rows)
+}
+
+// expectUsersWritten the lock of a users before an audited write of its columns
+func expectUsersWritten(h *gotest.Handler, id uint64) {
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id"}).AddRow(id), id)
+}
+
+// expectUsersRecorded the reread of a users after an audited write changed column to value, and the
+// update recorded
+func expectUsersRecorded(h *gotest.Handler, id uint64, column string, value driver.Value) {
+	expectUsersReread(h, sqlmock.NewRows([]string{"id", column}).AddRow(id, value), id)
+	expectUsersAudit(h, id, model.UsersAuditUpdate)
+}
+
+// expectUsersAudit the insert of the audit row of a users
+func expectUsersAudit(h *gotest.Handler, id uint64, action string) {
+	h.MockDao.SQLMock.ExpectExec("INSERT INTO `users_audit` .*").
+		WithArgs(id, action, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), h.MockDao.AnyTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersVersion(h.MockDao, id, action)
+	expectUsersEvent(h.MockDao, id, model.UsersEventTypes[action])
+}
+
+// expectUsersEvent the insert of the outbox event of a users, no webhook is subscribed
+func expectUsersEvent(d *gotest.Dao, id uint64, event string) {
+	d.SQLMock.ExpectExec("INSERT INTO `users_outbox` .*").
+		WithArgs(id, event, sqlmock.AnyArg(), d.AnyTime, nil, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook` .*").
+		WithArgs(true).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+}
+
+// expectUsersVersion the insert of the first version of a users
+func expectUsersVersion(d *gotest.Dao, id uint64, action string) {
+	d.SQLMock.ExpectQuery("SELECT users_id, MAX\\(version\\).*").
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"users_id", "version"}))
+	d.SQLMock.ExpectExec("INSERT INTO `users_version` .*").
+		WithArgs(id, 1, action, sqlmock.AnyArg(), d.AnyTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
 func Test_usersHandler_Create(t *testing.T) {
 	h := newUsersHandler()
 	defer h.Close()
 	testData := &types.CreateUsersRequest{}
 	_ = copier.Copy(testData, h.TestData.(*model.Users))
+	testData.Email = "foo@bar.com"
+	testData.Password = "123456"
 
 	h.MockDao.SQLMock.ExpectBegin()
 	args := h.MockDao.GetAnyArgs(h.TestData)
 	h.MockDao.SQLMock.ExpectExec("INSERT INTO .*").
 		WithArgs(args[:len(args)-1]...). // adjusted for the amount of test data
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUsersAudit(h, 1, model.UsersAuditCreate)
 	h.MockDao.SQLMock.ExpectCommit()
 
 	result := &httpcli.StdResult{}
@@ -128,19 +359,243 @@ func Test_usersHandler_Create(t *testing.T) {
 
 	t.Logf("%+v", result)
 
+	// duplicate email, 409 naming the column
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectExec("INSERT INTO .*").
+		WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'foo@bar.com' for key 'users.index_users_on_email'"})
+	h.MockDao.SQLMock.ExpectRollback()
+	resp := postJSON(t, h.GetRequestURL("Create"), testData)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, ecode.ErrDuplicateKey.Code(), resp.Code)
+	assert.Equal(t, map[string]interface{}{"column": "email"}, resp.Data)
+
+	// too long for the column, 400
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectExec("INSERT INTO .*").
+		WillReturnError(&mysql.MySQLError{Number: 1406, Message: "Data too long for column 'mobile' at row 1"})
+	h.MockDao.SQLMock.ExpectRollback()
+	resp = postJSON(t, h.GetRequestURL("Create"), testData)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, ecode.ErrDataTooLong.Code(), resp.Code)
+	assert.Equal(t, map[string]interface{}{"column": "mobile"}, resp.Data)
+}
+
+func Test_usersHandler_Create_validation(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+
+	// every failed field is listed with its rule
+	resp := postJSON(t, h.GetRequestURL("Create"), map[string]interface{}{
+		"email":     "not-an-email",
+		"password":  "123",
+		"mobile":    "12345",
+		"perPage":   500,
+		"clerkCode": strings.Repeat("x", 256),
+	})
+	assert.Equal(t, ecode.InvalidParams.Code(), resp.Code)
+	data, _ := json.Marshal(resp.Data)
+	reply := &types.InvalidParamsReply{}
+	_ = json.Unmarshal(data, &reply.Data)
+	assert.Equal(t, []types.FieldError{
+		{Field: "email", Rule: "email", Message: "must be a valid email address"},
+		{Field: "password", Rule: "min", Param: "6", Message: "must be at least 6 characters"},
+		{Field: "clerkCode", Rule: "max", Param: "255", Message: "must be at most 255 characters"},
+		{Field: "mobile", Rule: "mobile", Message: "must be a valid mobile number"},
+		{Field: "perPage", Rule: "max", Param: "100", Message: "must be at most 100"},
+	}, reply.Data.Errors)
+
+	// gender only takes true or false
+	resp = postJSON(t, h.GetRequestURL("Create"), map[string]interface{}{
+		"email":    "foo@bar.com",
+		"password": "123456",
+		"gender":   2,
+	})
+	assert.Equal(t, ecode.InvalidParams.Code(), resp.Code)
+	assert.Equal(t, map[string]interface{}{"errors": []interface{}{map[string]interface{}{
+		"field": "gender", "rule": "type", "param": "a boolean", "message": "must be a boolean",
+	}}}, resp.Data)
+
+	// a mainland china mobile number passes, with or without +86
+	assert.True(t, mobileRe.MatchString("13812345678"))
+	assert.True(t, mobileRe.MatchString("+8613812345678"))
+	assert.False(t, mobileRe.MatchString("1381234567"))
+}
+
+type statusResult struct {
+	httpcli.StdResult
+	StatusCode int
+}
+
+// postJSON like httpcli.Post but the status code and body of an error response are kept
+func postJSON(t *testing.T, url string, body interface{}) *statusResult {
+	data, _ := json.Marshal(body)
+	resp, err := http.Post(url, "application/json", strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	result := &statusResult{StatusCode: resp.StatusCode}
+	_ = json.NewDecoder(resp.Body).Decode(&result.StdResult)
+	return result
+}
+
+func Test_usersHandler_UpdateByID_reconfirmable(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	defer os.RemoveAll(testMailDir)
+	testData := h.TestData.(*model.Users)
+	devise.Init()
+	defer devise.Init()
+
+	// the new email waits in unconfirmed_email, email is left unchanged
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(testData.ID, "old@bar.com"))
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id", "email"}).AddRow(testData.ID, "old@bar.com"), testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "new@bar.com", h.MockDao.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id", "email", "unconfirmed_email"}).AddRow(testData.ID, "old@bar.com", "new@bar.com"), testData.ID)
+	expectUsersAudit(h, testData.ID, model.UsersAuditUpdate)
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Put(result, h.GetRequestURL("UpdateByID", testData.ID), &types.UpdateUsersByIDRequest{Email: "new@bar.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+	assert.NotEmpty(t, lastMailToken(t, "confirmation_token"))
+
+	// a stale etag is refused before the email change is postponed, no instructions are mailed
+	_ = os.RemoveAll(testMailDir)
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id", "email"}).AddRow(testData.ID, "old@bar.com"), testData.ID)
+	expectUsersLockedRead(h, sqlmock.NewRows([]string{"id", "email"}).AddRow(testData.ID, "old@bar.com"), testData.ID)
+	h.MockDao.SQLMock.ExpectRollback()
+	req, _ := http.NewRequest(http.MethodPut, h.GetRequestURL("UpdateByID", testData.ID), strings.NewReader(`{"email":"new@bar.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", `"1-0"`)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+	entries, _ := os.ReadDir(testMailDir)
+	assert.Empty(t, entries)
+}
+
+func Test_usersHandler_UpdateByID_ifMatch(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+	updatedAt := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	current := &model.Users{}
+	current.ID, current.UpdatedAt = testData.ID, updatedAt
+
+	put := func(ifMatch string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPut, h.GetRequestURL("UpdateByID", testData.ID), strings.NewReader(`{"mobile":"13812345678"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", ifMatch)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// stale etag, the update is refused with the row locked for it and its etag
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(testData.ID, updatedAt), testData.ID)
+	expectUsersLockedRead(h, sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(testData.ID, updatedAt), testData.ID)
+	h.MockDao.SQLMock.ExpectRollback()
+	resp := put(`"1-0"`)
+	body := map[string]interface{}{}
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.Equal(t, usersETag(current), resp.Header.Get("ETag"))
+	assert.Contains(t, body["data"], "users")
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// current etag
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(testData.ID, updatedAt), testData.ID)
+	expectUsersLockedRead(h, sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(testData.ID, updatedAt), testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE `users` SET `mobile`=\\?,`updated_at`=\\? WHERE deactivated_at IS NULL AND `id` = \\?").
+		WithArgs("13812345678", h.MockDao.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id", "mobile"}).AddRow(testData.ID, "13812345678"), testData.ID)
+	expectUsersAudit(h, testData.ID, model.UsersAuditUpdate)
+	h.MockDao.SQLMock.ExpectCommit()
+	resp = put(usersETag(current))
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// the rails app changed the row within the same second, the etag still differs
+	changed := &model.Users{}
+	changed.ID, changed.UpdatedAt, changed.Mobile = testData.ID, updatedAt.Add(time.Millisecond), "13900000000"
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id", "updated_at", "mobile"}).AddRow(testData.ID, changed.UpdatedAt, changed.Mobile), testData.ID)
+	expectUsersLockedRead(h, sqlmock.NewRows([]string{"id", "updated_at", "mobile"}).AddRow(testData.ID, changed.UpdatedAt, changed.Mobile), testData.ID)
+	h.MockDao.SQLMock.ExpectRollback()
+	resp = put(`W/` + usersETag(current))
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.Equal(t, usersETag(changed), resp.Header.Get("ETag"))
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// deleted since
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id"}), testData.ID)
+	expectUsersLockedRead(h, sqlmock.NewRows([]string{"id"}), testData.ID)
+	h.MockDao.SQLMock.ExpectRollback()
+	result := &httpcli.StdResult{}
+	err := httpcli.Put(result, h.GetRequestURL("UpdateByID", testData.ID), &types.UpdateUsersByIDRequest{Mobile: "13812345678"},
+		httpcli.WithHeaders(map[string]string{"If-Match": usersETag(current)}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
+}
+
+func Test_usersETag(t *testing.T) {
+	users := &model.Users{Mobile: "13812345678", EncryptedPassword: "$2a$12$secret"}
+	users.ID, users.UpdatedAt = 1, time.Date(2024, 5, 1, 8, 30, 0, 123456789, time.UTC)
+	etag := usersETag(users)
+	assert.Equal(t, `"1-1714552200123456"`, etag)
+
+	// the time zone of the connection and the nanoseconds mysql does not keep do not matter
+	read := *users
+	read.UpdatedAt = users.UpdatedAt.Truncate(time.Microsecond).In(time.FixedZone("CST", 8*3600))
+	assert.Equal(t, etag, usersETag(&read))
+
+	// a write changes it, the columns that do not touch updated_at do not
+	read.UpdatedAt = users.UpdatedAt.Add(time.Millisecond)
+	assert.NotEqual(t, etag, usersETag(&read))
+	read = *users
+	read.EncryptedPassword, read.FailedAttempts = "$2a$12$other", 3
+	assert.Equal(t, etag, usersETag(&read))
 }
 
 func Test_usersHandler_DeleteByID(t *testing.T) {
 	h := newUsersHandler()
 	defer h.Close()
 	testData := h.TestData.(*model.Users)
-	expectedSQLForDeletion := "UPDATE .*"
-	expectedArgsForDeletionTime := h.MockDao.AnyTime
+	expectedSQLForDeletion := "UPDATE `users` SET `deactivated_at`.*"
 
 	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
 	h.MockDao.SQLMock.ExpectExec(expectedSQLForDeletion).
-		WithArgs(expectedArgsForDeletionTime, testData.ID). // adjusted for the amount of test data
+		WithArgs(h.MockDao.AnyTime, h.MockDao.AnyTime, testData.ID). // adjusted for the amount of test data
 		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id", "deactivated_at"}).AddRow(testData.ID, time.Now()), testData.ID)
+	expectUsersAudit(h, testData.ID, model.UsersAuditDelete)
 	h.MockDao.SQLMock.ExpectCommit()
 
 	result := &httpcli.StdResult{}
@@ -156,6 +611,17 @@ func Test_usersHandler_DeleteByID(t *testing.T) {
 	err = httpcli.Delete(result, h.GetRequestURL("DeleteByID", 0))
 	assert.NoError(t, err)
 
+	// not found test
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id"}), uint64(222))
+	h.MockDao.SQLMock.ExpectExec(expectedSQLForDeletion).
+		WithArgs(h.MockDao.AnyTime, h.MockDao.AnyTime, uint64(222)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	h.MockDao.SQLMock.ExpectRollback()
+	err = httpcli.Delete(result, h.GetRequestURL("DeleteByID", 222))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
+
 	// delete error test
 	err = httpcli.Delete(result, h.GetRequestURL("DeleteByID", 111))
 	assert.Error(t, err)
@@ -168,9 +634,11 @@ func Test_usersHandler_UpdateByID(t *testing.T) {
 	_ = copier.Copy(testData, h.TestData.(*model.Users))
 
 	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
 	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
 		WithArgs(h.MockDao.AnyTime, testData.ID). // adjusted for the amount of test data
 		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
 	h.MockDao.SQLMock.ExpectCommit()
 
 	result := &httpcli.StdResult{}
@@ -186,11 +654,91 @@ func Test_usersHandler_UpdateByID(t *testing.T) {
 	err = httpcli.Put(result, h.GetRequestURL("UpdateByID", 0), testData)
 	assert.NoError(t, err)
 
+	// not found test
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id"}), uint64(222))
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(h.MockDao.AnyTime, uint64(222)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(uint64(222), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "updated_at"}))
+	h.MockDao.SQLMock.ExpectRollback()
+	err = httpcli.Put(result, h.GetRequestURL("UpdateByID", 222), &types.UpdateUsersByIDRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
+
 	// update error test
 	err = httpcli.Put(result, h.GetRequestURL("UpdateByID", 111), testData)
 	assert.Error(t, err)
 }
 
+func Test_usersHandler_PatchByID(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+
+	patch := func(contentType string, body string) *statusResult {
+		req, _ := http.NewRequest(http.MethodPatch, h.GetRequestURL("PatchByID", testData.ID), strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		result := &statusResult{StatusCode: resp.StatusCode}
+		_ = json.NewDecoder(resp.Body).Decode(&result.StdResult)
+		return result
+	}
+
+	// null clears, zero is written, absent members are left alone
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id", "failed_attempts", "mobile"}).AddRow(testData.ID, 5, "13812345678"), testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE `users` SET `failed_attempts`=\\?,`locked_at`=\\?,`mobile`=\\?,`open_in_new_tab`=\\?,`updated_at`=\\? WHERE id = \\?").
+		WithArgs(0, nil, nil, false, h.MockDao.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id", "failed_attempts", "mobile"}).AddRow(testData.ID, 0, ""), testData.ID)
+	expectUsersAudit(h, testData.ID, model.UsersAuditUpdate)
+	h.MockDao.SQLMock.ExpectCommit()
+	resp := patch(mergePatchContentType, `{"mobile":null,"failedAttempts":0,"lockedAt":null,"openInNewTab":false}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0, resp.Code, resp.Msg)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// unknown and denied columns are rejected
+	resp = patch(mergePatchContentType, `{"isAdmin":true,"email":"x@y.com","mobile":"13812345678"}`)
+	assert.Equal(t, ecode.InvalidParams.Code(), resp.Code)
+	assert.Equal(t, map[string]interface{}{"errors": []interface{}{
+		map[string]interface{}{"field": "email", "rule": "column", "param": "", "message": "is not a patchable column"},
+		map[string]interface{}{"field": "isAdmin", "rule": "column", "param": "", "message": "is not a patchable column"},
+	}}, resp.Data)
+
+	// the devise tokens are only written as digests by their own flows
+	resp = patch(mergePatchContentType, `{"resetPasswordToken":"raw","invitationToken":"raw"}`)
+	assert.Equal(t, ecode.InvalidParams.Code(), resp.Code)
+	assert.Equal(t, map[string]interface{}{"errors": []interface{}{
+		map[string]interface{}{"field": "invitationToken", "rule": "column", "param": "", "message": "is not a patchable column"},
+		map[string]interface{}{"field": "resetPasswordToken", "rule": "column", "param": "", "message": "is not a patchable column"},
+	}}, resp.Data)
+
+	// not null columns, the validation rules and the json types still apply
+	resp = patch(mergePatchContentType, `{"failedAttempts":null}`)
+	assert.Equal(t, ecode.InvalidParams.Code(), resp.Code)
+	assert.Contains(t, fmt.Sprint(resp.Data), "rule:nullable")
+	resp = patch(mergePatchContentType, `{"mobile":"12345"}`)
+	assert.Contains(t, fmt.Sprint(resp.Data), "rule:mobile")
+	resp = patch(mergePatchContentType, `{"gender":"male"}`)
+	assert.Contains(t, fmt.Sprint(resp.Data), "rule:type")
+	resp = patch(mergePatchContentType, `[]`)
+	assert.Equal(t, ecode.InvalidParams.Code(), resp.Code)
+	resp = patch(mergePatchContentType, `{}`)
+	assert.Equal(t, ecode.InvalidParams.Code(), resp.Code)
+
+	// only merge patch documents are accepted
+	resp = patch("application/json", `{"mobile":null}`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+}
+
 func Test_usersHandler_GetByID(t *testing.T) {
 	h := newUsersHandler()
 	defer h.Close()
@@ -222,103 +770,299 @@ func Test_usersHandler_GetByID(t *testing.T) {
 	assert.Error(t, err)
 }
 
-func Test_usersHandler_List(t *testing.T) {
+func Test_usersHandler_GetByID_asOf(t *testing.T) {
 	h := newUsersHandler()
 	defer h.Close()
 	testData := h.TestData.(*model.Users)
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
 
-	// column names and corresponding data
-	rows := sqlmock.NewRows([]string{"id"}).
-		AddRow(testData.ID)
-
-	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnRows(rows)
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` WHERE users_id = \\? AND created_at <= \\?").
+		WithArgs(testData.ID, at, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "users_id", "version", "action", "snapshot", "created_at"}).
+			AddRow(4, testData.ID, 2, model.UsersAuditUpdate, `{"id":1,"email":"foo@bar.com","positionTitle":"Engineer"}`, at.Add(-time.Hour)))
 
 	result := &httpcli.StdResult{}
-	err := httpcli.Post(result, h.GetRequestURL("List"), &types.ListUserssRequest{query.Params{
-		Page:  0,
-		Limit: 10,
-		Sort:  "ignore count", // ignore test count
-	}})
+	err := httpcli.Get(result, h.GetRequestURL("GetByID", testData.ID),
+		httpcli.WithParams(map[string]interface{}{"asOf": "2026-01-01T00:00:00Z", "fields": "positionTitle"}))
 	if err != nil {
 		t.Fatal(err)
 	}
 	if result.Code != 0 {
 		t.Fatalf("%+v", result)
 	}
+	users := result.Data.(map[string]interface{})["users"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"id": float64(1), "positionTitle": "Engineer"}, users)
 
-	// nil params error test
-	err = httpcli.Post(result, h.GetRequestURL("List"), nil)
+	// did not exist then
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users` .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	err = httpcli.Get(result, h.GetRequestURL("GetByID", testData.ID),
+		httpcli.WithParams(map[string]interface{}{"asOf": "2020-01-01T00:00:00+08:00"}))
 	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
 
-	// get error test
-	err = httpcli.Post(result, h.GetRequestURL("List"), &types.ListUserssRequest{query.Params{
-		Page:  0,
-		Limit: 10,
-		Sort:  "unknown-column",
-	}})
+	// written since without a version until then
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users` .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(testData.ID, at.AddDate(-1, 0, 0), at.Add(time.Hour)))
+	err = httpcli.Get(result, h.GetRequestURL("GetByID", testData.ID),
+		httpcli.WithParams(map[string]interface{}{"asOf": "2026-01-01T00:00:00Z"}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrHistoryUnavailableUsers.Code(), result.Code)
+
+	// not a RFC3339 time
+	err = httpcli.Get(result, h.GetRequestURL("GetByID", testData.ID),
+		httpcli.WithParams(map[string]interface{}{"asOf": "2026-01-01"}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+	fieldErr := result.Data.(map[string]interface{})["errors"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "asOf", fieldErr["field"])
+	assert.Equal(t, "datetime", fieldErr["rule"])
+
+	// error test
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	err = httpcli.Get(result, h.GetRequestURL("GetByID", testData.ID),
+		httpcli.WithParams(map[string]interface{}{"asOf": "2026-01-01T00:00:00Z"}))
 	assert.Error(t, err)
 }
 
-func Test_usersHandler_DeleteByIDs(t *testing.T) {
+func Test_usersHandler_GetByID_fields(t *testing.T) {
 	h := newUsersHandler()
 	defer h.Close()
 	testData := h.TestData.(*model.Users)
 
-	h.MockDao.SQLMock.ExpectBegin()
-	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
-		WithArgs(h.MockDao.AnyTime, testData.ID). // adjusted for the amount of test data
-		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
-	h.MockDao.SQLMock.ExpectCommit()
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "chinese_name", "mobile"}).
+			AddRow(testData.ID, "foo@bar.com", "zhang", "13812345678"))
 
 	result := &httpcli.StdResult{}
-	err := httpcli.Post(result, h.GetRequestURL("DeleteByIDs"), &types.DeleteUserssByIDsRequest{IDs: []uint64{testData.ID}})
+	err := httpcli.Get(result, h.GetRequestURL("GetByID", testData.ID),
+		httpcli.WithParams(map[string]interface{}{"fields": "email,chineseName"}))
 	if err != nil {
 		t.Fatal(err)
 	}
 	if result.Code != 0 {
 		t.Fatalf("%+v", result)
 	}
+	users := result.Data.(map[string]interface{})["users"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"id": float64(testData.ID), "email": "foo@bar.com", "chineseName": "zhang"}, users)
 
-	// zero id error test
-	err = httpcli.Post(result, h.GetRequestURL("DeleteByIDs"), nil)
+	// unknown and secret fields
+	for _, fields := range []string{"email,nickname", "encryptedPassword", "email_address"} {
+		err = httpcli.Get(result, h.GetRequestURL("GetByID", testData.ID), httpcli.WithParams(map[string]interface{}{"fields": fields}))
+		assert.NoError(t, err)
+		assert.Equal(t, ecode.InvalidParams.Code(), result.Code, fields)
+	}
+}
+
+func Test_sparseFields(t *testing.T) {
+	data := []*types.UsersObjDetail{{ID: 1, Email: "foo@bar.com", ChineseName: "zhang"}}
+	got, err := sparseFields(data, []string{"chineseName"})
 	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"id": float64(1), "chineseName": "zhang"}}, got)
 
-	// get error test
-	err = httpcli.Post(result, h.GetRequestURL("DeleteByIDs"), &types.DeleteUserssByIDsRequest{IDs: []uint64{111}})
-	assert.Error(t, err)
+	got, err = sparseFields(data, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
 }
 
-func Test_usersHandler_GetByCondition(t *testing.T) {
+func Test_usersHandler_GetByID_ifNoneMatch(t *testing.T) {
 	h := newUsersHandler()
 	defer h.Close()
 	testData := h.TestData.(*model.Users)
+	updatedAt := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
 
-	// column names and corresponding data
-	rows := sqlmock.NewRows([]string{"id"}).
-		AddRow(testData.ID)
-
-	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnRows(rows)
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(testData.ID, updatedAt))
 
-	result := &httpcli.StdResult{}
-	err := httpcli.Post(result, h.GetRequestURL("GetByCondition"), &types.GetUsersByConditionRequest{
-		query.Conditions{
-			Columns: []query.Column{
-				{
-					Name:  "id",
-					Value: testData.ID,
-				},
-			},
-		},
-	})
-	if err != nil {
-		t.Fatal(err)
-	}
-	if result.Code != 0 {
-		t.Fatalf("%+v", result)
+	get := func(ifNoneMatch string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, h.GetRequestURL("GetByID", testData.ID), nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp
 	}
 
-	// zero error test
-	err = httpcli.Post(result, h.GetRequestURL("GetByCondition"), nil)
+	resp := get("")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	// the cached copy is still current
+	resp = get(etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+
+	resp = get(`"1-0", "2-0"`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func Test_matchETag(t *testing.T) {
+	assert.False(t, matchETag("", `"1-a"`))
+	assert.True(t, matchETag(`"1-a"`, `"1-a"`))
+	assert.True(t, matchETag(`W/"1-a"`, `"1-a"`))
+	assert.True(t, matchETag(`"1-b", "1-a"`, `"1-a"`))
+	assert.True(t, matchETag("*", `"1-a"`))
+	assert.False(t, matchETag(`"1-b"`, `"1-a"`))
+}
+
+func Test_usersHandler_GetByKey(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("foo@bar.com", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(testData.ID, "foo@bar.com"))
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Get(result, h.GetRequestURL("GetByKey", "email", "foo@bar.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+
+	// not found
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs("nobody", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	err = httpcli.Get(result, h.GetRequestURL("GetByKey", "windows_sid", "nobody"))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
+
+	// not a natural key
+	err = httpcli.Get(result, h.GetRequestURL("GetByKey", "mobile", "13812345678"))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+}
+
+func Test_usersHandler_List(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+
+	// column names and corresponding data
+	rows := sqlmock.NewRows([]string{"id"}).
+		AddRow(testData.ID)
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnRows(rows)
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("List"), &types.ListUserssRequest{query.Params{
+		Page:  0,
+		Limit: 10,
+		Sort:  "ignore count", // ignore test count
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+
+	// nil params error test
+	err = httpcli.Post(result, h.GetRequestURL("List"), nil)
+	assert.NoError(t, err)
+
+	// get error test
+	err = httpcli.Post(result, h.GetRequestURL("List"), &types.ListUserssRequest{query.Params{
+		Page:  0,
+		Limit: 10,
+		Sort:  "unknown-column",
+	}})
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_DeleteByIDs(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testData.ID))
+	h.MockDao.SQLMock.ExpectExec("UPDATE `users` SET `deactivated_at`.*").
+		WithArgs(h.MockDao.AnyTime, h.MockDao.AnyTime, testData.ID). // adjusted for the amount of test data
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id", "deactivated_at"}).AddRow(testData.ID, time.Now()), testData.ID)
+	expectUsersAudit(h, testData.ID, model.UsersAuditDelete)
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("DeleteByIDs"), &types.DeleteUserssByIDsRequest{IDs: []uint64{testData.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+
+	// not found test, the missing ids are returned
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID, uint64(222))
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID, uint64(222)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testData.ID))
+	h.MockDao.SQLMock.ExpectRollback()
+	err = httpcli.Post(result, h.GetRequestURL("DeleteByIDs"), &types.DeleteUserssByIDsRequest{IDs: []uint64{testData.ID, 222}})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
+	assert.Equal(t, map[string]interface{}{"notFoundIDs": []interface{}{float64(222)}}, result.Data)
+
+	// zero id error test
+	err = httpcli.Post(result, h.GetRequestURL("DeleteByIDs"), nil)
+	assert.NoError(t, err)
+
+	// get error test
+	err = httpcli.Post(result, h.GetRequestURL("DeleteByIDs"), &types.DeleteUserssByIDsRequest{IDs: []uint64{111}})
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_GetByCondition(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+
+	// column names and corresponding data
+	rows := sqlmock.NewRows([]string{"id"}).
+		AddRow(testData.ID)
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnRows(rows)
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("GetByCondition"), &types.GetUsersByConditionRequest{
+		query.Conditions{
+			Columns: []query.Column{
+				{
+					Name:  "id",
+					Value: testData.ID,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+
+	// zero error test
+	err = httpcli.Post(result, h.GetRequestURL("GetByCondition"), nil)
 	assert.NoError(t, err)
 
 	// get error test
@@ -370,27 +1114,640 @@ func Test_usersHandler_ListByLastID(t *testing.T) {
 
 	// column names and corresponding data
 	rows := sqlmock.NewRows([]string{"id"}).
-		AddRow(testData.ID)
+		AddRow(testData.ID).
+		AddRow(testData.ID + 1)
 
 	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnRows(rows)
 
 	result := &httpcli.StdResult{}
-	err := httpcli.Get(result, h.GetRequestURL("ListByLastID"), httpcli.WithParams(map[string]interface{}{"id": 10}))
+	err := httpcli.Get(result, h.GetRequestURL("ListByLastID"), httpcli.WithParams(map[string]interface{}{"limit": 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	data := result.Data.(map[string]interface{})
+	nextCursor := data["nextCursor"].(string)
+	assert.NotEmpty(t, nextCursor)
+	assert.Empty(t, data["prevCursor"])
+
+	// the page after
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
+	err = httpcli.Get(result, h.GetRequestURL("ListByLastID"), httpcli.WithParams(map[string]interface{}{"limit": 1, "cursor": nextCursor}))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Code)
+	data = result.Data.(map[string]interface{})
+	assert.Empty(t, data["nextCursor"])
+	assert.NotEmpty(t, data["prevCursor"])
+
+	// the cursor was made for another sort
+	err = httpcli.Get(result, h.GetRequestURL("ListByLastID"), httpcli.WithParams(map[string]interface{}{"cursor": nextCursor, "sort": "email"}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// tampered cursor
+	err = httpcli.Get(result, h.GetRequestURL("ListByLastID"), httpcli.WithParams(map[string]interface{}{"cursor": "e30." + nextCursor}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// not a cursor column
+	err = httpcli.Get(result, h.GetRequestURL("ListByLastID"), httpcli.WithParams(map[string]interface{}{"limit": 10, "sort": "unknown-column"}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// too many per page
+	err = httpcli.Get(result, h.GetRequestURL("ListByLastID"), httpcli.WithParams(map[string]interface{}{"limit": 101}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// error test
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	err = httpcli.Get(result, h.GetRequestURL("ListByLastID"), httpcli.WithParams(map[string]interface{}{"sort": "-updated_at"}))
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_Search(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+	defer func(interval time.Duration) { dao.UsersSearchRebuildInterval = interval }(dao.UsersSearchRebuildInterval)
+	dao.UsersSearchRebuildInterval = 0
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "chinese_name"}).AddRow(testData.ID, "张三"))
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "chinese_name"}).AddRow(testData.ID, "张三"))
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Get(result, h.GetRequestURL("Search"), httpcli.WithParams(map[string]interface{}{"q": "zs"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	data := result.Data.(map[string]interface{})
+	assert.Equal(t, float64(1), data["total"])
+	hit := data["results"].([]interface{})[0].(map[string]interface{})
+	assert.Greater(t, hit["score"], 0.0)
+	assert.Equal(t, "张三", hit["users"].(map[string]interface{})["chineseName"])
+
+	// params error
+	for _, params := range []map[string]interface{}{{"q": " "}, {"q": "zs", "limit": 101}, {"q": "zs", "page": -1}} {
+		err = httpcli.Get(result, h.GetRequestURL("Search"), httpcli.WithParams(params))
+		assert.NoError(t, err)
+		assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+	}
+
+	// error test
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	err = httpcli.Get(result, h.GetRequestURL("Search"), httpcli.WithParams(map[string]interface{}{"q": "zs"}))
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_ListTrash(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	deactivatedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT count\\(\\*\\) FROM `users` WHERE deactivated_at IS NOT NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE deactivated_at IS NOT NULL ORDER BY deactivated_at DESC, id DESC LIMIT \\? OFFSET \\?").
+		WithArgs(10, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "deactivated_at"}).AddRow(5, "lisi@example.com", deactivatedAt))
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Get(result, h.GetRequestURL("ListTrash"), httpcli.WithParams(map[string]interface{}{"page": 1, "limit": 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	data := result.Data.(map[string]interface{})
+	assert.Equal(t, float64(1), data["total"])
+	users := data["userss"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "2024-01-02T03:04:05Z", users["deactivatedAt"])
+
+	// params error
+	err = httpcli.Get(result, h.GetRequestURL("ListTrash"), httpcli.WithParams(map[string]interface{}{"limit": 101}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// error test
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	err = httpcli.Get(result, h.GetRequestURL("ListTrash"))
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_Restore(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id", "deactivated_at"}).AddRow(testData.ID, time.Now()), testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE `users` SET `deactivated_at`=\\?,`updated_at`=\\? WHERE id = \\? AND deactivated_at IS NOT NULL").
+		WithArgs(nil, h.MockDao.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id", "deactivated_at"}).AddRow(testData.ID, nil), testData.ID)
+	expectUsersAudit(h, testData.ID, model.UsersAuditRestore)
+	h.MockDao.SQLMock.ExpectCommit()
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(testData.ID, "zhangsan@example.com"))
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("Restore", testData.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+
+	// not in the trash
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id"}), uint64(222))
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	h.MockDao.SQLMock.ExpectRollback()
+	err = httpcli.Post(result, h.GetRequestURL("Restore", 222), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
+
+	// zero id error
+	err = httpcli.Post(result, h.GetRequestURL("Restore", 0), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// error test
+	err = httpcli.Post(result, h.GetRequestURL("Restore", 111), nil)
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_History(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT count\\(\\*\\) FROM `users_audit` WHERE users_id = \\?").
+		WithArgs(testData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_audit` WHERE users_id = \\? ORDER BY id DESC LIMIT \\?").
+		WithArgs(testData.ID, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "users_id", "action", "actor_type", "actor_id", "request_id", "changes", "created_at"}).
+			AddRow(3, testData.ID, model.UsersAuditUpdate, "jwt", 7, "req-1",
+				`{"job_level":{"before":"P5","after":"P6"},"encrypted_password":{"before":"[FILTERED]","after":"[FILTERED]"}}`, createdAt))
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Get(result, h.GetRequestURL("History", testData.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	data := result.Data.(map[string]interface{})
+	assert.Equal(t, float64(1), data["total"])
+	audit := data["history"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "update", audit["action"])
+	assert.Equal(t, "jwt", audit["actorType"])
+	assert.Equal(t, float64(7), audit["actorID"])
+	assert.Equal(t, "req-1", audit["requestID"])
+	assert.Equal(t, "2026-01-02T03:04:05Z", audit["createdAt"])
+	assert.Equal(t, map[string]interface{}{
+		"job_level":          map[string]interface{}{"before": "P5", "after": "P6"},
+		"encrypted_password": map[string]interface{}{"before": "[FILTERED]", "after": "[FILTERED]"},
+	}, audit["changes"])
+
+	// params error
+	err = httpcli.Get(result, h.GetRequestURL("History", testData.ID), httpcli.WithParams(map[string]interface{}{"page": -1}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+	err = httpcli.Get(result, h.GetRequestURL("History", 0))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// error test
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	err = httpcli.Get(result, h.GetRequestURL("History", testData.ID))
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_ListVersions(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT count\\(\\*\\) FROM `users_version` WHERE users_id = \\?").
+		WithArgs(testData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` WHERE users_id = \\? ORDER BY version DESC LIMIT \\?").
+		WithArgs(testData.ID, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "users_id", "version", "action", "snapshot", "created_at"}).
+			AddRow(3, testData.ID, 1, model.UsersAuditCreate, `{"id":1,"email":"foo@bar.com","jobLevel":"P5"}`, createdAt))
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Get(result, h.GetRequestURL("ListVersions", testData.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	data := result.Data.(map[string]interface{})
+	assert.Equal(t, float64(1), data["total"])
+	version := data["versions"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, float64(1), version["version"])
+	assert.Equal(t, "create", version["action"])
+	assert.Equal(t, "2026-01-02T03:04:05Z", version["createdAt"])
+	users := version["users"].(map[string]interface{})
+	assert.Equal(t, "foo@bar.com", users["email"])
+	assert.Equal(t, "P5", users["jobLevel"])
+
+	// params error
+	err = httpcli.Get(result, h.GetRequestURL("ListVersions", testData.ID), httpcli.WithParams(map[string]interface{}{"limit": 101}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// error test
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	err = httpcli.Get(result, h.GetRequestURL("ListVersions", testData.ID))
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_DiffVersions(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+	columns := []string{"id", "users_id", "version", "action", "snapshot", "created_at"}
+
+	// against the version before by default
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` WHERE users_id = \\? AND version = \\?").
+		WithArgs(testData.ID, 1, 1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, testData.ID, 1, model.UsersAuditCreate, `{"id":1,"positionTitle":"Intern"}`, time.Now()))
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` WHERE users_id = \\? AND version = \\?").
+		WithArgs(testData.ID, 2, 1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(2, testData.ID, 2, model.UsersAuditUpdate, `{"id":1,"positionTitle":"Engineer"}`, time.Now()))
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Get(result, h.GetRequestURL("DiffVersions", testData.ID, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	data := result.Data.(map[string]interface{})
+	assert.Equal(t, float64(1), data["from"])
+	assert.Equal(t, float64(2), data["to"])
+	assert.Equal(t, map[string]interface{}{
+		"position_title": map[string]interface{}{"before": "Intern", "after": "Engineer"},
+	}, data["changes"])
+
+	// no such version
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WithArgs(testData.ID, 7, 1).
+		WillReturnRows(sqlmock.NewRows(columns))
+	err = httpcli.Get(result, h.GetRequestURL("DiffVersions", testData.ID, 2), httpcli.WithParams(map[string]interface{}{"against": 7}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
+
+	// params error
+	err = httpcli.Get(result, h.GetRequestURL("DiffVersions", testData.ID, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+	err = httpcli.Get(result, h.GetRequestURL("DiffVersions", testData.ID, 2), httpcli.WithParams(map[string]interface{}{"against": -1}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// error test
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	err = httpcli.Get(result, h.GetRequestURL("DiffVersions", testData.ID, 2))
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_Revert(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+	columns := []string{"id", "users_id", "version", "action", "snapshot", "created_at"}
+
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id", "position_title"}).AddRow(testData.ID, "Manager"), testData.ID)
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WithArgs(testData.ID, 2, 1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(2, testData.ID, 2, model.UsersAuditUpdate, `{"id":1,"positionTitle":"Engineer"}`, time.Now()))
+	h.MockDao.SQLMock.ExpectExec("UPDATE `users` SET .*").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id", "position_title"}).AddRow(testData.ID, "Engineer"), testData.ID)
+	expectUsersAudit(h, testData.ID, model.UsersAuditRevert)
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("Revert", testData.ID, 2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+
+	// no such version
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id"}).AddRow(testData.ID), testData.ID)
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_version` .*").
+		WillReturnRows(sqlmock.NewRows(columns))
+	h.MockDao.SQLMock.ExpectRollback()
+	err = httpcli.Post(result, h.GetRequestURL("Revert", testData.ID, 9), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
+
+	// params error
+	err = httpcli.Post(result, h.GetRequestURL("Revert", testData.ID, "x"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// error test
+	h.MockDao.SQLMock.ExpectBegin().WillReturnError(sql.ErrConnDone)
+	err = httpcli.Post(result, h.GetRequestURL("Revert", testData.ID, 2), nil)
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_ReplayEvents(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectExec("UPDATE `users_outbox` SET `published_at`=\\? WHERE id >= \\? AND published_at IS NOT NULL").
+		WithArgs(nil, 42).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("ReplayEvents"), &types.ReplayUsersEventsRequest{Offset: 42})
 	if err != nil {
 		t.Fatal(err)
 	}
 	if result.Code != 0 {
 		t.Fatalf("%+v", result)
 	}
+	assert.Equal(t, float64(3), result.Data.(map[string]interface{})["replayed"])
+
+	// params error
+	err = httpcli.Post(result, h.GetRequestURL("ReplayEvents"), map[string]interface{}{"offset": "x"})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
 
 	// error test
-	err = httpcli.Get(result, h.GetRequestURL("ListByLastID"), httpcli.WithParams(map[string]interface{}{"lastID": 0, "limit": 10, "sort": "unknown-column"}))
+	h.MockDao.SQLMock.ExpectBegin().WillReturnError(sql.ErrConnDone)
+	err = httpcli.Post(result, h.GetRequestURL("ReplayEvents"), &types.ReplayUsersEventsRequest{Offset: 42})
 	assert.Error(t, err)
 }
 
+func Test_usersHandler_ChangePassword(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+	devise.Init(devise.WithStretches(bcrypt.MinCost))
+	defer devise.Init()
+	encryptedPassword, _ := devise.DigestPassword("123456")
+
+	rows := sqlmock.NewRows([]string{"id", "encrypted_password"}).
+		AddRow(testData.ID, encryptedPassword)
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(rows)
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersWritten(h, testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WithArgs(sqlmock.AnyArg(), nil, nil, h.MockDao.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersRecorded(h, testData.ID, "encrypted_password", "digest")
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("ChangePassword", testData.ID), &types.ChangeUsersPasswordRequest{
+		CurrentPassword: "123456",
+		Password:        "abcdefg",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+
+	// wrong current password
+	rows = sqlmock.NewRows([]string{"id", "encrypted_password"}).
+		AddRow(testData.ID, encryptedPassword)
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(rows)
+	err = httpcli.Post(result, h.GetRequestURL("ChangePassword", testData.ID), &types.ChangeUsersPasswordRequest{
+		CurrentPassword: "654321",
+		Password:        "abcdefg",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.ErrCurrentPasswordUsers.Code(), result.Code)
+
+	// deleted after it was read, the record of the last request is cached
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id"}), testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	h.MockDao.SQLMock.ExpectQuery("SELECT `id` FROM `users` .*").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	h.MockDao.SQLMock.ExpectRollback()
+	err = httpcli.Post(result, h.GetRequestURL("ChangePassword", testData.ID), &types.ChangeUsersPasswordRequest{
+		CurrentPassword: "123456",
+		Password:        "abcdefg",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
+	assert.NoError(t, h.MockDao.SQLMock.ExpectationsWereMet())
+
+	// too short new password
+	err = httpcli.Post(result, h.GetRequestURL("ChangePassword", testData.ID), &types.ChangeUsersPasswordRequest{
+		CurrentPassword: "123456",
+		Password:        "abc",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+}
+
+func Test_usersHandler_GetByID_redactsSecretColumns(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+
+	rows := sqlmock.NewRows([]string{"id", "email", "encrypted_password", "reset_password_token",
+		"confirmation_token", "unlock_token", "invitation_token"}).
+		AddRow(testData.ID, "foo@bar.com", "$2a$11$secret", "reset-secret", "confirm-secret", "unlock-secret", "invite-secret")
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(rows)
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Get(result, h.GetRequestURL("GetByID", testData.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+
+	body, err := json.Marshal(result.Data)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "foo@bar.com")
+	for _, secret := range []string{"secret", "encryptedPassword", "resetPasswordToken",
+		"confirmationToken", "unlockToken", "invitationToken"} {
+		assert.NotContains(t, string(body), secret)
+	}
+}
+
+func Test_convertUsers_neverEmitsSecretColumns(t *testing.T) {
+	users := &model.Users{
+		Email:              "foo@bar.com",
+		EncryptedPassword:  "$2a$11$secret",
+		ResetPasswordToken: "reset-secret",
+		ConfirmationToken:  "confirm-secret",
+		UnlockToken:        "unlock-secret",
+		InvitationToken:    "invite-secret",
+		FailedAttempts:     3,
+	}
+	users.ID = 1
+
+	public, err := convertUsers(users)
+	assert.NoError(t, err)
+	admin, err := convertUsersAdmin(users)
+	assert.NoError(t, err)
+	publics, err := convertUserss([]*model.Users{users})
+	assert.NoError(t, err)
+	admins, err := convertUserssAdmin([]*model.Users{users})
+	assert.NoError(t, err)
+
+	for _, v := range []interface{}{public, admin, publics, admins} {
+		body, err := json.Marshal(v)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(body), "secret")
+	}
+
+	// only the admin projection carries devise state
+	assert.Equal(t, 3, admin.FailedAttempts)
+	body, _ := json.Marshal(public)
+	assert.NotContains(t, string(body), "failedAttempts")
+}
+
+func Test_usersObjDetail_hasNoSecretFields(t *testing.T) {
+	secretJSONNames := map[string]bool{}
+	for column := range model.UsersSecretColumnNames {
+		secretJSONNames[strings.ReplaceAll(column, "_", "")] = true
+	}
+
+	for _, v := range []interface{}{types.UsersObjDetail{}, types.UsersAdminObjDetail{}} {
+		rt := reflect.TypeOf(v)
+		for i := 0; i < rt.NumField(); i++ {
+			name := strings.ToLower(strings.Split(rt.Field(i).Tag.Get("json"), ",")[0])
+			assert.False(t, secretJSONNames[name], "%s.%s must not be exposed", rt.Name(), rt.Field(i).Name)
+		}
+	}
+}
+
+func Test_isAdminCaller(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.False(t, isAdminCaller(c))
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("claims", &jwt.Claims{UID: "1", Fields: map[string]interface{}{"role": "user"}})
+	assert.False(t, isAdminCaller(c))
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("claims", &jwt.Claims{UID: "1", Fields: map[string]interface{}{"role": "admin"}})
+	assert.True(t, isAdminCaller(c))
+	data, err := convertUsersByRole(c, &model.Users{UnlockToken: "unlock-secret"})
+	assert.NoError(t, err)
+	assert.IsType(t, &types.UsersAdminObjDetail{}, data)
+}
+
 func TestNewUsersHandler(t *testing.T) {
 	defer func() {
 		recover()
 	}()
 	_ = NewUsersHandler()
 }
+
+func Test_usersHandler_GetMe(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+
+	rows := sqlmock.NewRows([]string{"id", "email"}).
+		AddRow(testData.ID, "me@example.com")
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").
+		WithArgs(testData.ID, 1).
+		WillReturnRows(rows)
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Get(result, h.GetRequestURL("GetMe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	assert.Contains(t, result.Data.(map[string]interface{})["users"], "email")
+
+	// not signed in
+	err = httpcli.Get(result, h.GetRequestURL("GetMeAnonymous"))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.Unauthorized.Code(), result.Code)
+}
+
+func Test_usersHandler_UpdateMe(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	testData := h.TestData.(*model.Users)
+
+	// only the safe subset reaches the update, email and sign in columns are dropped
+	h.MockDao.SQLMock.ExpectBegin()
+	expectUsersLocked(h, sqlmock.NewRows([]string{"id", "per_page"}).AddRow(testData.ID, 12), testData.ID)
+	h.MockDao.SQLMock.ExpectExec("UPDATE `users` SET `mobile`=\\?,`per_page`=\\?,`updated_at`=\\? WHERE deactivated_at IS NULL AND `id` = \\?").
+		WithArgs("13812345678", 50, h.MockDao.AnyTime, testData.ID).
+		WillReturnResult(sqlmock.NewResult(int64(testData.ID), 1))
+	expectUsersReread(h, sqlmock.NewRows([]string{"id", "mobile", "per_page"}).AddRow(testData.ID, "13812345678", 50), testData.ID)
+	expectUsersAudit(h, testData.ID, model.UsersAuditUpdate)
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Put(result, h.GetRequestURL("UpdateMe"), map[string]interface{}{
+		"mobile":      "13812345678",
+		"perPage":     50,
+		"email":       "attacker@example.com",
+		"signInCount": 99,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+
+	// invalid params
+	err = httpcli.Put(result, h.GetRequestURL("UpdateMe"), map[string]interface{}{"perPage": -1})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// update error test
+	err = httpcli.Put(result, h.GetRequestURL("UpdateMe"), map[string]interface{}{"mobile": "13912345678"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,384 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/go-dev-frame/sponge/pkg/gin/middleware"
+	"github.com/go-dev-frame/sponge/pkg/gin/response"
+	"github.com/go-dev-frame/sponge/pkg/logger"
+	"github.com/go-dev-frame/sponge/pkg/utils"
+
+	"test-user-server/internal/database"
+	"test-user-server/internal/devise"
+	"test-user-server/internal/ecode"
+	"test-user-server/internal/model"
+	"test-user-server/internal/types"
+)
+
+// usersWebhookSecretLength characters of a generated webhook secret
+const usersWebhookSecretLength = 32
+
+// usersWebhookStatuses the states a delivery log can be filtered by
+var usersWebhookStatuses = map[string]bool{
+	model.UsersWebhookPending:   true,
+	model.UsersWebhookSucceeded: true,
+	model.UsersWebhookDead:      true,
+}
+
+// CreateWebhook subscribe a webhook to the users events
+// @Summary Subscribe a webhook to the users events
+// @Description Registers a receiver of the users events, it gets a POST of each event of the following writes that has one of the event types and changed one of the columns, an empty list matches them all. Each request carries X-Webhook-Event, X-Webhook-Delivery, X-Webhook-Timestamp and X-Webhook-Signature, sha256= and the hex HMAC-SHA256 of the timestamp, a dot and the body keyed by the secret. The secret is generated when it is not given and is only returned here.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param data body types.CreateUsersWebhookRequest true "webhook information"
+// @Success 200 {object} types.CreateUsersWebhookReply{}
+// @Router /api/v1/users/webhooks [post]
+// @Security BearerAuth
+func (h *usersHandler) CreateWebhook(c *gin.Context) {
+	form := &types.CreateUsersWebhookRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if fieldErrs := usersWebhookColumnErrors(form.Columns); len(fieldErrs) > 0 {
+		logger.Warn("CreateWebhook columns error", logger.Any("columns", form.Columns), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams, gin.H{"errors": fieldErrs})
+		return
+	}
+
+	secret := form.Secret
+	if secret == "" {
+		secret, err = devise.FriendlyToken(usersWebhookSecretLength)
+		if err != nil {
+			logger.Error("FriendlyToken error", logger.Err(err), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.ErrCreateWebhookUsers)
+			return
+		}
+	}
+	webhook := &model.UsersWebhook{
+		URL:     form.URL,
+		Secret:  secret,
+		Events:  strings.Join(form.Events, ","),
+		Columns: strings.Join(form.Columns, ","),
+		Active:  form.Active == nil || *form.Active,
+	}
+
+	ctx := middleware.WrapCtx(c)
+	err = h.iDao.CreateWebhook(ctx, webhook)
+	if err != nil {
+		if respondDBError(c, err) {
+			return
+		}
+		logger.Error("CreateWebhook error", logger.Err(err), logger.String("url", form.URL), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	response.Success(c, gin.H{
+		"webhook": convertUsersWebhook(webhook),
+		"secret":  secret,
+	})
+}
+
+// ListWebhooks list the webhooks subscribed to the users events
+// @Summary List the webhooks
+// @Description Returns the webhooks subscribed to the users events in the order they were created, without their secrets.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Success 200 {object} types.ListUsersWebhooksReply{}
+// @Router /api/v1/users/webhooks [get]
+// @Security BearerAuth
+func (h *usersHandler) ListWebhooks(c *gin.Context) {
+	ctx := middleware.WrapCtx(c)
+	webhooks, err := h.iDao.GetWebhooks(ctx)
+	if err != nil {
+		logger.Error("GetWebhooks error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	data := make([]*types.UsersWebhookObjDetail, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		data = append(data, convertUsersWebhook(webhook))
+	}
+	response.Success(c, gin.H{"webhooks": data})
+}
+
+// GetWebhook get a webhook
+// @Summary Get a webhook
+// @Description Gets the webhook identified by the given id in the path, without its secret.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "webhook id"
+// @Success 200 {object} types.GetUsersWebhookReply{}
+// @Router /api/v1/users/webhooks/{id} [get]
+// @Security BearerAuth
+func (h *usersHandler) GetWebhook(c *gin.Context) {
+	_, id, isAbort := getUsersIDFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	webhook, err := h.iDao.GetWebhook(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("GetWebhook not found", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+		} else {
+			logger.Error("GetWebhook error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	response.Success(c, gin.H{"webhook": convertUsersWebhook(webhook)})
+}
+
+// UpdateWebhook change a webhook
+// @Summary Update a webhook
+// @Description Replaces the url and the filters of the webhook identified by the given id in the path, an empty secret keeps the current one and a null active keeps its state. A disabled webhook gets no new deliveries and its pending ones are dead. The deliveries already queued keep the event they were queued for.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "webhook id"
+// @Param data body types.UpdateUsersWebhookRequest true "webhook information"
+// @Success 200 {object} types.UpdateUsersWebhookReply{}
+// @Router /api/v1/users/webhooks/{id} [put]
+// @Security BearerAuth
+func (h *usersHandler) UpdateWebhook(c *gin.Context) {
+	_, id, isAbort := getUsersIDFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+	form := &types.UpdateUsersWebhookRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if fieldErrs := usersWebhookColumnErrors(form.Columns); len(fieldErrs) > 0 {
+		logger.Warn("UpdateWebhook columns error", logger.Any("columns", form.Columns), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams, gin.H{"errors": fieldErrs})
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	webhook, err := h.iDao.GetWebhook(ctx, id)
+	if err == nil {
+		webhook.URL = form.URL
+		if form.Secret != "" {
+			webhook.Secret = form.Secret
+		}
+		webhook.Events = strings.Join(form.Events, ",")
+		webhook.Columns = strings.Join(form.Columns, ",")
+		if form.Active != nil {
+			webhook.Active = *form.Active
+		}
+		err = h.iDao.UpdateWebhook(ctx, webhook)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("UpdateWebhook not found", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+			return
+		}
+		if respondDBError(c, err) {
+			return
+		}
+		logger.Error("UpdateWebhook error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	response.Success(c, gin.H{"webhook": convertUsersWebhook(webhook)})
+}
+
+// DeleteWebhook unsubscribe a webhook
+// @Summary Delete a webhook
+// @Description Deletes the webhook identified by the given id in the path with its delivery log, the pending deliveries are not sent.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "webhook id"
+// @Success 200 {object} types.DeleteUsersWebhookReply{}
+// @Router /api/v1/users/webhooks/{id} [delete]
+// @Security BearerAuth
+func (h *usersHandler) DeleteWebhook(c *gin.Context) {
+	_, id, isAbort := getUsersIDFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	err := h.iDao.DeleteWebhook(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("DeleteWebhook not found", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+		} else {
+			logger.Error("DeleteWebhook error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+
+	response.Success(c)
+}
+
+// ListWebhookDeliveries list the delivery log of a webhook
+// @Summary List the deliveries of a webhook
+// @Description Returns a page of the deliveries of the webhook identified by the given id in the path, the last queued first, with the outcome of their last attempt. A failed delivery is retried with a backoff that doubles after each attempt, it is dead once its attempts run out.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "webhook id"
+// @Param status query string false "keep the deliveries in this state" Enums(pending, succeeded, dead)
+// @Param page query int false "page number, starting from 0" default(0)
+// @Param limit query int false "number per page, at most 100" default(20)
+// @Success 200 {object} types.ListUsersWebhookDeliveriesReply{}
+// @Router /api/v1/users/webhooks/{id}/deliveries [get]
+// @Security BearerAuth
+func (h *usersHandler) ListWebhookDeliveries(c *gin.Context) {
+	_, id, isAbort := getUsersIDFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+	status := c.Query("status")
+	page := utils.StrToInt(c.Query("page"))
+	limit := utils.StrToInt(c.Query("limit"))
+	if limit == 0 {
+		limit = 20
+	}
+	if status != "" && !usersWebhookStatuses[status] || page < 0 || limit < 1 || limit > 100 {
+		logger.Warn("ListWebhookDeliveries params error", logger.String("status", status), logger.Int("page", page),
+			logger.Int("limit", limit), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	deliveries, total, err := h.iDao.GetWebhookDeliveries(ctx, id, status, page, limit)
+	if err != nil {
+		logger.Error("GetWebhookDeliveries error", logger.Err(err), logger.Any("id", id), middleware.GCtxRequestIDField(c))
+		response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		return
+	}
+
+	data := make([]*types.UsersWebhookDeliveryObjDetail, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		data = append(data, convertUsersWebhookDelivery(delivery))
+	}
+	response.Success(c, gin.H{
+		"deliveries": data,
+		"total":      total,
+	})
+}
+
+// RedeliverWebhook send a delivery of a webhook again
+// @Summary Send a delivery of a webhook again
+// @Description Queues a new delivery of the event of the delivery in the path, whatever its state, it is sent at the next poll with all its attempts. Receivers tell a redelivery apart by the offset in the event. NotFound when the webhook has no such delivery.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "webhook id"
+// @Param deliveryID path string true "delivery id"
+// @Success 200 {object} types.RedeliverUsersWebhookReply{}
+// @Router /api/v1/users/webhooks/{id}/deliveries/{deliveryID}/redeliver [post]
+// @Security BearerAuth
+func (h *usersHandler) RedeliverWebhook(c *gin.Context) {
+	_, id, isAbort := getUsersIDFromPath(c)
+	if isAbort {
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+	deliveryIDStr := c.Param("deliveryID")
+	deliveryID, err := utils.StrToUint64E(deliveryIDStr)
+	if err != nil || deliveryID == 0 {
+		logger.Warn("StrToUint64E error: ", logger.String("deliveryIDStr", deliveryIDStr), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	ctx := middleware.WrapCtx(c)
+	delivery, err := h.iDao.RedeliverWebhook(ctx, id, deliveryID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			logger.Warn("RedeliverWebhook not found", logger.Err(err), logger.Any("id", id), logger.Uint64("deliveryID", deliveryID), middleware.GCtxRequestIDField(c))
+			response.Error(c, ecode.NotFound)
+		} else {
+			logger.Error("RedeliverWebhook error", logger.Err(err), logger.Any("id", id), logger.Uint64("deliveryID", deliveryID), middleware.GCtxRequestIDField(c))
+			response.Output(c, ecode.InternalServerError.ToHTTPCode())
+		}
+		return
+	}
+	logger.Info("redeliver users webhook", logger.Any("id", id), logger.Uint64("deliveryID", deliveryID),
+		logger.Uint64("redeliveryID", delivery.ID), middleware.GCtxRequestIDField(c))
+
+	response.Success(c, gin.H{"delivery": convertUsersWebhookDelivery(delivery)})
+}
+
+// usersWebhookColumnErrors the columns of a webhook filter that are not columns of users
+func usersWebhookColumnErrors(columns []string) []types.FieldError {
+	var fieldErrs []types.FieldError
+	for i, column := range columns {
+		if !model.UsersColumnNames[column] {
+			fieldErrs = append(fieldErrs, types.FieldError{
+				Field:   fmt.Sprintf("columns[%d]", i),
+				Rule:    "column",
+				Param:   column,
+				Message: "must be a column of users",
+			})
+		}
+	}
+	return fieldErrs
+}
+
+func convertUsersWebhook(webhook *model.UsersWebhook) *types.UsersWebhookObjDetail {
+	return &types.UsersWebhookObjDetail{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    splitUsersWebhookList(webhook.Events),
+		Columns:   splitUsersWebhookList(webhook.Columns),
+		Active:    webhook.Active,
+		CreatedAt: &webhook.CreatedAt,
+		UpdatedAt: &webhook.UpdatedAt,
+	}
+}
+
+func convertUsersWebhookDelivery(delivery *model.UsersWebhookDelivery) *types.UsersWebhookDeliveryObjDetail {
+	return &types.UsersWebhookDeliveryObjDetail{
+		ID:             delivery.ID,
+		WebhookID:      delivery.WebhookID,
+		Offset:         delivery.OutboxID,
+		UsersID:        delivery.UsersID,
+		Event:          delivery.Event,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		ResponseStatus: delivery.ResponseStatus,
+		LastError:      delivery.LastError,
+		DeliveredAt:    delivery.DeliveredAt,
+		CreatedAt:      &delivery.CreatedAt,
+	}
+}
+
+// splitUsersWebhookList the values of a comma separated filter of a webhook, empty for none
+func splitUsersWebhookList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,395 @@
+package handler
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/go-dev-frame/sponge/pkg/gotest"
+	"github.com/go-dev-frame/sponge/pkg/httpcli"
+
+	"test-user-server/internal/ecode"
+	"test-user-server/internal/model"
+	"test-user-server/internal/types"
+)
+
+var (
+	usersWebhookColumns         = []string{"id", "url", "secret", "events", "columns", "active", "created_at", "updated_at"}
+	usersWebhookDeliveryColumns = []string{"id", "webhook_id", "outbox_id", "users_id", "event", "payload", "status", "attempts",
+		"next_attempt_at", "response_status", "last_error", "delivered_at", "created_at"}
+)
+
+// expectUsersWebhook the read of a webhook, rows is nil when there is none
+func expectUsersWebhook(h *gotest.Handler, id uint64, rows *sqlmock.Rows) {
+	if rows == nil {
+		rows = sqlmock.NewRows(usersWebhookColumns)
+	}
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook` WHERE id = \\?").
+		WithArgs(id, 1).
+		WillReturnRows(rows)
+}
+
+// fieldErrorOf the first field error of an InvalidParams result
+func fieldErrorOf(t *testing.T, result *httpcli.StdResult) map[string]interface{} {
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+	data, ok := result.Data.(map[string]interface{})
+	if !ok {
+		t.Fatalf("%+v", result)
+	}
+	return data["errors"].([]interface{})[0].(map[string]interface{})
+}
+
+func Test_usersHandler_CreateWebhook(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectExec("INSERT INTO `users_webhook` .*").
+		WithArgs("https://hr.example.com/hooks", sqlmock.AnyArg(), model.UsersEventUpdated, "position_title,job_level", true,
+			h.MockDao.AnyTime, h.MockDao.AnyTime).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("CreateWebhook"), &types.CreateUsersWebhookRequest{
+		URL:     "https://hr.example.com/hooks",
+		Events:  []string{model.UsersEventUpdated},
+		Columns: []string{"position_title", "job_level"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	data := result.Data.(map[string]interface{})
+	// the generated secret is only returned here
+	assert.Len(t, data["secret"], usersWebhookSecretLength)
+	webhook := data["webhook"].(map[string]interface{})
+	assert.Equal(t, float64(3), webhook["id"])
+	assert.Equal(t, []interface{}{model.UsersEventUpdated}, webhook["events"])
+	assert.Equal(t, []interface{}{"position_title", "job_level"}, webhook["columns"])
+	assert.Equal(t, true, webhook["active"])
+	assert.NotContains(t, webhook, "secret")
+
+	// a given secret, disabled
+	inactive := false
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectExec("INSERT INTO `users_webhook` .*").
+		WithArgs("https://hr.example.com/all", "0123456789abcdef", "", "", false, h.MockDao.AnyTime, h.MockDao.AnyTime).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	h.MockDao.SQLMock.ExpectCommit()
+	err = httpcli.Post(result, h.GetRequestURL("CreateWebhook"), &types.CreateUsersWebhookRequest{
+		URL:    "https://hr.example.com/all",
+		Secret: "0123456789abcdef",
+		Active: &inactive,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Code)
+	assert.Equal(t, []interface{}{}, result.Data.(map[string]interface{})["webhook"].(map[string]interface{})["events"])
+
+	// params error
+	err = httpcli.Post(result, h.GetRequestURL("CreateWebhook"), &types.CreateUsersWebhookRequest{URL: "not a url"})
+	assert.NoError(t, err)
+	assert.Equal(t, "url", fieldErrorOf(t, result)["field"])
+	err = httpcli.Post(result, h.GetRequestURL("CreateWebhook"), &types.CreateUsersWebhookRequest{
+		URL: "https://hr.example.com/hooks", Events: []string{"user.renamed"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "events[0]", fieldErrorOf(t, result)["field"])
+	assert.Equal(t, "oneof", fieldErrorOf(t, result)["rule"])
+	err = httpcli.Post(result, h.GetRequestURL("CreateWebhook"), &types.CreateUsersWebhookRequest{
+		URL: "https://hr.example.com/hooks", Columns: []string{"position_title", "title"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"field": "columns[1]", "rule": "column", "param": "title", "message": "must be a column of users",
+	}, fieldErrorOf(t, result))
+
+	// error test
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectExec("INSERT INTO `users_webhook` .*").WillReturnError(sql.ErrConnDone)
+	h.MockDao.SQLMock.ExpectRollback()
+	err = httpcli.Post(result, h.GetRequestURL("CreateWebhook"), &types.CreateUsersWebhookRequest{URL: "https://hr.example.com/hooks"})
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_ListWebhooks(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook` ORDER BY id").
+		WillReturnRows(sqlmock.NewRows(usersWebhookColumns).
+			AddRow(1, "https://a.example.com", "s1", "", "", true, createdAt, createdAt).
+			AddRow(2, "https://b.example.com", "s2", "user.created,user.deleted", "position_title", false, createdAt, createdAt))
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Get(result, h.GetRequestURL("ListWebhooks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	webhooks := result.Data.(map[string]interface{})["webhooks"].([]interface{})
+	assert.Len(t, webhooks, 2)
+	webhook := webhooks[1].(map[string]interface{})
+	assert.Equal(t, []interface{}{model.UsersEventCreated, model.UsersEventDeleted}, webhook["events"])
+	assert.Equal(t, []interface{}{"position_title"}, webhook["columns"])
+	assert.Equal(t, false, webhook["active"])
+	assert.Equal(t, "2026-01-02T03:04:05Z", webhook["createdAt"])
+	assert.NotContains(t, webhook, "secret")
+
+	// error test
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	err = httpcli.Get(result, h.GetRequestURL("ListWebhooks"))
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_GetWebhook(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+
+	expectUsersWebhook(h, 3, sqlmock.NewRows(usersWebhookColumns).
+		AddRow(3, "https://hr.example.com/hooks", "secret", "", "position_title", true, time.Now(), time.Now()))
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Get(result, h.GetRequestURL("GetWebhook", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	webhook := result.Data.(map[string]interface{})["webhook"].(map[string]interface{})
+	assert.Equal(t, "https://hr.example.com/hooks", webhook["url"])
+	assert.NotContains(t, webhook, "secret")
+
+	// not found
+	expectUsersWebhook(h, 4, nil)
+	err = httpcli.Get(result, h.GetRequestURL("GetWebhook", 4))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
+
+	// params error
+	err = httpcli.Get(result, h.GetRequestURL("GetWebhook", 0))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// error test
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	err = httpcli.Get(result, h.GetRequestURL("GetWebhook", 3))
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_UpdateWebhook(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+
+	// the secret and the state are kept
+	expectUsersWebhook(h, 3, sqlmock.NewRows(usersWebhookColumns).
+		AddRow(3, "https://hr.example.com/hooks", "secret", "", "", false, time.Now(), time.Now()))
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectExec("UPDATE `users_webhook` SET `url`=\\?,`secret`=\\?,`events`=\\?,`columns`=\\?,`active`=\\?,`updated_at`=\\? WHERE `id` = \\?").
+		WithArgs("https://hr.example.com/v2/hooks", "secret", model.UsersEventDeleted, "", false, h.MockDao.AnyTime, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Put(result, h.GetRequestURL("UpdateWebhook", 3), &types.UpdateUsersWebhookRequest{
+		URL:    "https://hr.example.com/v2/hooks",
+		Events: []string{model.UsersEventDeleted},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	webhook := result.Data.(map[string]interface{})["webhook"].(map[string]interface{})
+	assert.Equal(t, "https://hr.example.com/v2/hooks", webhook["url"])
+	assert.Equal(t, false, webhook["active"])
+
+	// a new secret, enabled
+	active := true
+	expectUsersWebhook(h, 3, sqlmock.NewRows(usersWebhookColumns).
+		AddRow(3, "https://hr.example.com/hooks", "secret", "", "", false, time.Now(), time.Now()))
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectExec("UPDATE `users_webhook` .*").
+		WithArgs("https://hr.example.com/hooks", "fedcba9876543210", "", "mobile", true, h.MockDao.AnyTime, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.MockDao.SQLMock.ExpectCommit()
+	err = httpcli.Put(result, h.GetRequestURL("UpdateWebhook", 3), &types.UpdateUsersWebhookRequest{
+		URL:     "https://hr.example.com/hooks",
+		Secret:  "fedcba9876543210",
+		Columns: []string{"mobile"},
+		Active:  &active,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Code)
+
+	// not found
+	expectUsersWebhook(h, 4, nil)
+	err = httpcli.Put(result, h.GetRequestURL("UpdateWebhook", 4), &types.UpdateUsersWebhookRequest{URL: "https://hr.example.com/hooks"})
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
+
+	// params error
+	err = httpcli.Put(result, h.GetRequestURL("UpdateWebhook", 3), &types.UpdateUsersWebhookRequest{URL: "https://hr.example.com/hooks", Secret: "short"})
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", fieldErrorOf(t, result)["field"])
+	err = httpcli.Put(result, h.GetRequestURL("UpdateWebhook", 3), &types.UpdateUsersWebhookRequest{
+		URL: "https://hr.example.com/hooks", Columns: []string{"nope"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "columns[0]", fieldErrorOf(t, result)["field"])
+
+	// error test
+	expectUsersWebhook(h, 3, sqlmock.NewRows(usersWebhookColumns).
+		AddRow(3, "https://hr.example.com/hooks", "secret", "", "", true, time.Now(), time.Now()))
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectExec("UPDATE .*").WillReturnError(sql.ErrConnDone)
+	h.MockDao.SQLMock.ExpectRollback()
+	err = httpcli.Put(result, h.GetRequestURL("UpdateWebhook", 3), &types.UpdateUsersWebhookRequest{URL: "https://hr.example.com/hooks"})
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_DeleteWebhook(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectExec("DELETE FROM `users_webhook_delivery` WHERE webhook_id = \\?").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	h.MockDao.SQLMock.ExpectExec("DELETE FROM `users_webhook` WHERE id = \\?").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Delete(result, h.GetRequestURL("DeleteWebhook", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+
+	// not found
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectExec("DELETE FROM `users_webhook_delivery` .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	h.MockDao.SQLMock.ExpectExec("DELETE FROM `users_webhook` .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	h.MockDao.SQLMock.ExpectRollback()
+	err = httpcli.Delete(result, h.GetRequestURL("DeleteWebhook", 4))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
+
+	// params error
+	err = httpcli.Delete(result, h.GetRequestURL("DeleteWebhook", 0))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// error test
+	h.MockDao.SQLMock.ExpectBegin().WillReturnError(sql.ErrConnDone)
+	err = httpcli.Delete(result, h.GetRequestURL("DeleteWebhook", 3))
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_ListWebhookDeliveries(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT count\\(\\*\\) FROM `users_webhook_delivery` WHERE webhook_id = \\? AND status = \\?").
+		WithArgs(3, model.UsersWebhookDead).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook_delivery` WHERE webhook_id = \\? AND status = \\? ORDER BY id DESC LIMIT \\?").
+		WithArgs(3, model.UsersWebhookDead, 20).
+		WillReturnRows(sqlmock.NewRows(usersWebhookDeliveryColumns).
+			AddRow(9, 3, 41, 1, model.UsersEventUpdated, `{"offset":41}`, model.UsersWebhookDead, 8, nil,
+				500, "receiver answered 500 Internal Server Error", nil, createdAt))
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Get(result, h.GetRequestURL("ListWebhookDeliveries", 3),
+		httpcli.WithParams(map[string]interface{}{"status": model.UsersWebhookDead}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	data := result.Data.(map[string]interface{})
+	assert.Equal(t, float64(1), data["total"])
+	delivery := data["deliveries"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, float64(41), delivery["offset"])
+	assert.Equal(t, model.UsersWebhookDead, delivery["status"])
+	assert.Equal(t, float64(8), delivery["attempts"])
+	assert.Equal(t, float64(500), delivery["responseStatus"])
+	assert.Equal(t, "receiver answered 500 Internal Server Error", delivery["lastError"])
+	assert.Nil(t, delivery["nextAttemptAt"])
+	assert.NotContains(t, delivery, "payload")
+
+	// params error
+	err = httpcli.Get(result, h.GetRequestURL("ListWebhookDeliveries", 3), httpcli.WithParams(map[string]interface{}{"status": "failed"}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+	err = httpcli.Get(result, h.GetRequestURL("ListWebhookDeliveries", 3), httpcli.WithParams(map[string]interface{}{"limit": 101}))
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// error test
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	err = httpcli.Get(result, h.GetRequestURL("ListWebhookDeliveries", 3))
+	assert.Error(t, err)
+}
+
+func Test_usersHandler_RedeliverWebhook(t *testing.T) {
+	h := newUsersHandler()
+	defer h.Close()
+
+	h.MockDao.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook_delivery` WHERE id = \\? AND webhook_id = \\?").
+		WithArgs(9, 3, 1).
+		WillReturnRows(sqlmock.NewRows(usersWebhookDeliveryColumns).
+			AddRow(9, 3, 41, 1, model.UsersEventUpdated, `{"offset":41}`, model.UsersWebhookDead, 8, nil, 500, "", nil, time.Now()))
+	h.MockDao.SQLMock.ExpectBegin()
+	h.MockDao.SQLMock.ExpectExec("INSERT INTO `users_webhook_delivery` .*").
+		WithArgs(3, 41, 1, model.UsersEventUpdated, `{"offset":41}`, model.UsersWebhookPending, 0, h.MockDao.AnyTime, 0, "", nil,
+			h.MockDao.AnyTime, h.MockDao.AnyTime).
+		WillReturnResult(sqlmock.NewResult(10, 1))
+	h.MockDao.SQLMock.ExpectCommit()
+
+	result := &httpcli.StdResult{}
+	err := httpcli.Post(result, h.GetRequestURL("RedeliverWebhook", 3, 9), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 0 {
+		t.Fatalf("%+v", result)
+	}
+	delivery := result.Data.(map[string]interface{})["delivery"].(map[string]interface{})
+	assert.Equal(t, float64(10), delivery["id"])
+	assert.Equal(t, float64(41), delivery["offset"])
+	assert.Equal(t, model.UsersWebhookPending, delivery["status"])
+	assert.Equal(t, float64(0), delivery["attempts"])
+
+	// not found
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows(usersWebhookDeliveryColumns))
+	err = httpcli.Post(result, h.GetRequestURL("RedeliverWebhook", 4, 9), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.NotFound.Code(), result.Code)
+
+	// params error
+	err = httpcli.Post(result, h.GetRequestURL("RedeliverWebhook", 3, "x"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ecode.InvalidParams.Code(), result.Code)
+
+	// error test
+	h.MockDao.SQLMock.ExpectQuery("SELECT .*").WillReturnError(sql.ErrConnDone)
+	err = httpcli.Post(result, h.GetRequestURL("RedeliverWebhook", 3, 9), nil)
+	assert.Error(t, err)
+}
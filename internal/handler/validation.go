@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+
+	"github.com/go-dev-frame/sponge/pkg/gin/middleware"
+	"github.com/go-dev-frame/sponge/pkg/gin/response"
+	"github.com/go-dev-frame/sponge/pkg/logger"
+
+	"test-user-server/internal/ecode"
+	"test-user-server/internal/types"
+)
+
+// mobileRe mainland china mobile numbers, with or without the +86 country code
+var mobileRe = regexp.MustCompile(`^(\+86)?1[3-9]\d{9}$`)
+
+func init() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	// report fields by their json names
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	_ = v.RegisterValidation("mobile", func(fl validator.FieldLevel) bool {
+		return mobileRe.MatchString(fl.Field().String())
+	})
+}
+
+// respondBindError answer InvalidParams listing each field that failed and why
+func respondBindError(c *gin.Context, err error) {
+	logger.Warn("ShouldBindJSON error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
+	response.Error(c, ecode.InvalidParams, gin.H{"errors": fieldErrors(err)})
+}
+
+// fieldErrors convert the validator and json decoding errors, the order follows the struct fields
+func fieldErrors(err error) []types.FieldError {
+	var vErrs validator.ValidationErrors
+	if errors.As(err, &vErrs) {
+		fields := make([]types.FieldError, 0, len(vErrs))
+		for _, fe := range vErrs {
+			fields = append(fields, types.FieldError{
+				Field:   jsonPath(fe.Namespace()),
+				Rule:    fe.Tag(),
+				Param:   fe.Param(),
+				Message: ruleMessage(fe),
+			})
+		}
+		return fields
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		expected := jsonType(typeErr.Type)
+		return []types.FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Param:   expected,
+			Message: "must be " + expected,
+		}}
+	}
+
+	return []types.FieldError{{Rule: "json", Message: "request body is not valid json"}}
+}
+
+// jsonPath drop the struct name the validator puts in front, CreateUsersRequest.email becomes email
+func jsonPath(namespace string) string {
+	if _, path, ok := strings.Cut(namespace, "."); ok {
+		return path
+	}
+	return namespace
+}
+
+func ruleMessage(fe validator.FieldError) string {
+	unit := ""
+	if fe.Kind() == reflect.String {
+		unit = " characters"
+	}
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "mobile":
+		return "must be a valid mobile number"
+	case "min":
+		return fmt.Sprintf("must be at least %s%s", fe.Param(), unit)
+	case "max":
+		return fmt.Sprintf("must be at most %s%s", fe.Param(), unit)
+	case "oneof":
+		return "must be one of " + fe.Param()
+	}
+	return fmt.Sprintf("failed on the %s rule", fe.Tag())
+}
+
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	if t.String() == "time.Time" {
+		return "an RFC 3339 time"
+	}
+	return "an object"
+}
@@ -0,0 +1,72 @@
+package mailer
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// ResetPasswordInstructions same content as devise/mailer/reset_password_instructions.html.erb,
+// link is the url of the rails edit password page, the raw token is appended to it
+func ResetPasswordInstructions(to string, link string, token string) *Message {
+	return &Message{
+		To:      []string{to},
+		Subject: "Reset password instructions",
+		Body: fmt.Sprintf("Hello %s!\n\n"+
+			"Someone has requested a link to change your password. You can do this through the link below.\n\n"+
+			"%s\n\n"+
+			"If you didn't request this, please ignore this email.\n"+
+			"Your password won't change until you access the link above and create a new one.\n",
+			to, withQuery(link, "reset_password_token", token)),
+	}
+}
+
+// UnlockInstructions same content as devise/mailer/unlock_instructions.html.erb
+func UnlockInstructions(to string, link string, token string) *Message {
+	return &Message{
+		To:      []string{to},
+		Subject: "Unlock instructions",
+		Body: fmt.Sprintf("Hello %s!\n\n"+
+			"Your account has been locked due to an excessive number of unsuccessful sign in attempts.\n\n"+
+			"Click the link below to unlock your account:\n\n"+
+			"%s\n",
+			to, withQuery(link, "unlock_token", token)),
+	}
+}
+
+func withQuery(link string, key string, value string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link + "?" + key + "=" + url.QueryEscape(value)
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// ConfirmationInstructions same content as devise/mailer/confirmation_instructions.html.erb,
+// to is the unconfirmed email when an email change is reconfirmed
+func ConfirmationInstructions(to string, link string, token string) *Message {
+	return &Message{
+		To:      []string{to},
+		Subject: "Confirmation instructions",
+		Body: fmt.Sprintf("Welcome %s!\n\n"+
+			"You can confirm your account email through the link below:\n\n"+
+			"%s\n",
+			to, withQuery(link, "confirmation_token", token)),
+	}
+}
+
+// InvitationInstructions same content as devise/mailer/invitation_instructions.html.erb of devise_invitable
+func InvitationInstructions(to string, link string, token string) *Message {
+	return &Message{
+		To:      []string{to},
+		Subject: "Invitation instructions",
+		Body: fmt.Sprintf("Hello %s\n\n"+
+			"Someone has invited you, you can accept it through the link below.\n\n"+
+			"%s\n\n"+
+			"If you don't want to accept the invitation, please ignore this email.\n"+
+			"Your account won't be created until you access the link above and set your password.\n",
+			to, withQuery(link, "invitation_token", token)),
+	}
+}
@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+const fileMailerFrom = "no-reply@localhost"
+
+type fileMailer struct {
+	dir string
+}
+
+// NewFileMailer write each message to a .eml file in dir, for development and tests
+func NewFileMailer(dir string) Mailer {
+	return &fileMailer{dir: dir}
+}
+
+// Send write the message to <dir>/<unix nano>-<first recipient>.eml
+func (m *fileMailer) Send(_ context.Context, msg *Message) error {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%d-%s.eml", now.UnixNano(), strings.Join(msg.To, "_"))
+	return os.WriteFile(filepath.Join(m.dir, filepath.Base(name)), msg.bytes(fileMailerFrom, now), 0o600)
+}
+
+type logMailer struct{}
+
+// NewLogMailer write messages to the logger, the body contains tokens so only use it in development
+func NewLogMailer() Mailer {
+	return &logMailer{}
+}
+
+// Send log the message
+func (m *logMailer) Send(_ context.Context, msg *Message) error {
+	logger.Info("[mailer] message not sent, log mailer in use",
+		logger.Any("to", msg.To), logger.String("subject", msg.Subject), logger.String("body", msg.Body))
+	return nil
+}
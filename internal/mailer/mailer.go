@@ -0,0 +1,60 @@
+// Package mailer sends the devise instruction emails (reset password, unlock, confirmation).
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message an email
+type Message struct {
+	To      []string
+	Subject string
+	Body    string // plain text
+}
+
+// Mailer send messages
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+var (
+	defaultMailer Mailer
+	mu            sync.RWMutex
+)
+
+// Init set the mailer used by the handlers
+func Init(m Mailer) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultMailer = m
+}
+
+// Get get the mailer set by Init, messages are logged if Init has not been called
+func Get() Mailer {
+	mu.RLock()
+	defer mu.RUnlock()
+	if defaultMailer == nil {
+		return NewLogMailer()
+	}
+	return defaultMailer
+}
+
+// bytes of the message in RFC 5322 format
+func (m *Message) bytes(from string, date time.Time) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := NewFileMailer(dir)
+
+	err := m.Send(context.Background(), ResetPasswordInstructions("foo@bar.com", "http://localhost:3000/users/password/edit", "abc"))
+	assert.NoError(t, err)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	data, err := os.ReadFile(dir + "/" + entries[0].Name())
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(data), "To: foo@bar.com\r\n"))
+	assert.True(t, strings.Contains(string(data), "http://localhost:3000/users/password/edit?reset_password_token=abc"))
+}
+
+func TestInit(t *testing.T) {
+	Init(nil)
+	assert.IsType(t, &logMailer{}, Get())
+	assert.NoError(t, Get().Send(context.Background(), UnlockInstructions("foo@bar.com", "http://localhost:3000/users/unlock", "abc")))
+
+	Init(NewSMTPMailer("127.0.0.1", 25, "user", "pass", "no-reply@bar.com"))
+	defer Init(nil)
+	assert.IsType(t, &smtpMailer{}, Get())
+	assert.Error(t, Get().Send(context.Background(), &Message{}))
+}
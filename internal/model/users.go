@@ -59,6 +59,9 @@ type Users struct {
 	NewUI                      *sgorm.TinyBool `gorm:"column:new_ui;type:tinyint(1);default:1" json:"newUI"`
 	PositionNcPkPost           string          `gorm:"column:position_nc_pk_post;type:varchar(255)" json:"positionNcPkPost"`
 	WindowsSid                 string          `gorm:"column:windows_sid;type:varchar(255)" json:"windowsSid"`
+	// set when the users is deleted, the row stays for the rails app until it is purged, added by
+	// scripts/migrations/add_deactivated_at_to_users.sql
+	DeactivatedAt *time.Time `gorm:"column:deactivated_at;type:datetime" json:"deactivatedAt"`
 }
 
 // UsersColumnNames Whitelist for custom query fields to prevent sql injection attacks
@@ -109,4 +112,37 @@ var UsersColumnNames = map[string]bool{
 	"new_ui":                         true,
 	"position_nc_pk_post":            true,
 	"windows_sid":                    true,
+	"deactivated_at":                 true,
+}
+
+// UsersNaturalKeyColumnNames unique columns the integrations identify a users by, GetByKey looks them up
+// through the secondary cache indexes
+var UsersNaturalKeyColumnNames = map[string]bool{
+	"email":       true,
+	"clerk_code":  true,
+	"wecom_id":    true,
+	"windows_sid": true,
+	"pre_sso_id":  true,
+}
+
+// UsersCursorColumnNames columns a cursor page can be sorted by, the id is added to each of them to
+// break the ties between rows having the same value
+var UsersCursorColumnNames = map[string]bool{
+	"id":            true,
+	"created_at":    true,
+	"updated_at":    true,
+	"email":         true,
+	"chinese_name":  true,
+	"clerk_code":    true,
+	"sign_in_count": true,
+}
+
+// UsersSecretColumnNames columns holding password digests and devise tokens, they must never
+// be returned by any api, exported or written to logs in clear text
+var UsersSecretColumnNames = map[string]bool{
+	"encrypted_password":   true,
+	"reset_password_token": true,
+	"confirmation_token":   true,
+	"unlock_token":         true,
+	"invitation_token":     true,
 }
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	// UsersAuditCreate the users was created
+	UsersAuditCreate = "create"
+	// UsersAuditUpdate columns of the users were changed
+	UsersAuditUpdate = "update"
+	// UsersAuditDelete the users was deactivated
+	UsersAuditDelete = "delete"
+	// UsersAuditRestore the users was taken out of the trash
+	UsersAuditRestore = "restore"
+	// UsersAuditRevert the users was reverted to one of its versions
+	UsersAuditRevert = "revert"
+	// UsersAuditPurge the users was hard deleted from the trash
+	UsersAuditPurge = "purge"
+
+	// UsersAuditFiltered the value recorded in place of a secret column
+	UsersAuditFiltered = "[FILTERED]"
+)
+
+// UsersAudit a write of a users record, recorded by the dao in the transaction of the write, the table is created by
+// scripts/migrations/create_users_audit.sql
+type UsersAudit struct {
+	ID        uint64 `gorm:"primary_key" json:"id"`
+	UsersID   uint64 `gorm:"column:users_id;type:bigint(20) unsigned;not null" json:"usersID"`
+	Action    string `gorm:"column:action;type:varchar(16);not null" json:"action"`
+	ActorType string `gorm:"column:actor_type;type:varchar(32);not null" json:"actorType"` // policy source of the caller, empty when there was none
+	ActorID   uint64 `gorm:"column:actor_id;type:bigint(20) unsigned;not null" json:"actorID"`
+	RequestID string `gorm:"column:request_id;type:varchar(64);not null" json:"requestID"`
+	// the changed columns as json, {"job_level":{"before":"P5","after":"P6"}}
+	Changes   string    `gorm:"column:changes;type:json;not null" json:"changes"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"createdAt"`
+}
+
+// UsersChange the values of a column before and after a write, nil is NULL or not set
+type UsersChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
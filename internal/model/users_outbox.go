@@ -0,0 +1,49 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	// UsersEventCreated a users was created
+	UsersEventCreated = "user.created"
+	// UsersEventUpdated columns of a users were changed, it was restored or reverted
+	UsersEventUpdated = "user.updated"
+	// UsersEventDeleted a users was deactivated, or purged from the trash, then every column changes to null
+	UsersEventDeleted = "user.deleted"
+)
+
+// UsersOutbox an event of a users write, inserted by the dao in the transaction of the write and
+// published by the relay, the id is the offset of the event. The table is created by
+// scripts/migrations/create_users_outbox.sql
+type UsersOutbox struct {
+	ID      uint64 `gorm:"primary_key" json:"id"`
+	UsersID uint64 `gorm:"column:users_id;type:bigint(20) unsigned;not null" json:"usersID"`
+	Event   string `gorm:"column:event;type:varchar(32);not null" json:"event"`
+	// the event as json of UsersEvent, without the offset
+	Payload      string     `gorm:"column:payload;type:json;not null" json:"payload"`
+	CreatedAt    time.Time  `gorm:"column:created_at;type:datetime(6)" json:"createdAt"`
+	PublishedAt  *time.Time `gorm:"column:published_at;type:datetime(6)" json:"publishedAt"`   // NULL until the relay has published it
+	ClaimedUntil *time.Time `gorm:"column:claimed_until;type:datetime(6)" json:"claimedUntil"` // set while a relay is publishing it
+}
+
+// UsersEvent the message published for a users write
+type UsersEvent struct {
+	Offset     uint64                 `json:"offset"` // id of the outbox row, increasing in the order of the writes
+	Type       string                 `json:"type"`
+	UsersID    uint64                 `json:"usersID"`
+	Columns    []string               `json:"columns"` // names of the changed columns, sorted
+	Changes    map[string]UsersChange `json:"changes"` // by column name, the secret columns read [FILTERED]
+	RequestID  string                 `json:"requestID"`
+	OccurredAt time.Time              `json:"occurredAt"`
+}
+
+// UsersEventTypes the event of each audit action
+var UsersEventTypes = map[string]string{
+	UsersAuditCreate:  UsersEventCreated,
+	UsersAuditUpdate:  UsersEventUpdated,
+	UsersAuditDelete:  UsersEventDeleted,
+	UsersAuditRestore: UsersEventUpdated,
+	UsersAuditRevert:  UsersEventUpdated,
+	UsersAuditPurge:   UsersEventDeleted,
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"time"
+)
+
+// UsersVersion a snapshot of a users record after a write, the versions of a users are numbered from 1
+// in the order of the writes. They are recorded with the audit rows, the table is created by
+// scripts/migrations/create_users_version.sql
+type UsersVersion struct {
+	ID      uint64 `gorm:"primary_key" json:"id"`
+	UsersID uint64 `gorm:"column:users_id;type:bigint(20) unsigned;not null" json:"usersID"`
+	Version int    `gorm:"column:version;type:int(11);not null" json:"version"`
+	Action  string `gorm:"column:action;type:varchar(16);not null" json:"action"` // the UsersAudit action of the write
+	// the record as json of Users, the secret columns are left empty
+	Snapshot  string    `gorm:"column:snapshot;type:json;not null" json:"snapshot"`
+	CreatedAt time.Time `gorm:"column:created_at;type:datetime(6)" json:"createdAt"`
+}
+
+// UsersRevertibleColumnNames the profile columns a users is reverted to from one of its versions, the
+// email, the secret columns and the devise state have their own flows and are left as they are
+var UsersRevertibleColumnNames = map[string]bool{
+	"position_title":                 true,
+	"clerk_code":                     true,
+	"chinese_name":                   true,
+	"desk_phone":                     true,
+	"job_level":                      true,
+	"wecom_id":                       true,
+	"pre_sso_id":                     true,
+	"mobile":                         true,
+	"entry_company_date":             true,
+	"gender":                         true,
+	"per_page":                       true,
+	"open_in_new_tab":                true,
+	"major_code":                     true,
+	"major_name":                     true,
+	"position_changed_in_last_month": true,
+	"new_ui":                         true,
+	"position_nc_pk_post":            true,
+	"windows_sid":                    true,
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	// UsersWebhookPending the delivery waits for its next attempt
+	UsersWebhookPending = "pending"
+	// UsersWebhookSucceeded the receiver answered with a 2xx status
+	UsersWebhookSucceeded = "succeeded"
+	// UsersWebhookDead the attempts ran out or the webhook was disabled, only a redeliver sends it again
+	UsersWebhookDead = "dead"
+)
+
+// UsersWebhook a receiver subscribed to the users events, a delivery is queued in the transaction of
+// each write whose event it matches. The table is created by
+// scripts/migrations/create_users_webhook.sql
+type UsersWebhook struct {
+	ID     uint64 `gorm:"primary_key" json:"id"`
+	URL    string `gorm:"column:url;type:varchar(2048);not null" json:"url"`
+	Secret string `gorm:"column:secret;type:varchar(255);not null" json:"-"` // key of the HMAC-SHA256 signature of the deliveries
+	// comma separated event types the webhook gets, empty for all of them
+	Events string `gorm:"column:events;type:varchar(255);not null" json:"events"`
+	// comma separated column names, an event is only delivered when it changed one of them, empty for any
+	Columns   string    `gorm:"column:columns;type:varchar(4096);not null" json:"columns"`
+	Active    bool      `gorm:"column:active;type:tinyint(1);not null" json:"active"` // a disabled webhook gets no new deliveries
+	CreatedAt time.Time `gorm:"column:created_at;type:datetime(6)" json:"createdAt"`
+	UpdatedAt time.Time `gorm:"column:updated_at;type:datetime(6)" json:"updatedAt"`
+}
+
+// UsersWebhookDelivery an event to send to a webhook and the outcome of its last attempt, the rows of a
+// webhook are its delivery log. The table is created by
+// scripts/migrations/create_users_webhook.sql
+type UsersWebhookDelivery struct {
+	ID        uint64 `gorm:"primary_key" json:"id"`
+	WebhookID uint64 `gorm:"column:webhook_id;type:bigint(20) unsigned;not null" json:"webhookID"`
+	OutboxID  uint64 `gorm:"column:outbox_id;type:bigint(20) unsigned;not null" json:"outboxID"` // offset of the event
+	UsersID   uint64 `gorm:"column:users_id;type:bigint(20) unsigned;not null" json:"usersID"`
+	Event     string `gorm:"column:event;type:varchar(32);not null" json:"event"`
+	// the body of the request, json of UsersEvent with its offset
+	Payload        string     `gorm:"column:payload;type:json;not null" json:"payload"`
+	Status         string     `gorm:"column:status;type:varchar(16);not null" json:"status"`              // pending, succeeded or dead
+	Attempts       int        `gorm:"column:attempts;type:int(11);not null" json:"attempts"`              // requests sent so far
+	NextAttemptAt  *time.Time `gorm:"column:next_attempt_at;type:datetime(6)" json:"nextAttemptAt"`       // NULL unless pending, pushed out while a dispatcher attempts it
+	ResponseStatus int        `gorm:"column:response_status;type:int(11);not null" json:"responseStatus"` // of the last attempt, 0 when there was no response
+	LastError      string     `gorm:"column:last_error;type:varchar(1024);not null" json:"lastError"`     // why the last attempt failed
+	DeliveredAt    *time.Time `gorm:"column:delivered_at;type:datetime(6)" json:"deliveredAt"`
+	CreatedAt      time.Time  `gorm:"column:created_at;type:datetime(6)" json:"createdAt"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at;type:datetime(6)" json:"updatedAt"`
+}
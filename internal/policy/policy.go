@@ -0,0 +1,171 @@
+// Package policy is the authorization decision point shared by the jwt and the rails session
+// authentication, it maps the caller to a role and checks the rule of each route.
+package policy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/go-dev-frame/sponge/pkg/gin/middleware"
+	"github.com/go-dev-frame/sponge/pkg/rails"
+	"github.com/go-dev-frame/sponge/pkg/utils"
+)
+
+const (
+	// RoleAdmin may run every route and gets the admin projection
+	RoleAdmin = "admin"
+	// RoleUser any authenticated caller
+	RoleUser = "user"
+
+	// RoleClaim name of the jwt claim holding the role
+	RoleClaim = "role"
+
+	// SourceJWT the caller was authenticated by a jwt
+	SourceJWT = "jwt"
+	// SourceRailsSession the caller was authenticated by the rails session cookie
+	SourceRailsSession = "rails_session"
+
+	subjectKey = "policy.subject"
+)
+
+type subjectCtxKey struct{}
+
+var (
+	mu       sync.RWMutex
+	enabled  bool
+	adminIDs = map[uint64]bool{}
+)
+
+// Subject the authenticated caller
+type Subject struct {
+	ID     uint64
+	Role   string
+	Source string // SourceJWT or SourceRailsSession
+}
+
+// IsAdmin report whether the caller has the admin role
+func (s *Subject) IsAdmin() bool {
+	return s != nil && s.Role == RoleAdmin
+}
+
+// Init set the users that have the admin role whatever their session says, enable is false when
+// no authentication is configured, then every route is open like before authentication is set up.
+func Init(enable bool, admins []uint64) {
+	mu.Lock()
+	defer mu.Unlock()
+	enabled = enable
+	adminIDs = make(map[uint64]bool, len(admins))
+	for _, id := range admins {
+		adminIDs[id] = true
+	}
+}
+
+// CurrentSubject get the caller from the jwt claims or from the warden user of the rails session
+func CurrentSubject(c *gin.Context) (*Subject, bool) {
+	if v, ok := c.Get(subjectKey); ok {
+		s, ok := v.(*Subject)
+		return s, ok
+	}
+
+	s, ok := resolve(c)
+	if ok {
+		c.Set(subjectKey, s)
+		// the context of the request is what middleware.WrapCtx passes down to the dao
+		if c.Request != nil {
+			c.Request = c.Request.WithContext(WithSubject(c.Request.Context(), s))
+		}
+	}
+	return s, ok
+}
+
+// WithSubject return a copy of ctx carrying the caller
+func WithSubject(ctx context.Context, s *Subject) context.Context {
+	return context.WithValue(ctx, subjectCtxKey{}, s)
+}
+
+// SubjectFromContext get the caller put into ctx by CurrentSubject or WithSubject
+func SubjectFromContext(ctx context.Context) (*Subject, bool) {
+	s, ok := ctx.Value(subjectCtxKey{}).(*Subject)
+	return s, ok && s != nil
+}
+
+func resolve(c *gin.Context) (*Subject, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if claims, ok := middleware.GetClaims(c); ok {
+		id := utils.StrToUint64(claims.UID)
+		if id == 0 {
+			return nil, false
+		}
+		role := RoleUser
+		if r, _ := claims.GetString(RoleClaim); r == RoleAdmin || adminIDs[id] {
+			role = RoleAdmin
+		}
+		return &Subject{ID: id, Role: role, Source: SourceJWT}, true
+	}
+
+	id, ok := railsSessionUserID(c)
+	if !ok {
+		return nil, false
+	}
+	role := RoleUser
+	if adminIDs[id] {
+		role = RoleAdmin
+	}
+	return &Subject{ID: id, Role: role, Source: SourceRailsSession}, true
+}
+
+func railsSessionUserID(c *gin.Context) (uint64, bool) {
+	v, ok := c.Get("rails_session")
+	if !ok {
+		return 0, false
+	}
+	session, ok := v.(map[string]any)
+	if !ok {
+		return 0, false
+	}
+	uid, ok := rails.UserIDFromSession(session)
+	if !ok {
+		return 0, false
+	}
+	var id uint64
+	switch v := uid.(type) {
+	case int64:
+		id = uint64(v)
+	case int:
+		id = uint64(v)
+	case float64:
+		id = uint64(v)
+	case string:
+		id = utils.StrToUint64(v)
+	}
+	return id, id > 0
+}
+
+// Authorize the middleware of a route, it answers 401 when the caller is unknown and 403 when the rule refuses it
+func Authorize(rule Rule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mu.RLock()
+		open := !enabled
+		mu.RUnlock()
+		if open {
+			c.Next()
+			return
+		}
+
+		s, ok := CurrentSubject(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		if !rule(c, s) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,104 @@
+package policy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/go-dev-frame/sponge/pkg/jwt"
+)
+
+func runRoute(rule Rule, path string, url string, setCaller func(c *gin.Context)) int {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET(path, func(c *gin.Context) {
+		if setCaller != nil {
+			setCaller(c)
+		}
+		c.Next()
+	}, Authorize(rule), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	return w.Code
+}
+
+func jwtCaller(uid string, role string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.Set("claims", &jwt.Claims{UID: uid, Fields: map[string]interface{}{RoleClaim: role}})
+	}
+}
+
+func railsCaller(uid interface{}) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.Set("rails_session", map[string]any{"warden.user.user.key": []any{[]any{uid}, "salt"}})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	Init(true, []uint64{1137})
+	defer Init(false, nil)
+
+	// unknown caller
+	assert.Equal(t, http.StatusUnauthorized, runRoute(Authenticated(), "/users/:id", "/users/1", nil))
+
+	// own record only
+	assert.Equal(t, http.StatusOK, runRoute(SelfOrAdmin("id"), "/users/:id", "/users/1", jwtCaller("1", "")))
+	assert.Equal(t, http.StatusForbidden, runRoute(SelfOrAdmin("id"), "/users/:id", "/users/2", jwtCaller("1", "")))
+	assert.Equal(t, http.StatusOK, runRoute(SelfOrAdmin("id"), "/users/:id", "/users/2", railsCaller(float64(2))))
+	assert.Equal(t, http.StatusForbidden, runRoute(SelfOrAdmin("id"), "/users/:id", "/users/2", railsCaller("3")))
+
+	// admins by claim or by id, whatever the auth path
+	assert.Equal(t, http.StatusForbidden, runRoute(Admin(), "/users/list", "/users/list", jwtCaller("1", RoleUser)))
+	assert.Equal(t, http.StatusOK, runRoute(Admin(), "/users/list", "/users/list", jwtCaller("1", RoleAdmin)))
+	assert.Equal(t, http.StatusOK, runRoute(Admin(), "/users/list", "/users/list", jwtCaller("1137", "")))
+	assert.Equal(t, http.StatusOK, runRoute(Admin(), "/users/list", "/users/list", railsCaller(int64(1137))))
+	assert.Equal(t, http.StatusForbidden, runRoute(Admin(), "/users/list", "/users/list", railsCaller(int64(1))))
+
+	// without authentication every route is open
+	Init(false, nil)
+	assert.Equal(t, http.StatusOK, runRoute(Admin(), "/users/list", "/users/list", nil))
+}
+
+func TestCurrentSubject(t *testing.T) {
+	Init(true, nil)
+	defer Init(false, nil)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	_, ok := CurrentSubject(c)
+	assert.False(t, ok)
+
+	c.Set("claims", &jwt.Claims{UID: "0"})
+	_, ok = CurrentSubject(c)
+	assert.False(t, ok)
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/users/5", nil)
+	c.Set("claims", &jwt.Claims{UID: "5", Fields: map[string]interface{}{RoleClaim: RoleAdmin}})
+	s, ok := CurrentSubject(c)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(5), s.ID)
+	assert.Equal(t, SourceJWT, s.Source)
+	assert.True(t, s.IsAdmin())
+
+	// passed down with the context of the request
+	fromCtx, ok := SubjectFromContext(c.Request.Context())
+	assert.True(t, ok)
+	assert.Same(t, s, fromCtx)
+	_, ok = SubjectFromContext(context.Background())
+	assert.False(t, ok)
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	railsCaller("7")(c)
+	s, ok = CurrentSubject(c)
+	assert.True(t, ok)
+	assert.Equal(t, SourceRailsSession, s.Source)
+
+	var nilSubject *Subject
+	assert.False(t, nilSubject.IsAdmin())
+}
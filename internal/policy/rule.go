@@ -0,0 +1,48 @@
+package policy
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/go-dev-frame/sponge/pkg/utils"
+)
+
+// Rule decide whether the caller may run a route
+type Rule func(c *gin.Context, s *Subject) bool
+
+// Authenticated any authenticated caller
+func Authenticated() Rule {
+	return func(_ *gin.Context, _ *Subject) bool {
+		return true
+	}
+}
+
+// Admin only callers with the admin role
+func Admin() Rule {
+	return func(_ *gin.Context, s *Subject) bool {
+		return s.IsAdmin()
+	}
+}
+
+// Self only the users whose id is the path parameter, e.g. "id" of /users/:id
+func Self(param string) Rule {
+	return func(c *gin.Context, s *Subject) bool {
+		return utils.StrToUint64(c.Param(param)) == s.ID
+	}
+}
+
+// Any the caller passes one of the rules
+func Any(rules ...Rule) Rule {
+	return func(c *gin.Context, s *Subject) bool {
+		for _, rule := range rules {
+			if rule(c, s) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SelfOrAdmin the users of the path parameter or an admin
+func SelfOrAdmin(param string) Rule {
+	return Any(Self(param), Admin())
+}
@@ -0,0 +1,87 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type kafkaRESTPublisher struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewKafkaRESTPublisher publish to a kafka topic through the REST proxy API v2 at baseURL, such as the
+// Confluent REST Proxy or the Redpanda HTTP proxy. The key of a message is the record key so the messages
+// of a key go to the same partition and stay in order.
+func NewKafkaRESTPublisher(baseURL string, topic string) Publisher {
+	return &kafkaRESTPublisher{
+		endpoint: strings.TrimRight(baseURL, "/") + "/topics/" + url.PathEscape(topic),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type kafkaRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+type kafkaOffset struct {
+	Partition int     `json:"partition"`
+	Offset    int64   `json:"offset"`
+	ErrorCode *int    `json:"error_code"`
+	Error     *string `json:"error"`
+}
+
+// Publish the message as a json record, it is accepted once the proxy answers with its offset
+func (p *kafkaRESTPublisher) Publish(ctx context.Context, msg *Message) error {
+	body, err := json.Marshal(map[string][]kafkaRecord{
+		"records": {{Key: msg.Key, Value: msg.Data}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kafka rest proxy: %s %s", resp.Status, data)
+	}
+
+	result := struct {
+		Offsets []kafkaOffset `json:"offsets"`
+	}{}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+	if len(result.Offsets) != 1 {
+		return fmt.Errorf("kafka rest proxy: %d offsets for 1 record", len(result.Offsets))
+	}
+	if o := result.Offsets[0]; o.ErrorCode != nil {
+		reason := ""
+		if o.Error != nil {
+			reason = *o.Error
+		}
+		return fmt.Errorf("kafka rest proxy: error %d %s", *o.ErrorCode, reason)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKafkaRESTPublisher(t *testing.T) {
+	var path, contentType string
+	var body map[string][]map[string]interface{}
+	reply := `{"key_schema_id":null,"value_schema_id":null,"offsets":[{"partition":2,"offset":100,"error_code":null,"error":null}]}`
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(reply))
+	}))
+	defer srv.Close()
+
+	p := NewKafkaRESTPublisher(srv.URL+"/", "users")
+	err := p.Publish(context.Background(), &Message{Subject: "user.updated", Key: "1", ID: "users-outbox-7", Data: []byte(`{"offset":7}`)})
+	require.NoError(t, err)
+	assert.Equal(t, "/topics/users", path)
+	assert.Equal(t, "application/vnd.kafka.json.v2+json", contentType)
+	assert.Equal(t, map[string][]map[string]interface{}{
+		"records": {{"key": "1", "value": map[string]interface{}{"offset": float64(7)}}},
+	}, body)
+
+	// the record was refused
+	reply = `{"offsets":[{"partition":null,"offset":null,"error_code":50002,"error":"Kafka error"}]}`
+	err = p.Publish(context.Background(), &Message{Key: "1", Data: []byte(`{}`)})
+	assert.EqualError(t, err, "kafka rest proxy: error 50002 Kafka error")
+
+	// the proxy failed
+	status, reply = http.StatusNotFound, `{"error_code":40401,"message":"Topic not found."}`
+	err = p.Publish(context.Background(), &Message{Key: "1", Data: []byte(`{}`)})
+	assert.Error(t, err)
+}
@@ -0,0 +1,248 @@
+package publisher
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsTimeout bounds a publish when the context has no deadline
+const natsTimeout = 10 * time.Second
+
+type natsPublisher struct {
+	addr          string
+	user          string
+	pass          string
+	token         string
+	subjectPrefix string
+
+	mu    sync.Mutex
+	conn  net.Conn
+	r     *bufio.Reader
+	inbox string // prefix of the reply subjects the acks of the connection are sent to
+	seq   uint64
+}
+
+// natsPubAck the JetStream reply to a message published to a subject of a stream
+type natsPubAck struct {
+	Stream    string `json:"stream"`
+	Seq       uint64 `json:"seq"`
+	Duplicate bool   `json:"duplicate"`
+	Error     *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// NewNATSPublisher publish to a nats server with the client protocol, rawURL is nats://host:4222 with
+// user:pass@ or token@ for auth. The subject of a message is subjectPrefix followed by its subject, a
+// JetStream stream must capture the subjects, it stores each message once since the message id is sent
+// as Nats-Msg-Id.
+func NewNATSPublisher(rawURL string, subjectPrefix string) (Publisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("nats url %q must be nats://host:port", rawURL)
+	}
+	p := &natsPublisher{addr: u.Host, subjectPrefix: subjectPrefix}
+	if u.Port() == "" {
+		p.addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			p.user, p.pass = u.User.Username(), pass
+		} else {
+			p.token = u.User.Username()
+		}
+	}
+	return p, nil
+}
+
+// Publish a message with a reply subject and wait for the JetStream ack, the stream has then stored it.
+// The connection is made on the first publish and again after an error.
+func (p *natsPublisher) Publish(ctx context.Context, msg *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(natsTimeout)
+	}
+	err := p.publish(ctx, deadline, msg)
+	if err != nil && p.conn != nil {
+		_ = p.conn.Close()
+		p.conn = nil
+	}
+	return err
+}
+
+func (p *natsPublisher) publish(ctx context.Context, deadline time.Time, msg *Message) error {
+	if p.conn == nil {
+		if err := p.connect(ctx, deadline); err != nil {
+			return err
+		}
+	} else if err := p.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	subject := p.subjectPrefix + msg.Subject
+	p.seq++
+	reply := p.inbox + strconv.FormatUint(p.seq, 10)
+	var buf strings.Builder
+	if msg.ID != "" {
+		header := "NATS/1.0\r\nNats-Msg-Id: " + msg.ID + "\r\n\r\n"
+		fmt.Fprintf(&buf, "HPUB %s %s %d %d\r\n%s", subject, reply, len(header), len(header)+len(msg.Data), header)
+	} else {
+		fmt.Fprintf(&buf, "PUB %s %s %d\r\n", subject, reply, len(msg.Data))
+	}
+	buf.Write(msg.Data)
+	buf.WriteString("\r\n")
+	if _, err := p.conn.Write([]byte(buf.String())); err != nil {
+		return err
+	}
+
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "MSG ") || strings.HasPrefix(line, "HMSG "):
+			ackSubject, header, data, err := p.readMsg(line)
+			if err != nil {
+				return err
+			}
+			// the ack of a publish given up on before
+			if ackSubject != reply {
+				continue
+			}
+			return natsAckError(subject, header, data)
+		case line == "PING":
+			if _, err = p.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("nats: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK, PONG and INFO updates
+	}
+}
+
+// readMsg read the payload of the MSG or HMSG line, its subject, header and data are returned
+func (p *natsPublisher) readMsg(line string) (string, string, []byte, error) {
+	// MSG <subject> <sid> [reply] <size>, HMSG <subject> <sid> [reply] <header size> <size>
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return "", "", nil, fmt.Errorf("nats: unexpected %q from the server", line)
+	}
+	size, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		return "", "", nil, fmt.Errorf("nats: unexpected %q from the server", line)
+	}
+	headerSize := 0
+	if fields[0] == "HMSG" {
+		if headerSize, err = strconv.Atoi(fields[len(fields)-2]); err != nil || headerSize > size {
+			return "", "", nil, fmt.Errorf("nats: unexpected %q from the server", line)
+		}
+	}
+	payload := make([]byte, size+2)
+	if _, err = io.ReadFull(p.r, payload); err != nil {
+		return "", "", nil, err
+	}
+	return fields[1], string(payload[:headerSize]), payload[headerSize:size], nil
+}
+
+// natsAckError the error of the JetStream ack of a message published to subject, nil when it was stored
+func natsAckError(subject string, header string, data []byte) error {
+	// a status instead of an ack, 503 when no stream captures the subject
+	if status := strings.Fields(strings.SplitN(header, "\r\n", 2)[0]); len(status) > 1 {
+		if status[1] == "503" {
+			return fmt.Errorf("nats: no JetStream stream for subject %s", subject)
+		}
+		return fmt.Errorf("nats: publish to %s replied %s", subject, strings.Join(status[1:], " "))
+	}
+	ack := &natsPubAck{}
+	if err := json.Unmarshal(data, ack); err != nil {
+		return fmt.Errorf("nats: invalid JetStream ack %q", data)
+	}
+	if ack.Error != nil {
+		return fmt.Errorf("nats: %s (%d)", ack.Error.Description, ack.Error.Code)
+	}
+	if ack.Stream == "" {
+		return fmt.Errorf("nats: invalid JetStream ack %q", data)
+	}
+	return nil
+}
+
+// connect read the INFO of the server, send CONNECT and subscribe to the inbox of the acks
+func (p *natsPublisher) connect(ctx context.Context, deadline time.Time) error {
+	dialer := &net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return err
+	}
+	p.conn, p.r = conn, bufio.NewReader(conn)
+	if err = conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("nats: unexpected %q from the server", line)
+	}
+	info := struct {
+		Headers bool `json:"headers"`
+	}{}
+	if err = json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+		return err
+	}
+	// a server without headers has no JetStream either
+	if !info.Headers {
+		return errors.New("nats: the server does not support headers, JetStream is needed")
+	}
+
+	id := make([]byte, 12)
+	if _, err = rand.Read(id); err != nil {
+		return err
+	}
+	p.inbox, p.seq = "_INBOX."+hex.EncodeToString(id)+".", 0
+
+	options, _ := json.Marshal(map[string]interface{}{
+		"verbose":       false,
+		"pedantic":      false,
+		"name":          "user_server",
+		"lang":          "go",
+		"version":       "1.0.0",
+		"protocol":      1,
+		"headers":       true,
+		"no_responders": true,
+		"user":          p.user,
+		"pass":          p.pass,
+		"auth_token":    p.token,
+	})
+	_, err = conn.Write([]byte("CONNECT " + string(options) + "\r\nSUB " + p.inbox + "* 1\r\n"))
+	return err
+}
+
+func (p *natsPublisher) readLine() (string, error) {
+	line, err := p.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
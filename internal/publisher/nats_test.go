@@ -0,0 +1,155 @@
+package publisher
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// natsPublished a message received by the fake nats server
+type natsPublished struct {
+	op      string
+	subject string
+	reply   string
+	header  string
+	payload string
+}
+
+// natsMsg the MSG of data sent to the reply subject of a publish
+func natsMsg(reply string, data string) string {
+	return "MSG " + reply + " 1 " + strconv.Itoa(len(data)) + "\r\n" + data + "\r\n"
+}
+
+// natsStored the JetStream ack of a message stored by the stream
+func natsStored(reply string) string {
+	return natsMsg(reply, `{"stream":"HR","seq":1}`)
+}
+
+// fakeNATSServer accept one connection, read the CONNECT and the SUB of the inbox and answer each
+// PUB or HPUB with ack of its reply subject, the published messages are sent to the returned channel
+func fakeNATSServer(t *testing.T, headers bool, ack func(reply string) string) (string, <-chan natsPublished, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	published := make(chan natsPublished, 10)
+	connects := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close() //nolint
+		r := bufio.NewReader(conn)
+		_, _ = conn.Write([]byte(`INFO {"server_id":"fake","version":"2.10.0","headers":` + strconv.FormatBool(headers) + "}\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "CONNECT":
+				connects <- strings.TrimSpace(strings.TrimPrefix(line, "CONNECT"))
+			case "PUB", "HPUB":
+				// PUB <subject> <reply> <size>, HPUB <subject> <reply> <header size> <size>
+				msg := natsPublished{op: fields[0], subject: fields[1], reply: fields[2]}
+				size, _ := strconv.Atoi(fields[len(fields)-1])
+				data := make([]byte, size+2)
+				if _, err = io.ReadFull(r, data); err != nil {
+					return
+				}
+				body := string(data[:size])
+				if fields[0] == "HPUB" {
+					headerSize, _ := strconv.Atoi(fields[3])
+					msg.header, body = body[:headerSize], body[headerSize:]
+				}
+				msg.payload = body
+				published <- msg
+				_, _ = conn.Write([]byte(ack(msg.reply)))
+			}
+		}
+	}()
+	return ln.Addr().String(), published, connects
+}
+
+func TestNATSPublisher(t *testing.T) {
+	addr, published, connects := fakeNATSServer(t, true, func(reply string) string {
+		// a stale ack and a PING come before the ack of the message
+		return "+OK\r\nPING\r\n" + natsStored(reply+"0") + natsStored(reply)
+	})
+	p, err := NewNATSPublisher("nats://secret@"+addr, "hr.")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = p.Publish(ctx, &Message{Subject: "user.updated", Key: "1", ID: "users-outbox-7", Data: []byte(`{"offset":7}`)})
+	require.NoError(t, err)
+
+	connect := <-connects
+	assert.Contains(t, connect, `"auth_token":"secret"`)
+	assert.Contains(t, connect, `"no_responders":true`)
+	msg := <-published
+	assert.Equal(t, "HPUB", msg.op)
+	assert.Equal(t, "hr.user.updated", msg.subject)
+	assert.True(t, strings.HasPrefix(msg.reply, "_INBOX."))
+	assert.Equal(t, "NATS/1.0\r\nNats-Msg-Id: users-outbox-7\r\n\r\n", msg.header)
+	assert.Equal(t, `{"offset":7}`, msg.payload)
+
+	// the connection is reused once the deadline of the first publish has passed
+	time.Sleep(300 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = p.Publish(ctx, &Message{Subject: "user.deleted", Key: "1", Data: []byte(`{"offset":8}`)})
+	require.NoError(t, err)
+	msg = <-published
+	assert.Equal(t, "PUB", msg.op)
+	assert.Equal(t, "hr.user.deleted", msg.subject)
+	assert.Empty(t, connects)
+}
+
+func TestNATSPublisher_withoutHeaders(t *testing.T) {
+	addr, _, _ := fakeNATSServer(t, false, natsStored)
+	p, err := NewNATSPublisher("nats://user:pass@"+addr, "")
+	require.NoError(t, err)
+
+	err = p.Publish(context.Background(), &Message{Subject: "user.created", ID: "users-outbox-1", Data: []byte(`{}`)})
+	assert.EqualError(t, err, "nats: the server does not support headers, JetStream is needed")
+}
+
+func TestNATSPublisher_error(t *testing.T) {
+	for _, c := range []struct {
+		ack func(reply string) string
+		err string
+	}{
+		{func(string) string { return "-ERR 'Authorization Violation'\r\n" }, "nats: 'Authorization Violation'"},
+		{func(reply string) string { return "HMSG " + reply + " 1 16 16\r\nNATS/1.0 503\r\n\r\n\r\n" },
+			"nats: no JetStream stream for subject user.created"},
+		{func(reply string) string {
+			return natsMsg(reply, `{"error":{"code":400,"err_code":10060,"description":"expected stream"}}`)
+		}, "nats: expected stream (400)"},
+	} {
+		addr, _, _ := fakeNATSServer(t, true, c.ack)
+		p, err := NewNATSPublisher("nats://"+addr, "")
+		require.NoError(t, err)
+		err = p.Publish(context.Background(), &Message{Subject: "user.created", ID: "users-outbox-1", Data: []byte(`{}`)})
+		assert.EqualError(t, err, c.err)
+
+		// the connection is closed after an error, the fake server only accepts one
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		err = p.Publish(ctx, &Message{Subject: "user.created", Data: []byte(`{}`)})
+		cancel()
+		assert.Error(t, err)
+	}
+
+	_, err := NewNATSPublisher("http://127.0.0.1:4222", "")
+	assert.Error(t, err)
+}
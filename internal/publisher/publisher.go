@@ -0,0 +1,64 @@
+// Package publisher delivers the users events of the outbox to a message broker.
+package publisher
+
+import (
+	"context"
+	"sync"
+)
+
+// Message an event to publish
+type Message struct {
+	Subject string // event type, such as user.updated
+	Key     string // messages of a key are delivered in the order they are published, the users id
+	ID      string // unique id of the message, brokers that deduplicate use it to drop a redelivery
+	Data    []byte // json
+}
+
+// Publisher publish messages, Publish returns once the broker has accepted the message so that a
+// message that is not acknowledged is published again
+type Publisher interface {
+	Publish(ctx context.Context, msg *Message) error
+}
+
+// MemoryPublisher keep the messages in memory, for development and tests
+type MemoryPublisher struct {
+	mu       sync.Mutex
+	messages []*Message
+	err      error
+	failKeys map[string]bool
+}
+
+// NewMemoryPublisher creates a publisher keeping the messages in memory
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+// Publish append the message, or return the error set by Fail
+func (p *MemoryPublisher) Publish(_ context.Context, msg *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil && (len(p.failKeys) == 0 || p.failKeys[msg.Key]) {
+		return p.err
+	}
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+// Fail make Publish return err for the messages of keys, of any key when none is given, until it is
+// called with a nil err
+func (p *MemoryPublisher) Fail(err error, keys ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+	p.failKeys = make(map[string]bool, len(keys))
+	for _, key := range keys {
+		p.failKeys[key] = true
+	}
+}
+
+// Messages the messages published so far
+func (p *MemoryPublisher) Messages() []*Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*Message{}, p.messages...)
+}
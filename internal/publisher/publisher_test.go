@@ -0,0 +1,34 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryPublisher(t *testing.T) {
+	p := NewMemoryPublisher()
+	ctx := context.Background()
+
+	assert.NoError(t, p.Publish(ctx, &Message{Subject: "user.created", Key: "1"}))
+
+	// failing the messages of a key
+	p.Fail(errors.New("broker down"), "2")
+	assert.Error(t, p.Publish(ctx, &Message{Subject: "user.updated", Key: "2"}))
+	assert.NoError(t, p.Publish(ctx, &Message{Subject: "user.updated", Key: "3"}))
+
+	// failing all of them
+	p.Fail(errors.New("broker down"))
+	assert.Error(t, p.Publish(ctx, &Message{Subject: "user.updated", Key: "3"}))
+
+	p.Fail(nil)
+	assert.NoError(t, p.Publish(ctx, &Message{Subject: "user.deleted", Key: "2"}))
+
+	var subjects []string
+	for _, msg := range p.Messages() {
+		subjects = append(subjects, msg.Subject+" "+msg.Key)
+	}
+	assert.Equal(t, []string{"user.created 1", "user.updated 3", "user.deleted 2"}, subjects)
+}
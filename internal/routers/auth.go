@@ -0,0 +1,29 @@
+package routers
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"test-user-server/internal/config"
+	"test-user-server/internal/handler"
+)
+
+func init() {
+	apiV1RouterFns = append(apiV1RouterFns, func(group *gin.RouterGroup) {
+		authRouter(group, handler.NewAuthHandler())
+	})
+}
+
+func authRouter(group *gin.RouterGroup, h handler.AuthHandler) {
+	// tokens can only be issued when a signing key is configured, see initial.InitApp
+	if config.Get().JWT.SigningKey != "change-me" {
+		g := group.Group("/auth")
+		g.POST("/sign_in", h.SignIn) // [post] /api/v1/auth/sign_in
+	}
+
+	// reachable without a token, the users cannot sign in yet
+	u := group.Group("/users")
+	u.POST("/unlock", h.Unlock)                  // [post] /api/v1/users/unlock
+	u.POST("/password/forgot", h.ForgotPassword) // [post] /api/v1/users/password/forgot
+	u.PUT("/password/reset", h.ResetPassword)    // [put] /api/v1/users/password/reset
+	u.GET("/confirmation", h.Confirm)            // [get] /api/v1/users/confirmation
+}
@@ -0,0 +1,29 @@
+package routers
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"test-user-server/internal/handler"
+	"test-user-server/internal/policy"
+)
+
+func init() {
+	apiV1RouterFns = append(apiV1RouterFns, func(group *gin.RouterGroup) {
+		invitationRouter(group, handler.NewInvitationHandler())
+	})
+}
+
+func invitationRouter(group *gin.RouterGroup, h handler.InvitationHandler) {
+	g := group.Group("/users")
+	usersAuth(g)
+
+	authenticated := policy.Authorize(policy.Authenticated())
+
+	g.POST("/invitation", authenticated, h.Invite)        // [post] /api/v1/users/invitation
+	g.POST("/invitation/resend", authenticated, h.Resend) // [post] /api/v1/users/invitation/resend
+	g.GET("/invitees", authenticated, h.ListInvitees)     // [get] /api/v1/users/invitees
+
+	// reachable without a token, the invitee has no password yet
+	u := group.Group("/users")
+	u.PUT("/invitation/accept", h.Accept) // [put] /api/v1/users/invitation/accept
+}
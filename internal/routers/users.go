@@ -3,11 +3,10 @@ package routers
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-dev-frame/sponge/pkg/gin/middleware"
-	"github.com/go-dev-frame/sponge/pkg/jwt"
-	"github.com/go-dev-frame/sponge/pkg/logger"
 
 	"test-user-server/internal/config"
 	"test-user-server/internal/handler"
+	"test-user-server/internal/policy"
 )
 
 func init() {
@@ -18,35 +17,81 @@ func init() {
 
 func usersRouter(group *gin.RouterGroup, h handler.UsersHandler) {
 	g := group.Group("/users")
+	usersAuth(g)
+
+	// If jwt authentication is not required for all routes, authentication middleware can be added
+	// separately for only certain routes. In this case, usersAuth(g) above should not be used.
+
+	// anyone authenticated may read their own record, users change it only through /me and /:id/password,
+	// which bind the columns they may set, the rest is for admins
+	self, admin := policy.Authorize(policy.SelfOrAdmin("id")), policy.Authorize(policy.Admin())
+	authenticated := policy.Authorize(policy.Authenticated())
+
+	g.GET("/me", authenticated, h.GetMe)    // [get] /api/v1/users/me
+	g.PUT("/me", authenticated, h.UpdateMe) // [put] /api/v1/users/me
+
+	g.POST("/", admin, h.Create)          // [post] /api/v1/users
+	g.DELETE("/:id", admin, h.DeleteByID) // [delete] /api/v1/users/:id
+	g.PUT("/:id", admin, h.UpdateByID)    // [put] /api/v1/users/:id
+	g.PATCH("/:id", admin, h.PatchByID)   // [patch] /api/v1/users/:id, can clear the devise columns
+	g.GET("/:id", self, h.GetByID)        // [get] /api/v1/users/:id
+	g.POST("/list", admin, h.List)        // [post] /api/v1/users/list
+
+	g.POST("/delete/ids", admin, h.DeleteByIDs)     // [post] /api/v1/users/delete/ids
+	g.POST("/condition", admin, h.GetByCondition)   // [post] /api/v1/users/condition
+	g.POST("/list/ids", admin, h.ListByIDs)         // [post] /api/v1/users/list/ids
+	g.GET("/list", admin, h.ListByLastID)           // [get] /api/v1/users/list
+	g.GET("/by/:key/:value", admin, h.GetByKey)     // [get] /api/v1/users/by/:key/:value
+	g.GET("/search", admin, h.Search)               // [get] /api/v1/users/search
+	g.POST("/import", admin, h.Import)              // [post] /api/v1/users/import
+	g.GET("/export", admin, h.Export)               // [get] /api/v1/users/export
+	g.GET("/trash", admin, h.ListTrash)             // [get] /api/v1/users/trash
+	g.POST("/events/replay", admin, h.ReplayEvents) // [post] /api/v1/users/events/replay
+
+	g.POST("/:id/password", self, h.ChangePassword) // [post] /api/v1/users/:id/password
+	g.POST("/:id/restore", admin, h.Restore)        // [post] /api/v1/users/:id/restore
+	g.GET("/:id/history", admin, h.History)         // [get] /api/v1/users/:id/history
+
+	g.POST("/webhooks", admin, h.CreateWebhook)                                         // [post] /api/v1/users/webhooks
+	g.GET("/webhooks", admin, h.ListWebhooks)                                           // [get] /api/v1/users/webhooks
+	g.GET("/webhooks/:id", admin, h.GetWebhook)                                         // [get] /api/v1/users/webhooks/:id
+	g.PUT("/webhooks/:id", admin, h.UpdateWebhook)                                      // [put] /api/v1/users/webhooks/:id
+	g.DELETE("/webhooks/:id", admin, h.DeleteWebhook)                                   // [delete] /api/v1/users/webhooks/:id
+	g.GET("/webhooks/:id/deliveries", admin, h.ListWebhookDeliveries)                   // [get] /api/v1/users/webhooks/:id/deliveries
+	g.POST("/webhooks/:id/deliveries/:deliveryID/redeliver", admin, h.RedeliverWebhook) // [post] /api/v1/users/webhooks/:id/deliveries/:deliveryID/redeliver
+
+	g.GET("/:id/versions", admin, h.ListVersions)               // [get] /api/v1/users/:id/versions
+	g.GET("/:id/versions/:version/diff", admin, h.DiffVersions) // [get] /api/v1/users/:id/versions/:version/diff
+	g.POST("/:id/versions/:version/revert", admin, h.Revert)    // [post] /api/v1/users/:id/versions/:version/revert
+}
+
+// usersAuth add the authentication of signed in users, a bearer token is verified as a jwt and any other
+// request must carry the rails session cookie, who may do what is decided by policy.Authorize on each route
+func usersAuth(g *gin.RouterGroup) {
+	var jwtAuth, railsAuth gin.HandlerFunc
 
 	// not change-me signing key will make routes use jwt authentication
 	jwtCfg := config.Get().JWT
 	if jwtCfg.SigningKey != "change-me" {
-		g.Use(middleware.Auth(
-			middleware.WithSignKey([]byte(jwtCfg.SigningKey)),
-			middleware.WithExtraVerify(func(claims *jwt.Claims, c *gin.Context) error {
-				logger.Info("middleware.Auth", logger.Any("claims", claims))
-				return nil
-			}),
-		))
+		jwtAuth = middleware.Auth(middleware.WithSignKey([]byte(jwtCfg.SigningKey)))
 	}
 	railsCfg := config.Get().Rails
 	if railsCfg.SecretKeyBase != "change-me" {
-		g.Use(middleware.RailsCookieAuthMiddleware(railsCfg.SecretKeyBase, railsCfg.CookieName))
-		g.Use(VerifyRailsSessionUserIdIs(railsCfg.UserID))
+		railsAuth = middleware.RailsCookieAuthMiddleware(railsCfg.SecretKeyBase, railsCfg.CookieName)
 	}
 
-	// If jwt authentication is not required for all routes, authentication middleware can be added
-	// separately for only certain routes. In this case, g.Use(middleware.Auth()) above should not be used.
-
-	g.POST("/", h.Create)          // [post] /api/v1/users
-	g.DELETE("/:id", h.DeleteByID) // [delete] /api/v1/users/:id
-	g.PUT("/:id", h.UpdateByID)    // [put] /api/v1/users/:id
-	g.GET("/:id", h.GetByID)       // [get] /api/v1/users/:id
-	g.POST("/list", h.List)        // [post] /api/v1/users/list
-
-	g.POST("/delete/ids", h.DeleteByIDs)   // [post] /api/v1/users/delete/ids
-	g.POST("/condition", h.GetByCondition) // [post] /api/v1/users/condition
-	g.POST("/list/ids", h.ListByIDs)       // [post] /api/v1/users/list/ids
-	g.GET("/list", h.ListByLastID)         // [get] /api/v1/users/list
+	switch {
+	case jwtAuth != nil && railsAuth != nil:
+		g.Use(func(c *gin.Context) {
+			if c.GetHeader("Authorization") != "" {
+				jwtAuth(c)
+			} else {
+				railsAuth(c)
+			}
+		})
+	case jwtAuth != nil:
+		g.Use(jwtAuth)
+	case railsAuth != nil:
+		g.Use(railsAuth)
+	}
 }
@@ -0,0 +1,264 @@
+// Package search is an in-process people search index, the documents are scored by how well each
+// term of a query matches their fields, chinese text also matches by its pinyin and initials.
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+)
+
+// scores of the ways a term matches a value, multiplied by the weight of the field
+const (
+	scoreExact     = 10.0
+	scorePrefix    = 6.0
+	scoreSubstring = 3.0
+	scoreFuzzy     = 1.0
+
+	// terms shorter than this are not matched with a typo, it would match almost anything
+	fuzzyMinLength = 4
+)
+
+// Field a searchable field of the documents
+type Field struct {
+	Name   string
+	Weight float64
+	Pinyin bool // the chinese characters also match by their full pinyin and initials
+}
+
+// Document the values of the searchable fields of a record by field name
+type Document struct {
+	ID     uint64
+	Values map[string]string
+}
+
+// Hit a matching document
+type Hit struct {
+	ID    uint64
+	Score float64
+}
+
+type entry struct {
+	values   []string // lower case, in the order of the fields
+	pinyin   []string // full pinyin of the pinyin fields, empty for the others
+	initials []string // pinyin initials of the pinyin fields, empty for the others
+}
+
+// Index the documents of a table, Rebuild loads all of them, Put and Delete keep them fresh and
+// Invalidate marks the ones whose record changed to be reloaded by the owner
+type Index struct {
+	fields []Field
+
+	mu      sync.RWMutex
+	entries map[uint64]*entry
+	builtAt time.Time
+	dirty   map[uint64]bool
+}
+
+// NewIndex create an empty index of the given fields
+func NewIndex(fields ...Field) *Index {
+	return &Index{
+		fields:  fields,
+		entries: map[uint64]*entry{},
+		dirty:   map[uint64]bool{},
+	}
+}
+
+// Fields the names of the indexed fields
+func (x *Index) Fields() []string {
+	names := make([]string, 0, len(x.fields))
+	for _, f := range x.fields {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// BuiltAt time of the last Rebuild, zero when it was never built
+func (x *Index) BuiltAt() time.Time {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.builtAt
+}
+
+// Rebuild replace all the documents
+func (x *Index) Rebuild(docs []Document) {
+	entries := make(map[uint64]*entry, len(docs))
+	for _, doc := range docs {
+		entries[doc.ID] = x.newEntry(doc)
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.entries = entries
+	x.builtAt = time.Now()
+}
+
+// Put add or replace a document
+func (x *Index) Put(doc Document) {
+	e := x.newEntry(doc)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.entries[doc.ID] = e
+}
+
+// Delete remove a document
+func (x *Index) Delete(id uint64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.entries, id)
+}
+
+// Invalidate mark documents as changed
+func (x *Index) Invalidate(ids ...uint64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, id := range ids {
+		x.dirty[id] = true
+	}
+}
+
+// TakeDirty get and clear the ids marked by Invalidate
+func (x *Index) TakeDirty() []uint64 {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	ids := make([]uint64, 0, len(x.dirty))
+	for id := range x.dirty {
+		ids = append(ids, id)
+	}
+	x.dirty = map[uint64]bool{}
+	return ids
+}
+
+func (x *Index) newEntry(doc Document) *entry {
+	e := &entry{
+		values:   make([]string, len(x.fields)),
+		pinyin:   make([]string, len(x.fields)),
+		initials: make([]string, len(x.fields)),
+	}
+	for i, f := range x.fields {
+		value := doc.Values[f.Name]
+		e.values[i] = strings.ToLower(strings.TrimSpace(value))
+		if f.Pinyin {
+			e.pinyin[i], e.initials[i] = toPinyin(value)
+		}
+	}
+	return e
+}
+
+var pinyinArgs = pinyin.NewArgs()
+
+// toPinyin get the full pinyin and the initials of the chinese characters of s, 张三 is zhangsan and zs
+func toPinyin(s string) (string, string) {
+	syllables := pinyin.LazyPinyin(s, pinyinArgs)
+	if len(syllables) == 0 {
+		return "", ""
+	}
+	var initials strings.Builder
+	for _, syllable := range syllables {
+		initials.WriteByte(syllable[0])
+	}
+	return strings.Join(syllables, ""), initials.String()
+}
+
+// Search score the documents matching every term of q, the hits are sorted by score then id
+func (x *Index) Search(q string) []Hit {
+	terms := strings.FieldsFunc(strings.ToLower(q), unicode.IsSpace)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	hits := []Hit{}
+	for id, e := range x.entries {
+		total := 0.0
+		for _, term := range terms {
+			score := x.scoreTerm(e, term)
+			if score == 0 {
+				total = 0
+				break
+			}
+			total += score
+		}
+		if total > 0 {
+			hits = append(hits, Hit{ID: id, Score: total})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits
+}
+
+// scoreTerm the best score of a term over the fields of an entry
+func (x *Index) scoreTerm(e *entry, term string) float64 {
+	best := 0.0
+	for i, f := range x.fields {
+		score := matchScore(e.values[i], term)
+		if f.Pinyin {
+			// the pinyin matches rank below the characters themselves
+			score = max(score, matchScore(e.pinyin[i], term)*0.9, matchScore(e.initials[i], term)*0.8)
+		}
+		best = max(best, score*f.Weight)
+	}
+	return best
+}
+
+func matchScore(value string, term string) float64 {
+	switch {
+	case value == "":
+		return 0
+	case value == term:
+		return scoreExact
+	case strings.HasPrefix(value, term):
+		return scorePrefix
+	case strings.Contains(value, term):
+		return scoreSubstring
+	}
+
+	if len([]rune(term)) < fuzzyMinLength {
+		return 0
+	}
+	for _, word := range strings.FieldsFunc(value, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if withinOneEdit(word, term) {
+			return scoreFuzzy
+		}
+	}
+	return 0
+}
+
+// withinOneEdit report whether a and b differ by at most one inserted, deleted or replaced rune
+func withinOneEdit(a string, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(ra)-len(rb) > 1 {
+		return false
+	}
+	i, j, edits := 0, 0, 0
+	for i < len(ra) && j < len(rb) {
+		if ra[i] == rb[j] {
+			i, j = i+1, j+1
+			continue
+		}
+		edits++
+		if edits > 1 {
+			return false
+		}
+		if len(ra) == len(rb) {
+			j++
+		}
+		i++
+	}
+	return edits+len(ra)-i+len(rb)-j <= 1
+}
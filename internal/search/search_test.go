@@ -0,0 +1,98 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestIndex() *Index {
+	x := NewIndex(
+		Field{Name: "email", Weight: 3},
+		Field{Name: "chinese_name", Weight: 3, Pinyin: true},
+		Field{Name: "position_title", Weight: 1},
+	)
+	x.Rebuild([]Document{
+		{ID: 1, Values: map[string]string{"email": "zhangsan@example.com", "chinese_name": "张三", "position_title": "Engineer"}},
+		{ID: 2, Values: map[string]string{"email": "lisi@example.com", "chinese_name": "李四", "position_title": "Senior Engineer"}},
+		{ID: 3, Values: map[string]string{"email": "zs@example.com", "chinese_name": "赵四", "position_title": "Manager"}},
+	})
+	return x
+}
+
+func ids(hits []Hit) []uint64 {
+	var ids []uint64
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
+
+func TestToPinyin(t *testing.T) {
+	full, initials := toPinyin("张三")
+	assert.Equal(t, "zhangsan", full)
+	assert.Equal(t, "zs", initials)
+
+	full, initials = toPinyin("Tom")
+	assert.Empty(t, full)
+	assert.Empty(t, initials)
+}
+
+func TestIndex_Search(t *testing.T) {
+	x := newTestIndex()
+	assert.False(t, x.BuiltAt().IsZero())
+
+	// the initials of 张三 and 赵四, the ties are sorted by id
+	assert.Equal(t, []uint64{1, 3}, ids(x.Search("zs")))
+	assert.Equal(t, []uint64{1}, ids(x.Search("zhangsan")))
+	assert.Equal(t, []uint64{1}, ids(x.Search("张")))
+	assert.Equal(t, []uint64{1, 2}, ids(x.Search("engineer")))
+
+	// every term must match
+	assert.Equal(t, []uint64{2}, ids(x.Search("senior  ENGINEER")))
+	assert.Empty(t, x.Search("senior manager"))
+	assert.Empty(t, x.Search("  "))
+
+	// one typo
+	assert.Equal(t, []uint64{3}, ids(x.Search("managr")))
+	assert.Empty(t, x.Search("mangr"))
+
+	// exact above substring
+	hits := x.Search("engineer")
+	assert.Greater(t, hits[0].Score, hits[1].Score)
+}
+
+func TestIndex_PutDelete(t *testing.T) {
+	x := newTestIndex()
+
+	x.Put(Document{ID: 4, Values: map[string]string{"chinese_name": "王五"}})
+	assert.Equal(t, []uint64{4}, ids(x.Search("ww")))
+
+	x.Put(Document{ID: 4, Values: map[string]string{"chinese_name": "王六"}})
+	assert.Empty(t, x.Search("ww"))
+
+	x.Delete(4)
+	assert.Empty(t, x.Search("wl"))
+
+	x.Invalidate(1, 2, 1)
+	assert.ElementsMatch(t, []uint64{1, 2}, x.TakeDirty())
+	assert.Empty(t, x.TakeDirty())
+}
+
+func TestWithinOneEdit(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"manager", "manager", true},
+		{"manager", "managr", true},
+		{"manager", "manoger", true},
+		{"manager", "managers", true},
+		{"manager", "mangr", false},
+		{"manager", "regamam", false},
+		{"张三丰", "张三", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, withinOneEdit(tt.a, tt.b), tt.a+" "+tt.b)
+	}
+}
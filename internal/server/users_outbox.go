@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/app"
+	"github.com/go-dev-frame/sponge/pkg/logger"
+
+	"test-user-server/internal/dao"
+	"test-user-server/internal/model"
+	"test-user-server/internal/publisher"
+)
+
+// usersOutboxBatchSize events claimed and published at a time
+const usersOutboxBatchSize = 100
+
+var _ app.IServer = (*usersOutboxRelay)(nil)
+
+// usersOutboxRelay publish the events of the users outbox in offset order, an event is marked published
+// only once the publisher has accepted it so it is delivered at least once. After an event of a users
+// fails the later ones of that users are left for the next poll, the events of a users stay in order.
+type usersOutboxRelay struct {
+	iDao      dao.UsersDao
+	publisher publisher.Publisher
+	interval  time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewUsersOutboxRelay creates the relay of the outbox, it polls at start and then every interval
+func NewUsersOutboxRelay(iDao dao.UsersDao, p publisher.Publisher, interval time.Duration) app.IServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &usersOutboxRelay{
+		iDao:      iDao,
+		publisher: p,
+		interval:  interval,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Start relay until Stop
+func (s *usersOutboxRelay) Start() error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.relay()
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop the relay, the events being published are released and published again by the next relay
+func (s *usersOutboxRelay) Stop() error {
+	s.cancel()
+	return nil
+}
+
+// String provides a human readable description of the relay.
+func (s *usersOutboxRelay) String() string {
+	return "users outbox relay every " + s.interval.String()
+}
+
+// relay publish batches until one is not full or nothing of it could be published
+func (s *usersOutboxRelay) relay() {
+	for s.ctx.Err() == nil {
+		published := 0
+		n, err := s.iDao.PublishEvents(s.ctx, usersOutboxBatchSize, func(ctx context.Context, events []*model.UsersOutbox) []uint64 {
+			ids := s.publish(ctx, events)
+			published = len(ids)
+			return ids
+		})
+		if err != nil {
+			if s.ctx.Err() == nil {
+				logger.Error("PublishEvents error", logger.Err(err))
+			}
+			return
+		}
+		if n < usersOutboxBatchSize || published == 0 {
+			return
+		}
+	}
+}
+
+// publish the events in order until ctx ends, the ids of the published ones are returned
+func (s *usersOutboxRelay) publish(ctx context.Context, events []*model.UsersOutbox) []uint64 {
+	blocked := map[uint64]bool{}
+	ids := make([]uint64, 0, len(events))
+	for _, event := range events {
+		if blocked[event.UsersID] {
+			continue
+		}
+		msg, err := usersEventMessage(event)
+		if err == nil {
+			err = s.publisher.Publish(ctx, msg)
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warn("publish users event error", logger.Err(err), logger.Uint64("offset", event.ID), logger.Uint64("usersID", event.UsersID))
+			}
+			blocked[event.UsersID] = true
+			continue
+		}
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+// usersEventMessage the message of an outbox event, the payload with its offset
+func usersEventMessage(event *model.UsersOutbox) (*publisher.Message, error) {
+	data := &model.UsersEvent{}
+	if err := json.Unmarshal([]byte(event.Payload), data); err != nil {
+		return nil, err
+	}
+	data.Offset = event.ID
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &publisher.Message{
+		Subject: event.Event,
+		Key:     strconv.FormatUint(event.UsersID, 10),
+		ID:      "users-outbox-" + strconv.FormatUint(event.ID, 10),
+		Data:    b,
+	}, nil
+}
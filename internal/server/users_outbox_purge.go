@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/app"
+	"github.com/go-dev-frame/sponge/pkg/logger"
+
+	"test-user-server/internal/dao"
+)
+
+// usersOutboxPurgeBatchSize events deleted by a statement, a purge keeps deleting until a batch is not full
+const usersOutboxPurgeBatchSize = 1000
+
+var _ app.IServer = (*usersOutboxPurgeServer)(nil)
+
+// usersOutboxPurgeServer delete the users events published longer ago than the retention, without a relay
+// the events are never published and the ones created before the retention are deleted as they are.
+// Every instance runs it, deleting the same rows twice is harmless.
+type usersOutboxPurgeServer struct {
+	iDao        dao.UsersDao
+	retention   time.Duration
+	interval    time.Duration
+	unpublished bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewUsersOutboxPurgeServer creates the purge of the outbox, it runs at start and then every interval,
+// unpublished is set when no relay publishes the events
+func NewUsersOutboxPurgeServer(iDao dao.UsersDao, retention time.Duration, interval time.Duration, unpublished bool) app.IServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &usersOutboxPurgeServer{
+		iDao:        iDao,
+		retention:   retention,
+		interval:    interval,
+		unpublished: unpublished,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Start purge until Stop
+func (s *usersOutboxPurgeServer) Start() error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.purge()
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop the purge, a batch being deleted is cancelled
+func (s *usersOutboxPurgeServer) Stop() error {
+	s.cancel()
+	return nil
+}
+
+// String provides a human readable description of the purge.
+func (s *usersOutboxPurgeServer) String() string {
+	return "users outbox purge every " + s.interval.String() + " after " + s.retention.String()
+}
+
+func (s *usersOutboxPurgeServer) purge() {
+	before := time.Now().Add(-s.retention)
+	var total int64
+	for s.ctx.Err() == nil {
+		n, err := s.iDao.PurgeEvents(s.ctx, before, s.unpublished, usersOutboxPurgeBatchSize)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				logger.Error("PurgeEvents error", logger.Err(err))
+			}
+			break
+		}
+		total += n
+		if n < usersOutboxPurgeBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		logger.Info("purged the users outbox", logger.Int64("count", total), logger.Any("before", before))
+	}
+}
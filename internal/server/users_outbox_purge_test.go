@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-dev-frame/sponge/pkg/gotest"
+
+	"test-user-server/internal/dao"
+	"test-user-server/internal/model"
+)
+
+func TestUsersOutboxPurgeServer(t *testing.T) {
+	d := gotest.NewDao(nil, &model.Users{})
+	defer d.Close()
+
+	// a full batch is followed by another one, without a relay the unpublished events are deleted too
+	for _, deleted := range []int64{usersOutboxPurgeBatchSize, 2} {
+		ids := sqlmock.NewRows([]string{"id"})
+		for i := int64(1); i <= deleted; i++ {
+			ids.AddRow(i)
+		}
+		d.SQLMock.ExpectQuery("SELECT `id` FROM `users_outbox` WHERE published_at < \\? OR \\(published_at IS NULL AND created_at < \\?\\) ORDER BY id LIMIT \\?").
+			WithArgs(d.AnyTime, d.AnyTime, usersOutboxPurgeBatchSize).
+			WillReturnRows(ids)
+		d.SQLMock.ExpectBegin()
+		d.SQLMock.ExpectExec("DELETE FROM `users_outbox` WHERE id IN \\(.*\\)").
+			WillReturnResult(sqlmock.NewResult(0, deleted))
+		d.SQLMock.ExpectCommit()
+	}
+
+	s := NewUsersOutboxPurgeServer(dao.NewUsersDao(d.DB, nil), 7*24*time.Hour, time.Hour, true)
+	assert.Contains(t, s.String(), "outbox purge")
+	done := make(chan error)
+	go func() {
+		done <- s.Start()
+	}()
+	require.Eventually(t, func() bool {
+		return d.SQLMock.ExpectationsWereMet() == nil
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, s.Stop())
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("outbox purge server did not stop")
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-dev-frame/sponge/pkg/gotest"
+
+	"test-user-server/internal/dao"
+	"test-user-server/internal/model"
+	"test-user-server/internal/publisher"
+)
+
+var usersOutboxColumns = []string{"id", "users_id", "event", "payload", "created_at", "published_at", "claimed_until"}
+
+// expectUsersOutbox the poll of a batch, rows are the events read, claimed the ids claimed and published the
+// ids marked, the claims of the other ones are released
+func expectUsersOutbox(d *gotest.Dao, rows *sqlmock.Rows, claimed []driver.Value, published ...driver.Value) {
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_outbox` WHERE published_at IS NULL ORDER BY id LIMIT \\? FOR UPDATE").
+		WithArgs(usersOutboxBatchSize).
+		WillReturnRows(rows)
+	if len(claimed) == 0 {
+		d.SQLMock.ExpectCommit()
+		return
+	}
+	d.SQLMock.ExpectExec("UPDATE `users_outbox` SET `claimed_until`=\\? WHERE id IN \\(.*\\)").
+		WithArgs(append([]driver.Value{d.AnyTime}, claimed...)...).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(claimed))))
+	d.SQLMock.ExpectCommit()
+
+	d.SQLMock.ExpectBegin()
+	if len(published) > 0 {
+		d.SQLMock.ExpectExec("UPDATE `users_outbox` SET `claimed_until`=\\?,`published_at`=\\? WHERE id IN \\(.*\\)").
+			WithArgs(append([]driver.Value{nil, d.AnyTime}, published...)...).
+			WillReturnResult(sqlmock.NewResult(0, int64(len(published))))
+	}
+	released := []driver.Value{nil}
+	for _, id := range claimed {
+		if !slices.Contains(published, id) {
+			released = append(released, id)
+		}
+	}
+	if len(released) > 1 {
+		d.SQLMock.ExpectExec("UPDATE `users_outbox` SET `claimed_until`=\\? WHERE id IN \\(.*\\)").
+			WithArgs(released...).
+			WillReturnResult(sqlmock.NewResult(0, int64(len(released)-1)))
+	}
+	d.SQLMock.ExpectCommit()
+}
+
+func TestUsersOutboxRelay(t *testing.T) {
+	d := gotest.NewDao(nil, &model.Users{})
+	defer d.Close()
+	p := publisher.NewMemoryPublisher()
+	s := NewUsersOutboxRelay(dao.NewUsersDao(d.DB, nil), p, time.Hour).(*usersOutboxRelay)
+	now := time.Now()
+
+	// the later events of a users that failed wait for the next poll, the others go on
+	p.Fail(errors.New("broker down"), "1")
+	expectUsersOutbox(d, sqlmock.NewRows(usersOutboxColumns).
+		AddRow(7, 1, model.UsersEventCreated, `{"type":"user.created","usersID":1}`, now, nil, nil).
+		AddRow(8, 2, model.UsersEventUpdated, `{"type":"user.updated","usersID":2,"columns":["job_level"]}`, now, nil, nil).
+		AddRow(9, 1, model.UsersEventUpdated, `{"type":"user.updated","usersID":1}`, now, nil, nil),
+		[]driver.Value{7, 8, 9}, 8)
+	s.relay()
+	require.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	// an event claimed by the relay of another instance holds back the later ones of its users
+	p.Fail(nil)
+	expectUsersOutbox(d, sqlmock.NewRows(usersOutboxColumns).
+		AddRow(7, 1, model.UsersEventCreated, `{"type":"user.created","usersID":1}`, now, nil, now.Add(time.Minute)).
+		AddRow(9, 1, model.UsersEventUpdated, `{"type":"user.updated","usersID":1}`, now, nil, nil),
+		nil)
+	s.relay()
+	require.NoError(t, d.SQLMock.ExpectationsWereMet())
+	assert.Len(t, p.Messages(), 1)
+
+	// an expired claim is taken over
+	expectUsersOutbox(d, sqlmock.NewRows(usersOutboxColumns).
+		AddRow(7, 1, model.UsersEventCreated, `{"type":"user.created","usersID":1}`, now, nil, now.Add(-time.Minute)).
+		AddRow(9, 1, model.UsersEventUpdated, `{"type":"user.updated","usersID":1}`, now, nil, nil),
+		[]driver.Value{7, 9}, 7, 9)
+	s.relay()
+	require.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	messages := p.Messages()
+	require.Len(t, messages, 3)
+	var offsets []uint64
+	events := make([]*model.UsersEvent, len(messages))
+	for i, msg := range messages {
+		events[i] = &model.UsersEvent{}
+		require.NoError(t, json.Unmarshal(msg.Data, events[i]))
+		assert.Equal(t, msg.Subject, events[i].Type)
+		offsets = append(offsets, events[i].Offset)
+	}
+	assert.Equal(t, []uint64{8, 7, 9}, offsets)
+	assert.Equal(t, "2", messages[0].Key)
+	assert.Equal(t, "users-outbox-8", messages[0].ID)
+	assert.Equal(t, []string{"job_level"}, events[0].Columns)
+}
+
+func TestUsersOutboxRelay_Start(t *testing.T) {
+	d := gotest.NewDao(nil, &model.Users{})
+	defer d.Close()
+	p := publisher.NewMemoryPublisher()
+
+	// a full batch is followed by another one
+	rows := sqlmock.NewRows(usersOutboxColumns)
+	var ids []driver.Value
+	for i := 1; i <= usersOutboxBatchSize; i++ {
+		rows.AddRow(i, i, model.UsersEventCreated, `{"type":"user.created"}`, time.Now(), nil, nil)
+		ids = append(ids, i)
+	}
+	expectUsersOutbox(d, rows, ids, ids...)
+	expectUsersOutbox(d, sqlmock.NewRows(usersOutboxColumns), nil)
+
+	s := NewUsersOutboxRelay(dao.NewUsersDao(d.DB, nil), p, time.Hour)
+	assert.Contains(t, s.String(), "outbox")
+	done := make(chan error)
+	go func() {
+		done <- s.Start()
+	}()
+	require.Eventually(t, func() bool {
+		return d.SQLMock.ExpectationsWereMet() == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Len(t, p.Messages(), usersOutboxBatchSize)
+
+	require.NoError(t, s.Stop())
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("outbox relay did not stop")
+	}
+}
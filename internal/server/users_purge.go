@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/app"
+	"github.com/go-dev-frame/sponge/pkg/logger"
+
+	"test-user-server/internal/dao"
+)
+
+// usersPurgeBatchSize rows deleted by a statement, a purge keeps deleting until a batch is not full
+const usersPurgeBatchSize = 500
+
+var _ app.IServer = (*usersPurgeServer)(nil)
+
+// usersPurgeServer hard delete the userss that have been in the trash longer than the retention,
+// every instance runs it, deleting the same rows twice is harmless
+type usersPurgeServer struct {
+	iDao      dao.UsersDao
+	retention time.Duration
+	interval  time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewUsersPurgeServer creates the purge of the trash, it runs at start and then every interval
+func NewUsersPurgeServer(iDao dao.UsersDao, retention time.Duration, interval time.Duration) app.IServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &usersPurgeServer{
+		iDao:      iDao,
+		retention: retention,
+		interval:  interval,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Start purge until Stop
+func (s *usersPurgeServer) Start() error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.purge()
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop the purge, a batch being deleted is cancelled
+func (s *usersPurgeServer) Stop() error {
+	s.cancel()
+	return nil
+}
+
+// String provides a human readable description of the purge.
+func (s *usersPurgeServer) String() string {
+	return "users trash purge every " + s.interval.String() + " after " + s.retention.String()
+}
+
+func (s *usersPurgeServer) purge() {
+	before := time.Now().Add(-s.retention)
+	var total int64
+	for s.ctx.Err() == nil {
+		n, err := s.iDao.PurgeDeactivated(s.ctx, before, usersPurgeBatchSize)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				logger.Error("PurgeDeactivated error", logger.Err(err))
+			}
+			break
+		}
+		total += n
+		if n < usersPurgeBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		logger.Info("purged the trash of users", logger.Int64("count", total), logger.Any("deactivatedBefore", before))
+	}
+}
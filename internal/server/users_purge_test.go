@@ -0,0 +1,57 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-dev-frame/sponge/pkg/gotest"
+
+	"test-user-server/internal/dao"
+	"test-user-server/internal/model"
+)
+
+func TestUsersPurgeServer(t *testing.T) {
+	d := gotest.NewDao(nil, &model.Users{})
+	defer d.Close()
+
+	// a full batch is followed by another one, the audit of the purged userss is tested by the dao
+	for _, deleted := range []int64{usersPurgeBatchSize, 2} {
+		ids := sqlmock.NewRows([]string{"id"})
+		for i := int64(1); i <= deleted; i++ {
+			ids.AddRow(i)
+		}
+		d.SQLMock.ExpectQuery("SELECT `id` FROM `users` WHERE deactivated_at < \\? ORDER BY id LIMIT \\?").
+			WithArgs(d.AnyTime, usersPurgeBatchSize).
+			WillReturnRows(ids)
+		d.SQLMock.ExpectBegin()
+		d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE id IN \\(.*\\) FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		d.SQLMock.ExpectExec("DELETE FROM `users` WHERE id IN \\(.*\\) AND deactivated_at < \\?").
+			WillReturnResult(sqlmock.NewResult(0, deleted))
+		d.SQLMock.ExpectQuery("SELECT \\* FROM `users` WHERE id IN \\(.*\\)$").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		d.SQLMock.ExpectCommit()
+	}
+
+	s := NewUsersPurgeServer(dao.NewUsersDao(d.DB, nil), 24*time.Hour, time.Hour)
+	assert.Contains(t, s.String(), "purge")
+	done := make(chan error)
+	go func() {
+		done <- s.Start()
+	}()
+	require.Eventually(t, func() bool {
+		return d.SQLMock.ExpectationsWereMet() == nil
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, s.Stop())
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("purge server did not stop")
+	}
+}
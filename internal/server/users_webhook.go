@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/app"
+	"github.com/go-dev-frame/sponge/pkg/logger"
+
+	"test-user-server/internal/dao"
+	"test-user-server/internal/model"
+)
+
+// usersWebhookBatchSize deliveries claimed and attempted at a time
+const usersWebhookBatchSize = 20
+
+// usersWebhookErrorSize the longest reason of a failed attempt that is kept, the size of last_error
+const usersWebhookErrorSize = 1024
+
+// headers of a delivery request, the body is the event
+const (
+	usersWebhookEventHeader     = "X-Webhook-Event"     // type of the event
+	usersWebhookDeliveryHeader  = "X-Webhook-Delivery"  // id of the delivery, the same for each attempt
+	usersWebhookTimestampHeader = "X-Webhook-Timestamp" // unix seconds of the attempt, signed with the body
+	usersWebhookSignatureHeader = "X-Webhook-Signature" // sha256= and the hex HMAC-SHA256 of timestamp.body
+)
+
+var _ app.IServer = (*usersWebhookDispatcher)(nil)
+
+// usersWebhookDispatcher send the due deliveries of the users events to their webhooks. A delivery succeeds
+// once the receiver answers with a 2xx status, a failed attempt is retried after a backoff that doubles each
+// time and the delivery is dead once its attempts run out. Every instance runs it, the deliveries one of them
+// is attempting are skipped by the others.
+type usersWebhookDispatcher struct {
+	iDao     dao.UsersDao
+	interval time.Duration
+	opts     *usersWebhookOptions
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewUsersWebhookDispatcher creates the dispatcher of the webhook deliveries, it polls at start and then every interval
+func NewUsersWebhookDispatcher(iDao dao.UsersDao, interval time.Duration, opts ...UsersWebhookOption) app.IServer {
+	o := defaultUsersWebhookOptions()
+	o.apply(opts...)
+	ctx, cancel := context.WithCancel(context.Background())
+	return &usersWebhookDispatcher{
+		iDao:     iDao,
+		interval: interval,
+		opts:     o,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start dispatch until Stop
+func (s *usersWebhookDispatcher) Start() error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.dispatch()
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop the dispatcher, the attempts being made are made again by the next poll
+func (s *usersWebhookDispatcher) Stop() error {
+	s.cancel()
+	return nil
+}
+
+// String provides a human readable description of the dispatcher.
+func (s *usersWebhookDispatcher) String() string {
+	return "users webhook dispatcher every " + s.interval.String()
+}
+
+// dispatch attempt batches of due deliveries until one is not full, the failed ones are not due again
+// before their backoff
+func (s *usersWebhookDispatcher) dispatch() {
+	for s.ctx.Err() == nil {
+		n, err := s.iDao.DeliverWebhooks(s.ctx, usersWebhookBatchSize, s.deliver)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				logger.Error("DeliverWebhooks error", logger.Err(err))
+			}
+			return
+		}
+		if n < usersWebhookBatchSize {
+			return
+		}
+	}
+}
+
+// deliver attempt a delivery until ctx ends and set its outcome, the deliveries of a deleted or disabled
+// webhook are dead
+func (s *usersWebhookDispatcher) deliver(ctx context.Context, delivery *model.UsersWebhookDelivery, webhook *model.UsersWebhook) {
+	switch {
+	case webhook == nil:
+		delivery.Status, delivery.NextAttemptAt, delivery.LastError = model.UsersWebhookDead, nil, "webhook was deleted"
+		return
+	case !webhook.Active:
+		delivery.Status, delivery.NextAttemptAt, delivery.LastError = model.UsersWebhookDead, nil, "webhook is disabled"
+		return
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	status, err := s.post(ctx, delivery, webhook, now)
+	delivery.ResponseStatus = status
+	if err == nil {
+		delivery.Status, delivery.NextAttemptAt, delivery.DeliveredAt, delivery.LastError = model.UsersWebhookSucceeded, nil, &now, ""
+		return
+	}
+
+	delivery.LastError = truncateError(err.Error(), usersWebhookErrorSize)
+	if delivery.Attempts >= s.opts.maxAttempts {
+		delivery.Status, delivery.NextAttemptAt = model.UsersWebhookDead, nil
+		logger.Warn("users webhook delivery is dead", logger.Err(err), logger.Uint64("deliveryID", delivery.ID),
+			logger.Uint64("webhookID", webhook.ID), logger.Int("attempts", delivery.Attempts))
+		return
+	}
+	next := now.Add(s.backoff(delivery.Attempts))
+	delivery.NextAttemptAt = &next
+}
+
+// backoff the wait after a number of failed attempts, doubled from the first one up to the cap
+func (s *usersWebhookDispatcher) backoff(attempts int) time.Duration {
+	wait := s.opts.backoff
+	for i := 1; i < attempts && wait < s.opts.maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, s.opts.maxBackoff)
+}
+
+// post send the payload of a delivery to its webhook, the status of the response is returned, with an
+// error unless it is 2xx
+func (s *usersWebhookDispatcher) post(ctx context.Context, delivery *model.UsersWebhookDelivery, webhook *model.UsersWebhook,
+	now time.Time) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "user_server-webhook")
+	req.Header.Set(usersWebhookEventHeader, delivery.Event)
+	req.Header.Set(usersWebhookDeliveryHeader, strconv.FormatUint(delivery.ID, 10))
+	req.Header.Set(usersWebhookTimestampHeader, timestamp)
+	req.Header.Set(usersWebhookSignatureHeader, usersWebhookSignature(webhook.Secret, timestamp, body))
+
+	resp, err := s.opts.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close() //nolint
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// usersWebhookSignature the X-Webhook-Signature of a body, a receiver computes it with its copy of the secret
+// and compares them in constant time, the timestamp lets it refuse replayed requests
+func usersWebhookSignature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// truncateError cut a reason to at most size bytes without splitting a character
+func truncateError(reason string, size int) string {
+	if len(reason) <= size {
+		return reason
+	}
+	return strings.ToValidUTF8(reason[:size], "")
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// UsersWebhookOption setting up the webhook dispatcher
+type UsersWebhookOption func(*usersWebhookOptions)
+
+type usersWebhookOptions struct {
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+}
+
+func defaultUsersWebhookOptions() *usersWebhookOptions {
+	return &usersWebhookOptions{
+		client:      &http.Client{Timeout: 10 * time.Second},
+		maxAttempts: 8,
+		backoff:     30 * time.Second,
+		maxBackoff:  time.Hour,
+	}
+}
+
+func (o *usersWebhookOptions) apply(opts ...UsersWebhookOption) {
+	for _, opt := range opts {
+		opt(o)
+	}
+}
+
+// WithUsersWebhookClient setting up the http client of the deliveries, its timeout bounds an attempt
+func WithUsersWebhookClient(client *http.Client) UsersWebhookOption {
+	return func(o *usersWebhookOptions) {
+		o.client = client
+	}
+}
+
+// WithUsersWebhookRetry setting up the attempts of a delivery before it is dead, the wait before the
+// first retry and the cap of the wait as it doubles after each failed attempt
+func WithUsersWebhookRetry(maxAttempts int, backoff time.Duration, maxBackoff time.Duration) UsersWebhookOption {
+	return func(o *usersWebhookOptions) {
+		if maxAttempts > 0 {
+			o.maxAttempts = maxAttempts
+		}
+		if backoff > 0 {
+			o.backoff = backoff
+		}
+		if maxBackoff > 0 {
+			o.maxBackoff = maxBackoff
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"crypto/hmac"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-dev-frame/sponge/pkg/gotest"
+
+	"test-user-server/internal/dao"
+	"test-user-server/internal/model"
+)
+
+var (
+	usersWebhookColumns         = []string{"id", "url", "secret", "events", "columns", "active"}
+	usersWebhookDeliveryColumns = []string{"id", "webhook_id", "outbox_id", "users_id", "event", "payload", "status", "attempts", "next_attempt_at"}
+)
+
+// afterTime match a time later than the one it holds
+type afterTime struct {
+	t time.Time
+}
+
+func (a afterTime) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.After(a.t)
+}
+
+// webhookReceiver an httptest receiver answering with status, the requests it got are kept
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func newWebhookReceiver(t *testing.T, status int) *webhookReceiver {
+	r := &webhookReceiver{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, string(body))
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// expectUsersWebhookDeliveries the claim of a batch of due deliveries, the read of their webhooks and the
+// begin of the save of the attempts
+func expectUsersWebhookDeliveries(d *gotest.Dao, deliveries *sqlmock.Rows, claimed []driver.Value, webhooks *sqlmock.Rows,
+	webhookIDs ...driver.Value) {
+	d.SQLMock.ExpectBegin()
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook_delivery` WHERE status = \\? AND next_attempt_at <= \\? ORDER BY next_attempt_at, id LIMIT \\? FOR UPDATE SKIP LOCKED").
+		WithArgs(model.UsersWebhookPending, d.AnyTime, usersWebhookBatchSize).
+		WillReturnRows(deliveries)
+	if len(claimed) == 0 {
+		d.SQLMock.ExpectCommit()
+		return
+	}
+	// the next attempt is pushed out while the deliveries are attempted
+	d.SQLMock.ExpectExec("UPDATE `users_webhook_delivery` SET `next_attempt_at`=\\?,`updated_at`=\\? WHERE id IN \\(.*\\)").
+		WithArgs(append([]driver.Value{afterTime{time.Now().Add(time.Minute)}, d.AnyTime}, claimed...)...).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(claimed))))
+	d.SQLMock.ExpectCommit()
+	d.SQLMock.ExpectQuery("SELECT \\* FROM `users_webhook` WHERE id IN \\(.*\\)").
+		WithArgs(webhookIDs...).
+		WillReturnRows(webhooks)
+	d.SQLMock.ExpectBegin()
+}
+
+// expectUsersWebhookAttempt the save of the outcome of an attempt
+func expectUsersWebhookAttempt(d *gotest.Dao, id uint64, status string, attempts int, nextAttemptAt interface{},
+	responseStatus int, lastError string, deliveredAt interface{}) {
+	d.SQLMock.ExpectExec("UPDATE `users_webhook_delivery` SET `status`=\\?,`attempts`=\\?,`next_attempt_at`=\\?,`response_status`=\\?,`last_error`=\\?,`delivered_at`=\\?,`updated_at`=\\? WHERE `id` = \\?").
+		WithArgs(status, attempts, nextAttemptAt, responseStatus, lastError, deliveredAt, d.AnyTime, id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestUsersWebhookDispatcher(t *testing.T) {
+	d := gotest.NewDao(nil, &model.Users{})
+	defer d.Close()
+	ok := newWebhookReceiver(t, http.StatusNoContent)
+	failing := newWebhookReceiver(t, http.StatusInternalServerError)
+	s := NewUsersWebhookDispatcher(dao.NewUsersDao(d.DB, nil), time.Hour,
+		WithUsersWebhookRetry(3, time.Minute, time.Hour)).(*usersWebhookDispatcher)
+	now := time.Now()
+
+	payload := `{"offset":41,"type":"user.updated","usersID":1,"columns":["position_title"]}`
+	expectUsersWebhookDeliveries(d, sqlmock.NewRows(usersWebhookDeliveryColumns).
+		AddRow(9, 3, 41, 1, model.UsersEventUpdated, payload, model.UsersWebhookPending, 0, now).
+		AddRow(10, 4, 41, 1, model.UsersEventUpdated, payload, model.UsersWebhookPending, 0, now).
+		AddRow(11, 4, 40, 2, model.UsersEventCreated, `{"offset":40}`, model.UsersWebhookPending, 2, now).
+		AddRow(12, 5, 41, 1, model.UsersEventUpdated, payload, model.UsersWebhookPending, 0, now).
+		AddRow(13, 6, 41, 1, model.UsersEventUpdated, payload, model.UsersWebhookPending, 0, now),
+		[]driver.Value{9, 10, 11, 12, 13},
+		sqlmock.NewRows(usersWebhookColumns).
+			AddRow(3, ok.URL+"/hooks/users", "s3cret", "", "position_title", true).
+			AddRow(4, failing.URL, "other", "", "", true).
+			AddRow(6, ok.URL, "disabled", "", "", false),
+		3, 4, 5, 6)
+	// delivered
+	expectUsersWebhookAttempt(d, 9, model.UsersWebhookSucceeded, 1, nil, http.StatusNoContent, "", d.AnyTime)
+	// retried after the backoff
+	expectUsersWebhookAttempt(d, 10, model.UsersWebhookPending, 1, afterTime{now.Add(time.Minute - time.Second)},
+		http.StatusInternalServerError, "receiver answered 500 Internal Server Error", nil)
+	// out of attempts
+	expectUsersWebhookAttempt(d, 11, model.UsersWebhookDead, 3, nil,
+		http.StatusInternalServerError, "receiver answered 500 Internal Server Error", nil)
+	// the webhook is gone or disabled
+	expectUsersWebhookAttempt(d, 12, model.UsersWebhookDead, 0, nil, 0, "webhook was deleted", nil)
+	expectUsersWebhookAttempt(d, 13, model.UsersWebhookDead, 0, nil, 0, "webhook is disabled", nil)
+	d.SQLMock.ExpectCommit()
+
+	s.dispatch()
+	require.NoError(t, d.SQLMock.ExpectationsWereMet())
+
+	require.Len(t, ok.requests, 1)
+	req := ok.requests[0]
+	assert.Equal(t, http.MethodPost, req.Method)
+	assert.Equal(t, "/hooks/users", req.URL.Path)
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, model.UsersEventUpdated, req.Header.Get("X-Webhook-Event"))
+	assert.Equal(t, "9", req.Header.Get("X-Webhook-Delivery"))
+	assert.Equal(t, payload, ok.bodies[0])
+	// the receiver checks the signature with its copy of the secret
+	timestamp := req.Header.Get("X-Webhook-Timestamp")
+	assert.NotEmpty(t, timestamp)
+	signature := req.Header.Get("X-Webhook-Signature")
+	assert.True(t, strings.HasPrefix(signature, "sha256="))
+	assert.True(t, hmac.Equal([]byte(signature), []byte(usersWebhookSignature("s3cret", timestamp, []byte(payload)))))
+	assert.NotEqual(t, signature, usersWebhookSignature("other", timestamp, []byte(payload)))
+
+	assert.Len(t, failing.requests, 2)
+}
+
+func TestUsersWebhookDispatcher_backoff(t *testing.T) {
+	s := NewUsersWebhookDispatcher(nil, time.Hour, WithUsersWebhookRetry(8, 30*time.Second, 10*time.Minute)).(*usersWebhookDispatcher)
+	assert.Equal(t, 30*time.Second, s.backoff(1))
+	assert.Equal(t, time.Minute, s.backoff(2))
+	assert.Equal(t, 8*time.Minute, s.backoff(5))
+	assert.Equal(t, 10*time.Minute, s.backoff(6))
+	assert.Equal(t, 10*time.Minute, s.backoff(100))
+
+	// the defaults
+	s = NewUsersWebhookDispatcher(nil, time.Hour, WithUsersWebhookRetry(0, 0, 0)).(*usersWebhookDispatcher)
+	assert.Equal(t, 8, s.opts.maxAttempts)
+	assert.Equal(t, 30*time.Second, s.backoff(1))
+
+	assert.Equal(t, "abc", truncateError("abc", 3))
+	assert.Equal(t, "ab", truncateError("ab中", 4))
+}
+
+func TestUsersWebhookDispatcher_Start(t *testing.T) {
+	d := gotest.NewDao(nil, &model.Users{})
+	defer d.Close()
+	receiver := newWebhookReceiver(t, http.StatusOK)
+
+	// a full batch is followed by another one
+	deliveries := sqlmock.NewRows(usersWebhookDeliveryColumns)
+	var ids []driver.Value
+	for i := 1; i <= usersWebhookBatchSize; i++ {
+		deliveries.AddRow(i, 1, i, i, model.UsersEventCreated, `{}`, model.UsersWebhookPending, 0, time.Now())
+		ids = append(ids, i)
+	}
+	expectUsersWebhookDeliveries(d, deliveries, ids, sqlmock.NewRows(usersWebhookColumns).AddRow(1, receiver.URL, "secret", "", "", true), 1)
+	for i := 1; i <= usersWebhookBatchSize; i++ {
+		expectUsersWebhookAttempt(d, uint64(i), model.UsersWebhookSucceeded, 1, nil, http.StatusOK, "", d.AnyTime)
+	}
+	d.SQLMock.ExpectCommit()
+	expectUsersWebhookDeliveries(d, sqlmock.NewRows(usersWebhookDeliveryColumns), nil, nil)
+
+	s := NewUsersWebhookDispatcher(dao.NewUsersDao(d.DB, nil), time.Hour, WithUsersWebhookClient(receiver.Client()))
+	assert.Contains(t, s.String(), "webhook")
+	done := make(chan error)
+	go func() {
+		done <- s.Start()
+	}()
+	require.Eventually(t, func() bool {
+		return d.SQLMock.ExpectationsWereMet() == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	receiver.mu.Lock()
+	assert.Len(t, receiver.requests, usersWebhookBatchSize)
+	receiver.mu.Unlock()
+
+	require.NoError(t, s.Stop())
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("webhook dispatcher did not stop")
+	}
+}
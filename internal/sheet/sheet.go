@@ -0,0 +1,143 @@
+// Package sheet reads the rows of the spreadsheets people hand over, csv files and xlsx workbooks,
+// as text the way they are shown, and writes them row by row.
+package sheet
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ErrUnsupportedFormat the file is neither csv nor xlsx
+var ErrUnsupportedFormat = errors.New("unsupported file format, must be csv or xlsx")
+
+// utf8BOM excel puts it at the start of the csv files it saves as utf-8
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Format get the format of a file by its extension, csv or xlsx
+func Format(name string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".csv", ".xlsx":
+		return ext[1:], nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Read read all the rows of a csv file or of the first sheet of a xlsx workbook, the format is told
+// by the extension of name. Rows may have fewer cells than the header, the blank lines of a csv file
+// are skipped while the blank rows of a sheet are kept as rows without cells.
+func Read(name string, r io.Reader) ([][]string, error) {
+	format, err := Format(name)
+	if err != nil {
+		return nil, err
+	}
+	if format == "xlsx" {
+		return readXLSX(r)
+	}
+	return readCSV(r)
+}
+
+func readCSV(r io.Reader) ([][]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cr := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, utf8BOM)))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	return cr.ReadAll()
+}
+
+func readXLSX(r io.Reader) ([][]string, error) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, nil
+	}
+	return f.GetRows(sheets[0])
+}
+
+// Writer write the rows of a csv file or of the single sheet of a xlsx workbook, a cell is nil for
+// an empty one, a string, a number or a bool
+type Writer interface {
+	Write(row []interface{}) error
+	// Close flush the rows, a xlsx workbook is only written to w at Close
+	Close() error
+}
+
+// NewWriter create a writer of the format, csv or xlsx. The csv files start with the utf-8 BOM so
+// that excel does not take them for the local code page, the xlsx rows are kept in a temporary file
+// past a few megabytes rather than in memory.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case "csv":
+		if _, err := w.Write(utf8BOM); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case "xlsx":
+		f := excelize.NewFile()
+		sw, err := f.NewStreamWriter(f.GetSheetName(0))
+		if err != nil {
+			return nil, err
+		}
+		return &xlsxWriter{w: w, f: f, sw: sw}, nil
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func (cw *csvWriter) Write(row []interface{}) error {
+	cw.record = cw.record[:0]
+	for _, cell := range row {
+		if cell == nil {
+			cw.record = append(cw.record, "")
+		} else {
+			cw.record = append(cw.record, fmt.Sprint(cell))
+		}
+	}
+	return cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type xlsxWriter struct {
+	w   io.Writer
+	f   *excelize.File
+	sw  *excelize.StreamWriter
+	row int
+}
+
+func (xw *xlsxWriter) Write(row []interface{}) error {
+	xw.row++
+	cell, err := excelize.CoordinatesToCellName(1, xw.row)
+	if err != nil {
+		return err
+	}
+	return xw.sw.SetRow(cell, row)
+}
+
+func (xw *xlsxWriter) Close() error {
+	defer xw.f.Close() //nolint
+	if err := xw.sw.Flush(); err != nil {
+		return err
+	}
+	return xw.f.Write(xw.w)
+}
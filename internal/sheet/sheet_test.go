@@ -0,0 +1,87 @@
+package sheet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestFormat(t *testing.T) {
+	format, err := Format("userss.CSV")
+	assert.NoError(t, err)
+	assert.Equal(t, "csv", format)
+	format, err = Format("/tmp/userss.xlsx")
+	assert.NoError(t, err)
+	assert.Equal(t, "xlsx", format)
+
+	_, err = Format("userss.xls")
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+func TestRead_csv(t *testing.T) {
+	data := "\xEF\xBB\xBF工号,姓名,邮箱\nA001, 张三,zhangsan@example.com\n\nA002,李四\n"
+	rows, err := Read("userss.csv", strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, [][]string{
+		{"工号", "姓名", "邮箱"},
+		{"A001", "张三", "zhangsan@example.com"},
+		{"A002", "李四"},
+	}, rows)
+
+	_, err = Read("userss.csv", strings.NewReader("a,\"b\n"))
+	assert.Error(t, err)
+}
+
+func TestRead_xlsx(t *testing.T) {
+	f := excelize.NewFile()
+	_ = f.SetSheetRow("Sheet1", "A1", &[]interface{}{"工号", "姓名"})
+	_ = f.SetSheetRow("Sheet1", "A2", &[]interface{}{"A001", "张三"})
+	_ = f.SetSheetRow("Sheet1", "A4", &[]interface{}{1002})
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := Read("userss.xlsx", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, [][]string{{"工号", "姓名"}, {"A001", "张三"}, nil, {"1002"}}, rows)
+
+	_, err = Read("userss.xlsx", bytes.NewReader([]byte("not a workbook")))
+	assert.Error(t, err)
+	_, err = Read("userss.txt", bytes.NewReader(nil))
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+func TestNewWriter(t *testing.T) {
+	for _, format := range []string{"csv", "xlsx"} {
+		buf := &bytes.Buffer{}
+		w, err := NewWriter(format, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, w.Write([]interface{}{"id", "chineseName", "gender"}))
+		assert.NoError(t, w.Write([]interface{}{1, "张三", true}))
+		assert.NoError(t, w.Write([]interface{}{2, nil, false}))
+		assert.NoError(t, w.Close())
+
+		rows, err := Read("userss."+format, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := [][]string{{"id", "chineseName", "gender"}, {"1", "张三", "true"}, {"2", "", "false"}}
+		if format == "xlsx" {
+			want[1][2], want[2][2] = "TRUE", "FALSE"
+		}
+		assert.Equal(t, want, rows, format)
+	}
+
+	_, err := NewWriter("xls", &bytes.Buffer{})
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
@@ -0,0 +1,67 @@
+package types
+
+// SignInRequest request params
+type SignInRequest struct {
+	Email    string `json:"email" binding:"required,email"` // email of the users
+	Password string `json:"password" binding:"required"`    // plaintext password
+}
+
+// SignInReply only for api docs
+type SignInReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		ID        uint64 `json:"id"`        // id of the signed in users
+		Token     string `json:"token"`     // jwt, send it as "Authorization: Bearer token"
+		ExpiresIn int    `json:"expiresIn"` // token lifetime in seconds
+	} `json:"data"` // return data
+}
+
+// UnlockUsersRequest request params
+type UnlockUsersRequest struct {
+	Token string `json:"token" binding:"required"` // raw unlock token from the unlock instructions
+}
+
+// UnlockUsersReply only for api docs
+type UnlockUsersReply struct {
+	Code int      `json:"code"` // return code
+	Msg  string   `json:"msg"`  // return information description
+	Data struct{} `json:"data"` // return data
+}
+
+// ForgotPasswordRequest request params
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"` // email of the users
+}
+
+// ForgotPasswordReply only for api docs
+type ForgotPasswordReply struct {
+	Code int      `json:"code"` // return code
+	Msg  string   `json:"msg"`  // return information description
+	Data struct{} `json:"data"` // return data
+}
+
+// ResetPasswordRequest request params
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`                  // raw reset password token from the instructions
+	Password string `json:"password" binding:"required,min=6,max=128"` // new plaintext password
+}
+
+// ResetPasswordReply only for api docs
+type ResetPasswordReply struct {
+	Code int      `json:"code"` // return code
+	Msg  string   `json:"msg"`  // return information description
+	Data struct{} `json:"data"` // return data
+}
+
+// ConfirmUsersRequest request params
+type ConfirmUsersRequest struct {
+	Token string `form:"token" binding:"required"` // confirmation token from the confirmation instructions
+}
+
+// ConfirmUsersReply only for api docs
+type ConfirmUsersReply struct {
+	Code int      `json:"code"` // return code
+	Msg  string   `json:"msg"`  // return information description
+	Data struct{} `json:"data"` // return data
+}
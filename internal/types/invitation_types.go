@@ -0,0 +1,63 @@
+package types
+
+import (
+	"time"
+)
+
+// InviteUsersRequest request params
+type InviteUsersRequest struct {
+	Email string `json:"email" binding:"required,email"` // email of the invitee
+}
+
+// InviteUsersReply only for api docs
+type InviteUsersReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		ID uint64 `json:"id"` // id of the invitee
+	} `json:"data"` // return data
+}
+
+// ResendInvitationRequest request params
+type ResendInvitationRequest struct {
+	Email string `json:"email" binding:"required,email"` // email of the invitee
+}
+
+// ResendInvitationReply only for api docs
+type ResendInvitationReply struct {
+	Code int      `json:"code"` // return code
+	Msg  string   `json:"msg"`  // return information description
+	Data struct{} `json:"data"` // return data
+}
+
+// AcceptInvitationRequest request params
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`                  // raw invitation token from the invitation instructions
+	Password string `json:"password" binding:"required,min=6,max=128"` // plaintext password of the new account
+}
+
+// AcceptInvitationReply only for api docs
+type AcceptInvitationReply struct {
+	Code int      `json:"code"` // return code
+	Msg  string   `json:"msg"`  // return information description
+	Data struct{} `json:"data"` // return data
+}
+
+// InviteeObjDetail detail of an invitee
+type InviteeObjDetail struct {
+	ID                   uint64     `json:"id"`
+	Email                string     `json:"email"`
+	InvitationSentAt     *time.Time `json:"invitationSentAt"`
+	InvitationAcceptedAt *time.Time `json:"invitationAcceptedAt"`
+	CreatedAt            *time.Time `json:"createdAt"`
+}
+
+// ListInviteesReply only for api docs
+type ListInviteesReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Invitees []InviteeObjDetail `json:"invitees"`
+		Total    int64              `json:"total"`
+	} `json:"data"` // return data
+}
@@ -33,3 +33,20 @@ type Column struct {
 type Conditions struct {
 	Columns []Column `json:"columns"` // columns info
 }
+
+// FieldError a request field that failed validation
+type FieldError struct {
+	Field   string `json:"field"`   // json name of the field, dotted for nested fields
+	Rule    string `json:"rule"`    // failed rule, such as required, email, max, mobile, or type when the json value has the wrong type
+	Param   string `json:"param"`   // parameter of the rule, such as 255 for max=255, the expected type for type
+	Message string `json:"message"` // readable reason
+}
+
+// InvalidParamsReply the reply of a request that failed validation
+type InvalidParamsReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Errors []FieldError `json:"errors"` // each field that failed and why
+	} `json:"data"` // return data
+}
@@ -12,107 +12,149 @@ var _ time.Time
 
 // CreateUsersRequest request params
 type CreateUsersRequest struct {
-	Email                      string     `json:"email" binding:""`
-	EncryptedPassword          string     `json:"encryptedPassword" binding:""`
-	ResetPasswordToken         string     `json:"resetPasswordToken" binding:""`
+	Email                      string     `json:"email" binding:"required,email,max=255"`
+	Password                   string     `json:"password" binding:"required,min=6,max=128"` // plaintext, hashed with bcrypt before it is stored
 	ResetPasswordSentAt        *time.Time `json:"resetPasswordSentAt" binding:""`
 	RememberCreatedAt          *time.Time `json:"rememberCreatedAt" binding:""`
-	SignInCount                int        `json:"signInCount" binding:""`
+	SignInCount                int        `json:"signInCount" binding:"min=0"`
 	CurrentSignInAt            *time.Time `json:"currentSignInAt" binding:""`
 	LastSignInAt               *time.Time `json:"lastSignInAt" binding:""`
-	CurrentSignInIP            string     `json:"currentSignInIP" binding:""`
-	LastSignInIP               string     `json:"lastSignInIP" binding:""`
-	ConfirmationToken          string     `json:"confirmationToken" binding:""`
+	CurrentSignInIP            string     `json:"currentSignInIP" binding:"max=255"`
+	LastSignInIP               string     `json:"lastSignInIP" binding:"max=255"`
 	ConfirmedAt                *time.Time `json:"confirmedAt" binding:""`
 	ConfirmationSentAt         *time.Time `json:"confirmationSentAt" binding:""`
-	UnconfirmedEmail           string     `json:"unconfirmedEmail" binding:""`
-	FailedAttempts             int        `json:"failedAttempts" binding:""`
-	UnlockToken                string     `json:"unlockToken" binding:""`
+	UnconfirmedEmail           string     `json:"unconfirmedEmail" binding:"omitempty,email,max=255"`
+	FailedAttempts             int        `json:"failedAttempts" binding:"min=0"`
 	LockedAt                   *time.Time `json:"lockedAt" binding:""`
-	InvitationToken            string     `json:"invitationToken" binding:""`
 	InvitationCreatedAt        *time.Time `json:"invitationCreatedAt" binding:""`
 	InvitationSentAt           *time.Time `json:"invitationSentAt" binding:""`
 	InvitationAcceptedAt       *time.Time `json:"invitationAcceptedAt" binding:""`
-	InvitationLimit            int        `json:"invitationLimit" binding:""`
-	InvitedByType              string     `json:"invitedByType" binding:""`
+	InvitationLimit            int        `json:"invitationLimit" binding:"min=0"`
+	InvitedByType              string     `json:"invitedByType" binding:"max=255"`
 	InvitedByID                int64      `json:"invitedByID" binding:""`
-	InvitationsCount           int        `json:"invitationsCount" binding:""`
-	PositionTitle              string     `json:"positionTitle" binding:""`
-	ClerkCode                  string     `json:"clerkCode" binding:""`
-	ChineseName                string     `json:"chineseName" binding:""`
-	DeskPhone                  string     `json:"deskPhone" binding:""`
-	JobLevel                   string     `json:"jobLevel" binding:""`
-	WecomID                    string     `json:"wecomID" binding:""`
-	PreSsoID                   string     `json:"preSsoID" binding:""`
-	Mobile                     string     `json:"mobile" binding:""`
-	EntryCompanyDate           *time.Time `json:"entryCompanyDate" binding:""`
-	Gender                     *bool      `json:"gender" binding:""`
-	PerPage                    int        `json:"perPage" binding:""`
+	InvitationsCount           int        `json:"invitationsCount" binding:"min=0"`
+	PositionTitle              string     `json:"positionTitle" binding:"max=255"`
+	ClerkCode                  string     `json:"clerkCode" binding:"max=255"`
+	ChineseName                string     `json:"chineseName" binding:"max=255"`
+	DeskPhone                  string     `json:"deskPhone" binding:"max=255"`
+	JobLevel                   string     `json:"jobLevel" binding:"max=255"`
+	WecomID                    string     `json:"wecomID" binding:"max=255"`
+	PreSsoID                   string     `json:"preSsoID" binding:"max=255"`
+	Mobile                     string     `json:"mobile" binding:"omitempty,mobile"` // mainland china mobile number, +86 is optional
+	EntryCompanyDate           *time.Time `json:"entryCompanyDate"`
+	Gender                     *bool      `json:"gender" binding:""`                         // enum of true and false, anything else is rejected when the body is decoded
+	PerPage                    int        `json:"perPage" binding:"omitempty,min=1,max=100"` // 0 keeps the default of 12
 	OpenInNewTab               *bool      `json:"openInNewTab" binding:""`
-	MajorCode                  string     `json:"majorCode" binding:""`
-	MajorName                  string     `json:"majorName" binding:""`
+	MajorCode                  string     `json:"majorCode" binding:"max=255"`
+	MajorName                  string     `json:"majorName" binding:"max=255"`
 	PositionChangedInLastMonth *bool      `json:"positionChangedInLastMonth" binding:""`
 	NewUI                      *bool      `json:"newUI" binding:""`
-	PositionNcPkPost           string     `json:"positionNcPkPost" binding:""`
-	WindowsSid                 string     `json:"windowsSid" binding:""`
+	PositionNcPkPost           string     `json:"positionNcPkPost" binding:"max=255"`
+	WindowsSid                 string     `json:"windowsSid" binding:"max=255"`
 }
 
 // UpdateUsersByIDRequest request params
 type UpdateUsersByIDRequest struct {
 	ID uint64 `json:"id" binding:""` // uint64 id
 
-	Email                      string     `json:"email" binding:""`
-	EncryptedPassword          string     `json:"encryptedPassword" binding:""`
-	ResetPasswordToken         string     `json:"resetPasswordToken" binding:""`
+	Email                      string     `json:"email" binding:"omitempty,email,max=255"`
+	Password                   string     `json:"password" binding:"omitempty,min=6,max=128"` // plaintext, hashed with bcrypt before it is stored
 	ResetPasswordSentAt        *time.Time `json:"resetPasswordSentAt" binding:""`
 	RememberCreatedAt          *time.Time `json:"rememberCreatedAt" binding:""`
-	SignInCount                int        `json:"signInCount" binding:""`
+	SignInCount                int        `json:"signInCount" binding:"min=0"`
 	CurrentSignInAt            *time.Time `json:"currentSignInAt" binding:""`
 	LastSignInAt               *time.Time `json:"lastSignInAt" binding:""`
-	CurrentSignInIP            string     `json:"currentSignInIP" binding:""`
-	LastSignInIP               string     `json:"lastSignInIP" binding:""`
-	ConfirmationToken          string     `json:"confirmationToken" binding:""`
+	CurrentSignInIP            string     `json:"currentSignInIP" binding:"max=255"`
+	LastSignInIP               string     `json:"lastSignInIP" binding:"max=255"`
 	ConfirmedAt                *time.Time `json:"confirmedAt" binding:""`
 	ConfirmationSentAt         *time.Time `json:"confirmationSentAt" binding:""`
-	UnconfirmedEmail           string     `json:"unconfirmedEmail" binding:""`
-	FailedAttempts             int        `json:"failedAttempts" binding:""`
-	UnlockToken                string     `json:"unlockToken" binding:""`
+	UnconfirmedEmail           string     `json:"unconfirmedEmail" binding:"omitempty,email,max=255"`
+	FailedAttempts             int        `json:"failedAttempts" binding:"min=0"`
 	LockedAt                   *time.Time `json:"lockedAt" binding:""`
-	InvitationToken            string     `json:"invitationToken" binding:""`
 	InvitationCreatedAt        *time.Time `json:"invitationCreatedAt" binding:""`
 	InvitationSentAt           *time.Time `json:"invitationSentAt" binding:""`
 	InvitationAcceptedAt       *time.Time `json:"invitationAcceptedAt" binding:""`
-	InvitationLimit            int        `json:"invitationLimit" binding:""`
-	InvitedByType              string     `json:"invitedByType" binding:""`
+	InvitationLimit            int        `json:"invitationLimit" binding:"min=0"`
+	InvitedByType              string     `json:"invitedByType" binding:"max=255"`
 	InvitedByID                int64      `json:"invitedByID" binding:""`
-	InvitationsCount           int        `json:"invitationsCount" binding:""`
-	PositionTitle              string     `json:"positionTitle" binding:""`
-	ClerkCode                  string     `json:"clerkCode" binding:""`
-	ChineseName                string     `json:"chineseName" binding:""`
-	DeskPhone                  string     `json:"deskPhone" binding:""`
-	JobLevel                   string     `json:"jobLevel" binding:""`
-	WecomID                    string     `json:"wecomID" binding:""`
-	PreSsoID                   string     `json:"preSsoID" binding:""`
-	Mobile                     string     `json:"mobile" binding:""`
-	EntryCompanyDate           *time.Time `json:"entryCompanyDate" binding:""`
-	Gender                     *bool      `json:"gender" binding:""`
-	PerPage                    int        `json:"perPage" binding:""`
+	InvitationsCount           int        `json:"invitationsCount" binding:"min=0"`
+	PositionTitle              string     `json:"positionTitle" binding:"max=255"`
+	ClerkCode                  string     `json:"clerkCode" binding:"max=255"`
+	ChineseName                string     `json:"chineseName" binding:"max=255"`
+	DeskPhone                  string     `json:"deskPhone" binding:"max=255"`
+	JobLevel                   string     `json:"jobLevel" binding:"max=255"`
+	WecomID                    string     `json:"wecomID" binding:"max=255"`
+	PreSsoID                   string     `json:"preSsoID" binding:"max=255"`
+	Mobile                     string     `json:"mobile" binding:"omitempty,mobile"` // mainland china mobile number, +86 is optional
+	EntryCompanyDate           *time.Time `json:"entryCompanyDate"`
+	Gender                     *bool      `json:"gender" binding:""`                         // enum of true and false, anything else is rejected when the body is decoded
+	PerPage                    int        `json:"perPage" binding:"omitempty,min=1,max=100"` // 0 keeps the current value
 	OpenInNewTab               *bool      `json:"openInNewTab" binding:""`
-	MajorCode                  string     `json:"majorCode" binding:""`
-	MajorName                  string     `json:"majorName" binding:""`
+	MajorCode                  string     `json:"majorCode" binding:"max=255"`
+	MajorName                  string     `json:"majorName" binding:"max=255"`
 	PositionChangedInLastMonth *bool      `json:"positionChangedInLastMonth" binding:""`
 	NewUI                      *bool      `json:"newUI" binding:""`
-	PositionNcPkPost           string     `json:"positionNcPkPost" binding:""`
-	WindowsSid                 string     `json:"windowsSid" binding:""`
+	PositionNcPkPost           string     `json:"positionNcPkPost" binding:"max=255"`
+	WindowsSid                 string     `json:"windowsSid" binding:"max=255"`
+}
+
+// UpdateMeRequest request params, the columns a users may change on its own record
+type UpdateMeRequest struct {
+	DeskPhone    string `json:"deskPhone" binding:"max=255"`
+	Mobile       string `json:"mobile" binding:"omitempty,mobile"`         // mainland china mobile number, +86 is optional
+	PerPage      int    `json:"perPage" binding:"omitempty,min=1,max=100"` // 0 keeps the current value
+	OpenInNewTab *bool  `json:"openInNewTab" binding:""`
+	NewUI        *bool  `json:"newUI" binding:""`
+}
+
+// ChangeUsersPasswordRequest request params
+type ChangeUsersPasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`        // plaintext current password
+	Password        string `json:"password" binding:"required,min=6,max=128"` // plaintext new password
+}
+
+// ChangeUsersPasswordReply only for api docs
+type ChangeUsersPasswordReply struct {
+	Code int      `json:"code"` // return code
+	Msg  string   `json:"msg"`  // return information description
+	Data struct{} `json:"data"` // return data
 }
 
-// UsersObjDetail detail
+// UsersObjDetail public projection of a users record, secret columns and devise
+// tracking state are never copied into it, see model.UsersSecretColumnNames.
 type UsersObjDetail struct {
 	ID uint64 `json:"id"` // convert to uint64 id
 
 	Email                      string     `json:"email"`
-	EncryptedPassword          string     `json:"encryptedPassword"`
-	ResetPasswordToken         string     `json:"resetPasswordToken"`
+	CreatedAt                  *time.Time `json:"createdAt"`
+	UpdatedAt                  *time.Time `json:"updatedAt"`
+	PositionTitle              string     `json:"positionTitle"`
+	ClerkCode                  string     `json:"clerkCode"`
+	ChineseName                string     `json:"chineseName"`
+	DeskPhone                  string     `json:"deskPhone"`
+	JobLevel                   string     `json:"jobLevel"`
+	WecomID                    string     `json:"wecomID"`
+	PreSsoID                   string     `json:"preSsoID"`
+	Mobile                     string     `json:"mobile"`
+	EntryCompanyDate           *time.Time `json:"entryCompanyDate"`
+	Gender                     *bool      `json:"gender"`
+	PerPage                    int        `json:"perPage"`
+	OpenInNewTab               *bool      `json:"openInNewTab"`
+	MajorCode                  string     `json:"majorCode"`
+	MajorName                  string     `json:"majorName"`
+	PositionChangedInLastMonth *bool      `json:"positionChangedInLastMonth"`
+	NewUI                      *bool      `json:"newUI"`
+	PositionNcPkPost           string     `json:"positionNcPkPost"`
+	WindowsSid                 string     `json:"windowsSid"`
+}
+
+// UsersAdminObjDetail admin projection of a users record, returned only to callers holding
+// the admin role claim. It adds the devise trackable, confirmable, lockable and invitable
+// state to the public projection, secret columns are still never copied into it.
+type UsersAdminObjDetail struct {
+	ID uint64 `json:"id"` // convert to uint64 id
+
+	Email                      string     `json:"email"`
 	ResetPasswordSentAt        *time.Time `json:"resetPasswordSentAt"`
 	RememberCreatedAt          *time.Time `json:"rememberCreatedAt"`
 	SignInCount                int        `json:"signInCount"`
@@ -120,14 +162,11 @@ type UsersObjDetail struct {
 	LastSignInAt               *time.Time `json:"lastSignInAt"`
 	CurrentSignInIP            string     `json:"currentSignInIP"`
 	LastSignInIP               string     `json:"lastSignInIP"`
-	ConfirmationToken          string     `json:"confirmationToken"`
 	ConfirmedAt                *time.Time `json:"confirmedAt"`
 	ConfirmationSentAt         *time.Time `json:"confirmationSentAt"`
 	UnconfirmedEmail           string     `json:"unconfirmedEmail"`
 	FailedAttempts             int        `json:"failedAttempts"`
-	UnlockToken                string     `json:"unlockToken"`
 	LockedAt                   *time.Time `json:"lockedAt"`
-	InvitationToken            string     `json:"invitationToken"`
 	InvitationCreatedAt        *time.Time `json:"invitationCreatedAt"`
 	InvitationSentAt           *time.Time `json:"invitationSentAt"`
 	InvitationAcceptedAt       *time.Time `json:"invitationAcceptedAt"`
@@ -155,6 +194,7 @@ type UsersObjDetail struct {
 	NewUI                      *bool      `json:"newUI"`
 	PositionNcPkPost           string     `json:"positionNcPkPost"`
 	WindowsSid                 string     `json:"windowsSid"`
+	DeactivatedAt              *time.Time `json:"deactivatedAt"` // set while the users is in the trash
 }
 
 // CreateUsersReply only for api docs
@@ -210,6 +250,235 @@ type ListUserssReply struct {
 	} `json:"data"` // return data
 }
 
+// ListTrashUserssReply only for api docs
+type ListTrashUserssReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Userss []UsersAdminObjDetail `json:"userss"`
+		Total  int64                 `json:"total"`
+	} `json:"data"` // return data
+}
+
+// UsersChangeObj the values of a column before and after a write, the secret columns read [FILTERED]
+type UsersChangeObj struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// UsersAuditObjDetail a write of a users record
+type UsersAuditObjDetail struct {
+	ID        uint64                    `json:"id"`
+	Action    string                    `json:"action"`    // create, update, delete, restore, revert or purge
+	ActorType string                    `json:"actorType"` // jwt or rails_session, empty when the write had no authenticated caller
+	ActorID   uint64                    `json:"actorID"`
+	RequestID string                    `json:"requestID"`
+	Changes   map[string]UsersChangeObj `json:"changes"` // by column name
+	CreatedAt *time.Time                `json:"createdAt"`
+}
+
+// ListUsersHistoryReply only for api docs
+type ListUsersHistoryReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		History []UsersAuditObjDetail `json:"history"`
+		Total   int64                 `json:"total"`
+	} `json:"data"` // return data
+}
+
+// UsersVersionObjDetail a version of a users record
+type UsersVersionObjDetail struct {
+	Version   int                  `json:"version"` // numbered from 1 in the order of the writes
+	Action    string               `json:"action"`  // the write that made it, as in UsersAuditObjDetail
+	CreatedAt *time.Time           `json:"createdAt"`
+	Users     *UsersAdminObjDetail `json:"users"` // the record after the write, the secret columns are empty
+}
+
+// ListUsersVersionsReply only for api docs
+type ListUsersVersionsReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Versions []UsersVersionObjDetail `json:"versions"`
+		Total    int64                   `json:"total"`
+	} `json:"data"` // return data
+}
+
+// DiffUsersVersionsReply only for api docs
+type DiffUsersVersionsReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		From    int                       `json:"from"` // 0 is before the users was created
+		To      int                       `json:"to"`
+		Changes map[string]UsersChangeObj `json:"changes"` // by column name
+	} `json:"data"` // return data
+}
+
+// RevertUsersReply only for api docs
+type RevertUsersReply struct {
+	Code int      `json:"code"` // return code
+	Msg  string   `json:"msg"`  // return information description
+	Data struct{} `json:"data"` // return data
+}
+
+// ReplayUsersEventsRequest request params
+type ReplayUsersEventsRequest struct {
+	Offset uint64 `json:"offset" binding:""` // the events from this offset on are published again, 0 is all of them
+}
+
+// ReplayUsersEventsReply only for api docs
+type ReplayUsersEventsReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Replayed int64 `json:"replayed"` // number of events to publish again
+	} `json:"data"` // return data
+}
+
+// CreateUsersWebhookRequest request params
+type CreateUsersWebhookRequest struct {
+	URL     string   `json:"url" binding:"required,url,max=2048"`                                // gets a POST of each event it matches
+	Secret  string   `json:"secret" binding:"omitempty,min=16,max=255"`                          // key of the signatures, generated when empty
+	Events  []string `json:"events" binding:"dive,oneof=user.created user.updated user.deleted"` // event types to deliver, empty for all of them
+	Columns []string `json:"columns" binding:""`                                                 // an event is delivered when it changed one of these columns, empty for any
+	Active  *bool    `json:"active" binding:""`                                                  // true by default
+}
+
+// UpdateUsersWebhookRequest request params
+type UpdateUsersWebhookRequest struct {
+	URL     string   `json:"url" binding:"required,url,max=2048"`
+	Secret  string   `json:"secret" binding:"omitempty,min=16,max=255"`                          // empty keeps the current one
+	Events  []string `json:"events" binding:"dive,oneof=user.created user.updated user.deleted"` // empty for all of them
+	Columns []string `json:"columns" binding:""`                                                 // empty for any
+	Active  *bool    `json:"active" binding:""`                                                  // null keeps the current state
+}
+
+// UsersWebhookObjDetail a webhook subscribed to the users events, the secret is only shown when it is created
+type UsersWebhookObjDetail struct {
+	ID        uint64     `json:"id"`
+	URL       string     `json:"url"`
+	Events    []string   `json:"events"`  // empty for all of them
+	Columns   []string   `json:"columns"` // empty for any
+	Active    bool       `json:"active"`
+	CreatedAt *time.Time `json:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+}
+
+// CreateUsersWebhookReply only for api docs
+type CreateUsersWebhookReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Webhook UsersWebhookObjDetail `json:"webhook"`
+		Secret  string                `json:"secret"` // key of the X-Webhook-Signature of the deliveries, not shown again
+	} `json:"data"` // return data
+}
+
+// UpdateUsersWebhookReply only for api docs
+type UpdateUsersWebhookReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Webhook UsersWebhookObjDetail `json:"webhook"`
+	} `json:"data"` // return data
+}
+
+// DeleteUsersWebhookReply only for api docs
+type DeleteUsersWebhookReply struct {
+	Code int      `json:"code"` // return code
+	Msg  string   `json:"msg"`  // return information description
+	Data struct{} `json:"data"` // return data
+}
+
+// GetUsersWebhookReply only for api docs
+type GetUsersWebhookReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Webhook UsersWebhookObjDetail `json:"webhook"`
+	} `json:"data"` // return data
+}
+
+// ListUsersWebhooksReply only for api docs
+type ListUsersWebhooksReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Webhooks []UsersWebhookObjDetail `json:"webhooks"`
+	} `json:"data"` // return data
+}
+
+// UsersWebhookDeliveryObjDetail a delivery of an event to a webhook and the outcome of its last attempt
+type UsersWebhookDeliveryObjDetail struct {
+	ID             uint64     `json:"id"`
+	WebhookID      uint64     `json:"webhookID"`
+	Offset         uint64     `json:"offset"` // of the event, a redelivery has the offset of the delivery it repeats
+	UsersID        uint64     `json:"usersID"`
+	Event          string     `json:"event"`
+	Status         string     `json:"status"`         // pending, succeeded or dead
+	Attempts       int        `json:"attempts"`       // requests sent so far
+	NextAttemptAt  *time.Time `json:"nextAttemptAt"`  // null unless pending
+	ResponseStatus int        `json:"responseStatus"` // http status of the last attempt, 0 when there was no response
+	LastError      string     `json:"lastError"`      // why the last attempt failed
+	DeliveredAt    *time.Time `json:"deliveredAt"`
+	CreatedAt      *time.Time `json:"createdAt"`
+}
+
+// ListUsersWebhookDeliveriesReply only for api docs
+type ListUsersWebhookDeliveriesReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Deliveries []UsersWebhookDeliveryObjDetail `json:"deliveries"`
+		Total      int64                           `json:"total"`
+	} `json:"data"` // return data
+}
+
+// RedeliverUsersWebhookReply only for api docs
+type RedeliverUsersWebhookReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Delivery UsersWebhookDeliveryObjDetail `json:"delivery"` // the new delivery, sent at the next poll
+	} `json:"data"` // return data
+}
+
+// RestoreUsersByIDReply only for api docs
+type RestoreUsersByIDReply struct {
+	Code int      `json:"code"` // return code
+	Msg  string   `json:"msg"`  // return information description
+	Data struct{} `json:"data"` // return data
+}
+
+// ListUserssByCursorReply only for api docs
+type ListUserssByCursorReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Userss     []UsersObjDetail `json:"userss"`
+		NextCursor string           `json:"nextCursor"` // cursor of the page after, empty on the last page
+		PrevCursor string           `json:"prevCursor"` // cursor of the page before, empty on the first page
+	} `json:"data"` // return data
+}
+
+// SearchUsersResult a users matching a search
+type SearchUsersResult struct {
+	Score float64        `json:"score"` // the higher the better the match
+	Users UsersObjDetail `json:"users"`
+}
+
+// SearchUserssReply only for api docs
+type SearchUserssReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Results []SearchUsersResult `json:"results"`
+		Total   int                 `json:"total"` // number of matches of all pages
+	} `json:"data"` // return data
+}
+
 // DeleteUserssByIDsRequest request params
 type DeleteUserssByIDsRequest struct {
 	IDs []uint64 `json:"ids" binding:"min=1"` // id list
@@ -242,3 +511,47 @@ type ListUserssByIDsReply struct {
 		Userss []UsersObjDetail `json:"userss"`
 	} `json:"data"` // return data
 }
+
+// ImportUsersRow a row of a users import sheet, the header of a column is the json name, the column
+// name or the chinese title of a field. Empty cells are left alone when a users is updated.
+type ImportUsersRow struct {
+	Email            string     `json:"email" binding:"omitempty,email,max=255"` // required to create a users
+	ChineseName      string     `json:"chineseName" binding:"max=255"`
+	ClerkCode        string     `json:"clerkCode" binding:"max=255"`
+	Mobile           string     `json:"mobile" binding:"omitempty,mobile"`
+	DeskPhone        string     `json:"deskPhone" binding:"max=255"`
+	PositionTitle    string     `json:"positionTitle" binding:"max=255"`
+	JobLevel         string     `json:"jobLevel" binding:"max=255"`
+	WecomID          string     `json:"wecomID" binding:"max=255"`
+	PreSsoID         string     `json:"preSsoID" binding:"max=255"`
+	WindowsSid       string     `json:"windowsSid" binding:"max=255"`
+	EntryCompanyDate *time.Time `json:"entryCompanyDate"` // such as 2024-01-02 or 2024/1/2
+	MajorCode        string     `json:"majorCode" binding:"max=255"`
+	MajorName        string     `json:"majorName" binding:"max=255"`
+	PositionNcPkPost string     `json:"positionNcPkPost" binding:"max=255"`
+}
+
+// ImportUsersRowResult what became of a row of the sheet
+type ImportUsersRowResult struct {
+	Row    int          `json:"row"`              // number of the row, the header is row 1
+	Action string       `json:"action"`           // created, updated, unchanged or failed, what would be done in a dry run
+	ID     uint64       `json:"id"`               // id of the users, 0 when it failed or would be created
+	Errors []FieldError `json:"errors,omitempty"` // why the row failed
+}
+
+// ImportUsersReport the result of each row of an import
+type ImportUsersReport struct {
+	DryRun    bool                   `json:"dryRun"` // nothing was written
+	Created   int                    `json:"created"`
+	Updated   int                    `json:"updated"`
+	Unchanged int                    `json:"unchanged"`
+	Failed    int                    `json:"failed"`
+	Rows      []ImportUsersRowResult `json:"rows"` // the blank rows are left out
+}
+
+// ImportUserssReply only for api docs
+type ImportUserssReply struct {
+	Code int               `json:"code"` // return code
+	Msg  string            `json:"msg"`  // return information description
+	Data ImportUsersReport `json:"data"` // return data
+}
@@ -0,0 +1,21 @@
+-- The trash of the deleted userss, run once on the database shared with the rails app before this
+-- version of user_server is deployed. DELETE /api/v1/users/:id sets deactivated_at instead of
+-- removing the row, the reads and writes of user_server skip the rows where it is set, only the
+-- trash endpoints see them.
+--
+-- The rails app may run it as a migration instead:
+--
+--   add_column :users, :deactivated_at, :datetime
+--   add_index :users, :deactivated_at
+--
+-- The rails app keeps seeing the deactivated rows until it adds deactivated_at IS NULL to its own scopes.
+
+ALTER TABLE users
+  ADD COLUMN deactivated_at datetime NULL,
+  ADD INDEX index_users_on_deactivated_at (deactivated_at);
+
+-- rollback, the rows in the trash become active users again:
+--
+-- ALTER TABLE users
+--   DROP INDEX index_users_on_deactivated_at,
+--   DROP COLUMN deactivated_at;
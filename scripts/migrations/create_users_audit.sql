@@ -0,0 +1,20 @@
+-- The audit trail of the users writes, run once before this version of user_server is deployed. Every
+-- create, update, delete, restore, revert and purge of user_server inserts its audit row in the
+-- transaction of the write, a write fails while the table is missing.
+
+CREATE TABLE users_audit (
+  id bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  users_id bigint(20) unsigned NOT NULL,
+  action varchar(16) NOT NULL,
+  actor_type varchar(32) NOT NULL DEFAULT '',
+  actor_id bigint(20) unsigned NOT NULL DEFAULT 0,
+  request_id varchar(64) NOT NULL DEFAULT '',
+  changes json NOT NULL,
+  created_at datetime NOT NULL,
+  PRIMARY KEY (id),
+  KEY index_users_audit_on_users_id (users_id, id)
+);
+
+-- rollback, after user_server is rolled back to a version without the audit:
+--
+-- DROP TABLE users_audit;
@@ -0,0 +1,19 @@
+-- The outbox of the users events, run once before this version of user_server is deployed. The event of
+-- each write is inserted in the transaction of the write, the relay claims the events in id order with
+-- claimed_until, publishes them and sets published_at.
+
+CREATE TABLE users_outbox (
+  id bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  users_id bigint(20) unsigned NOT NULL,
+  event varchar(32) NOT NULL,
+  payload json NOT NULL,
+  created_at datetime(6) NOT NULL,
+  published_at datetime(6) DEFAULT NULL,
+  claimed_until datetime(6) DEFAULT NULL,
+  PRIMARY KEY (id),
+  KEY index_users_outbox_on_published_at (published_at, id)
+);
+
+-- rollback, after the relays are stopped:
+--
+-- DROP TABLE users_outbox;
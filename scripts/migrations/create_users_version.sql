@@ -0,0 +1,19 @@
+-- The versions of the userss, run once before this version of user_server is deployed. A snapshot of
+-- the record is inserted with the audit row of each write, the userss written before the table was
+-- created have no history before their first write.
+
+CREATE TABLE users_version (
+  id bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  users_id bigint(20) unsigned NOT NULL,
+  version int(11) NOT NULL,
+  action varchar(16) NOT NULL,
+  snapshot json NOT NULL,
+  created_at datetime(6) NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY index_users_version_on_users_id_and_version (users_id, version),
+  KEY index_users_version_on_users_id_and_created_at (users_id, created_at)
+);
+
+-- rollback, after user_server is rolled back to a version without the versions:
+--
+-- DROP TABLE users_version;
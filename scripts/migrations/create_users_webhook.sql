@@ -0,0 +1,40 @@
+-- The webhooks of the users events and their deliveries, run once before this version of user_server is
+-- deployed. A delivery is queued in the transaction of each write whose event a webhook matches, the
+-- dispatcher sends the pending ones.
+
+CREATE TABLE users_webhook (
+  id bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  url varchar(2048) NOT NULL,
+  secret varchar(255) NOT NULL,
+  events varchar(255) NOT NULL DEFAULT '',
+  columns varchar(4096) NOT NULL DEFAULT '',
+  active tinyint(1) NOT NULL DEFAULT 1,
+  created_at datetime(6) NOT NULL,
+  updated_at datetime(6) NOT NULL,
+  PRIMARY KEY (id)
+);
+
+CREATE TABLE users_webhook_delivery (
+  id bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  webhook_id bigint(20) unsigned NOT NULL,
+  outbox_id bigint(20) unsigned NOT NULL,
+  users_id bigint(20) unsigned NOT NULL,
+  event varchar(32) NOT NULL,
+  payload json NOT NULL,
+  status varchar(16) NOT NULL,
+  attempts int(11) NOT NULL DEFAULT 0,
+  next_attempt_at datetime(6) DEFAULT NULL,
+  response_status int(11) NOT NULL DEFAULT 0,
+  last_error varchar(1024) NOT NULL DEFAULT '',
+  delivered_at datetime(6) DEFAULT NULL,
+  created_at datetime(6) NOT NULL,
+  updated_at datetime(6) NOT NULL,
+  PRIMARY KEY (id),
+  KEY index_users_webhook_delivery_on_status_and_next_attempt_at (status, next_attempt_at),
+  KEY index_users_webhook_delivery_on_webhook_id_and_id (webhook_id, id)
+);
+
+-- rollback, after the dispatchers are stopped:
+--
+-- DROP TABLE users_webhook_delivery;
+-- DROP TABLE users_webhook;